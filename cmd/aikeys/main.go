@@ -0,0 +1,72 @@
+// 供应商 API Key 加密迁移与主密钥轮换工具
+//
+// 一次性迁移：配置主密钥后执行，加密 DB（含密钥池与用户自有密钥）与 configs/providers/*.yaml 中的存量明文密钥。
+// 主密钥轮换：将旧主密钥移入 ai.encryption.previous_keys、设置新的 master_key 后执行，
+// 旧主密钥包裹的数据密钥将改由新主密钥包裹，完成后即可移除旧主密钥。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"novel-agent-os-backend/internal/config"
+	"novel-agent-os-backend/internal/repository"
+	"novel-agent-os-backend/pkg/database"
+	"novel-agent-os-backend/pkg/logger"
+)
+
+func main() {
+	configPath := flag.String("config", "", "config file path")
+	configName := flag.String("config-name", "config", "config name without extension")
+	flag.Parse()
+
+	if err := config.Init(*configPath, *configName); err != nil {
+		fmt.Printf("Failed to init config: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg := config.Get()
+	logger.Init(logger.Config{
+		Level:   cfg.Logging.Level,
+		Console: true,
+	})
+
+	if cfg.AI.Encryption.MasterKey == "" {
+		fmt.Println("ai.encryption.master_key is not configured (set NOVEL_AGENT_OS_AI_ENCRYPTION_MASTER_KEY)")
+		os.Exit(1)
+	}
+	if err := repository.ValidateAPIKeyMasterKeys(); err != nil {
+		fmt.Printf("Invalid ai.encryption config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := database.Init(config.GetDBConfig()); err != nil {
+		fmt.Printf("Failed to init database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	result, err := repository.NewAIConfigRepository().ReencryptAPIKeys()
+	if result != nil {
+		fmt.Printf("rows updated: %d, files updated: %d\n", result.RowsUpdated, result.FilesUpdated)
+	}
+	if err != nil {
+		fmt.Printf("Failed to re-encrypt api keys: %v\n", err)
+		os.Exit(1)
+	}
+
+	keyRows, err := repository.NewAIProviderKeyRepository(database.GetDB()).ReencryptAPIKeys()
+	fmt.Printf("pool keys updated: %d\n", keyRows)
+	if err != nil {
+		fmt.Printf("Failed to re-encrypt pool keys: %v\n", err)
+		os.Exit(1)
+	}
+
+	userRows, err := repository.NewAIUserProviderKeyRepository(database.GetDB()).ReencryptAPIKeys()
+	fmt.Printf("user keys updated: %d\n", userRows)
+	if err != nil {
+		fmt.Printf("Failed to re-encrypt user keys: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -40,6 +40,11 @@ func main() {
 		Console:    cfg.Logging.Console,
 	})
 
+	if err := repository.ValidateAPIKeyMasterKeys(); err != nil {
+		logger.Error("Invalid ai.encryption config", logger.Err(err))
+		os.Exit(1)
+	}
+
 	if err := database.Init(database.Config{
 		Type:            cfg.Database.Type,
 		Host:            cfg.Database.Host,
@@ -99,6 +104,8 @@ func autoMigrate() error {
 		&model.Volume{},
 		&model.Document{},
 		&model.DocumentEntityRef{},
+		&model.DocumentRevision{},
+		&model.DocumentProposal{},
 		&model.Entity{},
 		&model.EntityTag{},
 		&model.EntityLink{},
@@ -108,12 +115,21 @@ func autoMigrate() error {
 		&model.Session{},
 		&model.SessionStep{},
 		&model.Job{},
+		&model.ChapterBatchJobItem{},
+		&model.Pipeline{},
+		&model.PipelineRun{},
 		&model.SettlementEntry{},
 		&model.CorpusStory{},
 		&model.File{},
 		&model.RedemptionCode{},
 		&model.RedemptionCodeUse{},
 		&model.AIProviderConfig{},
+		&model.AIUsageRecord{},
+		&model.AIUserQuota{},
+		&model.AIProviderKey{},
+		&model.AIUserProviderKey{},
+		&model.AICallLog{},
+		&model.AIResponseCache{},
 	)
 }
 
@@ -50,11 +50,219 @@ type RateLimitConfig struct {
 }
 
 type AIConfig struct {
-	DefaultProvider      string `mapstructure:"default_provider"`
-	ProvidersPath        string `mapstructure:"providers_path"`
-	AllowInsecureHTTP    bool   `mapstructure:"allow_insecure_http"`
-	ModelsCacheTTL       int    `mapstructure:"models_cache_ttl"`
-	UseStaleCacheOnError bool   `mapstructure:"use_stale_cache_on_error"`
+	DefaultProvider      string        `mapstructure:"default_provider"`
+	ProvidersPath        string        `mapstructure:"providers_path"`
+	AllowInsecureHTTP    bool          `mapstructure:"allow_insecure_http"`
+	ModelsCacheTTL       int           `mapstructure:"models_cache_ttl"`
+	UseStaleCacheOnError bool          `mapstructure:"use_stale_cache_on_error"`
+	RequestTimeout       int           `mapstructure:"request_timeout"`
+	Retry                AIRetryConfig `mapstructure:"retry"`
+	// FallbackChains 按主供应商配置的备用链，如 openai: [openrouter, local]
+	FallbackChains map[string][]AIFallbackEntry `mapstructure:"fallback_chains"`
+	Billing        AIBillingConfig              `mapstructure:"billing"`
+	Encryption     AIEncryptionConfig           `mapstructure:"encryption"`
+	KeyPool        AIKeyPoolConfig              `mapstructure:"key_pool"`
+	// ModelOverrides 模型能力覆盖，优先于上游 /models 返回的元数据
+	ModelOverrides []AIModelOverride      `mapstructure:"model_overrides"`
+	Mock           AIMockConfig           `mapstructure:"mock"`
+	CircuitBreaker AICircuitBreakerConfig `mapstructure:"circuit_breaker"`
+	Concurrency    AIConcurrencyConfig    `mapstructure:"concurrency"`
+	CallLog        AICallLogConfig        `mapstructure:"call_log"`
+	ResponseCache  AIResponseCacheConfig  `mapstructure:"response_cache"`
+	Context        AIContextConfig        `mapstructure:"context"`
+	Batch          AIBatchConfig          `mapstructure:"batch"`
+	QualityGate    AIQualityGateConfig    `mapstructure:"quality_gate"`
+	Pipeline       AIPipelineConfig       `mapstructure:"pipeline"`
+}
+
+// AIBatchConfig 批量生成章节任务（后台 Job）
+type AIBatchConfig struct {
+	// Parallelism 请求未指定时同时生成的章节数
+	Parallelism int `mapstructure:"parallelism"`
+	// MaxParallelism 请求可指定的并行数上限
+	MaxParallelism int `mapstructure:"max_parallelism"`
+	// MaxItems 单个任务的章节数上限
+	MaxItems int `mapstructure:"max_items"`
+}
+
+// AIQualityGateConfig 章节生成/重写与 AgentWriter 的质量门禁循环；项目 ai_settings.quality_gate 可覆盖
+type AIQualityGateConfig struct {
+	// Enabled 项目与请求均未指定时是否启用
+	Enabled bool `mapstructure:"enabled"`
+	// MinScore 质量得分阈值，低于阈值或存在硬性问题（内容过短、敏感词）时按检测到的问题重新生成
+	MinScore int `mapstructure:"min_score"`
+	// MaxAttempts 默认最多生成次数（含首次）
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// MaxAttemptsLimit 项目或请求可指定的生成次数上限
+	MaxAttemptsLimit int `mapstructure:"max_attempts_limit"`
+}
+
+// AIPipelineConfig 项目自定义工作流流水线
+type AIPipelineConfig struct {
+	// MaxSteps 单个定义的步骤数上限
+	MaxSteps int `mapstructure:"max_steps"`
+	// MaxStepRuns 单次执行（含恢复后继续）的步骤执行次数上限，防止条件分支形成死循环
+	MaxStepRuns int `mapstructure:"max_step_runs"`
+}
+
+// AIContextConfig 章节工作流的服务端上下文组装（项目设定、卷规划、关联实体与前情摘要）
+type AIContextConfig struct {
+	// AutoInject 请求未指定 context.inject 时是否自动注入（仅对 chat 请求生效）
+	AutoInject bool `mapstructure:"auto_inject"`
+	// TokenBudget 默认 token 预算
+	TokenBudget int `mapstructure:"token_budget"`
+	// PreviousChapters 最多纳入的前情章节摘要数
+	PreviousChapters int `mapstructure:"previous_chapters"`
+	// MaxEntities 最多纳入的关联实体数
+	MaxEntities int `mapstructure:"max_entities"`
+}
+
+// AIResponseCacheConfig 确定性请求的响应缓存（默认关闭）
+// 仅缓存 temperature 为 0 或调用方显式要求缓存的非流式工作流调用，缓存命中不再请求上游、不计费
+type AIResponseCacheConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TTLSec 缓存有效期（秒），过期条目定期清理
+	TTLSec int `mapstructure:"ttl_sec"`
+	// MaxEntryKB 单条响应的最大缓存长度，超出时不缓存
+	MaxEntryKB int `mapstructure:"max_entry_kb"`
+}
+
+// AICallLogConfig AI 调用审计日志（默认关闭）：保存实际发往上游的请求体与上游响应，落库前脱敏
+type AICallLogConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// RetentionDays 保留天数，过期记录定期清理
+	RetentionDays int `mapstructure:"retention_days"`
+	// MaxBodyKB 请求体/响应体各自的最大保存长度，超出部分截断
+	MaxBodyKB int `mapstructure:"max_body_kb"`
+	// RedactPatterns 额外的脱敏正则（如手机号、身份证号），命中内容替换为 [REDACTED]；API Key 始终脱敏
+	RedactPatterns []string `mapstructure:"redact_patterns"`
+}
+
+// AIConcurrencyConfig 上游并发限制：每个供应商与每个用户的同时调用数，超出时按用户轮转排队
+type AIConcurrencyConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// ProviderLimit 每个供应商的默认并发上限
+	ProviderLimit int `mapstructure:"provider_limit"`
+	// PerUserLimit 单个用户在同一供应商上的默认并发上限
+	PerUserLimit int `mapstructure:"per_user_limit"`
+	// MaxQueue 每个供应商的最大排队数，超出时直接拒绝
+	MaxQueue int `mapstructure:"max_queue"`
+	// QueueTimeoutSec 最长排队时间
+	QueueTimeoutSec int `mapstructure:"queue_timeout_sec"`
+	// Providers 按供应商覆盖并发上限（列表形式，避免 viper 对键名中的 . 进行拆分）
+	Providers []AIProviderConcurrency `mapstructure:"providers"`
+}
+
+// AIProviderConcurrency 单个供应商的并发上限，0 表示使用默认值
+type AIProviderConcurrency struct {
+	Provider     string `mapstructure:"provider"`
+	Limit        int    `mapstructure:"limit"`
+	PerUserLimit int    `mapstructure:"per_user_limit"`
+}
+
+// AICircuitBreakerConfig 供应商熔断：窗口内错误率达到阈值后熔断并快速失败，冷却结束后半开放行探测请求
+type AICircuitBreakerConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// WindowSec 错误率统计窗口
+	WindowSec int `mapstructure:"window_sec"`
+	// MinRequests 窗口内请求数达到该值才计算错误率
+	MinRequests int `mapstructure:"min_requests"`
+	// ErrorRateThreshold 错误率阈值（0-1），仅连接失败、超时与 5xx 计为错误
+	ErrorRateThreshold float64 `mapstructure:"error_rate_threshold"`
+	// OpenDurationSec 熔断持续时长，之后进入半开状态
+	OpenDurationSec int `mapstructure:"open_duration_sec"`
+	// HalfOpenMaxRequests 半开状态允许同时进行的探测请求数
+	HalfOpenMaxRequests int `mapstructure:"half_open_max_requests"`
+}
+
+// AIMockConfig 内置 mock 供应商（provider=mock），不访问网络，用于本地开发与自动化测试
+type AIMockConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// ChunkSize 流式输出每个 chunk 的字符数
+	ChunkSize int `mapstructure:"chunk_size"`
+	// ChunkDelayMs 流式 chunk 间隔
+	ChunkDelayMs int `mapstructure:"chunk_delay_ms"`
+	// Responses 按最后一条用户消息匹配的预设回复，按顺序取第一个命中项
+	Responses []AIMockResponse `mapstructure:"responses"`
+}
+
+// AIMockResponse mock 预设回复
+// Match 为空时匹配所有请求；Content 为 text/template 模板，可用 .Model .Prompt .System
+type AIMockResponse struct {
+	Match     string           `mapstructure:"match"`
+	Content   string           `mapstructure:"content"`
+	ToolCalls []AIMockToolCall `mapstructure:"tool_calls"`
+}
+
+// AIMockToolCall mock 返回的工具调用，Arguments 为 JSON 字符串
+type AIMockToolCall struct {
+	Name      string `mapstructure:"name"`
+	Arguments string `mapstructure:"arguments"`
+}
+
+// AIModelOverride 模型能力与价格覆盖
+// Model 以 * 结尾时按前缀匹配；Provider 为空时匹配所有供应商；未填写的字段沿用上游元数据
+type AIModelOverride struct {
+	Provider          string  `mapstructure:"provider"`
+	Model             string  `mapstructure:"model"`
+	ContextWindow     int     `mapstructure:"context_window"`
+	MaxOutputTokens   int     `mapstructure:"max_output_tokens"`
+	SupportsTools     *bool   `mapstructure:"supports_tools"`
+	SupportsStreaming *bool   `mapstructure:"supports_streaming"`
+	InputPricePer1M   float64 `mapstructure:"input_price_per_1m"`
+	OutputPricePer1M  float64 `mapstructure:"output_price_per_1m"`
+}
+
+// AIKeyPoolConfig 供应商密钥池选择策略
+type AIKeyPoolConfig struct {
+	// Strategy round_robin（按权重轮询）或 least_recently_limited（优先最久未被限流的密钥）
+	Strategy string `mapstructure:"strategy"`
+	// AuthCooldownSec 401/403 后的冷却时长
+	AuthCooldownSec int `mapstructure:"auth_cooldown_sec"`
+	// RateLimitCooldownSec 429 且上游未给出 Retry-After 时的冷却时长
+	RateLimitCooldownSec int `mapstructure:"rate_limit_cooldown_sec"`
+}
+
+// AIEncryptionConfig 供应商 API Key 落盘加密（信封加密）
+// MasterKey 为 base64/hex 编码的 32 字节随机值（openssl rand -base64 32），建议通过环境变量 NOVEL_AGENT_OS_AI_ENCRYPTION_MASTER_KEY 注入；
+// 轮换时将旧主密钥移入 PreviousKeys 并执行 cmd/aikeys，完成后即可移除。
+type AIEncryptionConfig struct {
+	MasterKey    string   `mapstructure:"master_key"`
+	PreviousKeys []string `mapstructure:"previous_keys"`
+}
+
+// AIBillingConfig AI 调用积分计费，未在 ModelPricing 中配置的模型使用 DefaultPointsPer1K
+type AIBillingConfig struct {
+	Enabled            bool           `mapstructure:"enabled"`
+	DefaultPointsPer1K float64        `mapstructure:"default_points_per_1k"`
+	ModelPricing       []AIModelPrice `mapstructure:"model_pricing"`
+	// MinBalance 发起调用前要求的最低积分余额
+	MinBalance int `mapstructure:"min_balance"`
+}
+
+// AIModelPrice 模型单价（每 1K token 消耗积分）
+// 模型名可能包含 "."，因此用列表而非 map 配置，避免被 viper 当作层级分隔符
+type AIModelPrice struct {
+	Model       string  `mapstructure:"model" json:"model"`
+	PointsPer1K float64 `mapstructure:"points_per_1k" json:"points_per_1k"`
+}
+
+// AIFallbackEntry 备用供应商链条目
+// ModelMap 将主供应商模型映射为本供应商模型，未命中时使用 Model，均为空则沿用原模型
+type AIFallbackEntry struct {
+	Provider string            `mapstructure:"provider" json:"provider"`
+	Model    string            `mapstructure:"model" json:"model"`
+	ModelMap map[string]string `mapstructure:"model_map" json:"model_map"`
+}
+
+// AIRetryConfig 上游 AI 调用重试策略
+type AIRetryConfig struct {
+	MaxAttempts      int     `mapstructure:"max_attempts"`
+	InitialBackoffMs int     `mapstructure:"initial_backoff_ms"`
+	MaxBackoffMs     int     `mapstructure:"max_backoff_ms"`
+	Multiplier       float64 `mapstructure:"multiplier"`
+	Jitter           float64 `mapstructure:"jitter"`
+	MaxRetryAfterMs  int     `mapstructure:"max_retry_after_ms"`
 }
 
 var cfgMu sync.RWMutex
@@ -120,6 +328,116 @@ func Init(configPath string, configName string) error {
 	if loaded.AI.ModelsCacheTTL == 0 {
 		loaded.AI.ModelsCacheTTL = 3600
 	}
+	if loaded.AI.RequestTimeout == 0 {
+		loaded.AI.RequestTimeout = 60
+	}
+	if loaded.AI.Retry.MaxAttempts == 0 {
+		loaded.AI.Retry.MaxAttempts = 3
+	}
+	if loaded.AI.Retry.InitialBackoffMs == 0 {
+		loaded.AI.Retry.InitialBackoffMs = 1000
+	}
+	if loaded.AI.Retry.MaxBackoffMs == 0 {
+		loaded.AI.Retry.MaxBackoffMs = 30000
+	}
+	if loaded.AI.Retry.Multiplier == 0 {
+		loaded.AI.Retry.Multiplier = 2
+	}
+	if loaded.AI.Retry.MaxRetryAfterMs == 0 {
+		loaded.AI.Retry.MaxRetryAfterMs = 60000
+	}
+	if loaded.AI.KeyPool.Strategy == "" {
+		loaded.AI.KeyPool.Strategy = "round_robin"
+	}
+	if loaded.AI.KeyPool.AuthCooldownSec == 0 {
+		loaded.AI.KeyPool.AuthCooldownSec = 600
+	}
+	if loaded.AI.KeyPool.RateLimitCooldownSec == 0 {
+		loaded.AI.KeyPool.RateLimitCooldownSec = 60
+	}
+	if loaded.AI.Mock.ChunkSize == 0 {
+		loaded.AI.Mock.ChunkSize = 8
+	}
+	if loaded.AI.CircuitBreaker.WindowSec == 0 {
+		loaded.AI.CircuitBreaker.WindowSec = 60
+	}
+	if loaded.AI.CircuitBreaker.MinRequests == 0 {
+		loaded.AI.CircuitBreaker.MinRequests = 10
+	}
+	if loaded.AI.CircuitBreaker.ErrorRateThreshold == 0 {
+		loaded.AI.CircuitBreaker.ErrorRateThreshold = 0.5
+	}
+	if loaded.AI.CircuitBreaker.OpenDurationSec == 0 {
+		loaded.AI.CircuitBreaker.OpenDurationSec = 30
+	}
+	if loaded.AI.CircuitBreaker.HalfOpenMaxRequests == 0 {
+		loaded.AI.CircuitBreaker.HalfOpenMaxRequests = 1
+	}
+	if loaded.AI.Concurrency.ProviderLimit == 0 {
+		loaded.AI.Concurrency.ProviderLimit = 8
+	}
+	if loaded.AI.Concurrency.PerUserLimit == 0 {
+		loaded.AI.Concurrency.PerUserLimit = 2
+	}
+	if loaded.AI.Concurrency.MaxQueue == 0 {
+		loaded.AI.Concurrency.MaxQueue = 100
+	}
+	if loaded.AI.Concurrency.QueueTimeoutSec == 0 {
+		loaded.AI.Concurrency.QueueTimeoutSec = 120
+	}
+	if loaded.AI.CallLog.RetentionDays == 0 {
+		loaded.AI.CallLog.RetentionDays = 7
+	}
+	if loaded.AI.CallLog.MaxBodyKB == 0 {
+		loaded.AI.CallLog.MaxBodyKB = 256
+	}
+	if loaded.AI.ResponseCache.TTLSec == 0 {
+		loaded.AI.ResponseCache.TTLSec = 86400
+	}
+	if loaded.AI.ResponseCache.MaxEntryKB == 0 {
+		loaded.AI.ResponseCache.MaxEntryKB = 512
+	}
+	if loaded.AI.Context.TokenBudget == 0 {
+		loaded.AI.Context.TokenBudget = 2000
+	}
+	if loaded.AI.Context.PreviousChapters == 0 {
+		loaded.AI.Context.PreviousChapters = 5
+	}
+	if loaded.AI.Context.MaxEntities == 0 {
+		loaded.AI.Context.MaxEntities = 20
+	}
+	if loaded.AI.Batch.Parallelism == 0 {
+		loaded.AI.Batch.Parallelism = 2
+	}
+	if loaded.AI.Batch.MaxParallelism == 0 {
+		loaded.AI.Batch.MaxParallelism = 8
+	}
+	if loaded.AI.Batch.MaxItems == 0 {
+		loaded.AI.Batch.MaxItems = 200
+	}
+	// 阈值允许配置为 0（不限得分，内容过短、敏感词等硬性问题仍不通过），仅在未配置时使用默认值
+	if !v.IsSet("ai.quality_gate.min_score") {
+		loaded.AI.QualityGate.MinScore = 70
+	}
+	if loaded.AI.QualityGate.MaxAttempts == 0 {
+		loaded.AI.QualityGate.MaxAttempts = 3
+	}
+	if loaded.AI.QualityGate.MaxAttemptsLimit == 0 {
+		loaded.AI.QualityGate.MaxAttemptsLimit = 5
+	}
+	if loaded.AI.Pipeline.MaxSteps == 0 {
+		loaded.AI.Pipeline.MaxSteps = 30
+	}
+	if loaded.AI.Pipeline.MaxStepRuns == 0 {
+		loaded.AI.Pipeline.MaxStepRuns = 100
+	}
+	// 计费项允许配置为 0（不扣费/不要求最低余额），仅在未配置时使用默认值
+	if !v.IsSet("ai.billing.default_points_per_1k") {
+		loaded.AI.Billing.DefaultPointsPer1K = 1
+	}
+	if !v.IsSet("ai.billing.min_balance") {
+		loaded.AI.Billing.MinBalance = 1
+	}
 
 	cfgMu.Lock()
 	cfg = loaded
@@ -29,7 +29,12 @@ type StartWritingTaskRequest struct {
 	Prompt     string                   `json:"prompt" binding:"required"`
 	Outline    []service.ChapterOutline `json:"outline" binding:"required"`
 	Provider   string                   `json:"provider" binding:"required"`
-	Path       string                   `json:"path" binding:"required"`
+	Path       string                   `json:"path"`
+	Model      string                   `json:"model"`
+	// Context 章节上下文注入选项（项目设定、关联实体与前情摘要）
+	Context *service.ChapterContextOptions `json:"context"`
+	// QualityGate 质量门禁选项（得分低于项目阈值时按问题重新生成）
+	QualityGate *service.QualityGateOptions `json:"quality_gate"`
 }
 
 // StartWritingTask 启动写作任务
@@ -57,8 +62,14 @@ func (h *AgentWriterHandler) StartWritingTask(c *gin.Context) {
 		req.Outline,
 		req.Provider,
 		req.Path,
+		req.Model,
+		req.Context,
+		req.QualityGate,
 	)
 	if err != nil {
+		if respondAIPreflightError(c, err) {
+			return
+		}
 		logger.Error("启动写作任务失败", logger.Err(err))
 		response.Fail(c, errors.CodeInternalError, "启动写作任务失败")
 		return
@@ -1,6 +1,9 @@
 package handler
 
 import (
+	stderrors "errors"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -35,3 +38,58 @@ func RequireAIAccess(userService service.UserService) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// respondAIPreflightError 调用前校验失败时返回结构化错误，返回是否已处理
+// - 计费预检：code 为计费错误码，data 含余额/上限信息
+// - 上下文窗口：code 为 30004，data 含模型上下文长度与估算 token 数
+// - 供应商熔断：HTTP 503，code 为 30005，data 含熔断状态与建议重试秒数
+// - 并发排队已满或超时：HTTP 429，code 为 30006
+func respondAIPreflightError(c *gin.Context, err error) bool {
+	var queueErr *service.AIQueueError
+	if stderrors.As(err, &queueErr) {
+		c.JSON(http.StatusTooManyRequests, response.Response{
+			Code:    queueErr.Code,
+			Message: queueErr.Message,
+			Data:    queueErr,
+		})
+		return true
+	}
+
+	var openErr *service.AICircuitOpenError
+	if stderrors.As(err, &openErr) {
+		if openErr.RetryAfterSec > 0 {
+			c.Header("Retry-After", strconv.Itoa(openErr.RetryAfterSec))
+		}
+		c.JSON(http.StatusServiceUnavailable, response.Response{
+			Code:    openErr.Code,
+			Message: openErr.Message,
+			Data:    openErr,
+		})
+		return true
+	}
+
+	var contextErr *service.AIContextWindowError
+	if stderrors.As(err, &contextErr) {
+		c.JSON(http.StatusBadRequest, response.Response{
+			Code:    contextErr.Code,
+			Message: contextErr.Message,
+			Data:    contextErr,
+		})
+		return true
+	}
+
+	var billingErr *service.AIBillingError
+	if !stderrors.As(err, &billingErr) {
+		return false
+	}
+	status := http.StatusTooManyRequests
+	if billingErr.Code == service.CodeAIInsufficientPoints {
+		status = http.StatusPaymentRequired
+	}
+	c.JSON(status, response.Response{
+		Code:    billingErr.Code,
+		Message: billingErr.Message,
+		Data:    billingErr,
+	})
+	return true
+}
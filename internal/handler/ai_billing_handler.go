@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"novel-agent-os-backend/internal/service"
+	"novel-agent-os-backend/pkg/errors"
+	"novel-agent-os-backend/pkg/response"
+)
+
+// AIBillingHandler AI 计费与上限处理器
+type AIBillingHandler struct {
+	billingService service.AIBillingService
+}
+
+// NewAIBillingHandler 创建 AI 计费处理器
+func NewAIBillingHandler(billingService service.AIBillingService) *AIBillingHandler {
+	return &AIBillingHandler{billingService: billingService}
+}
+
+// UpdateAIQuotaRequest 设置用户 token 上限请求（0 表示不限制）
+type UpdateAIQuotaRequest struct {
+	DailyTokenLimit   int64 `json:"daily_token_limit"`
+	MonthlyTokenLimit int64 `json:"monthly_token_limit"`
+}
+
+// GetStatus 当前用户的积分余额、模型单价与 token 上限
+func (h *AIBillingHandler) GetStatus(c *gin.Context) {
+	userID := getUserIDFromContext(c)
+	if userID == 0 {
+		response.Fail(c, errors.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	status, err := h.billingService.GetStatus(userID)
+	if err != nil {
+		response.Fail(c, errors.CodeInternalError, "获取计费信息失败")
+		return
+	}
+	response.SuccessWithData(c, status)
+}
+
+// GetQuota 获取指定用户的 token 上限（管理员）
+func (h *AIBillingHandler) GetQuota(c *gin.Context) {
+	userID, err := parseUintParam(c, "user_id")
+	if err != nil {
+		response.Fail(c, errors.CodeInvalidParams, "无效的用户ID")
+		return
+	}
+	status, err := h.billingService.GetStatus(userID)
+	if err != nil {
+		response.Fail(c, errors.CodeNotFound, "用户不存在")
+		return
+	}
+	response.SuccessWithData(c, status)
+}
+
+// UpdateQuota 设置指定用户的每日/每月 token 上限（管理员）
+func (h *AIBillingHandler) UpdateQuota(c *gin.Context) {
+	userID, err := parseUintParam(c, "user_id")
+	if err != nil {
+		response.Fail(c, errors.CodeInvalidParams, "无效的用户ID")
+		return
+	}
+	var req UpdateAIQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, errors.CodeInvalidParams, err.Error())
+		return
+	}
+	if req.DailyTokenLimit < 0 || req.MonthlyTokenLimit < 0 {
+		response.Fail(c, errors.CodeInvalidParams, "上限不能为负数")
+		return
+	}
+	quota, err := h.billingService.SetQuota(userID, req.DailyTokenLimit, req.MonthlyTokenLimit)
+	if err != nil {
+		response.Fail(c, errors.CodeNotFound, "用户不存在")
+		return
+	}
+	response.SuccessWithData(c, quota)
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"novel-agent-os-backend/internal/service"
+	"novel-agent-os-backend/pkg/errors"
+	"novel-agent-os-backend/pkg/response"
+)
+
+// AICallLogHandler AI 调用审计日志查询（会话所有者或管理员）
+type AICallLogHandler struct {
+	callLogService service.AICallLogService
+	sessionService service.SessionService
+}
+
+// NewAICallLogHandler 创建 AI 调用日志处理器
+func NewAICallLogHandler(callLogService service.AICallLogService, sessionService service.SessionService) *AICallLogHandler {
+	return &AICallLogHandler{
+		callLogService: callLogService,
+		sessionService: sessionService,
+	}
+}
+
+// ListBySession 会话的调用日志列表（不含请求体与响应）
+func (h *AICallLogHandler) ListBySession(c *gin.Context) {
+	sessionID, err := parseUintParam(c, "session_id")
+	if err != nil {
+		response.Fail(c, errors.CodeInvalidParams, "Invalid session ID")
+		return
+	}
+	session, err := h.sessionService.GetSession(sessionID)
+	if err != nil {
+		response.Fail(c, errors.CodeSessionNotFound, "Session not found")
+		return
+	}
+	if session.UserID != getUserIDFromContext(c) && !isAdminFromContext(c) {
+		response.Fail(c, errors.CodeForbidden, "Access denied")
+		return
+	}
+
+	page := parseIntQuery(c, "page", 1)
+	size := parseIntQuery(c, "size", 20)
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 20
+	}
+	logs, total, err := h.callLogService.ListBySession(sessionID, page, size)
+	if err != nil {
+		response.Fail(c, errors.CodeInternalError, "查询调用日志失败")
+		return
+	}
+	response.SuccessWithPage(c, logs, total, page, size)
+}
+
+// Get 单条调用日志详情（含脱敏后的请求体与响应）
+func (h *AICallLogHandler) Get(c *gin.Context) {
+	id, err := parseUintParam(c, "id")
+	if err != nil {
+		response.Fail(c, errors.CodeInvalidParams, "无效的日志ID")
+		return
+	}
+	log, err := h.callLogService.GetByID(id)
+	if err != nil {
+		response.Fail(c, errors.CodeNotFound, "调用日志不存在")
+		return
+	}
+	if log.UserID != getUserIDFromContext(c) && !isAdminFromContext(c) {
+		response.Fail(c, errors.CodeForbidden, "Access denied")
+		return
+	}
+	response.SuccessWithData(c, log)
+}
@@ -86,3 +86,179 @@ func (h *AIConfigHandler) TestProvider(c *gin.Context) {
 
 	response.Success(c)
 }
+
+// CreateProviderKeyRequest 添加密钥请求
+type CreateProviderKeyRequest struct {
+	Provider string `json:"provider" binding:"required"`
+	Name     string `json:"name"`
+	APIKey   string `json:"api_key" binding:"required"`
+	Weight   int    `json:"weight"`
+	Enabled  *bool  `json:"enabled"`
+}
+
+// UpdateProviderKeyRequest 更新密钥请求（未传字段保持不变）
+type UpdateProviderKeyRequest struct {
+	Name          *string `json:"name"`
+	APIKey        *string `json:"api_key"`
+	Weight        *int    `json:"weight"`
+	Enabled       *bool   `json:"enabled"`
+	ResetCooldown bool    `json:"reset_cooldown"`
+}
+
+// ListProviderKeys 列出供应商密钥池及健康状态
+func (h *AIConfigHandler) ListProviderKeys(c *gin.Context) {
+	var req GetProviderRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Fail(c, errors.CodeInvalidParams, err.Error())
+		return
+	}
+
+	items, err := h.configService.ListProviderKeys(req.Provider)
+	if err != nil {
+		response.Fail(c, errors.CodeInternalError, "获取密钥列表失败")
+		return
+	}
+
+	response.SuccessWithData(c, items)
+}
+
+// CreateProviderKey 向密钥池添加密钥
+func (h *AIConfigHandler) CreateProviderKey(c *gin.Context) {
+	var req CreateProviderKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, errors.CodeInvalidParams, err.Error())
+		return
+	}
+	if req.Weight < 0 {
+		response.Fail(c, errors.CodeInvalidParams, "权重不能为负数")
+		return
+	}
+
+	input := service.ProviderKeyInput{
+		Name:    &req.Name,
+		APIKey:  &req.APIKey,
+		Enabled: req.Enabled,
+	}
+	if req.Weight > 0 {
+		input.Weight = &req.Weight
+	}
+	item, err := h.configService.AddProviderKey(req.Provider, input)
+	if err != nil {
+		logger.Error("Create provider key failed", logger.Err(err))
+		response.Fail(c, errors.CodeInternalError, "添加密钥失败")
+		return
+	}
+
+	response.SuccessWithData(c, item)
+}
+
+// UpdateProviderKey 更新密钥（权重、启用状态、重置冷却）
+func (h *AIConfigHandler) UpdateProviderKey(c *gin.Context) {
+	id, err := parseUintParam(c, "id")
+	if err != nil {
+		response.Fail(c, errors.CodeInvalidParams, "无效的密钥ID")
+		return
+	}
+	var req UpdateProviderKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, errors.CodeInvalidParams, err.Error())
+		return
+	}
+	if req.Weight != nil && *req.Weight <= 0 {
+		response.Fail(c, errors.CodeInvalidParams, "权重必须大于0")
+		return
+	}
+
+	item, err := h.configService.UpdateProviderKey(id, service.ProviderKeyInput{
+		Name:          req.Name,
+		APIKey:        req.APIKey,
+		Weight:        req.Weight,
+		Enabled:       req.Enabled,
+		ResetCooldown: req.ResetCooldown,
+	})
+	if err != nil {
+		response.Fail(c, errors.CodeNotFound, "密钥不存在")
+		return
+	}
+
+	response.SuccessWithData(c, item)
+}
+
+// DeleteProviderKey 删除密钥
+func (h *AIConfigHandler) DeleteProviderKey(c *gin.Context) {
+	id, err := parseUintParam(c, "id")
+	if err != nil {
+		response.Fail(c, errors.CodeInvalidParams, "无效的密钥ID")
+		return
+	}
+
+	if err := h.configService.DeleteProviderKey(id); err != nil {
+		response.Fail(c, errors.CodeInternalError, "删除密钥失败")
+		return
+	}
+
+	response.Success(c)
+}
+
+// SaveUserProviderRequest 保存用户自有供应商配置请求
+type SaveUserProviderRequest struct {
+	Provider string `json:"provider" binding:"required"`
+	BaseURL  string `json:"base_url"`
+	APIKey   string `json:"api_key" binding:"required"`
+}
+
+// ListUserProviders 当前用户的自有供应商配置（API Key 脱敏）
+func (h *AIConfigHandler) ListUserProviders(c *gin.Context) {
+	userID := getUserIDFromContext(c)
+	if userID == 0 {
+		response.Fail(c, errors.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	items, err := h.configService.ListUserProviders(userID)
+	if err != nil {
+		response.Fail(c, errors.CodeInternalError, "获取配置失败")
+		return
+	}
+
+	response.SuccessWithData(c, items)
+}
+
+// SaveUserProvider 保存当前用户的自有供应商配置
+func (h *AIConfigHandler) SaveUserProvider(c *gin.Context) {
+	userID := getUserIDFromContext(c)
+	if userID == 0 {
+		response.Fail(c, errors.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	var req SaveUserProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, errors.CodeInvalidParams, err.Error())
+		return
+	}
+
+	item, err := h.configService.SaveUserProvider(userID, req.Provider, req.BaseURL, req.APIKey)
+	if err != nil {
+		logger.Error("Save user provider failed", logger.Uint("user_id", userID), logger.Err(err))
+		response.Fail(c, errors.CodeInvalidParams, err.Error())
+		return
+	}
+
+	response.SuccessWithData(c, item)
+}
+
+// DeleteUserProvider 删除当前用户的自有供应商配置
+func (h *AIConfigHandler) DeleteUserProvider(c *gin.Context) {
+	userID := getUserIDFromContext(c)
+	if userID == 0 {
+		response.Fail(c, errors.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := h.configService.DeleteUserProvider(userID, c.Param("provider")); err != nil {
+		response.Fail(c, errors.CodeInternalError, "删除配置失败")
+		return
+	}
+
+	response.Success(c)
+}
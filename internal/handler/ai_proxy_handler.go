@@ -17,11 +17,12 @@ import (
 // AIProxyHandler AI代理处理器
 type AIProxyHandler struct {
 	configService service.AIConfigService
+	usageService  service.AIUsageService
 }
 
 // NewAIProxyHandler 创建AI代理处理器
-func NewAIProxyHandler(configService service.AIConfigService) *AIProxyHandler {
-	return &AIProxyHandler{configService: configService}
+func NewAIProxyHandler(configService service.AIConfigService, usageService service.AIUsageService) *AIProxyHandler {
+	return &AIProxyHandler{configService: configService, usageService: usageService}
 }
 
 // ProxyRequest 代理请求
@@ -44,7 +45,8 @@ func (h *AIProxyHandler) Proxy(c *gin.Context) {
 		return
 	}
 
-	providerCfg, err := h.configService.GetProviderConfigRaw(req.Provider)
+	userID := getUserIDFromContext(c)
+	providerCfg, userKey, err := service.ResolveAIProviderConfig(h.configService, userID, req.Provider)
 	if err != nil {
 		response.Fail(c, errors.CodeNotFound, "provider not found")
 		return
@@ -54,10 +56,31 @@ func (h *AIProxyHandler) Proxy(c *gin.Context) {
 		return
 	}
 
+	if err := h.usageService.CheckBeforeCall(userID, req.Provider, nil); err != nil {
+		if !respondAIPreflightError(c, err) {
+			response.Fail(c, errors.CodeInternalError, "计费校验失败")
+		}
+		return
+	}
+
+	// 使用用户自有密钥时不经过平台密钥池
+	var lease *service.AIKeyLease
+	if !userKey {
+		lease, err = h.configService.AcquireAPIKey(req.Provider)
+		if err != nil {
+			response.Fail(c, errors.CodeInternalError, "获取API密钥失败")
+			return
+		}
+	}
+	apiKey := providerCfg.APIKey
+	if lease != nil {
+		apiKey = lease.APIKey
+	}
+
 	base := strings.TrimRight(providerCfg.BaseURL, "/")
 	url := base + "/" + strings.TrimLeft(req.Path, "/")
 
-	client := &http.Client{Timeout: 60 * time.Second}
+	client := service.NewAIHTTPClient(60*time.Second, userKey)
 	proxyReq, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(req.Body))
 	if err != nil {
 		response.Fail(c, errors.CodeInternalError, "proxy request failed")
@@ -65,17 +88,25 @@ func (h *AIProxyHandler) Proxy(c *gin.Context) {
 	}
 	proxyReq.Header.Set("Content-Type", "application/json")
 	proxyReq.Header.Set("Accept", "application/json")
-	if providerCfg.APIKey != "" {
-		if req.Provider == "gemini" {
-			proxyReq.Header.Set("x-goog-api-key", providerCfg.APIKey)
-		} else {
-			proxyReq.Header.Set("Authorization", "Bearer "+providerCfg.APIKey)
+	service.SetAIProviderHeaders(proxyReq, req.Provider, apiKey)
+
+	// 供应商熔断中直接失败，不再等待上游超时
+	var permit *service.AICircuitPermit
+	if !userKey {
+		permit, err = service.AcquireAICircuit(req.Provider)
+		if err != nil {
+			respondAIPreflightError(c, err)
+			return
 		}
 	}
 
+	start := time.Now()
 	resp, err := client.Do(proxyReq)
 	if err != nil {
 		logger.Error("proxy request failed", logger.Err(err))
+		transportErr := &service.AITransportError{Err: err}
+		_ = h.configService.ReportAPIKeyResult(lease, transportErr)
+		service.ReportAICircuitResult(permit, transportErr)
 		response.Fail(c, errors.CodeExternalAPIError, "代理请求失败")
 		return
 	}
@@ -83,11 +114,27 @@ func (h *AIProxyHandler) Proxy(c *gin.Context) {
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
+		service.ReportAICircuitResult(permit, &service.AITransportError{Err: err})
 		response.Fail(c, errors.CodeExternalAPIError, "读取响应失败")
 		return
 	}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+	success := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if success {
+		_ = h.configService.ReportAPIKeyResult(lease, nil)
+		service.ReportAICircuitResult(permit, nil)
+	} else {
+		upstreamErr := service.NewAIUpstreamError(resp, body)
+		_ = h.configService.ReportAPIKeyResult(lease, upstreamErr)
+		service.ReportAICircuitResult(permit, upstreamErr)
+	}
+	h.usageService.RecordProxyCall(service.AIUsageScope{
+		UserID:  userID,
+		Source:  "proxy",
+		UserKey: userKey,
+	}, req.Provider, req.Path, req.Body, body, false, time.Since(start), success)
+
+	if !success {
 		response.Fail(c, errors.CodeExternalAPIError, string(body))
 		return
 	}
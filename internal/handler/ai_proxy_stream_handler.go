@@ -14,14 +14,18 @@ import (
 	"novel-agent-os-backend/pkg/response"
 )
 
+// proxyUsageCaptureLimit 流式代理为计量留存的最大数据量
+const proxyUsageCaptureLimit = 8 * 1024 * 1024
+
 // AIProxyStreamHandler AI代理流式处理器
 type AIProxyStreamHandler struct {
 	configService service.AIConfigService
+	usageService  service.AIUsageService
 }
 
 // NewAIProxyStreamHandler 创建AI代理流式处理器
-func NewAIProxyStreamHandler(configService service.AIConfigService) *AIProxyStreamHandler {
-	return &AIProxyStreamHandler{configService: configService}
+func NewAIProxyStreamHandler(configService service.AIConfigService, usageService service.AIUsageService) *AIProxyStreamHandler {
+	return &AIProxyStreamHandler{configService: configService, usageService: usageService}
 }
 
 // ProxyStreamRequest 代理流式请求
@@ -44,7 +48,8 @@ func (h *AIProxyStreamHandler) ProxyStream(c *gin.Context) {
 		return
 	}
 
-	providerCfg, err := h.configService.GetProviderConfigRaw(req.Provider)
+	userID := getUserIDFromContext(c)
+	providerCfg, userKey, err := service.ResolveAIProviderConfig(h.configService, userID, req.Provider)
 	if err != nil {
 		response.Fail(c, errors.CodeNotFound, "provider not found")
 		return
@@ -54,10 +59,31 @@ func (h *AIProxyStreamHandler) ProxyStream(c *gin.Context) {
 		return
 	}
 
+	if err := h.usageService.CheckBeforeCall(userID, req.Provider, nil); err != nil {
+		if !respondAIPreflightError(c, err) {
+			response.Fail(c, errors.CodeInternalError, "计费校验失败")
+		}
+		return
+	}
+
+	// 使用用户自有密钥时不经过平台密钥池
+	var lease *service.AIKeyLease
+	if !userKey {
+		lease, err = h.configService.AcquireAPIKey(req.Provider)
+		if err != nil {
+			response.Fail(c, errors.CodeInternalError, "获取API密钥失败")
+			return
+		}
+	}
+	apiKey := providerCfg.APIKey
+	if lease != nil {
+		apiKey = lease.APIKey
+	}
+
 	base := strings.TrimRight(providerCfg.BaseURL, "/")
 	url := base + "/" + strings.TrimLeft(req.Path, "/")
 
-	client := &http.Client{Timeout: 0}
+	client := service.NewAIHTTPClient(0, userKey)
 	proxyReq, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(req.Body))
 	if err != nil {
 		response.Fail(c, errors.CodeInternalError, "proxy request failed")
@@ -65,17 +91,25 @@ func (h *AIProxyStreamHandler) ProxyStream(c *gin.Context) {
 	}
 	proxyReq.Header.Set("Content-Type", "application/json")
 	proxyReq.Header.Set("Accept", "text/event-stream")
-	if providerCfg.APIKey != "" {
-		if req.Provider == "gemini" {
-			proxyReq.Header.Set("x-goog-api-key", providerCfg.APIKey)
-		} else {
-			proxyReq.Header.Set("Authorization", "Bearer "+providerCfg.APIKey)
+	service.SetAIProviderHeaders(proxyReq, req.Provider, apiKey)
+
+	// 供应商熔断中直接失败，不再等待上游超时
+	var permit *service.AICircuitPermit
+	if !userKey {
+		permit, err = service.AcquireAICircuit(req.Provider)
+		if err != nil {
+			respondAIPreflightError(c, err)
+			return
 		}
 	}
 
+	start := time.Now()
 	resp, err := client.Do(proxyReq)
 	if err != nil {
 		logger.Error("proxy stream request failed", logger.Err(err))
+		transportErr := &service.AITransportError{Err: err}
+		_ = h.configService.ReportAPIKeyResult(lease, transportErr)
+		service.ReportAICircuitResult(permit, transportErr)
 		response.Fail(c, errors.CodeExternalAPIError, "代理请求失败")
 		return
 	}
@@ -83,21 +117,45 @@ func (h *AIProxyStreamHandler) ProxyStream(c *gin.Context) {
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
+		upstreamErr := service.NewAIUpstreamError(resp, body)
+		_ = h.configService.ReportAPIKeyResult(lease, upstreamErr)
+		service.ReportAICircuitResult(permit, upstreamErr)
+		h.usageService.RecordProxyCall(service.AIUsageScope{
+			UserID:  userID,
+			Source:  "proxy",
+			UserKey: userKey,
+		}, req.Provider, req.Path, req.Body, body, true, time.Since(start), false)
 		response.Fail(c, errors.CodeExternalAPIError, string(body))
 		return
 	}
 
+	_ = h.configService.ReportAPIKeyResult(lease, nil)
+	service.ReportAICircuitResult(permit, nil)
+
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
 	c.Writer.Header().Set("Cache-Control", "no-cache")
 	c.Writer.Header().Set("Connection", "keep-alive")
 	c.Writer.Flush()
 
+	// 透传的同时留存一份 SSE 数据用于用量计量（超出上限后不再留存）
+	var captured bytes.Buffer
+	defer func() {
+		h.usageService.RecordProxyCall(service.AIUsageScope{
+			UserID:  userID,
+			Source:  "proxy",
+			UserKey: userKey,
+		}, req.Provider, req.Path, req.Body, captured.Bytes(), true, time.Since(start), true)
+	}()
+
 	buffer := make([]byte, 4096)
 	for {
 		read, err := resp.Body.Read(buffer)
 		if read > 0 {
 			_, _ = c.Writer.Write(buffer[:read])
 			c.Writer.Flush()
+			if captured.Len()+read <= proxyUsageCaptureLimit {
+				captured.Write(buffer[:read])
+			}
 		}
 		if err != nil {
 			if err != io.EOF {
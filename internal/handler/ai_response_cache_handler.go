@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"novel-agent-os-backend/internal/service"
+	"novel-agent-os-backend/pkg/errors"
+	"novel-agent-os-backend/pkg/response"
+)
+
+// AIResponseCacheHandler AI 响应缓存统计（管理员）
+type AIResponseCacheHandler struct {
+	cacheService service.AIResponseCacheService
+}
+
+// NewAIResponseCacheHandler 创建响应缓存处理器
+func NewAIResponseCacheHandler(cacheService service.AIResponseCacheService) *AIResponseCacheHandler {
+	return &AIResponseCacheHandler{cacheService: cacheService}
+}
+
+// Stats 缓存条目数与命中/未命中统计
+func (h *AIResponseCacheHandler) Stats(c *gin.Context) {
+	stats, err := h.cacheService.Stats()
+	if err != nil {
+		response.Fail(c, errors.CodeInternalError, "Failed to get cache stats")
+		return
+	}
+	response.SuccessWithData(c, stats)
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"novel-agent-os-backend/internal/repository"
+	"novel-agent-os-backend/internal/service"
+	"novel-agent-os-backend/pkg/errors"
+	"novel-agent-os-backend/pkg/response"
+)
+
+// AIUsageHandler AI 用量查询处理器
+type AIUsageHandler struct {
+	usageService   service.AIUsageService
+	projectService service.ProjectService
+}
+
+// NewAIUsageHandler 创建 AI 用量查询处理器
+func NewAIUsageHandler(usageService service.AIUsageService, projectService service.ProjectService) *AIUsageHandler {
+	return &AIUsageHandler{
+		usageService:   usageService,
+		projectService: projectService,
+	}
+}
+
+// Summary 当前用户的用量聚合（group_by=day|project|model|provider|session）
+func (h *AIUsageHandler) Summary(c *gin.Context) {
+	userID := getUserIDFromContext(c)
+	if userID == 0 {
+		response.Fail(c, errors.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	filter, ok := h.bindUsageFilter(c, userID, true)
+	if !ok {
+		return
+	}
+	h.writeSummary(c, filter)
+}
+
+// ListRecords 当前用户的用量明细
+func (h *AIUsageHandler) ListRecords(c *gin.Context) {
+	userID := getUserIDFromContext(c)
+	if userID == 0 {
+		response.Fail(c, errors.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	filter, ok := h.bindUsageFilter(c, userID, true)
+	if !ok {
+		return
+	}
+	h.writeRecords(c, filter)
+}
+
+// AdminSummary 全站用量聚合（管理员，可按 user_id 过滤，支持 group_by=user）
+func (h *AIUsageHandler) AdminSummary(c *gin.Context) {
+	filter, ok := h.bindUsageFilter(c, parseUintQuery(c, "user_id", 0), false)
+	if !ok {
+		return
+	}
+	h.writeSummary(c, filter)
+}
+
+// AdminListRecords 全站用量明细（管理员）
+func (h *AIUsageHandler) AdminListRecords(c *gin.Context) {
+	filter, ok := h.bindUsageFilter(c, parseUintQuery(c, "user_id", 0), false)
+	if !ok {
+		return
+	}
+	h.writeRecords(c, filter)
+}
+
+func (h *AIUsageHandler) writeSummary(c *gin.Context, filter repository.AIUsageFilter) {
+	groupBy := c.DefaultQuery("group_by", "day")
+	items, err := h.usageService.Summary(filter, groupBy)
+	if err != nil {
+		response.Fail(c, errors.CodeInvalidParams, err.Error())
+		return
+	}
+	response.SuccessWithData(c, gin.H{
+		"group_by": groupBy,
+		"items":    items,
+	})
+}
+
+func (h *AIUsageHandler) writeRecords(c *gin.Context, filter repository.AIUsageFilter) {
+	page := parseIntQuery(c, "page", 1)
+	size := parseIntQuery(c, "size", 20)
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 20
+	}
+	records, total, err := h.usageService.ListRecords(filter, page, size)
+	if err != nil {
+		response.Fail(c, errors.CodeInternalError, "查询用量失败")
+		return
+	}
+	response.SuccessWithPage(c, records, total, page, size)
+}
+
+// bindUsageFilter 解析公共查询参数；checkOwner 时 project_id 需属于 userID
+func (h *AIUsageHandler) bindUsageFilter(c *gin.Context, userID uint, checkOwner bool) (repository.AIUsageFilter, bool) {
+	filter := repository.AIUsageFilter{
+		UserID:    userID,
+		ProjectID: parseUintQuery(c, "project_id", 0),
+		SessionID: parseUintQuery(c, "session_id", 0),
+		Provider:  c.Query("provider"),
+		Model:     c.Query("model"),
+		From:      c.Query("from"),
+		To:        c.Query("to"),
+	}
+	for _, value := range []string{filter.From, filter.To} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			response.Fail(c, errors.CodeInvalidParams, "from/to 格式应为 YYYY-MM-DD")
+			return filter, false
+		}
+	}
+	if checkOwner && filter.ProjectID != 0 {
+		project, err := h.projectService.GetByID(filter.ProjectID)
+		if err != nil {
+			response.Fail(c, errors.CodeNotFound, "项目不存在")
+			return filter, false
+		}
+		if project.UserID != userID {
+			response.Fail(c, errors.CodeForbidden, "无权访问该项目")
+			return filter, false
+		}
+	}
+	return filter, true
+}
@@ -14,3 +14,9 @@ func getUserIDFromContext(c *gin.Context) uint {
 	}
 	return uid
 }
+
+// isAdminFromContext 当前用户是否为管理员
+func isAdminFromContext(c *gin.Context) bool {
+	role, _ := c.Get("userRole")
+	return role == "admin"
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	stderrors "errors"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -15,14 +16,16 @@ type DocumentHandler struct {
 	documentService service.DocumentService
 	projectService  service.ProjectService
 	volumeService   service.VolumeService
+	proposalService service.DocumentProposalService
 }
 
 // NewDocumentHandler 创建文档处理器
-func NewDocumentHandler(documentService service.DocumentService, projectService service.ProjectService, volumeService service.VolumeService) *DocumentHandler {
+func NewDocumentHandler(documentService service.DocumentService, projectService service.ProjectService, volumeService service.VolumeService, proposalService service.DocumentProposalService) *DocumentHandler {
 	return &DocumentHandler{
 		documentService: documentService,
 		projectService:  projectService,
 		volumeService:   volumeService,
+		proposalService: proposalService,
 	}
 }
 
@@ -186,6 +189,7 @@ func (h *DocumentHandler) Create(c *gin.Context) {
 		req.CauseEffect,
 		req.ForeshadowingDetails,
 		req.VolumeID,
+		service.DocumentRevisionSource{UserID: getUserIDFromContext(c)},
 	)
 	if err != nil {
 		response.Error(c, err)
@@ -335,7 +339,7 @@ func (h *DocumentHandler) Update(c *gin.Context) {
 		updates["volume_id"] = *req.VolumeID
 	}
 
-	document, err := h.documentService.Update(id, updates)
+	document, err := h.documentService.Update(id, updates, service.DocumentRevisionSource{UserID: getUserIDFromContext(c)})
 	if err != nil {
 		response.Error(c, err)
 		return
@@ -469,3 +473,245 @@ func (h *DocumentHandler) UnlinkEntity(c *gin.Context) {
 
 	response.Success(c)
 }
+
+// ListRevisions 获取文档修订列表（不含正文）
+func (h *DocumentHandler) ListRevisions(c *gin.Context) {
+	id, err := parseUintParam(c, "id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+	if _, ok := h.ensureDocumentOwner(c, id); !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 20
+	}
+
+	revisions, total, err := h.documentService.ListRevisions(id, page, size)
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.SuccessWithPage(c, revisions, total, page, size)
+}
+
+// GetRevision 获取修订详情（含正文）
+func (h *DocumentHandler) GetRevision(c *gin.Context) {
+	id, err := parseUintParam(c, "id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+	if _, ok := h.ensureDocumentOwner(c, id); !ok {
+		return
+	}
+	revisionID, err := parseUintParam(c, "revision_id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+
+	revision, err := h.documentService.GetRevision(id, revisionID)
+	if err != nil {
+		respondRevisionError(c, err)
+		return
+	}
+	response.SuccessWithData(c, revision)
+}
+
+// DiffRevisions 比较两个修订；未传 to 时与当前正文比较
+func (h *DocumentHandler) DiffRevisions(c *gin.Context) {
+	id, err := parseUintParam(c, "id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+	if _, ok := h.ensureDocumentOwner(c, id); !ok {
+		return
+	}
+
+	fromID, err := strconv.ParseUint(c.Query("from"), 10, 64)
+	if err != nil || fromID == 0 {
+		response.Fail(c, errors.CodeInvalidParams, "from 必须为修订ID")
+		return
+	}
+	var toID uint64
+	if raw := c.Query("to"); raw != "" {
+		toID, err = strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			response.Fail(c, errors.CodeInvalidParams, "to 必须为修订ID")
+			return
+		}
+	}
+
+	diff, err := h.documentService.DiffRevisions(id, uint(fromID), uint(toID))
+	if err != nil {
+		respondRevisionError(c, err)
+		return
+	}
+	response.SuccessWithData(c, diff)
+}
+
+// RestoreRevision 将正文恢复为指定修订
+func (h *DocumentHandler) RestoreRevision(c *gin.Context) {
+	id, err := parseUintParam(c, "id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+	if _, ok := h.ensureDocumentOwner(c, id); !ok {
+		return
+	}
+	revisionID, err := parseUintParam(c, "revision_id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+
+	document, err := h.documentService.RestoreRevision(id, revisionID, service.DocumentRevisionSource{UserID: getUserIDFromContext(c)})
+	if err != nil {
+		respondRevisionError(c, err)
+		return
+	}
+	response.SuccessWithData(c, document)
+}
+
+func respondRevisionError(c *gin.Context, err error) {
+	if stderrors.Is(err, service.ErrDocumentRevisionNotFound) {
+		response.Fail(c, errors.CodeNotFound, "修订不存在")
+		return
+	}
+	response.Error(c, err)
+}
+
+// AcceptProposalHunksRequest 采纳部分改动块请求
+type AcceptProposalHunksRequest struct {
+	Hunks []int `json:"hunks" binding:"required,min=1"`
+}
+
+// ListProposals 获取文档提案列表（不含正文），可按 status 过滤
+func (h *DocumentHandler) ListProposals(c *gin.Context) {
+	id, err := parseUintParam(c, "id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+	if _, ok := h.ensureDocumentOwner(c, id); !ok {
+		return
+	}
+
+	proposals, err := h.proposalService.List(id, c.Query("status"))
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+	response.SuccessWithData(c, proposals)
+}
+
+// GetProposal 获取提案详情及段落级改动块
+func (h *DocumentHandler) GetProposal(c *gin.Context) {
+	id, proposalID, ok := h.parseProposalParams(c)
+	if !ok {
+		return
+	}
+
+	detail, err := h.proposalService.Get(id, proposalID)
+	if err != nil {
+		respondProposalError(c, err)
+		return
+	}
+	response.SuccessWithData(c, detail)
+}
+
+// AcceptProposal 采纳提案的全部改动
+func (h *DocumentHandler) AcceptProposal(c *gin.Context) {
+	id, proposalID, ok := h.parseProposalParams(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.proposalService.AcceptAll(id, proposalID, getUserIDFromContext(c))
+	if err != nil {
+		respondProposalError(c, err)
+		return
+	}
+	response.SuccessWithData(c, result)
+}
+
+// AcceptProposalHunks 只采纳选中的改动块
+func (h *DocumentHandler) AcceptProposalHunks(c *gin.Context) {
+	id, proposalID, ok := h.parseProposalParams(c)
+	if !ok {
+		return
+	}
+
+	var req AcceptProposalHunksRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Warn("采纳提案请求参数错误", logger.Err(err))
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+
+	result, err := h.proposalService.AcceptHunks(id, proposalID, req.Hunks, getUserIDFromContext(c))
+	if err != nil {
+		respondProposalError(c, err)
+		return
+	}
+	response.SuccessWithData(c, result)
+}
+
+// RejectProposal 拒绝提案
+func (h *DocumentHandler) RejectProposal(c *gin.Context) {
+	id, proposalID, ok := h.parseProposalParams(c)
+	if !ok {
+		return
+	}
+
+	proposal, err := h.proposalService.Reject(id, proposalID)
+	if err != nil {
+		respondProposalError(c, err)
+		return
+	}
+	response.SuccessWithData(c, proposal)
+}
+
+func (h *DocumentHandler) parseProposalParams(c *gin.Context) (uint, uint, bool) {
+	id, err := parseUintParam(c, "id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return 0, 0, false
+	}
+	if _, ok := h.ensureDocumentOwner(c, id); !ok {
+		return 0, 0, false
+	}
+	proposalID, err := parseUintParam(c, "proposal_id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return 0, 0, false
+	}
+	return id, proposalID, true
+}
+
+func respondProposalError(c *gin.Context, err error) {
+	switch {
+	case stderrors.Is(err, service.ErrDocumentProposalNotFound):
+		response.Fail(c, errors.CodeNotFound, "提案不存在")
+	case stderrors.Is(err, service.ErrDocumentProposalResolved):
+		response.Fail(c, errors.CodeInvalidParams, "提案已处理")
+	case stderrors.Is(err, service.ErrDocumentProposalStale):
+		response.Fail(c, errors.CodeInvalidParams, "文档正文已在提案生成后修改，请重新生成")
+	case stderrors.Is(err, service.ErrDocumentProposalHunk):
+		response.Fail(c, errors.CodeInvalidParams, "改动块下标无效")
+	default:
+		response.Error(c, err)
+	}
+}
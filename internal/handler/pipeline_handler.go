@@ -0,0 +1,343 @@
+package handler
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"strconv"
+
+	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/internal/service"
+	"novel-agent-os-backend/pkg/errors"
+	"novel-agent-os-backend/pkg/logger"
+	"novel-agent-os-backend/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PipelineHandler 自定义工作流流水线处理器
+type PipelineHandler struct {
+	pipelineService service.PipelineService
+	projectService  service.ProjectService
+	sessionService  service.SessionService
+}
+
+// NewPipelineHandler 创建流水线处理器
+func NewPipelineHandler(pipelineService service.PipelineService, projectService service.ProjectService, sessionService service.SessionService) *PipelineHandler {
+	return &PipelineHandler{
+		pipelineService: pipelineService,
+		projectService:  projectService,
+		sessionService:  sessionService,
+	}
+}
+
+func (h *PipelineHandler) ensureProjectOwner(c *gin.Context, projectID uint) bool {
+	userID := getUserIDFromContext(c)
+	if userID == 0 {
+		response.Error(c, errors.ErrUnauthorized)
+		return false
+	}
+	project, err := h.projectService.GetByID(projectID)
+	if err != nil {
+		response.Error(c, errors.ErrProjectNotFound)
+		return false
+	}
+	if project.UserID != userID {
+		response.Error(c, errors.ErrForbidden)
+		return false
+	}
+	return true
+}
+
+// ensurePipelineOwner 读取路径中的流水线并校验所属项目归属
+func (h *PipelineHandler) ensurePipelineOwner(c *gin.Context) (*model.Pipeline, bool) {
+	id, err := parseUintParam(c, "id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return nil, false
+	}
+	pipeline, err := h.pipelineService.GetByID(id)
+	if err != nil {
+		response.Fail(c, errors.CodeNotFound, "流水线不存在")
+		return nil, false
+	}
+	if !h.ensureProjectOwner(c, pipeline.ProjectID) {
+		return nil, false
+	}
+	return pipeline, true
+}
+
+// SavePipelineRequest 创建/更新流水线请求：definition（JSON 对象）与 source（JSON/YAML 文本）二选一
+type SavePipelineRequest struct {
+	Name        string          `json:"name" binding:"max=100"`
+	Description string          `json:"description"`
+	Definition  json.RawMessage `json:"definition"`
+	Source      string          `json:"source"`
+	Format      string          `json:"format"`
+}
+
+// RunPipelineRequest 启动流水线请求
+type RunPipelineRequest struct {
+	SessionID uint                   `json:"session_id"`
+	Inputs    map[string]interface{} `json:"inputs"`
+}
+
+// Create 创建流水线
+func (h *PipelineHandler) Create(c *gin.Context) {
+	projectID, err := parseUintParam(c, "project_id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+	if !h.ensureProjectOwner(c, projectID) {
+		return
+	}
+
+	var req SavePipelineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Warn("创建流水线请求参数错误", logger.Err(err))
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+
+	pipeline, err := h.pipelineService.Create(projectID, getUserIDFromContext(c), req.toService())
+	if err != nil {
+		if respondPipelineValidationError(c, err) {
+			return
+		}
+		response.Error(c, err)
+		return
+	}
+
+	response.SuccessWithData(c, pipeline)
+}
+
+// ListByProject 分页获取项目的流水线（不含定义）
+func (h *PipelineHandler) ListByProject(c *gin.Context) {
+	projectID, err := parseUintParam(c, "project_id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+	if !h.ensureProjectOwner(c, projectID) {
+		return
+	}
+
+	page, size := parsePipelinePage(c)
+	pipelines, total, err := h.pipelineService.ListByProjectID(projectID, page, size)
+	if err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.SuccessWithPage(c, pipelines, total, page, size)
+}
+
+// GetByID 获取流水线
+func (h *PipelineHandler) GetByID(c *gin.Context) {
+	pipeline, ok := h.ensurePipelineOwner(c)
+	if !ok {
+		return
+	}
+
+	response.SuccessWithData(c, pipeline)
+}
+
+// Update 更新流水线
+func (h *PipelineHandler) Update(c *gin.Context) {
+	pipeline, ok := h.ensurePipelineOwner(c)
+	if !ok {
+		return
+	}
+
+	var req SavePipelineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Warn("更新流水线请求参数错误", logger.Err(err))
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+
+	updated, err := h.pipelineService.Update(pipeline.ID, req.toService())
+	if err != nil {
+		if respondPipelineValidationError(c, err) {
+			return
+		}
+		response.Error(c, err)
+		return
+	}
+
+	response.SuccessWithData(c, updated)
+}
+
+// Delete 删除流水线
+func (h *PipelineHandler) Delete(c *gin.Context) {
+	pipeline, ok := h.ensurePipelineOwner(c)
+	if !ok {
+		return
+	}
+
+	if err := h.pipelineService.Delete(pipeline.ID); err != nil {
+		response.Error(c, err)
+		return
+	}
+
+	response.Success(c)
+}
+
+// Run 启动流水线（后台执行，步骤与进度通过会话 SSE 推送）
+func (h *PipelineHandler) Run(c *gin.Context) {
+	pipeline, ok := h.ensurePipelineOwner(c)
+	if !ok {
+		return
+	}
+
+	var req RunPipelineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, errors.CodeInvalidParams, "Invalid request body")
+		return
+	}
+
+	userID := getUserIDFromContext(c)
+	var sess *model.Session
+	if req.SessionID > 0 {
+		existing, err := h.sessionService.GetSession(req.SessionID)
+		if err != nil {
+			response.Fail(c, errors.CodeSessionNotFound, "Session not found")
+			return
+		}
+		if existing.UserID != userID {
+			response.Fail(c, errors.CodeForbidden, "Access denied")
+			return
+		}
+		sess = existing
+	}
+
+	result, err := h.pipelineService.Run(service.PipelineRunRequest{
+		PipelineID:          pipeline.ID,
+		UserID:              userID,
+		Session:             sess,
+		Inputs:              req.Inputs,
+		AuthorizationHeader: c.GetHeader("Authorization"),
+	})
+	if err != nil {
+		if respondPipelineValidationError(c, err) {
+			return
+		}
+		response.Fail(c, errors.CodeInternalError, "Failed to run pipeline")
+		return
+	}
+
+	response.SuccessWithData(c, gin.H{
+		"session": result.Session,
+		"job":     result.Job.ToPublic(),
+		"run":     result.Run,
+	})
+}
+
+// ListRuns 分页获取流水线的执行任务
+func (h *PipelineHandler) ListRuns(c *gin.Context) {
+	pipeline, ok := h.ensurePipelineOwner(c)
+	if !ok {
+		return
+	}
+
+	page, size := parsePipelinePage(c)
+	jobs, total, err := h.pipelineService.ListRuns(pipeline.ID, page, size)
+	if err != nil {
+		response.Fail(c, errors.CodeInternalError, "Failed to list pipeline runs")
+		return
+	}
+	list := make([]model.JobPublic, 0, len(jobs))
+	for _, job := range jobs {
+		list = append(list, job.ToPublic())
+	}
+
+	response.SuccessWithPage(c, list, total, page, size)
+}
+
+// GetRun 查询执行任务、执行位置与变量
+func (h *PipelineHandler) GetRun(c *gin.Context) {
+	h.handleRun(c, h.pipelineService.GetRun)
+}
+
+// PauseRun 请求暂停执行（当前步骤完成后生效）
+func (h *PipelineHandler) PauseRun(c *gin.Context) {
+	h.handleRun(c, h.pipelineService.PauseRun)
+}
+
+// ResumeRun 从下一个待执行的步骤继续执行
+func (h *PipelineHandler) ResumeRun(c *gin.Context) {
+	h.handleRun(c, func(userID uint, jobUUID string) (*service.PipelineRunResult, error) {
+		return h.pipelineService.ResumeRun(userID, jobUUID, c.GetHeader("Authorization"))
+	})
+}
+
+// CancelRun 取消执行
+func (h *PipelineHandler) CancelRun(c *gin.Context) {
+	h.handleRun(c, h.pipelineService.CancelRun)
+}
+
+func (h *PipelineHandler) handleRun(c *gin.Context, action func(userID uint, jobUUID string) (*service.PipelineRunResult, error)) {
+	userID := getUserIDFromContext(c)
+	if userID == 0 {
+		response.Fail(c, errors.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	result, err := action(userID, c.Param("job_uuid"))
+	if err != nil {
+		respondPipelineRunError(c, err)
+		return
+	}
+	response.SuccessWithData(c, gin.H{
+		"job": result.Job.ToPublic(),
+		"run": result.Run,
+	})
+}
+
+func (r SavePipelineRequest) toService() service.PipelineSaveRequest {
+	return service.PipelineSaveRequest{
+		Name:        r.Name,
+		Description: r.Description,
+		Definition:  r.Definition,
+		Source:      r.Source,
+		Format:      r.Format,
+	}
+}
+
+func parsePipelinePage(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 20
+	}
+	return page, size
+}
+
+// respondPipelineValidationError 流水线定义或启动输入不合法时返回具体原因
+func respondPipelineValidationError(c *gin.Context, err error) bool {
+	var validationErr *service.PipelineValidationError
+	if stderrors.As(err, &validationErr) {
+		response.Fail(c, errors.CodeValidationError, validationErr.Message)
+		return true
+	}
+	return false
+}
+
+func respondPipelineRunError(c *gin.Context, err error) {
+	switch {
+	case stderrors.Is(err, service.ErrJobAccessDenied):
+		response.Fail(c, errors.CodeJobAccessDenied, "Access denied")
+	case stderrors.Is(err, service.ErrJobActive):
+		response.Fail(c, errors.CodeInvalidParams, "Job is still running")
+	case stderrors.Is(err, service.ErrPipelineRunNotRunning):
+		response.Fail(c, errors.CodeInvalidParams, "Pipeline run is not running")
+	case stderrors.Is(err, service.ErrPipelineRunNotResumable):
+		response.Fail(c, errors.CodeInvalidParams, "Pipeline run cannot be resumed")
+	case stderrors.Is(err, service.ErrJobNotFound):
+		response.Fail(c, errors.CodeJobNotFound, "Job not found")
+	default:
+		response.Fail(c, errors.CodeInternalError, "Failed to update pipeline run")
+	}
+}
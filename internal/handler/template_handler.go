@@ -1,6 +1,7 @@
 package handler
 
 import (
+	stderrors "errors"
 	"strconv"
 
 	"novel-agent-os-backend/internal/model"
@@ -80,10 +81,11 @@ func (h *TemplateHandler) ensureTemplateOwner(c *gin.Context, templateID uint, w
 
 // CreateTemplateRequest 创建模板请求
 type CreateTemplateRequest struct {
-	Name        string `json:"name" binding:"required,max=100"`
-	Description string `json:"description"`
-	Category    string `json:"category"`
-	Template    string `json:"template" binding:"required"`
+	Name        string                   `json:"name" binding:"required,max=100"`
+	Description string                   `json:"description"`
+	Category    string                   `json:"category"`
+	Template    string                   `json:"template" binding:"required"`
+	Variables   []model.TemplateVariable `json:"variables"`
 }
 
 // UpdateTemplateRequest 更新模板请求
@@ -92,6 +94,18 @@ type UpdateTemplateRequest struct {
 	Description string `json:"description"`
 	Category    string `json:"category"`
 	Template    string `json:"template"`
+	// Variables 传入时整体替换变量声明（空数组清空）
+	Variables *[]model.TemplateVariable `json:"variables"`
+}
+
+// RenderTemplateRequest 模板渲染预览请求
+type RenderTemplateRequest struct {
+	ProjectID  uint                   `json:"project_id"`
+	VolumeID   uint                   `json:"volume_id"`
+	DocumentID uint                   `json:"document_id"`
+	EntityIDs  []uint                 `json:"entity_ids"`
+	Vars       map[string]interface{} `json:"vars"`
+	Item       map[string]string      `json:"item"`
 }
 
 // Create 创建模板
@@ -118,8 +132,12 @@ func (h *TemplateHandler) Create(c *gin.Context) {
 		req.Description,
 		req.Category,
 		req.Template,
+		req.Variables,
 	)
 	if err != nil {
+		if respondTemplateError(c, err) {
+			return
+		}
 		response.Error(c, err)
 		return
 	}
@@ -224,9 +242,15 @@ func (h *TemplateHandler) Update(c *gin.Context) {
 	if req.Template != "" {
 		updates["template"] = req.Template
 	}
+	if req.Variables != nil {
+		updates["variables"] = *req.Variables
+	}
 
 	template, err := h.templateService.Update(id, updates)
 	if err != nil {
+		if respondTemplateError(c, err) {
+			return
+		}
 		response.Error(c, err)
 		return
 	}
@@ -252,3 +276,58 @@ func (h *TemplateHandler) Delete(c *gin.Context) {
 
 	response.Success(c)
 }
+
+// Render 预览模板渲染结果（不调用模型）
+func (h *TemplateHandler) Render(c *gin.Context) {
+	id, err := parseUintParam(c, "id")
+	if err != nil {
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+	tmpl, ok := h.ensureTemplateOwner(c, id, false)
+	if !ok {
+		return
+	}
+
+	var req RenderTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Warn("渲染模板请求参数错误", logger.Err(err))
+		response.Error(c, errors.ErrInvalidParams)
+		return
+	}
+	// 项目模板默认绑定所属项目；系统模板绑定项目时校验项目归属
+	if req.ProjectID == 0 {
+		req.ProjectID = tmpl.ProjectID
+	}
+	if req.ProjectID > 0 && req.ProjectID != tmpl.ProjectID && !h.ensureProjectOwner(c, req.ProjectID) {
+		return
+	}
+
+	result, err := h.templateService.Render(id, service.TemplateRenderRequest{
+		ProjectID:  req.ProjectID,
+		VolumeID:   req.VolumeID,
+		DocumentID: req.DocumentID,
+		EntityIDs:  req.EntityIDs,
+		Vars:       req.Vars,
+		Item:       req.Item,
+	})
+	if err != nil {
+		if respondTemplateError(c, err) {
+			return
+		}
+		response.Error(c, err)
+		return
+	}
+
+	response.SuccessWithData(c, result)
+}
+
+// respondTemplateError 模板语法、变量或渲染错误返回具体原因
+func respondTemplateError(c *gin.Context, err error) bool {
+	var validationErr *service.TemplateValidationError
+	if stderrors.As(err, &validationErr) {
+		response.Fail(c, errors.CodeValidationError, validationErr.Message)
+		return true
+	}
+	return false
+}
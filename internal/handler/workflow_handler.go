@@ -1,6 +1,9 @@
 package handler
 
 import (
+	stderrors "errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"novel-agent-os-backend/internal/model"
@@ -78,14 +81,65 @@ func (h *WorkflowHandler) ensureVolumeInProject(c *gin.Context, projectID uint,
 	return true
 }
 
+// ensureBodyOrChat 校验请求体：原始 body 需配合 path 透传，或使用供应商无关的 chat 由后端编码
+func ensureBodyOrChat(c *gin.Context, path, body string, chat *service.ChatRequest) bool {
+	if chat != nil {
+		if err := chat.Validate(); err != nil {
+			response.Fail(c, errors.CodeInvalidParams, "Invalid chat: "+err.Error())
+			return false
+		}
+		return true
+	}
+	if strings.TrimSpace(body) == "" || strings.TrimSpace(path) == "" {
+		response.Fail(c, errors.CodeInvalidParams, "Body and path or chat required")
+		return false
+	}
+	return true
+}
+
+// ensureBodyOrChatWithTemplate 引用提示词模板时 chat 必填，chat.messages 可为空（由模板渲染结果补齐）
+func ensureBodyOrChatWithTemplate(c *gin.Context, path, body string, chat *service.ChatRequest, tmpl *service.PromptTemplateRef) bool {
+	if tmpl == nil {
+		return ensureBodyOrChat(c, path, body, chat)
+	}
+	if tmpl.ID == 0 {
+		response.Fail(c, errors.CodeInvalidParams, "prompt_template.id required")
+		return false
+	}
+	if !service.IsValidPromptTemplateRole(tmpl.Role) {
+		response.Fail(c, errors.CodeInvalidParams, "Invalid prompt_template.role")
+		return false
+	}
+	if chat == nil {
+		response.Fail(c, errors.CodeInvalidParams, "Chat required with prompt_template")
+		return false
+	}
+	probe := chat.Clone()
+	probe.Messages = append(probe.Messages, service.ChatMessage{Role: "user", Content: "prompt_template"})
+	return ensureBodyOrChat(c, path, body, probe)
+}
+
+// ensureCacheMode 校验响应缓存模式：空（temperature 为 0 时缓存）、force、bypass
+func ensureCacheMode(c *gin.Context, mode string) bool {
+	if !service.IsValidAICacheMode(mode) {
+		response.Fail(c, errors.CodeInvalidParams, "Invalid cache mode")
+		return false
+	}
+	return true
+}
+
 type RunWorkflowRequest struct {
-	ProjectID uint   `json:"project_id" binding:"required"`
-	SessionID uint   `json:"session_id"`
-	Title     string `json:"title"`
-	StepTitle string `json:"step_title"`
-	Provider  string `json:"provider" binding:"required"`
-	Path      string `json:"path" binding:"required"`
-	Body      string `json:"body" binding:"required"`
+	ProjectID uint                 `json:"project_id" binding:"required"`
+	SessionID uint                 `json:"session_id"`
+	Title     string               `json:"title"`
+	StepTitle string               `json:"step_title"`
+	Provider  string               `json:"provider" binding:"required"`
+	Path      string               `json:"path"`
+	Body      string               `json:"body"`
+	Chat      *service.ChatRequest `json:"chat"`
+	Cache     string               `json:"cache"`
+	// PromptTemplate 引用模板渲染提示词，渲染结果追加到 chat.messages
+	PromptTemplate *service.PromptTemplateRef `json:"prompt_template"`
 }
 
 type ChapterWriteBack struct {
@@ -95,37 +149,59 @@ type ChapterWriteBack struct {
 }
 
 type ChapterGenerateRequest struct {
-	ProjectID  uint             `json:"project_id" binding:"required"`
-	SessionID  uint             `json:"session_id"`
-	DocumentID uint             `json:"document_id"`
-	VolumeID   uint             `json:"volume_id"`
-	Title      string           `json:"title"`
-	OrderIndex int              `json:"order_index"`
-	Provider   string           `json:"provider" binding:"required"`
-	Path       string           `json:"path" binding:"required"`
-	Body       string           `json:"body" binding:"required"`
-	WriteBack  ChapterWriteBack `json:"write_back"`
+	ProjectID      uint                           `json:"project_id" binding:"required"`
+	SessionID      uint                           `json:"session_id"`
+	DocumentID     uint                           `json:"document_id"`
+	VolumeID       uint                           `json:"volume_id"`
+	Title          string                         `json:"title"`
+	OrderIndex     int                            `json:"order_index"`
+	Provider       string                         `json:"provider" binding:"required"`
+	Path           string                         `json:"path"`
+	Body           string                         `json:"body"`
+	Chat           *service.ChatRequest           `json:"chat"`
+	Cache          string                         `json:"cache"`
+	PromptTemplate *service.PromptTemplateRef     `json:"prompt_template"`
+	Context        *service.ChapterContextOptions `json:"context"`
+	QualityGate    *service.QualityGateOptions    `json:"quality_gate"`
+	WriteBack      ChapterWriteBack               `json:"write_back"`
 }
 
 type ChapterAnalyzeRequest struct {
-	ProjectID  uint             `json:"project_id" binding:"required"`
-	SessionID  uint             `json:"session_id"`
-	DocumentID uint             `json:"document_id" binding:"required"`
-	Provider   string           `json:"provider" binding:"required"`
-	Path       string           `json:"path" binding:"required"`
-	Body       string           `json:"body" binding:"required"`
-	WriteBack  ChapterWriteBack `json:"write_back"`
+	ProjectID      uint                       `json:"project_id" binding:"required"`
+	SessionID      uint                       `json:"session_id"`
+	DocumentID     uint                       `json:"document_id" binding:"required"`
+	Provider       string                     `json:"provider" binding:"required"`
+	Path           string                     `json:"path"`
+	Body           string                     `json:"body"`
+	Chat           *service.ChatRequest       `json:"chat"`
+	Cache          string                     `json:"cache"`
+	PromptTemplate *service.PromptTemplateRef `json:"prompt_template"`
+	WriteBack      ChapterWriteBack           `json:"write_back"`
 }
 
 type ChapterRewriteRequest struct {
-	ProjectID   uint             `json:"project_id" binding:"required"`
-	SessionID   uint             `json:"session_id"`
-	DocumentID  uint             `json:"document_id" binding:"required"`
-	RewriteMode string           `json:"rewrite_mode"`
-	Provider    string           `json:"provider" binding:"required"`
-	Path        string           `json:"path" binding:"required"`
-	Body        string           `json:"body" binding:"required"`
-	WriteBack   ChapterWriteBack `json:"write_back"`
+	ProjectID      uint                           `json:"project_id" binding:"required"`
+	SessionID      uint                           `json:"session_id"`
+	DocumentID     uint                           `json:"document_id" binding:"required"`
+	RewriteMode    string                         `json:"rewrite_mode"`
+	Provider       string                         `json:"provider" binding:"required"`
+	Path           string                         `json:"path"`
+	Body           string                         `json:"body"`
+	Chat           *service.ChatRequest           `json:"chat"`
+	Cache          string                         `json:"cache"`
+	PromptTemplate *service.PromptTemplateRef     `json:"prompt_template"`
+	Context        *service.ChapterContextOptions `json:"context"`
+	QualityGate    *service.QualityGateOptions    `json:"quality_gate"`
+	WriteBack      ChapterWriteBack               `json:"write_back"`
+}
+
+// ChapterContextPreviewRequest 章节上下文预览请求
+type ChapterContextPreviewRequest struct {
+	ProjectID   uint `json:"project_id" binding:"required"`
+	VolumeID    uint `json:"volume_id"`
+	DocumentID  uint `json:"document_id"`
+	OrderIndex  int  `json:"order_index"`
+	TokenBudget int  `json:"token_budget"`
 }
 
 type ChapterBatchItem struct {
@@ -136,14 +212,20 @@ type ChapterBatchItem struct {
 }
 
 type ChapterBatchRequest struct {
-	ProjectID    uint               `json:"project_id" binding:"required"`
-	SessionID    uint               `json:"session_id"`
-	VolumeID     uint               `json:"volume_id"`
-	Items        []ChapterBatchItem `json:"items" binding:"required"`
-	Provider     string             `json:"provider" binding:"required"`
-	Path         string             `json:"path" binding:"required"`
-	BodyTemplate string             `json:"body_template" binding:"required"`
-	WriteBack    ChapterWriteBack   `json:"write_back"`
+	ProjectID    uint                 `json:"project_id" binding:"required"`
+	SessionID    uint                 `json:"session_id"`
+	VolumeID     uint                 `json:"volume_id"`
+	Items        []ChapterBatchItem   `json:"items" binding:"required"`
+	Provider     string               `json:"provider" binding:"required"`
+	Path         string               `json:"path"`
+	BodyTemplate string               `json:"body_template"`
+	ChatTemplate *service.ChatRequest `json:"chat_template"`
+	Cache        string               `json:"cache"`
+	// PromptTemplate 每个 item 渲染一次，模板中可用 .item.title / .item.outline
+	PromptTemplate *service.PromptTemplateRef `json:"prompt_template"`
+	WriteBack      ChapterWriteBack           `json:"write_back"`
+	// Parallelism 同时生成的章节数，0 使用服务端默认值
+	Parallelism int `json:"parallelism"`
 }
 
 func (h *WorkflowHandler) RunWorld(c *gin.Context) {
@@ -162,6 +244,110 @@ func (h *WorkflowHandler) RunWizardOutline(c *gin.Context) {
 	h.runWorkflow(c, "wizard.outline", "向导·大纲")
 }
 
+// RunWizardWorldStructured 世界观结构化输出，返回待确认的项目设定变更
+func (h *WorkflowHandler) RunWizardWorldStructured(c *gin.Context) {
+	h.runWizardStructured(c, service.WizardKindWorld, "向导·世界观")
+}
+
+// RunWizardCharactersStructured 角色结构化输出，返回待确认的实体/标签/关联变更
+func (h *WorkflowHandler) RunWizardCharactersStructured(c *gin.Context) {
+	h.runWizardStructured(c, service.WizardKindCharacters, "向导·角色")
+}
+
+// RunWizardOutlineStructured 大纲结构化输出，返回待确认的卷/章节骨架变更
+func (h *WorkflowHandler) RunWizardOutlineStructured(c *gin.Context) {
+	h.runWizardStructured(c, service.WizardKindOutline, "向导·大纲")
+}
+
+func (h *WorkflowHandler) runWizardStructured(c *gin.Context, kind string, defaultTitle string) {
+	runReq, ok := h.bindRunWorkflow(c, "wizard."+kind+".structured", defaultTitle)
+	if !ok {
+		return
+	}
+	if runReq.Chat == nil {
+		response.Fail(c, errors.CodeInvalidParams, "Chat required for structured output")
+		return
+	}
+
+	result, err := h.workflowService.RunWizardStructured(runReq, kind)
+	if err != nil {
+		if respondAIPreflightError(c, err) || respondTemplateError(c, err) || respondWizardError(c, err) {
+			return
+		}
+		response.Fail(c, errors.CodeInternalError, "Failed to run workflow")
+		return
+	}
+
+	response.SuccessWithData(c, gin.H{
+		"session":  result.Session,
+		"step":     result.Step,
+		"content":  result.Content,
+		"raw":      result.Raw,
+		"proposal": result.Proposal,
+	})
+}
+
+// CommitWizardRequest 确认提交向导结构化结果
+type CommitWizardRequest struct {
+	StepID uint `json:"step_id" binding:"required"`
+}
+
+// CommitWizardProposal 写入结构化向导步骤中待确认的结果（按当前项目状态重新比对）
+func (h *WorkflowHandler) CommitWizardProposal(c *gin.Context) {
+	var req CommitWizardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, errors.CodeInvalidParams, "Invalid request body")
+		return
+	}
+
+	userID := getUserIDFromContext(c)
+	if userID == 0 {
+		response.Fail(c, errors.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	step, err := h.sessionService.GetStep(req.StepID)
+	if err != nil {
+		response.Fail(c, errors.CodeSessionStepNotFound, "Session step not found")
+		return
+	}
+	sess, err := h.sessionService.GetSession(step.SessionID)
+	if err != nil {
+		response.Fail(c, errors.CodeSessionNotFound, "Session not found")
+		return
+	}
+	if sess.UserID != userID {
+		response.Fail(c, errors.CodeForbidden, "Access denied")
+		return
+	}
+	if !h.ensureProjectOwner(c, sess.ProjectID) {
+		return
+	}
+
+	proposal, err := h.workflowService.CommitWizardProposal(step.ID)
+	if err != nil {
+		if respondWizardError(c, err) {
+			return
+		}
+		response.Fail(c, errors.CodeInternalError, "Failed to commit wizard result")
+		return
+	}
+	response.SuccessWithData(c, proposal)
+}
+
+// respondWizardError 映射向导结构化结果的校验与重复提交错误
+func respondWizardError(c *gin.Context, err error) bool {
+	var validationErr *service.WizardValidationError
+	if stderrors.As(err, &validationErr) {
+		response.Fail(c, errors.CodeValidationError, validationErr.Error())
+		return true
+	}
+	if stderrors.Is(err, service.ErrWizardProposalCommitted) {
+		response.Fail(c, errors.CodeAlreadyExists, "Wizard result already committed")
+		return true
+	}
+	return false
+}
+
 func (h *WorkflowHandler) RunPolish(c *gin.Context) {
 	h.runWorkflow(c, "polish", "章节润色")
 }
@@ -172,6 +358,12 @@ func (h *WorkflowHandler) RunChapterGenerate(c *gin.Context) {
 		response.Fail(c, errors.CodeInvalidParams, "Invalid request body")
 		return
 	}
+	if !ensureBodyOrChatWithTemplate(c, req.Path, req.Body, req.Chat, req.PromptTemplate) {
+		return
+	}
+	if !ensureCacheMode(c, req.Cache) {
+		return
+	}
 	if !h.ensureProjectOwner(c, req.ProjectID) {
 		return
 	}
@@ -222,6 +414,11 @@ func (h *WorkflowHandler) RunChapterGenerate(c *gin.Context) {
 		Provider:            req.Provider,
 		Path:                req.Path,
 		Body:                req.Body,
+		Chat:                req.Chat,
+		Context:             req.Context,
+		QualityGate:         req.QualityGate,
+		Cache:               req.Cache,
+		PromptTemplate:      req.PromptTemplate,
 		AuthorizationHeader: c.GetHeader("Authorization"),
 		WriteBack: service.ChapterWriteBack{
 			Mode:       req.WriteBack.Mode,
@@ -230,6 +427,9 @@ func (h *WorkflowHandler) RunChapterGenerate(c *gin.Context) {
 		},
 	})
 	if err != nil {
+		if respondAIPreflightError(c, err) || respondTemplateError(c, err) {
+			return
+		}
 		response.Fail(c, errors.CodeInternalError, "Failed to run workflow")
 		return
 	}
@@ -240,6 +440,8 @@ func (h *WorkflowHandler) RunChapterGenerate(c *gin.Context) {
 		"steps":    result.Steps,
 		"content":  result.Content,
 		"raw":      result.Raw,
+		"proposal": result.Proposal,
+		"quality":  result.Quality,
 	})
 }
 
@@ -249,6 +451,12 @@ func (h *WorkflowHandler) RunChapterAnalyze(c *gin.Context) {
 		response.Fail(c, errors.CodeInvalidParams, "Invalid request body")
 		return
 	}
+	if !ensureBodyOrChatWithTemplate(c, req.Path, req.Body, req.Chat, req.PromptTemplate) {
+		return
+	}
+	if !ensureCacheMode(c, req.Cache) {
+		return
+	}
 	if !h.ensureProjectOwner(c, req.ProjectID) {
 		return
 	}
@@ -286,6 +494,9 @@ func (h *WorkflowHandler) RunChapterAnalyze(c *gin.Context) {
 		Provider:            req.Provider,
 		Path:                req.Path,
 		Body:                req.Body,
+		Chat:                req.Chat,
+		Cache:               req.Cache,
+		PromptTemplate:      req.PromptTemplate,
 		AuthorizationHeader: c.GetHeader("Authorization"),
 		WriteBack: service.ChapterWriteBack{
 			SetStatus:  req.WriteBack.SetStatus,
@@ -293,6 +504,9 @@ func (h *WorkflowHandler) RunChapterAnalyze(c *gin.Context) {
 		},
 	})
 	if err != nil {
+		if respondAIPreflightError(c, err) || respondTemplateError(c, err) {
+			return
+		}
 		response.Fail(c, errors.CodeInternalError, "Failed to run workflow")
 		return
 	}
@@ -311,6 +525,12 @@ func (h *WorkflowHandler) RunChapterRewrite(c *gin.Context) {
 		response.Fail(c, errors.CodeInvalidParams, "Invalid request body")
 		return
 	}
+	if !ensureBodyOrChatWithTemplate(c, req.Path, req.Body, req.Chat, req.PromptTemplate) {
+		return
+	}
+	if !ensureCacheMode(c, req.Cache) {
+		return
+	}
 	if !h.ensureProjectOwner(c, req.ProjectID) {
 		return
 	}
@@ -349,6 +569,11 @@ func (h *WorkflowHandler) RunChapterRewrite(c *gin.Context) {
 		Provider:            req.Provider,
 		Path:                req.Path,
 		Body:                req.Body,
+		Chat:                req.Chat,
+		Context:             req.Context,
+		QualityGate:         req.QualityGate,
+		Cache:               req.Cache,
+		PromptTemplate:      req.PromptTemplate,
 		AuthorizationHeader: c.GetHeader("Authorization"),
 		WriteBack: service.ChapterWriteBack{
 			Mode:      req.WriteBack.Mode,
@@ -356,6 +581,9 @@ func (h *WorkflowHandler) RunChapterRewrite(c *gin.Context) {
 		},
 	})
 	if err != nil {
+		if respondAIPreflightError(c, err) || respondTemplateError(c, err) {
+			return
+		}
 		response.Fail(c, errors.CodeInternalError, "Failed to run workflow")
 		return
 	}
@@ -365,6 +593,8 @@ func (h *WorkflowHandler) RunChapterRewrite(c *gin.Context) {
 		"document": result.Document,
 		"content":  result.Content,
 		"raw":      result.Raw,
+		"proposal": result.Proposal,
+		"quality":  result.Quality,
 	})
 }
 
@@ -374,10 +604,24 @@ func (h *WorkflowHandler) RunChapterBatch(c *gin.Context) {
 		response.Fail(c, errors.CodeInvalidParams, "Invalid request body")
 		return
 	}
+	if !ensureBodyOrChatWithTemplate(c, req.Path, req.BodyTemplate, req.ChatTemplate, req.PromptTemplate) {
+		return
+	}
+	if !ensureCacheMode(c, req.Cache) {
+		return
+	}
 	if len(req.Items) == 0 {
 		response.Fail(c, errors.CodeInvalidParams, "Items required")
 		return
 	}
+	if max := service.ChapterBatchMaxItems(); len(req.Items) > max {
+		response.Fail(c, errors.CodeInvalidParams, fmt.Sprintf("Too many items (max %d)", max))
+		return
+	}
+	if req.Parallelism < 0 {
+		response.Fail(c, errors.CodeInvalidParams, "Invalid parallelism")
+		return
+	}
 	if !h.ensureProjectOwner(c, req.ProjectID) {
 		return
 	}
@@ -426,6 +670,10 @@ func (h *WorkflowHandler) RunChapterBatch(c *gin.Context) {
 		Provider:            req.Provider,
 		Path:                req.Path,
 		BodyTemplate:        req.BodyTemplate,
+		ChatTemplate:        req.ChatTemplate,
+		Cache:               req.Cache,
+		PromptTemplate:      req.PromptTemplate,
+		Parallelism:         req.Parallelism,
 		AuthorizationHeader: c.GetHeader("Authorization"),
 		WriteBack: service.ChapterWriteBack{
 			SetStatus:  req.WriteBack.SetStatus,
@@ -433,31 +681,124 @@ func (h *WorkflowHandler) RunChapterBatch(c *gin.Context) {
 		},
 	})
 	if err != nil {
+		if respondAIPreflightError(c, err) || respondTemplateError(c, err) {
+			return
+		}
 		response.Fail(c, errors.CodeInternalError, "Failed to run workflow")
 		return
 	}
 
 	response.SuccessWithData(c, gin.H{
-		"session":   result.Session,
-		"documents": result.Documents,
-		"results":   result.Results,
+		"session": result.Session,
+		"job":     result.Job.ToPublic(),
+		"items":   result.Items,
 	})
 }
 
+// GetChapterBatchJob 查询批量生成任务及各章节状态
+func (h *WorkflowHandler) GetChapterBatchJob(c *gin.Context) {
+	userID := getUserIDFromContext(c)
+	if userID == 0 {
+		response.Fail(c, errors.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	result, err := h.workflowService.GetChapterBatchJob(userID, c.Param("job_uuid"))
+	if err != nil {
+		respondChapterBatchError(c, err)
+		return
+	}
+	response.SuccessWithData(c, gin.H{
+		"job":   result.Job.ToPublic(),
+		"items": result.Items,
+	})
+}
+
+// RetryChapterBatchJob 重新生成批量任务中失败的章节
+func (h *WorkflowHandler) RetryChapterBatchJob(c *gin.Context) {
+	h.requeueChapterBatchJob(c, h.workflowService.RetryChapterBatchFailed)
+}
+
+// ResumeChapterBatchJob 恢复因服务重启或取消而中断的批量任务
+func (h *WorkflowHandler) ResumeChapterBatchJob(c *gin.Context) {
+	h.requeueChapterBatchJob(c, h.workflowService.ResumeChapterBatch)
+}
+
+func (h *WorkflowHandler) requeueChapterBatchJob(c *gin.Context, requeue func(userID uint, jobUUID, authorizationHeader string) (*service.ChapterBatchResult, error)) {
+	userID := getUserIDFromContext(c)
+	if userID == 0 {
+		response.Fail(c, errors.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	result, err := requeue(userID, c.Param("job_uuid"), c.GetHeader("Authorization"))
+	if err != nil {
+		respondChapterBatchError(c, err)
+		return
+	}
+	response.SuccessWithData(c, gin.H{
+		"job":   result.Job.ToPublic(),
+		"items": result.Items,
+	})
+}
+
+func respondChapterBatchError(c *gin.Context, err error) {
+	switch {
+	case stderrors.Is(err, service.ErrJobAccessDenied):
+		response.Fail(c, errors.CodeJobAccessDenied, "Access denied")
+	case stderrors.Is(err, service.ErrJobActive):
+		response.Fail(c, errors.CodeInvalidParams, "Job is still running")
+	case stderrors.Is(err, service.ErrChapterBatchNothingToRun):
+		response.Fail(c, errors.CodeInvalidParams, "No items to retry or resume")
+	case stderrors.Is(err, service.ErrJobNotFound):
+		response.Fail(c, errors.CodeJobNotFound, "Job not found")
+	default:
+		response.Fail(c, errors.CodeInternalError, "Failed to update job")
+	}
+}
+
 func (h *WorkflowHandler) runWorkflow(c *gin.Context, formatType string, defaultTitle string) {
+	runReq, ok := h.bindRunWorkflow(c, formatType, defaultTitle)
+	if !ok {
+		return
+	}
+
+	result, err := h.workflowService.RunStep(runReq)
+	if err != nil {
+		if respondAIPreflightError(c, err) || respondTemplateError(c, err) {
+			return
+		}
+		response.Fail(c, errors.CodeInternalError, "Failed to run workflow")
+		return
+	}
+
+	response.SuccessWithData(c, gin.H{
+		"session": result.Session,
+		"step":    result.Step,
+		"content": result.Content,
+		"raw":     result.Raw,
+	})
+}
+
+// bindRunWorkflow 解析并校验通用工作流请求（项目与会话归属），转换为服务层请求
+func (h *WorkflowHandler) bindRunWorkflow(c *gin.Context, formatType string, defaultTitle string) (service.RunWorkflowRequest, bool) {
 	var req RunWorkflowRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		response.Fail(c, errors.CodeInvalidParams, "Invalid request body")
-		return
+		return service.RunWorkflowRequest{}, false
+	}
+	if !ensureBodyOrChatWithTemplate(c, req.Path, req.Body, req.Chat, req.PromptTemplate) {
+		return service.RunWorkflowRequest{}, false
+	}
+	if !ensureCacheMode(c, req.Cache) {
+		return service.RunWorkflowRequest{}, false
 	}
 	if !h.ensureProjectOwner(c, req.ProjectID) {
-		return
+		return service.RunWorkflowRequest{}, false
 	}
 
 	userID := getUserIDFromContext(c)
 	if userID == 0 {
 		response.Fail(c, errors.CodeUnauthorized, "Unauthorized")
-		return
+		return service.RunWorkflowRequest{}, false
 	}
 
 	var sess *model.Session
@@ -465,11 +806,11 @@ func (h *WorkflowHandler) runWorkflow(c *gin.Context, formatType string, default
 		existing, err := h.sessionService.GetSession(req.SessionID)
 		if err != nil {
 			response.Fail(c, errors.CodeSessionNotFound, "Session not found")
-			return
+			return service.RunWorkflowRequest{}, false
 		}
 		if existing.UserID != userID {
 			response.Fail(c, errors.CodeForbidden, "Access denied")
-			return
+			return service.RunWorkflowRequest{}, false
 		}
 		sess = existing
 	}
@@ -493,31 +834,58 @@ func (h *WorkflowHandler) runWorkflow(c *gin.Context, formatType string, default
 		Provider:            req.Provider,
 		Path:                req.Path,
 		Body:                req.Body,
+		Chat:                req.Chat,
 		Session:             sess,
+		Cache:               req.Cache,
+		PromptTemplate:      req.PromptTemplate,
 		AuthorizationHeader: c.GetHeader("Authorization"),
 	}
+	return runReq, true
+}
 
-	result, err := h.workflowService.RunStep(runReq)
-	if err != nil {
-		response.Fail(c, errors.CodeInternalError, "Failed to run workflow")
+// PreviewChapterContext 预览章节工作流将注入的上下文：纳入/舍弃的片段及原因（不调用模型）
+func (h *WorkflowHandler) PreviewChapterContext(c *gin.Context) {
+	var req ChapterContextPreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, errors.CodeInvalidParams, "Invalid request body")
+		return
+	}
+	if req.TokenBudget < 0 {
+		response.Fail(c, errors.CodeInvalidParams, "Invalid token_budget")
+		return
+	}
+	if !h.ensureProjectOwner(c, req.ProjectID) {
+		return
+	}
+	if !h.ensureDocumentOwner(c, req.DocumentID) {
+		return
+	}
+	if !h.ensureVolumeInProject(c, req.ProjectID, req.VolumeID) {
 		return
 	}
 
-	response.SuccessWithData(c, gin.H{
-		"session": result.Session,
-		"step":    result.Step,
-		"content": result.Content,
-		"raw":     result.Raw,
+	result, err := h.workflowService.PreviewChapterContext(service.ChapterContextRequest{
+		ProjectID:   req.ProjectID,
+		VolumeID:    req.VolumeID,
+		DocumentID:  req.DocumentID,
+		OrderIndex:  req.OrderIndex,
+		TokenBudget: req.TokenBudget,
 	})
+	if err != nil {
+		response.Fail(c, errors.CodeInvalidParams, err.Error())
+		return
+	}
+	response.SuccessWithData(c, result)
 }
 
 // RunWorkflowStreamRequest 流式工作流请求
 type RunWorkflowStreamRequest struct {
-	SessionID uint   `json:"session_id" binding:"required"`
-	StepTitle string `json:"step_title"`
-	Provider  string `json:"provider" binding:"required"`
-	Path      string `json:"path" binding:"required"`
-	Body      string `json:"body" binding:"required"`
+	SessionID uint                 `json:"session_id" binding:"required"`
+	StepTitle string               `json:"step_title"`
+	Provider  string               `json:"provider" binding:"required"`
+	Path      string               `json:"path"`
+	Body      string               `json:"body"`
+	Chat      *service.ChatRequest `json:"chat"`
 }
 
 // RunWorkflowStream 执行流式工作流
@@ -527,6 +895,9 @@ func (h *WorkflowHandler) RunWorkflowStream(c *gin.Context) {
 		response.Fail(c, errors.CodeInvalidParams, "Invalid request body")
 		return
 	}
+	if !ensureBodyOrChat(c, req.Path, req.Body, req.Chat) {
+		return
+	}
 
 	userID := getUserIDFromContext(c)
 	if userID == 0 {
@@ -553,14 +924,20 @@ func (h *WorkflowHandler) RunWorkflowStream(c *gin.Context) {
 	// 执行流式工作流
 	result, err := h.workflowStreamService.ExecuteWorkflowStream(service.ExecuteWorkflowStreamRequest{
 		SessionID: req.SessionID,
+		UserID:    userID,
+		ProjectID: session.ProjectID,
 		StepTitle: stepTitle,
 		Provider:  req.Provider,
 		Path:      req.Path,
 		Body:      req.Body,
+		Chat:      req.Chat,
 		Timeout:   30 * time.Second,
 	})
 
 	if err != nil {
+		if respondAIPreflightError(c, err) {
+			return
+		}
 		response.Fail(c, errors.CodeInternalError, "Failed to start stream")
 		return
 	}
@@ -0,0 +1,29 @@
+package model
+
+import "gorm.io/datatypes"
+
+// AICallLog AI 调用审计日志（请求体与响应已脱敏、截断）
+type AICallLog struct {
+	BaseModel
+	UserID       uint           `gorm:"index;not null" json:"user_id"`
+	ProjectID    uint           `gorm:"index" json:"project_id"`
+	SessionID    uint           `gorm:"index" json:"session_id"`
+	Source       string         `gorm:"size:50" json:"source"`
+	Provider     string         `gorm:"size:30;index" json:"provider"`
+	Model        string         `gorm:"size:100" json:"model"`
+	Path         string         `gorm:"size:255" json:"path"`
+	StatusCode   int            `json:"status_code"`
+	Success      bool           `gorm:"index" json:"success"`
+	Cached       bool           `gorm:"index" json:"cached"` // 命中响应缓存，未请求上游
+	Error        string         `gorm:"type:text" json:"error,omitempty"`
+	LatencyMs    int64          `json:"latency_ms"`
+	Attempts     datatypes.JSON `json:"attempts"`
+	RequestBody  string         `gorm:"type:text" json:"request_body,omitempty"`
+	ResponseBody string         `gorm:"type:text" json:"response_body,omitempty"`
+	Truncated    bool           `json:"truncated"`
+}
+
+// TableName 指定表名
+func (AICallLog) TableName() string {
+	return "ai_call_logs"
+}
@@ -20,3 +20,42 @@ type AIProviderConfig struct {
 func (AIProviderConfig) TableName() string {
 	return "ai_provider_configs"
 }
+
+// AIProviderKey 供应商密钥池中的单个密钥
+// APIKey 加密落盘（见 repository/api_key_cipher.go），健康状态字段随每次调用更新
+type AIProviderKey struct {
+	BaseModel
+	Provider       string     `gorm:"size:30;index;not null" json:"provider"`
+	Name           string     `gorm:"size:100" json:"name"`
+	APIKey         string     `gorm:"type:text" json:"-"`
+	Weight         int        `json:"weight"`
+	Enabled        bool       `gorm:"not null" json:"enabled"`
+	CooldownUntil  *time.Time `json:"cooldown_until,omitempty"`
+	SuccessCount   int64      `json:"success_count"`
+	ErrorCount     int64      `json:"error_count"`
+	RateLimitCount int64      `json:"rate_limit_count"`
+	LastStatus     int        `json:"last_status"`
+	LastError      string     `gorm:"type:text" json:"last_error"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	LastLimitedAt  *time.Time `json:"last_limited_at,omitempty"`
+}
+
+// TableName 指定表名
+func (AIProviderKey) TableName() string {
+	return "ai_provider_keys"
+}
+
+// AIUserProviderKey 用户自有供应商配置（BYOK），APIKey 加密落盘
+// BaseURL 为空时沿用平台配置的 BaseURL
+type AIUserProviderKey struct {
+	BaseModel
+	UserID   uint   `gorm:"not null;uniqueIndex:idx_ai_user_provider" json:"user_id"`
+	Provider string `gorm:"size:30;not null;uniqueIndex:idx_ai_user_provider" json:"provider"`
+	BaseURL  string `gorm:"type:text" json:"base_url"`
+	APIKey   string `gorm:"type:text" json:"-"`
+}
+
+// TableName 指定表名
+func (AIUserProviderKey) TableName() string {
+	return "ai_user_provider_keys"
+}
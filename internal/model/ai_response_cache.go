@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// AIResponseCache 确定性 AI 请求的响应缓存，按供应商、模型与规范化请求体摘要命中
+type AIResponseCache struct {
+	BaseModelWithoutSoftDelete
+	CacheKey string `gorm:"size:64;uniqueIndex;not null" json:"cache_key"`
+	Provider string `gorm:"size:30;index" json:"provider"`
+	Model    string `gorm:"size:100" json:"model"`
+	Path     string `gorm:"size:255" json:"path"`
+	// ProviderUsed/ModelUsed 实际应答的供应商与模型（发生 fallback 时与请求不同）
+	ProviderUsed string    `gorm:"size:30" json:"provider_used"`
+	ModelUsed    string    `gorm:"size:100" json:"model_used"`
+	Response     string    `gorm:"type:text" json:"response"`
+	HitCount     int64     `gorm:"default:0" json:"hit_count"`
+	ExpiresAt    time.Time `gorm:"index" json:"expires_at"`
+}
+
+// TableName 指定表名
+func (AIResponseCache) TableName() string {
+	return "ai_response_caches"
+}
@@ -0,0 +1,40 @@
+package model
+
+// AIUsageRecord AI 调用用量台账
+type AIUsageRecord struct {
+	BaseModel
+	UserID           uint   `gorm:"index;not null" json:"user_id"`
+	ProjectID        uint   `gorm:"index" json:"project_id"`
+	SessionID        uint   `gorm:"index" json:"session_id"`
+	Source           string `gorm:"size:50;index" json:"source"`
+	Provider         string `gorm:"size:30;index" json:"provider"`
+	Model            string `gorm:"size:100;index" json:"model"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+	Estimated        bool   `json:"estimated"`
+	LatencyMs        int64  `json:"latency_ms"`
+	Success          bool   `gorm:"index" json:"success"`
+	PointsCharged    int    `json:"points_charged"`
+	UserKey          bool   `gorm:"index" json:"user_key"`           // 使用用户自有密钥，不扣积分、不计入 token 上限
+	Cached           bool   `gorm:"index" json:"cached"`             // 命中响应缓存，未请求上游，token 与积分均记为 0
+	UsageDate        string `gorm:"size:10;index" json:"usage_date"` // YYYY-MM-DD（UTC），便于按天聚合
+}
+
+// TableName 指定表名
+func (AIUsageRecord) TableName() string {
+	return "ai_usage_records"
+}
+
+// AIUserQuota 用户 AI token 上限（0 表示不限制）
+type AIUserQuota struct {
+	BaseModel
+	UserID            uint  `gorm:"uniqueIndex;not null" json:"user_id"`
+	DailyTokenLimit   int64 `json:"daily_token_limit"`
+	MonthlyTokenLimit int64 `json:"monthly_token_limit"`
+}
+
+// TableName 指定表名
+func (AIUserQuota) TableName() string {
+	return "ai_user_quotas"
+}
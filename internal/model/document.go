@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"gorm.io/datatypes"
 )
 
@@ -57,3 +59,60 @@ type DocumentEntityRef struct {
 func (DocumentEntityRef) TableName() string {
 	return "document_entity_refs"
 }
+
+// 修订来源
+const (
+	RevisionSourceUser        = "user"
+	RevisionSourceWorkflow    = "workflow"
+	RevisionSourceAgentWriter = "agent_writer"
+	RevisionSourceRestore     = "restore"
+	// RevisionSourceInitial 首次记录修订前已存在的正文快照
+	RevisionSourceInitial = "initial"
+)
+
+// DocumentRevision 文档正文修订记录：每次正文变化保存变化后的完整正文
+type DocumentRevision struct {
+	BaseModelWithoutSoftDelete
+	DocumentID uint   `gorm:"not null;uniqueIndex:idx_doc_revision_version" json:"document_id"`
+	Version    int    `gorm:"not null;uniqueIndex:idx_doc_revision_version" json:"version"` // 文档内从 1 递增
+	Content    string `gorm:"type:text" json:"content,omitempty"`
+	CharCount  int    `json:"char_count"`
+	Source     string `gorm:"size:20;index" json:"source"` // user/workflow/agent_writer/restore/initial
+	SessionID  uint   `gorm:"index" json:"session_id,omitempty"`
+	UserID     uint   `gorm:"index" json:"user_id,omitempty"`
+	Note       string `gorm:"size:200" json:"note"`
+}
+
+// TableName 指定表名
+func (DocumentRevision) TableName() string {
+	return "document_revisions"
+}
+
+// 提案状态
+const (
+	ProposalStatusPending  = "pending"
+	ProposalStatusAccepted = "accepted"
+	ProposalStatusRejected = "rejected"
+	// ProposalStatusSuperseded 同一文档产生了新的提案
+	ProposalStatusSuperseded = "superseded"
+)
+
+// DocumentProposal AI 生成/重写的待审阅正文（write_back.mode=propose），采纳后才写回文档
+type DocumentProposal struct {
+	BaseModelWithoutSoftDelete
+	DocumentID  uint       `gorm:"index;not null" json:"document_id"`
+	SessionID   uint       `gorm:"index" json:"session_id"`
+	UserID      uint       `gorm:"index" json:"user_id"`
+	Source      string     `gorm:"size:50" json:"source"`         // chapter.generate / chapter.rewrite
+	BaseContent string     `gorm:"type:text" json:"base_content"` // 生成提案时的文档正文
+	Content     string     `gorm:"type:text" json:"content"`      // AI 输出
+	SetStatus   string     `gorm:"size:20" json:"set_status"`     // 采纳时一并写回的文档状态
+	SetSummary  bool       `json:"set_summary"`                   // 采纳时是否以采纳后的正文写回摘要
+	Status      string     `gorm:"size:20;index" json:"status"`   // pending/accepted/rejected/superseded
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+}
+
+// TableName 指定表名
+func (DocumentProposal) TableName() string {
+	return "document_proposals"
+}
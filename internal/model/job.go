@@ -11,6 +11,8 @@ type JobType string
 
 const (
 	JobTypePluginInvoke JobType = "plugin_invoke"
+	JobTypeChapterBatch JobType = "chapter_batch"
+	JobTypePipeline     JobType = "pipeline"
 )
 
 // JobStatus 任务状态
@@ -22,6 +24,8 @@ const (
 	JobStatusSucceeded JobStatus = "succeeded"
 	JobStatusFailed    JobStatus = "failed"
 	JobStatusCanceled  JobStatus = "canceled"
+	// JobStatusPaused 执行函数在步骤间主动暂停，可重新排队继续
+	JobStatusPaused JobStatus = "paused"
 )
 
 // Job 异步任务模型
@@ -86,3 +90,35 @@ func (j *Job) ToPublic() JobPublic {
 func (Job) TableName() string {
 	return "jobs"
 }
+
+// JobItemStatus 批量任务条目状态
+type JobItemStatus string
+
+const (
+	JobItemStatusPending   JobItemStatus = "pending"
+	JobItemStatusRunning   JobItemStatus = "running"
+	JobItemStatusSucceeded JobItemStatus = "succeeded"
+	JobItemStatusFailed    JobItemStatus = "failed"
+)
+
+// ChapterBatchJobItem 批量生成章节任务的单个章节
+// 每个条目独立记录状态，失败不影响其余条目，可单独重试
+type ChapterBatchJobItem struct {
+	BaseModelWithoutSoftDelete
+	JobID            uint          `gorm:"index;not null" json:"-"`
+	ItemIndex        int           `gorm:"not null" json:"item_index"`
+	ClientDocumentID string        `gorm:"size:100" json:"client_document_id"`
+	Title            string        `gorm:"size:200" json:"title"`
+	OrderIndex       int           `json:"order_index"`
+	Outline          string        `gorm:"type:text" json:"outline"`
+	Status           JobItemStatus `gorm:"size:20;not null;index" json:"status"`
+	DocumentID       uint          `json:"document_id,omitempty"`
+	Attempts         int           `json:"attempts"`
+	ErrorMessage     string        `gorm:"type:text" json:"error_message"`
+	StartedAt        *time.Time    `json:"started_at,omitempty"`
+	FinishedAt       *time.Time    `json:"finished_at,omitempty"`
+}
+
+func (ChapterBatchJobItem) TableName() string {
+	return "chapter_batch_job_items"
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"gorm.io/datatypes"
+)
+
+// Pipeline 项目级自定义工作流流水线：按定义依次执行生成、质量检查、重写、排版、插件调用等步骤
+type Pipeline struct {
+	BaseModel
+	ProjectID   uint   `gorm:"index;not null" json:"project_id"`
+	UserID      uint   `gorm:"index;not null" json:"user_id"`
+	Name        string `gorm:"size:100;not null" json:"name"`
+	Description string `gorm:"type:text" json:"description"`
+	// Definition 校验并规范化后的定义（JSON），执行时以此为准
+	Definition datatypes.JSON `json:"definition"`
+	// Source 用户提交的原始定义文本，SourceFormat 为 json/yaml
+	Source       string `gorm:"type:text" json:"source"`
+	SourceFormat string `gorm:"size:10" json:"source_format"`
+}
+
+// TableName 指定表名
+func (Pipeline) TableName() string {
+	return "pipelines"
+}
+
+// PipelineRun 流水线的一次执行（对应一个 pipeline 类型的 Job），逐步保存执行位置与变量，暂停或中断后从 NextStep 继续
+type PipelineRun struct {
+	BaseModelWithoutSoftDelete
+	PipelineID uint `gorm:"index;not null" json:"pipeline_id"`
+	JobID      uint `gorm:"uniqueIndex;not null" json:"-"`
+	// Definition 启动时的定义快照，执行期间修改流水线不影响本次执行
+	Definition datatypes.JSON `json:"definition"`
+	// Variables 当前变量（定义默认值、启动输入与各步骤输出）
+	Variables datatypes.JSON `json:"variables"`
+	// NextStep 下一个待执行的步骤 ID，为空表示已执行完毕
+	NextStep string `gorm:"size:100" json:"next_step"`
+	// StepsRun 已执行的步骤次数（条件分支回跳时同一步骤重复计数）
+	StepsRun int `json:"steps_run"`
+}
+
+// TableName 指定表名
+func (PipelineRun) TableName() string {
+	return "pipeline_runs"
+}
@@ -1,13 +1,28 @@
 package model
 
+import (
+	"gorm.io/datatypes"
+)
+
+// TemplateVariable 模板声明的自定义变量（渲染时通过 .vars.<name> 引用）
+type TemplateVariable struct {
+	Name        string      `json:"name"`
+	Label       string      `json:"label"`
+	Type        string      `json:"type"` // string/number/boolean/list
+	Required    bool        `json:"required"`
+	Default     interface{} `json:"default,omitempty"`
+	Description string      `json:"description"`
+}
+
 // Template AI模板模型
 type Template struct {
 	BaseModel
-	Name        string `gorm:"size:100;not null" json:"name"`
-	Description string `gorm:"type:text" json:"description"`
-	Category    string `gorm:"size:20" json:"category"` // logic/style/content/character
-	Template    string `gorm:"type:text" json:"template"`
-	ProjectID   uint   `gorm:"index" json:"project_id"` // 0表示系统模板
+	Name        string         `gorm:"size:100;not null" json:"name"`
+	Description string         `gorm:"type:text" json:"description"`
+	Category    string         `gorm:"size:20" json:"category"` // logic/style/content/character
+	Template    string         `gorm:"type:text" json:"template"`
+	Variables   datatypes.JSON `json:"variables"`               // TemplateVariable[]
+	ProjectID   uint           `gorm:"index" json:"project_id"` // 0表示系统模板
 }
 
 // TableName 指定表名
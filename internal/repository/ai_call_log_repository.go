@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"time"
+
+	"novel-agent-os-backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// aiCallLogSummaryColumns 列表查询不返回请求体与响应
+var aiCallLogSummaryColumns = []string{
+	"id", "created_at", "updated_at", "user_id", "project_id", "session_id", "source", "provider",
+	"model", "path", "status_code", "success", "error", "latency_ms", "attempts", "truncated",
+}
+
+type AICallLogRepository interface {
+	Create(log *model.AICallLog) error
+	GetByID(id uint) (*model.AICallLog, error)
+	// ListBySession 按时间倒序列出会话的调用日志（不含请求体与响应）
+	ListBySession(sessionID uint, page, pageSize int) ([]*model.AICallLog, int64, error)
+	// DeleteBefore 物理删除早于 before 的日志，返回删除行数
+	DeleteBefore(before time.Time) (int64, error)
+}
+
+type aiCallLogRepository struct {
+	db *gorm.DB
+}
+
+func NewAICallLogRepository(db *gorm.DB) AICallLogRepository {
+	return &aiCallLogRepository{db: db}
+}
+
+func (r *aiCallLogRepository) Create(log *model.AICallLog) error {
+	return r.db.Create(log).Error
+}
+
+func (r *aiCallLogRepository) GetByID(id uint) (*model.AICallLog, error) {
+	var log model.AICallLog
+	if err := r.db.First(&log, id).Error; err != nil {
+		return nil, err
+	}
+	return &log, nil
+}
+
+func (r *aiCallLogRepository) ListBySession(sessionID uint, page, pageSize int) ([]*model.AICallLog, int64, error) {
+	var logs []*model.AICallLog
+	var total int64
+
+	db := r.db.Model(&model.AICallLog{}).Where("session_id = ?", sessionID)
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := db.Select(aiCallLogSummaryColumns).
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("created_at DESC").
+		Find(&logs).Error
+
+	return logs, total, err
+}
+
+func (r *aiCallLogRepository) DeleteBefore(before time.Time) (int64, error) {
+	result := r.db.Unscoped().Where("created_at < ?", before).Delete(&model.AICallLog{})
+	return result.RowsAffected, result.Error
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -27,6 +28,8 @@ type AIConfigRepository interface {
 	UpdateModelsCache(provider, baseURL, apiKey string, models []ProviderModelInfo) error
 	GetModelsCache(provider, baseURL, apiKey string) ([]ProviderModelInfo, error)
 	IsCacheValid(provider, baseURL, apiKey string, ttl int) bool
+	// ReencryptAPIKeys 加密存量明文密钥，并将旧主密钥加密的密钥改由当前主密钥包裹
+	ReencryptAPIKeys() (*APIKeyReencryptResult, error)
 }
 
 // ProviderConfigRecord 供应商配置记录
@@ -43,12 +46,33 @@ type ProviderConfigPayload struct {
 	BaseURL     string   `yaml:"base_url"`
 	APIKey      string   `yaml:"api_key"`
 	ModelsCache []string `yaml:"models_cache"`
-	UpdatedAt   string   `yaml:"updated_at"`
+	// ModelsMeta 上游返回的模型元数据（上下文长度、能力、价格），仅保存有元数据的模型
+	ModelsMeta []ProviderModelInfo `yaml:"models_meta,omitempty"`
+	UpdatedAt  string              `yaml:"updated_at"`
 }
 
-// ProviderModelInfo 模型信息
+// APIKeyReencryptResult 密钥迁移/轮换结果
+type APIKeyReencryptResult struct {
+	RowsUpdated  int
+	FilesUpdated int
+}
+
+// ProviderModelInfo 模型信息（元数据字段为 0/nil 表示上游未提供）
 type ProviderModelInfo struct {
-	ID string
+	ID                string  `yaml:"id" json:"id"`
+	Name              string  `yaml:"name,omitempty" json:"name,omitempty"`
+	ContextWindow     int     `yaml:"context_window,omitempty" json:"context_window,omitempty"`
+	MaxOutputTokens   int     `yaml:"max_output_tokens,omitempty" json:"max_output_tokens,omitempty"`
+	SupportsTools     *bool   `yaml:"supports_tools,omitempty" json:"supports_tools,omitempty"`
+	SupportsStreaming *bool   `yaml:"supports_streaming,omitempty" json:"supports_streaming,omitempty"`
+	InputPricePer1M   float64 `yaml:"input_price_per_1m,omitempty" json:"input_price_per_1m,omitempty"`
+	OutputPricePer1M  float64 `yaml:"output_price_per_1m,omitempty" json:"output_price_per_1m,omitempty"`
+}
+
+// hasMetadata 是否携带 ID 以外的元数据
+func (m ProviderModelInfo) hasMetadata() bool {
+	return m.ContextWindow > 0 || m.MaxOutputTokens > 0 || m.SupportsTools != nil || m.SupportsStreaming != nil ||
+		m.InputPricePer1M > 0 || m.OutputPricePer1M > 0 || (m.Name != "" && m.Name != m.ID)
 }
 
 // aiConfigRepository 实现
@@ -61,13 +85,31 @@ func NewAIConfigRepository() AIConfigRepository {
 	return &aiConfigRepository{BaseRepository: GetBaseRepository()}
 }
 
-// SaveProviderConfig 保存供应商配置
+// SaveProviderConfig 保存供应商配置（写入 providers/<provider>.yaml + DB，API Key 加密落盘）
 func (r *aiConfigRepository) SaveProviderConfig(provider string, payload ProviderConfigPayload) error {
 	clean := strings.TrimSpace(provider)
 	if clean == "" {
 		return errors.New("invalid provider")
 	}
 
+	storedKey, err := sealAPIKey(clean, payload.APIKey)
+	if err != nil {
+		return err
+	}
+	payload.APIKey = storedKey
+
+	providersPath := config.Get().AI.ProvidersPath
+	if providersPath == "" {
+		providersPath = "./configs/providers"
+	}
+	configPath := filepath.Join(providersPath, clean+".yaml")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return err
+	}
+	if err := writeProviderConfigFile(configPath, payload); err != nil {
+		return err
+	}
+
 	record := &AIProviderConfig{}
 	if err := r.BaseRepository.db.Where("provider = ?", clean).First(record).Error; err != nil {
 		record.Provider = clean
@@ -106,7 +148,11 @@ func (r *aiConfigRepository) GetProviderConfig(provider string) (*ProviderConfig
 	if payload, err := readProviderConfigFile(configPath); err == nil {
 		result.BaseURL = payload.BaseURL
 		if result.APIKey == "" {
-			result.APIKey = payload.APIKey
+			apiKey, err := decryptAPIKey(clean, payload.APIKey)
+			if err != nil {
+				return nil, err
+			}
+			result.APIKey = apiKey
 		}
 		return result, nil
 	}
@@ -115,7 +161,11 @@ func (r *aiConfigRepository) GetProviderConfig(provider string) (*ProviderConfig
 	if err := r.BaseRepository.db.Where("provider = ?", clean).First(&record).Error; err == nil {
 		result.BaseURL = record.BaseURL
 		if result.APIKey == "" {
-			result.APIKey = record.APIKey
+			apiKey, err := decryptAPIKey(clean, record.APIKey)
+			if err != nil {
+				return nil, err
+			}
+			result.APIKey = apiKey
 		}
 	}
 
@@ -139,17 +189,32 @@ func (r *aiConfigRepository) UpdateModelsCache(provider, baseURL, apiKey string,
 	}
 
 	modelIDs := make([]string, 0, len(models))
+	var modelsMeta []ProviderModelInfo
 	for _, item := range models {
 		if item.ID != "" {
 			modelIDs = append(modelIDs, item.ID)
+			if item.hasMetadata() {
+				modelsMeta = append(modelsMeta, item)
+			}
 		}
 	}
 
+	// 优先沿用已落盘的密钥（占位符或密文），避免把环境变量中的密钥写入文件
+	storedKey := apiKey
+	if existing, err := readProviderConfigFileRaw(configPath); err == nil && existing.APIKey != "" {
+		storedKey = existing.APIKey
+	}
+	storedKey, err := sealAPIKey(clean, storedKey)
+	if err != nil {
+		return err
+	}
+
 	payload := ProviderConfigPayload{
 		Provider:    clean,
 		BaseURL:     baseURL,
-		APIKey:      apiKey,
+		APIKey:      storedKey,
 		ModelsCache: modelIDs,
+		ModelsMeta:  modelsMeta,
 		UpdatedAt:   time.Now().UTC().Format(time.RFC3339),
 	}
 
@@ -162,7 +227,7 @@ func (r *aiConfigRepository) UpdateModelsCache(provider, baseURL, apiKey string,
 		record.Provider = clean
 	}
 	record.BaseURL = baseURL
-	record.APIKey = apiKey
+	record.APIKey = storedKey
 	cacheBytes, _ := json.Marshal(modelIDs)
 	record.ModelsCache = datatypes.JSON(cacheBytes)
 	record.UpdatedAtAt = time.Now()
@@ -171,27 +236,34 @@ func (r *aiConfigRepository) UpdateModelsCache(provider, baseURL, apiKey string,
 }
 
 func readProviderConfigFile(path string) (*ProviderConfigPayload, error) {
-	data, err := os.ReadFile(path)
+	payload, err := readProviderConfigFileRaw(path)
 	if err != nil {
 		return nil, err
 	}
-	var payload ProviderConfigPayload
-	if err := yaml.Unmarshal(data, &payload); err != nil {
-		return nil, err
+
+	// 如果配置文件中有明文密钥（不是环境变量占位符或密文），输出警告
+	if needsAPIKeyEncryption(payload.APIKey) && len(payload.APIKey) > 10 {
+		logger.Warn("配置文件中包含明文API密钥，请配置主密钥并执行 cmd/aikeys 加密，或改用环境变量",
+			logger.String("provider", payload.Provider),
+			logger.String("file", path))
 	}
 
 	// 环境变量替换：支持 ${ENV_VAR} 格式
 	payload.APIKey = expandEnvVar(payload.APIKey)
 
-	// 如果配置文件中有明文密钥（不是环境变量占位符），输出警告
-	if payload.APIKey != "" && !strings.HasPrefix(strings.TrimSpace(payload.APIKey), "${") {
-		if len(payload.APIKey) > 10 {
-			logger.Warn("配置文件中包含明文API密钥，建议使用环境变量",
-				logger.String("provider", payload.Provider),
-				logger.String("file", path))
-		}
-	}
+	return payload, nil
+}
 
+// readProviderConfigFileRaw 读取配置文件原始内容（不展开环境变量占位符）
+func readProviderConfigFileRaw(path string) (*ProviderConfigPayload, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var payload ProviderConfigPayload
+	if err := yaml.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
 	return &payload, nil
 }
 
@@ -221,6 +293,73 @@ func writeProviderConfigFile(path string, payload ProviderConfigPayload) error {
 	return os.WriteFile(path, data, 0644)
 }
 
+// sealAPIKey 落盘前加密 API Key；未配置主密钥时保持明文写入并告警（兼容未启用加密的部署）
+func sealAPIKey(provider, apiKey string) (string, error) {
+	sealed, err := encryptAPIKey(provider, apiKey)
+	if errors.Is(err, ErrAPIKeyMasterKeyMissing) {
+		logger.Warn("未配置 API Key 主密钥，密钥将以明文落盘", logger.String("provider", provider))
+		return apiKey, nil
+	}
+	return sealed, err
+}
+
+// ReencryptAPIKeys 遍历 DB 记录与 providers 目录下的 YAML 文件，执行一次性迁移或主密钥轮换
+func (r *aiConfigRepository) ReencryptAPIKeys() (*APIKeyReencryptResult, error) {
+	result := &APIKeyReencryptResult{}
+
+	var records []AIProviderConfig
+	if err := r.BaseRepository.db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	for i := range records {
+		record := &records[i]
+		updated, changed, err := rewrapAPIKey(record.Provider, record.APIKey)
+		if err != nil {
+			return result, fmt.Errorf("provider %s: %w", record.Provider, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := r.BaseRepository.db.Model(record).Update("api_key", updated).Error; err != nil {
+			return result, err
+		}
+		result.RowsUpdated++
+	}
+
+	providersPath := config.Get().AI.ProvidersPath
+	if providersPath == "" {
+		providersPath = "./configs/providers"
+	}
+	files, err := filepath.Glob(filepath.Join(providersPath, "*.yaml"))
+	if err != nil {
+		return result, err
+	}
+	for _, path := range files {
+		payload, err := readProviderConfigFileRaw(path)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", path, err)
+		}
+		provider := strings.TrimSpace(payload.Provider)
+		if provider == "" {
+			provider = strings.TrimSuffix(filepath.Base(path), ".yaml")
+		}
+		updated, changed, err := rewrapAPIKey(provider, payload.APIKey)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", path, err)
+		}
+		if !changed {
+			continue
+		}
+		payload.APIKey = updated
+		if err := writeProviderConfigFile(path, *payload); err != nil {
+			return result, err
+		}
+		result.FilesUpdated++
+	}
+
+	return result, nil
+}
+
 // GetModelsCache 获取模型缓存
 func (r *aiConfigRepository) GetModelsCache(provider, baseURL, apiKey string) ([]ProviderModelInfo, error) {
 	clean := strings.TrimSpace(provider)
@@ -257,11 +396,20 @@ func (r *aiConfigRepository) GetModelsCache(provider, baseURL, apiKey string) ([
 		return models, nil
 	}
 
+	metaByID := make(map[string]ProviderModelInfo, len(payload.ModelsMeta))
+	for _, item := range payload.ModelsMeta {
+		metaByID[item.ID] = item
+	}
 	models := make([]ProviderModelInfo, 0, len(payload.ModelsCache))
 	for _, id := range payload.ModelsCache {
-		if id != "" {
-			models = append(models, ProviderModelInfo{ID: id})
+		if id == "" {
+			continue
+		}
+		if meta, ok := metaByID[id]; ok {
+			models = append(models, meta)
+			continue
 		}
+		models = append(models, ProviderModelInfo{ID: id})
 	}
 
 	return models, nil
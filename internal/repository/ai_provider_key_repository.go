@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"novel-agent-os-backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// AIProviderKeyRepository 供应商密钥池仓储，读取时解密、写入时加密 APIKey
+type AIProviderKeyRepository interface {
+	ListByProvider(provider string) ([]*model.AIProviderKey, error)
+	GetByID(id uint) (*model.AIProviderKey, error)
+	Create(key *model.AIProviderKey) error
+	Update(key *model.AIProviderKey) error
+	Delete(id uint) error
+	RecordSuccess(id uint, at time.Time) error
+	// RecordFailure 记录失败；cooldownUntil 非空时进入冷却，limited 表示被限流（429）
+	RecordFailure(id uint, status int, message string, cooldownUntil *time.Time, limited bool) error
+	// ReencryptAPIKeys 加密存量明文密钥，并将旧主密钥包裹的数据密钥改由当前主密钥包裹，返回更新行数
+	ReencryptAPIKeys() (int, error)
+}
+
+type aiProviderKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAIProviderKeyRepository(db *gorm.DB) AIProviderKeyRepository {
+	return &aiProviderKeyRepository{db: db}
+}
+
+func (r *aiProviderKeyRepository) ListByProvider(provider string) ([]*model.AIProviderKey, error) {
+	var keys []*model.AIProviderKey
+	if err := r.db.Where("provider = ?", provider).Order("id ASC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		plain, err := decryptAPIKey(key.Provider, key.APIKey)
+		if err != nil {
+			return nil, err
+		}
+		key.APIKey = plain
+	}
+	return keys, nil
+}
+
+func (r *aiProviderKeyRepository) GetByID(id uint) (*model.AIProviderKey, error) {
+	var key model.AIProviderKey
+	if err := r.db.First(&key, id).Error; err != nil {
+		return nil, err
+	}
+	plain, err := decryptAPIKey(key.Provider, key.APIKey)
+	if err != nil {
+		return nil, err
+	}
+	key.APIKey = plain
+	return &key, nil
+}
+
+func (r *aiProviderKeyRepository) Create(key *model.AIProviderKey) error {
+	return r.save(key, r.db.Create)
+}
+
+// Update 仅写入可编辑的字段，计数与最近状态由 RecordSuccess/RecordFailure 原子更新，整行保存会覆盖并发写入的计数
+func (r *aiProviderKeyRepository) Update(key *model.AIProviderKey) error {
+	return r.save(key, func(value interface{}) *gorm.DB {
+		return r.db.Model(value).Select("name", "api_key", "weight", "enabled", "cooldown_until", "updated_at").Updates(value)
+	})
+}
+
+// save 以密文写入，写入后恢复调用方持有的明文
+func (r *aiProviderKeyRepository) save(key *model.AIProviderKey, write func(value interface{}) *gorm.DB) error {
+	plain := key.APIKey
+	sealed, err := sealAPIKey(key.Provider, plain)
+	if err != nil {
+		return err
+	}
+	key.APIKey = sealed
+	err = write(key).Error
+	key.APIKey = plain
+	return err
+}
+
+func (r *aiProviderKeyRepository) Delete(id uint) error {
+	return r.db.Delete(&model.AIProviderKey{}, id).Error
+}
+
+func (r *aiProviderKeyRepository) RecordSuccess(id uint, at time.Time) error {
+	return r.db.Model(&model.AIProviderKey{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"success_count": gorm.Expr("success_count + 1"),
+		"last_status":   200,
+		"last_used_at":  at,
+	}).Error
+}
+
+func (r *aiProviderKeyRepository) RecordFailure(id uint, status int, message string, cooldownUntil *time.Time, limited bool) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"error_count":  gorm.Expr("error_count + 1"),
+		"last_status":  status,
+		"last_error":   message,
+		"last_used_at": now,
+	}
+	if cooldownUntil != nil {
+		updates["cooldown_until"] = *cooldownUntil
+	}
+	if limited {
+		updates["rate_limit_count"] = gorm.Expr("rate_limit_count + 1")
+		updates["last_limited_at"] = now
+	}
+	return r.db.Model(&model.AIProviderKey{}).Where("id = ?", id).UpdateColumns(updates).Error
+}
+
+func (r *aiProviderKeyRepository) ReencryptAPIKeys() (int, error) {
+	var keys []model.AIProviderKey
+	if err := r.db.Find(&keys).Error; err != nil {
+		return 0, err
+	}
+	updatedRows := 0
+	for i := range keys {
+		key := &keys[i]
+		updated, changed, err := rewrapAPIKey(key.Provider, key.APIKey)
+		if err != nil {
+			return updatedRows, fmt.Errorf("provider key %d: %w", key.ID, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := r.db.Model(key).UpdateColumn("api_key", updated).Error; err != nil {
+			return updatedRows, err
+		}
+		updatedRows++
+	}
+	return updatedRows, nil
+}
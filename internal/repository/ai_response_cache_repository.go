@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"time"
+
+	"novel-agent-os-backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type AIResponseCacheRepository interface {
+	// GetValid 按缓存键查询未过期的条目
+	GetValid(cacheKey string, now time.Time) (*model.AIResponseCache, error)
+	// Save 按缓存键写入或覆盖条目
+	Save(entry *model.AIResponseCache) error
+	IncrementHit(id uint) error
+	Count() (int64, error)
+	// DeleteExpired 删除已过期的条目，返回删除行数
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+type aiResponseCacheRepository struct {
+	db *gorm.DB
+}
+
+func NewAIResponseCacheRepository(db *gorm.DB) AIResponseCacheRepository {
+	return &aiResponseCacheRepository{db: db}
+}
+
+func (r *aiResponseCacheRepository) GetValid(cacheKey string, now time.Time) (*model.AIResponseCache, error) {
+	var entry model.AIResponseCache
+	if err := r.db.Where("cache_key = ? AND expires_at > ?", cacheKey, now).First(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (r *aiResponseCacheRepository) Save(entry *model.AIResponseCache) error {
+	return r.db.Where("cache_key = ?", entry.CacheKey).
+		Assign(map[string]interface{}{
+			"provider":      entry.Provider,
+			"model":         entry.Model,
+			"path":          entry.Path,
+			"provider_used": entry.ProviderUsed,
+			"model_used":    entry.ModelUsed,
+			"response":      entry.Response,
+			"hit_count":     0,
+			"expires_at":    entry.ExpiresAt,
+		}).
+		FirstOrCreate(entry).Error
+}
+
+func (r *aiResponseCacheRepository) IncrementHit(id uint) error {
+	return r.db.Model(&model.AIResponseCache{}).Where("id = ?", id).
+		UpdateColumn("hit_count", gorm.Expr("hit_count + 1")).Error
+}
+
+func (r *aiResponseCacheRepository) Count() (int64, error) {
+	var total int64
+	err := r.db.Model(&model.AIResponseCache{}).Count(&total).Error
+	return total, err
+}
+
+func (r *aiResponseCacheRepository) DeleteExpired(now time.Time) (int64, error) {
+	result := r.db.Where("expires_at <= ?", now).Delete(&model.AIResponseCache{})
+	return result.RowsAffected, result.Error
+}
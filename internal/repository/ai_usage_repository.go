@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"novel-agent-os-backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// AIUsageFilter 用量查询条件（零值表示不过滤）
+type AIUsageFilter struct {
+	UserID    uint
+	ProjectID uint
+	SessionID uint
+	Provider  string
+	Model     string
+	From      string // YYYY-MM-DD
+	To        string // YYYY-MM-DD
+}
+
+// AIUsageAggregate 用量聚合结果
+type AIUsageAggregate struct {
+	GroupKey         string  `json:"group_key"`
+	Calls            int64   `json:"calls"`
+	FailedCalls      int64   `json:"failed_calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+}
+
+// aiUsageGroupColumns 允许的聚合维度
+var aiUsageGroupColumns = map[string]string{
+	"day":      "usage_date",
+	"project":  "project_id",
+	"model":    "model",
+	"provider": "provider",
+	"user":     "user_id",
+	"session":  "session_id",
+}
+
+type AIUsageRepository interface {
+	Create(record *model.AIUsageRecord) error
+	List(filter AIUsageFilter, page, pageSize int) ([]*model.AIUsageRecord, int64, error)
+	Aggregate(filter AIUsageFilter, groupBy string) ([]*AIUsageAggregate, error)
+	// SumUserTokens 统计用户自 fromDate（YYYY-MM-DD，含）起使用平台密钥的 token 总量
+	SumUserTokens(userID uint, fromDate string) (int64, error)
+	GetQuota(userID uint) (*model.AIUserQuota, error)
+	SaveQuota(quota *model.AIUserQuota) error
+}
+
+type aiUsageRepository struct {
+	db *gorm.DB
+}
+
+func NewAIUsageRepository(db *gorm.DB) AIUsageRepository {
+	return &aiUsageRepository{db: db}
+}
+
+func (r *aiUsageRepository) Create(record *model.AIUsageRecord) error {
+	return r.db.Create(record).Error
+}
+
+func (r *aiUsageRepository) List(filter AIUsageFilter, page, pageSize int) ([]*model.AIUsageRecord, int64, error) {
+	var records []*model.AIUsageRecord
+	var total int64
+
+	offset := (page - 1) * pageSize
+
+	db := r.applyFilter(r.db.Model(&model.AIUsageRecord{}), filter)
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := db.Offset(offset).
+		Limit(pageSize).
+		Order("created_at DESC").
+		Find(&records).Error
+
+	return records, total, err
+}
+
+// Aggregate 按维度聚合用量，groupBy 取值见 aiUsageGroupColumns
+func (r *aiUsageRepository) Aggregate(filter AIUsageFilter, groupBy string) ([]*AIUsageAggregate, error) {
+	column, ok := aiUsageGroupColumns[groupBy]
+	if !ok {
+		return nil, gorm.ErrInvalidField
+	}
+
+	var rows []*AIUsageAggregate
+	err := r.applyFilter(r.db.Model(&model.AIUsageRecord{}), filter).
+		Select(column + " AS group_key, " +
+			"COUNT(*) AS calls, " +
+			"SUM(CASE WHEN success THEN 0 ELSE 1 END) AS failed_calls, " +
+			"SUM(prompt_tokens) AS prompt_tokens, " +
+			"SUM(completion_tokens) AS completion_tokens, " +
+			"SUM(total_tokens) AS total_tokens, " +
+			"AVG(latency_ms) AS avg_latency_ms").
+		Group(column).
+		Order(column).
+		Scan(&rows).Error
+	return rows, err
+}
+
+func (r *aiUsageRepository) SumUserTokens(userID uint, fromDate string) (int64, error) {
+	var total int64
+	err := r.db.Model(&model.AIUsageRecord{}).
+		Where("user_id = ? AND usage_date >= ? AND user_key = ?", userID, fromDate, false).
+		Select("COALESCE(SUM(total_tokens), 0)").
+		Scan(&total).Error
+	return total, err
+}
+
+func (r *aiUsageRepository) GetQuota(userID uint) (*model.AIUserQuota, error) {
+	var quota model.AIUserQuota
+	if err := r.db.Where("user_id = ?", userID).First(&quota).Error; err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+func (r *aiUsageRepository) SaveQuota(quota *model.AIUserQuota) error {
+	return r.db.Save(quota).Error
+}
+
+func (r *aiUsageRepository) applyFilter(db *gorm.DB, filter AIUsageFilter) *gorm.DB {
+	if filter.UserID > 0 {
+		db = db.Where("user_id = ?", filter.UserID)
+	}
+	if filter.ProjectID > 0 {
+		db = db.Where("project_id = ?", filter.ProjectID)
+	}
+	if filter.SessionID > 0 {
+		db = db.Where("session_id = ?", filter.SessionID)
+	}
+	if filter.Provider != "" {
+		db = db.Where("provider = ?", filter.Provider)
+	}
+	if filter.Model != "" {
+		db = db.Where("model = ?", filter.Model)
+	}
+	if filter.From != "" {
+		db = db.Where("usage_date >= ?", filter.From)
+	}
+	if filter.To != "" {
+		db = db.Where("usage_date <= ?", filter.To)
+	}
+	return db
+}
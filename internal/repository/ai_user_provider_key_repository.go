@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"novel-agent-os-backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// AIUserProviderKeyRepository 用户自有供应商配置仓储，读取时解密、写入时加密 APIKey
+type AIUserProviderKeyRepository interface {
+	ListByUser(userID uint) ([]*model.AIUserProviderKey, error)
+	// Get 未配置时返回 gorm.ErrRecordNotFound
+	Get(userID uint, provider string) (*model.AIUserProviderKey, error)
+	Exists(userID uint, provider string) (bool, error)
+	// Save 按 user_id + provider 新增或覆盖
+	Save(key *model.AIUserProviderKey) error
+	Delete(userID uint, provider string) error
+	// ReencryptAPIKeys 加密存量明文密钥，并将旧主密钥包裹的数据密钥改由当前主密钥包裹，返回更新行数
+	ReencryptAPIKeys() (int, error)
+}
+
+type aiUserProviderKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAIUserProviderKeyRepository(db *gorm.DB) AIUserProviderKeyRepository {
+	return &aiUserProviderKeyRepository{db: db}
+}
+
+// userAPIKeyAAD 用户密钥的附加数据绑定用户与供应商，防止密文在用户间挪用
+func userAPIKeyAAD(userID uint, provider string) string {
+	return fmt.Sprintf("user:%d:%s", userID, provider)
+}
+
+func (r *aiUserProviderKeyRepository) ListByUser(userID uint) ([]*model.AIUserProviderKey, error) {
+	var keys []*model.AIUserProviderKey
+	if err := r.db.Where("user_id = ?", userID).Order("provider ASC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if err := r.open(key); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func (r *aiUserProviderKeyRepository) Get(userID uint, provider string) (*model.AIUserProviderKey, error) {
+	var key model.AIUserProviderKey
+	if err := r.db.Where("user_id = ? AND provider = ?", userID, provider).First(&key).Error; err != nil {
+		return nil, err
+	}
+	if err := r.open(&key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *aiUserProviderKeyRepository) Exists(userID uint, provider string) (bool, error) {
+	var count int64
+	err := r.db.Model(&model.AIUserProviderKey{}).
+		Where("user_id = ? AND provider = ? AND api_key <> ''", userID, provider).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *aiUserProviderKeyRepository) Save(key *model.AIUserProviderKey) error {
+	var existing model.AIUserProviderKey
+	err := r.db.Where("user_id = ? AND provider = ?", key.UserID, key.Provider).First(&existing).Error
+	if err == nil {
+		key.ID = existing.ID
+		key.CreatedAt = existing.CreatedAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	plain := key.APIKey
+	sealed, err := sealAPIKey(userAPIKeyAAD(key.UserID, key.Provider), plain)
+	if err != nil {
+		return err
+	}
+	key.APIKey = sealed
+	err = r.db.Save(key).Error
+	key.APIKey = plain
+	return err
+}
+
+// Delete 物理删除，避免软删除记录占用唯一索引
+func (r *aiUserProviderKeyRepository) Delete(userID uint, provider string) error {
+	return r.db.Unscoped().
+		Where("user_id = ? AND provider = ?", userID, provider).
+		Delete(&model.AIUserProviderKey{}).Error
+}
+
+func (r *aiUserProviderKeyRepository) ReencryptAPIKeys() (int, error) {
+	var keys []model.AIUserProviderKey
+	if err := r.db.Find(&keys).Error; err != nil {
+		return 0, err
+	}
+	updatedRows := 0
+	for i := range keys {
+		key := &keys[i]
+		updated, changed, err := rewrapAPIKey(userAPIKeyAAD(key.UserID, key.Provider), key.APIKey)
+		if err != nil {
+			return updatedRows, fmt.Errorf("user %d provider %s: %w", key.UserID, key.Provider, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := r.db.Model(key).UpdateColumn("api_key", updated).Error; err != nil {
+			return updatedRows, err
+		}
+		updatedRows++
+	}
+	return updatedRows, nil
+}
+
+func (r *aiUserProviderKeyRepository) open(key *model.AIUserProviderKey) error {
+	plain, err := decryptAPIKey(userAPIKeyAAD(key.UserID, key.Provider), key.APIKey)
+	if err != nil {
+		return err
+	}
+	key.APIKey = plain
+	return nil
+}
@@ -0,0 +1,286 @@
+package repository
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"novel-agent-os-backend/internal/config"
+)
+
+// apiKeyCipherPrefix 加密后的 API Key 格式：
+// enc:v1:<主密钥ID>:<base64(nonce+被包裹的数据密钥)>:<base64(nonce+密文)>
+// 每个值使用独立的随机数据密钥（DEK）加密，DEK 再由主密钥（KEK）包裹；轮换主密钥时只需重新包裹 DEK。
+const apiKeyCipherPrefix = "enc:v1:"
+
+// apiKeyMasterKeySize 主密钥须为随机生成的 32 字节（base64 或 hex 编码），例如 openssl rand -base64 32
+const apiKeyMasterKeySize = 32
+
+// ErrAPIKeyMasterKeyMissing 未配置主密钥
+var ErrAPIKeyMasterKeyMissing = errors.New("ai api key master key not configured")
+
+// ErrAPIKeyMasterKeyInvalid 主密钥不是 base64/hex 编码的 32 字节随机值
+var ErrAPIKeyMasterKeyInvalid = fmt.Errorf("ai api key master key must be %d random bytes encoded as base64 or hex", apiKeyMasterKeySize)
+
+type apiKeyKEK struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// apiKeyKeyring 当前主密钥用于加密，旧主密钥仅用于解密
+type apiKeyKeyring struct {
+	current *apiKeyKEK
+	byID    map[string]*apiKeyKEK
+}
+
+func loadAPIKeyKeyring() (*apiKeyKeyring, error) {
+	encCfg := config.Get().AI.Encryption
+	ring := &apiKeyKeyring{byID: make(map[string]*apiKeyKEK)}
+
+	if secret := strings.TrimSpace(encCfg.MasterKey); secret != "" {
+		kek, err := newAPIKeyKEK(secret)
+		if err != nil {
+			return nil, err
+		}
+		ring.current = kek
+		ring.byID[kek.id] = kek
+	}
+	for _, previous := range encCfg.PreviousKeys {
+		secret := strings.TrimSpace(previous)
+		if secret == "" {
+			continue
+		}
+		kek, err := newAPIKeyKEK(secret)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := ring.byID[kek.id]; !exists {
+			ring.byID[kek.id] = kek
+		}
+	}
+	return ring, nil
+}
+
+// ValidateAPIKeyMasterKeys 校验已配置的主密钥与旧主密钥格式，启动时调用以尽早发现配置错误
+func ValidateAPIKeyMasterKeys() error {
+	_, err := loadAPIKeyKeyring()
+	return err
+}
+
+// newAPIKeyKEK 由主密钥以 HMAC-SHA256 按用途分别派生 KEK 与主密钥 ID（HKDF-Expand 单块）；
+// 主密钥为 256 位随机值，ID 无法用于离线猜测主密钥
+func newAPIKeyKEK(secret string) (*apiKeyKEK, error) {
+	master, err := decodeAPIKeyMasterKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAPIKeyAEAD(deriveAPIKeyMaterial(master, "kek"))
+	if err != nil {
+		return nil, err
+	}
+	return &apiKeyKEK{id: hex.EncodeToString(deriveAPIKeyMaterial(master, "key-id"))[:8], aead: aead}, nil
+}
+
+// decodeAPIKeyMasterKey 解码 hex 或 base64（标准/URL，可省略填充）编码的主密钥，长度不足 32 字节时拒绝
+func decodeAPIKeyMasterKey(secret string) ([]byte, error) {
+	if strings.Trim(strings.ToLower(secret), "0123456789abcdef") == "" {
+		if key, err := hex.DecodeString(secret); err == nil && len(key) >= apiKeyMasterKeySize {
+			return key, nil
+		}
+		return nil, ErrAPIKeyMasterKeyInvalid
+	}
+	decoders := []func(string) ([]byte, error){
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+	}
+	for _, decode := range decoders {
+		if key, err := decode(secret); err == nil && len(key) >= apiKeyMasterKeySize {
+			return key, nil
+		}
+	}
+	return nil, ErrAPIKeyMasterKeyInvalid
+}
+
+func deriveAPIKeyMaterial(master []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte("novel-agent-os/api-key/" + purpose))
+	mac.Write([]byte{1})
+	return mac.Sum(nil)
+}
+
+func newAPIKeyAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsEncryptedAPIKey 是否为加密后的 API Key
+func IsEncryptedAPIKey(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), apiKeyCipherPrefix)
+}
+
+// isAPIKeyPlaceholder ${ENV_VAR} 占位符本身不是密钥，保持原样
+func isAPIKeyPlaceholder(value string) bool {
+	trimmed := strings.TrimSpace(value)
+	return strings.HasPrefix(trimmed, "${") && strings.HasSuffix(trimmed, "}")
+}
+
+// needsAPIKeyEncryption 明文密钥需要加密（空值、占位符与已加密值除外）
+func needsAPIKeyEncryption(value string) bool {
+	trimmed := strings.TrimSpace(value)
+	return trimmed != "" && !isAPIKeyPlaceholder(trimmed) && !IsEncryptedAPIKey(trimmed)
+}
+
+// encryptAPIKey 使用当前主密钥加密，provider 作为附加数据防止密文在供应商间挪用
+func encryptAPIKey(provider, plain string) (string, error) {
+	if !needsAPIKeyEncryption(plain) {
+		return plain, nil
+	}
+	ring, err := loadAPIKeyKeyring()
+	if err != nil {
+		return "", err
+	}
+	if ring.current == nil {
+		return "", ErrAPIKeyMasterKeyMissing
+	}
+
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAPIKeyAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealWithNonce(dataAEAD, []byte(strings.TrimSpace(plain)), []byte(provider))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := sealWithNonce(ring.current.aead, dek, []byte(ring.current.id))
+	if err != nil {
+		return "", err
+	}
+	return formatEncryptedAPIKey(ring.current.id, wrapped, ciphertext), nil
+}
+
+// decryptAPIKey 解密 API Key，非加密值原样返回
+func decryptAPIKey(provider, value string) (string, error) {
+	if !IsEncryptedAPIKey(value) {
+		return value, nil
+	}
+	keyID, wrapped, ciphertext, err := parseEncryptedAPIKey(value)
+	if err != nil {
+		return "", err
+	}
+	ring, err := loadAPIKeyKeyring()
+	if err != nil {
+		return "", err
+	}
+	kek, ok := ring.byID[keyID]
+	if !ok {
+		return "", fmt.Errorf("master key %s not configured", keyID)
+	}
+	dek, err := openWithNonce(kek.aead, wrapped, []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("unwrap data key failed: %w", err)
+	}
+	dataAEAD, err := newAPIKeyAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+	plain, err := openWithNonce(dataAEAD, ciphertext, []byte(provider))
+	if err != nil {
+		return "", fmt.Errorf("decrypt api key failed: %w", err)
+	}
+	return string(plain), nil
+}
+
+// rewrapAPIKey 迁移/轮换：明文加密，旧主密钥包裹的 DEK 改由当前主密钥包裹（密文不变）
+// 返回值 changed 表示存储值是否需要更新
+func rewrapAPIKey(provider, value string) (string, bool, error) {
+	if needsAPIKeyEncryption(value) {
+		encrypted, err := encryptAPIKey(provider, value)
+		return encrypted, err == nil, err
+	}
+	if !IsEncryptedAPIKey(value) {
+		return value, false, nil
+	}
+
+	keyID, wrapped, ciphertext, err := parseEncryptedAPIKey(value)
+	if err != nil {
+		return "", false, err
+	}
+	ring, err := loadAPIKeyKeyring()
+	if err != nil {
+		return "", false, err
+	}
+	if ring.current == nil {
+		return "", false, ErrAPIKeyMasterKeyMissing
+	}
+	if keyID == ring.current.id {
+		return value, false, nil
+	}
+	kek, ok := ring.byID[keyID]
+	if !ok {
+		return "", false, fmt.Errorf("master key %s not configured", keyID)
+	}
+	dek, err := openWithNonce(kek.aead, wrapped, []byte(keyID))
+	if err != nil {
+		return "", false, fmt.Errorf("unwrap data key failed: %w", err)
+	}
+	rewrapped, err := sealWithNonce(ring.current.aead, dek, []byte(ring.current.id))
+	if err != nil {
+		return "", false, err
+	}
+	return formatEncryptedAPIKey(ring.current.id, rewrapped, ciphertext), true, nil
+}
+
+func sealWithNonce(aead cipher.AEAD, plain, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, additional), nil
+}
+
+func openWithNonce(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additional)
+}
+
+func formatEncryptedAPIKey(keyID string, wrapped, ciphertext []byte) string {
+	return apiKeyCipherPrefix + keyID + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext)
+}
+
+func parseEncryptedAPIKey(value string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(value), apiKeyCipherPrefix), ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", nil, nil, errors.New("invalid encrypted api key")
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid encrypted api key: %w", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid encrypted api key: %w", err)
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
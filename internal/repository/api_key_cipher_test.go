@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"novel-agent-os-backend/internal/config"
+)
+
+func useEncryptionConfig(t *testing.T, masterKey string, previousKeys ...string) {
+	t.Helper()
+	content := "ai:\n  encryption:\n    master_key: \"" + masterKey + "\"\n"
+	if len(previousKeys) > 0 {
+		content += "    previous_keys: [\"" + strings.Join(previousKeys, "\", \"") + "\"]\n"
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := config.Init(path, ""); err != nil {
+		t.Fatalf("init config: %v", err)
+	}
+}
+
+func TestDecodeAPIKeyMasterKey(t *testing.T) {
+	random := []byte("0123456789abcdef0123456789abcdef")
+	tests := []struct {
+		name   string
+		secret string
+		ok     bool
+	}{
+		{name: "base64", secret: base64.StdEncoding.EncodeToString(random), ok: true},
+		{name: "raw url base64", secret: base64.RawURLEncoding.EncodeToString(random), ok: true},
+		{name: "hex", secret: hex.EncodeToString(random), ok: true},
+		{name: "passphrase", secret: "a-long-random-secret"},
+		{name: "short base64", secret: base64.StdEncoding.EncodeToString(random[:16])},
+		{name: "short hex", secret: hex.EncodeToString(random[:31])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := decodeAPIKeyMasterKey(tt.secret)
+			if !tt.ok {
+				if !errors.Is(err, ErrAPIKeyMasterKeyInvalid) {
+					t.Errorf("err = %v, want ErrAPIKeyMasterKeyInvalid", err)
+				}
+				return
+			}
+			if err != nil || string(key) != string(random) {
+				t.Errorf("key = %q, err = %v", key, err)
+			}
+		})
+	}
+}
+
+func TestAPIKeyEncryptRotate(t *testing.T) {
+	oldKey := base64.StdEncoding.EncodeToString([]byte("old-master-key-0123456789abcdef!"))
+	newKey := hex.EncodeToString([]byte("new-master-key-0123456789abcdef!"))
+
+	useEncryptionConfig(t, oldKey)
+	sealed, err := encryptAPIKey("openai", "sk-test")
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if plain, err := decryptAPIKey("openai", sealed); err != nil || plain != "sk-test" {
+		t.Fatalf("decrypt = %q, %v", plain, err)
+	}
+	if _, err := decryptAPIKey("anthropic", sealed); err == nil {
+		t.Error("ciphertext must be bound to its provider")
+	}
+	// 主密钥 ID 由 HMAC 派生，不是主密钥摘要
+	derived := sha256.Sum256([]byte(oldKey))
+	digest := sha256.Sum256(derived[:])
+	if strings.HasPrefix(sealed, apiKeyCipherPrefix+hex.EncodeToString(digest[:])[:8]+":") {
+		t.Error("key id must not be a digest of the master key")
+	}
+
+	useEncryptionConfig(t, newKey, oldKey)
+	rewrapped, changed, err := rewrapAPIKey("openai", sealed)
+	if err != nil || !changed {
+		t.Fatalf("rewrap changed = %v, err = %v", changed, err)
+	}
+	useEncryptionConfig(t, newKey)
+	if plain, err := decryptAPIKey("openai", rewrapped); err != nil || plain != "sk-test" {
+		t.Errorf("decrypt after rotation = %q, %v", plain, err)
+	}
+}
+
+func TestValidateAPIKeyMasterKeys(t *testing.T) {
+	useEncryptionConfig(t, "a-long-random-secret")
+	if err := ValidateAPIKeyMasterKeys(); !errors.Is(err, ErrAPIKeyMasterKeyInvalid) {
+		t.Errorf("err = %v, want ErrAPIKeyMasterKeyInvalid", err)
+	}
+	useEncryptionConfig(t, "")
+	if err := ValidateAPIKeyMasterKeys(); err != nil {
+		t.Errorf("unset master key: err = %v", err)
+	}
+}
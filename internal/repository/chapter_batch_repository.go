@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"novel-agent-os-backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type ChapterBatchRepository interface {
+	// CreateJob 在同一事务中创建批量任务及其条目
+	CreateJob(job *model.Job, items []*model.ChapterBatchJobItem) error
+	ListItems(jobID uint) ([]*model.ChapterBatchJobItem, error)
+	UpdateItem(item *model.ChapterBatchJobItem) error
+}
+
+type chapterBatchRepository struct {
+	db *gorm.DB
+}
+
+func NewChapterBatchRepository(db *gorm.DB) ChapterBatchRepository {
+	return &chapterBatchRepository{db: db}
+}
+
+func (r *chapterBatchRepository) CreateJob(job *model.Job, items []*model.ChapterBatchJobItem) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		for _, item := range items {
+			item.JobID = job.ID
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		return tx.Create(&items).Error
+	})
+}
+
+func (r *chapterBatchRepository) ListItems(jobID uint) ([]*model.ChapterBatchJobItem, error) {
+	var items []*model.ChapterBatchJobItem
+	err := r.db.Where("job_id = ?", jobID).Order("item_index ASC").Find(&items).Error
+	return items, err
+}
+
+func (r *chapterBatchRepository) UpdateItem(item *model.ChapterBatchJobItem) error {
+	return r.db.Save(item).Error
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"novel-agent-os-backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type DocumentProposalRepository interface {
+	// Create 在同一事务中将该文档其余待审阅提案标记为 superseded 并创建新提案
+	Create(proposal *model.DocumentProposal) error
+	// ListByDocumentID 按创建时间倒序查询提案（不含正文），status 为空时不过滤
+	ListByDocumentID(documentID uint, status string) ([]*model.DocumentProposal, error)
+	FindByID(id uint) (*model.DocumentProposal, error)
+	Update(proposal *model.DocumentProposal) error
+}
+
+type documentProposalRepository struct {
+	db *gorm.DB
+}
+
+func NewDocumentProposalRepository(db *gorm.DB) DocumentProposalRepository {
+	return &documentProposalRepository{db: db}
+}
+
+func (r *documentProposalRepository) Create(proposal *model.DocumentProposal) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.DocumentProposal{}).
+			Where("document_id = ? AND status = ?", proposal.DocumentID, model.ProposalStatusPending).
+			Updates(map[string]interface{}{
+				"status":      model.ProposalStatusSuperseded,
+				"resolved_at": gorm.Expr("CURRENT_TIMESTAMP"),
+			}).Error; err != nil {
+			return err
+		}
+		return tx.Create(proposal).Error
+	})
+}
+
+func (r *documentProposalRepository) ListByDocumentID(documentID uint, status string) ([]*model.DocumentProposal, error) {
+	var proposals []*model.DocumentProposal
+	db := r.db.Omit("base_content", "content").Where("document_id = ?", documentID)
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+	err := db.Order("id DESC").Find(&proposals).Error
+	return proposals, err
+}
+
+func (r *documentProposalRepository) FindByID(id uint) (*model.DocumentProposal, error) {
+	var proposal model.DocumentProposal
+	if err := r.db.First(&proposal, id).Error; err != nil {
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+func (r *documentProposalRepository) Update(proposal *model.DocumentProposal) error {
+	return r.db.Save(proposal).Error
+}
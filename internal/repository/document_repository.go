@@ -23,6 +23,7 @@ type DocumentRepository interface {
 	LinkEntity(documentID, entityID uint, refType string, metadata map[string]interface{}) error
 	UnlinkEntity(documentID, entityID uint) error
 	GetEntityRefs(documentID uint) ([]*model.DocumentEntityRef, error)
+	FindPreviousWithSummary(projectID, volumeID uint, beforeOrderIndex int, excludeID uint, limit int) ([]*model.Document, error)
 }
 
 // documentRepository 文档数据访问实现
@@ -110,6 +111,26 @@ func (r *documentRepository) GetMaxOrderIndexByVolume(volumeID uint) (int, error
 	return maxOrder, err
 }
 
+// FindPreviousWithSummary 查找排序在 beforeOrderIndex 之前且有摘要的章节（按排序倒序，不含正文）
+// volumeID 为 0 时在整个项目内查找；beforeOrderIndex 为 0 时取最新的章节
+func (r *documentRepository) FindPreviousWithSummary(projectID, volumeID uint, beforeOrderIndex int, excludeID uint, limit int) ([]*model.Document, error) {
+	var documents []*model.Document
+	db := database.GetDB().Model(&model.Document{}).
+		Select("id", "title", "summary", "order_index", "volume_id").
+		Where("project_id = ? AND summary <> ''", projectID)
+	if volumeID > 0 {
+		db = db.Where("volume_id = ?", volumeID)
+	}
+	if beforeOrderIndex > 0 {
+		db = db.Where("order_index < ?", beforeOrderIndex)
+	}
+	if excludeID > 0 {
+		db = db.Where("id <> ?", excludeID)
+	}
+	err := db.Order("order_index DESC, id DESC").Limit(limit).Find(&documents).Error
+	return documents, err
+}
+
 // Update 更新文档
 func (r *documentRepository) Update(document *model.Document) error {
 	return database.GetDB().Save(document).Error
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"novel-agent-os-backend/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DocumentRevisionRepository interface {
+	// CreateDocument 在同一事务中创建文档及其修订记录（修订的 DocumentID 与版本号在事务内填充）
+	CreateDocument(document *model.Document, revisions []*model.DocumentRevision) error
+	// UpdateDocument 在同一事务中保存文档并追加修订记录
+	UpdateDocument(document *model.Document, revisions []*model.DocumentRevision) error
+	// ListByDocumentID 按版本倒序分页查询修订（不含正文）
+	ListByDocumentID(documentID uint, page, size int) ([]*model.DocumentRevision, int64, error)
+	FindByID(id uint) (*model.DocumentRevision, error)
+	CountByDocumentID(documentID uint) (int64, error)
+}
+
+type documentRevisionRepository struct {
+	db *gorm.DB
+}
+
+func NewDocumentRevisionRepository(db *gorm.DB) DocumentRevisionRepository {
+	return &documentRevisionRepository{db: db}
+}
+
+func (r *documentRevisionRepository) CreateDocument(document *model.Document, revisions []*model.DocumentRevision) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(document).Error; err != nil {
+			return err
+		}
+		return appendDocumentRevisions(tx, document.ID, revisions)
+	})
+}
+
+func (r *documentRevisionRepository) UpdateDocument(document *model.Document, revisions []*model.DocumentRevision) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(document).Error; err != nil {
+			return err
+		}
+		return appendDocumentRevisions(tx, document.ID, revisions)
+	})
+}
+
+// appendDocumentRevisions 按当前最大版本号依次编号并写入修订
+// 编号前锁定文档行，同一文档的并发写入依次编号（SQLite 不支持行锁，由库级写锁串行）；(document_id, version) 唯一索引兜底
+func appendDocumentRevisions(tx *gorm.DB, documentID uint, revisions []*model.DocumentRevision) error {
+	if len(revisions) == 0 {
+		return nil
+	}
+	var locked model.Document
+	if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		First(&locked, documentID).Error; err != nil {
+		return err
+	}
+	var maxVersion int
+	if err := tx.Model(&model.DocumentRevision{}).
+		Where("document_id = ?", documentID).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&maxVersion).Error; err != nil {
+		return err
+	}
+	for _, revision := range revisions {
+		maxVersion++
+		revision.DocumentID = documentID
+		revision.Version = maxVersion
+		if err := tx.Create(revision).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *documentRevisionRepository) ListByDocumentID(documentID uint, page, size int) ([]*model.DocumentRevision, int64, error) {
+	var revisions []*model.DocumentRevision
+	var total int64
+
+	db := r.db.Model(&model.DocumentRevision{}).Where("document_id = ?", documentID)
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * size
+	err := db.Omit("content").Order("version DESC").Offset(offset).Limit(size).Find(&revisions).Error
+	return revisions, total, err
+}
+
+func (r *documentRevisionRepository) FindByID(id uint) (*model.DocumentRevision, error) {
+	var revision model.DocumentRevision
+	if err := r.db.First(&revision, id).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func (r *documentRevisionRepository) CountByDocumentID(documentID uint) (int64, error) {
+	var total int64
+	err := r.db.Model(&model.DocumentRevision{}).Where("document_id = ?", documentID).Count(&total).Error
+	return total, err
+}
@@ -11,6 +11,8 @@ type JobRepository interface {
 	GetByID(id uint) (*model.Job, error)
 	GetByUUID(jobUUID string) (*model.Job, error)
 	Update(job *model.Job) error
+	// UpdateProgress 仅更新进度，不覆盖并发写入的状态
+	UpdateProgress(id uint, progress int) error
 }
 
 type jobRepository struct {
@@ -44,3 +46,7 @@ func (r *jobRepository) GetByUUID(jobUUID string) (*model.Job, error) {
 func (r *jobRepository) Update(job *model.Job) error {
 	return r.db.Save(job).Error
 }
+
+func (r *jobRepository) UpdateProgress(id uint, progress int) error {
+	return r.db.Model(&model.Job{}).Where("id = ?", id).Update("progress", progress).Error
+}
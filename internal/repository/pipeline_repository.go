@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"novel-agent-os-backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type PipelineRepository interface {
+	Create(pipeline *model.Pipeline) error
+	FindByID(id uint) (*model.Pipeline, error)
+	// ListByProjectID 按创建时间倒序分页查询（不含定义与原文）
+	ListByProjectID(projectID uint, page, size int) ([]*model.Pipeline, int64, error)
+	Update(pipeline *model.Pipeline) error
+	Delete(id uint) error
+	// CreateRun 在同一事务中创建执行任务及其执行记录
+	CreateRun(job *model.Job, run *model.PipelineRun) error
+	FindRunByJobID(jobID uint) (*model.PipelineRun, error)
+	UpdateRun(run *model.PipelineRun) error
+	// ListRunJobs 按创建时间倒序分页查询流水线的执行任务
+	ListRunJobs(pipelineID uint, page, size int) ([]*model.Job, int64, error)
+}
+
+type pipelineRepository struct {
+	db *gorm.DB
+}
+
+func NewPipelineRepository(db *gorm.DB) PipelineRepository {
+	return &pipelineRepository{db: db}
+}
+
+func (r *pipelineRepository) Create(pipeline *model.Pipeline) error {
+	return r.db.Create(pipeline).Error
+}
+
+func (r *pipelineRepository) FindByID(id uint) (*model.Pipeline, error) {
+	var pipeline model.Pipeline
+	if err := r.db.First(&pipeline, id).Error; err != nil {
+		return nil, err
+	}
+	return &pipeline, nil
+}
+
+func (r *pipelineRepository) ListByProjectID(projectID uint, page, size int) ([]*model.Pipeline, int64, error) {
+	var pipelines []*model.Pipeline
+	var total int64
+
+	db := r.db.Model(&model.Pipeline{}).Where("project_id = ?", projectID)
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * size
+	err := db.Omit("definition", "source").Order("created_at DESC").Offset(offset).Limit(size).Find(&pipelines).Error
+	return pipelines, total, err
+}
+
+func (r *pipelineRepository) Update(pipeline *model.Pipeline) error {
+	return r.db.Save(pipeline).Error
+}
+
+func (r *pipelineRepository) Delete(id uint) error {
+	return r.db.Delete(&model.Pipeline{}, id).Error
+}
+
+func (r *pipelineRepository) CreateRun(job *model.Job, run *model.PipelineRun) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		run.JobID = job.ID
+		return tx.Create(run).Error
+	})
+}
+
+func (r *pipelineRepository) FindRunByJobID(jobID uint) (*model.PipelineRun, error) {
+	var run model.PipelineRun
+	if err := r.db.Where("job_id = ?", jobID).First(&run).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (r *pipelineRepository) UpdateRun(run *model.PipelineRun) error {
+	return r.db.Save(run).Error
+}
+
+func (r *pipelineRepository) ListRunJobs(pipelineID uint, page, size int) ([]*model.Job, int64, error) {
+	var jobs []*model.Job
+	var total int64
+
+	db := r.db.Model(&model.Job{}).
+		Joins("JOIN pipeline_runs ON pipeline_runs.job_id = jobs.id").
+		Where("pipeline_runs.pipeline_id = ?", pipelineID)
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * size
+	err := db.Order("jobs.created_at DESC").Offset(offset).Limit(size).Find(&jobs).Error
+	return jobs, total, err
+}
@@ -2,6 +2,8 @@ package repository
 
 import (
 	"novel-agent-os-backend/internal/model"
+
+	"gorm.io/gorm"
 )
 
 // UserRepository 用户仓储接口
@@ -13,6 +15,7 @@ type UserRepository interface {
 	Update(user *model.User) error
 	Delete(id uint) error
 	List(page, size int) ([]*model.User, int64, error)
+	AddPoints(id uint, delta int) error
 }
 
 // userRepository 用户仓储实现
@@ -64,6 +67,13 @@ func (r *userRepository) Update(user *model.User) error {
 	return r.BaseRepository.db.Save(user).Error
 }
 
+// AddPoints 原子增减用户积分
+func (r *userRepository) AddPoints(id uint, delta int) error {
+	return r.BaseRepository.db.Model(&model.User{}).
+		Where("id = ?", id).
+		UpdateColumn("points", gorm.Expr("points + ?", delta)).Error
+}
+
 // Delete 删除用户（软删除）
 func (r *userRepository) Delete(id uint) error {
 	return r.BaseRepository.db.Delete(&model.User{}, id).Error
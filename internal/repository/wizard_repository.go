@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"novel-agent-os-backend/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WizardEntityTag 待添加的实体标签（实体可能在同一事务中创建）
+type WizardEntityTag struct {
+	Entity *model.Entity
+	Tag    string
+}
+
+// WizardEntityLink 待创建的实体关联
+type WizardEntityLink struct {
+	Source       *model.Entity
+	Target       *model.Entity
+	Type         string
+	RelationName string
+}
+
+// WizardEntity 待写入的实体；Fields 为已有实体需要更新的列
+type WizardEntity struct {
+	Entity *model.Entity
+	Fields map[string]interface{}
+}
+
+// WizardVolume 待写入的卷；Fields 为已有卷需要更新的列
+type WizardVolume struct {
+	Volume *model.Volume
+	Fields map[string]interface{}
+}
+
+// WizardDocument 待写入的章节骨架（所属卷可能在同一事务中创建）；Fields 为已有章节需要更新的列
+type WizardDocument struct {
+	Volume   *model.Volume
+	Document *model.Document
+	Fields   map[string]interface{}
+}
+
+// WizardCommitPlan 向导结构化结果的写入计划；ID 为 0 的记录创建，否则按主键只更新 Fields 中的列，
+// 不覆盖计划生成后其他请求写入的内容
+type WizardCommitPlan struct {
+	ProjectID     uint
+	ProjectFields map[string]interface{}
+	Entities      []WizardEntity
+	EntityTags    []WizardEntityTag
+	EntityLinks   []WizardEntityLink
+	Volumes       []WizardVolume
+	Documents     []WizardDocument
+}
+
+type WizardRepository interface {
+	// Commit 在同一事务中执行写入计划，任一步失败整体回滚
+	Commit(plan *WizardCommitPlan) error
+}
+
+type wizardRepository struct {
+	db *gorm.DB
+}
+
+func NewWizardRepository(db *gorm.DB) WizardRepository {
+	return &wizardRepository{db: db}
+}
+
+func (r *wizardRepository) Commit(plan *WizardCommitPlan) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if len(plan.ProjectFields) > 0 {
+			if err := tx.Model(&model.Project{}).Where("id = ?", plan.ProjectID).Updates(plan.ProjectFields).Error; err != nil {
+				return err
+			}
+		}
+		for _, item := range plan.Entities {
+			if err := wizardWrite(tx, item.Entity, item.Entity.ID, item.Fields); err != nil {
+				return err
+			}
+		}
+		for _, item := range plan.EntityTags {
+			tag := &model.EntityTag{EntityID: item.Entity.ID, Tag: item.Tag}
+			if err := tx.Omit(clause.Associations).Create(tag).Error; err != nil {
+				return err
+			}
+		}
+		for _, item := range plan.EntityLinks {
+			link := &model.EntityLink{
+				SourceID:     item.Source.ID,
+				TargetID:     item.Target.ID,
+				Type:         item.Type,
+				RelationName: item.RelationName,
+			}
+			if err := tx.Omit(clause.Associations).Create(link).Error; err != nil {
+				return err
+			}
+		}
+		for _, item := range plan.Volumes {
+			if err := wizardWrite(tx, item.Volume, item.Volume.ID, item.Fields); err != nil {
+				return err
+			}
+		}
+		for _, item := range plan.Documents {
+			fields := item.Fields
+			if item.Volume != nil {
+				item.Document.VolumeID = item.Volume.ID
+				if item.Document.ID > 0 {
+					fields = make(map[string]interface{}, len(item.Fields)+1)
+					for field, value := range item.Fields {
+						fields[field] = value
+					}
+					fields["volume_id"] = item.Volume.ID
+				}
+			}
+			if err := wizardWrite(tx, item.Document, item.Document.ID, fields); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// wizardWrite id 为 0 时创建 record，否则只更新 fields 中的列
+func wizardWrite(tx *gorm.DB, record interface{}, id uint, fields map[string]interface{}) error {
+	if id == 0 {
+		return tx.Omit(clause.Associations).Create(record).Error
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return tx.Model(record).Updates(fields).Error
+}
@@ -41,6 +41,8 @@ func Setup() *gin.Engine {
 	projectRepo := repository.NewProjectRepository()
 	volumeRepo := repository.NewVolumeRepository()
 	documentRepo := repository.NewDocumentRepository()
+	documentRevisionRepo := repository.NewDocumentRevisionRepository(db)
+	documentProposalRepo := repository.NewDocumentProposalRepository(db)
 	entityRepo := repository.NewEntityRepository()
 	templateRepo := repository.NewTemplateRepository()
 	projectService := service.NewProjectService(projectRepo, volumeRepo, documentRepo, entityRepo, templateRepo)
@@ -48,13 +50,15 @@ func Setup() *gin.Engine {
 	volumeService := service.NewVolumeService(volumeRepo, projectRepo)
 	volumeHandler := handler.NewVolumeHandler(volumeService, projectService)
 
-	documentService := service.NewDocumentService(documentRepo, projectRepo, volumeRepo)
-	documentHandler := handler.NewDocumentHandler(documentService, projectService, volumeService)
+	documentService := service.NewDocumentService(documentRepo, projectRepo, volumeRepo, documentRevisionRepo)
+	documentProposalService := service.NewDocumentProposalService(documentProposalRepo, documentService)
+	documentHandler := handler.NewDocumentHandler(documentService, projectService, volumeService, documentProposalService)
+	contextBuilder := service.NewContextBuilder(projectRepo, volumeRepo, documentRepo)
 
 	entityService := service.NewEntityService(entityRepo, projectRepo)
 	entityHandler := handler.NewEntityHandler(entityService, projectService)
 
-	templateService := service.NewTemplateService(templateRepo, projectRepo)
+	templateService := service.NewTemplateService(templateRepo, projectRepo, volumeRepo, documentRepo, entityRepo)
 	templateHandler := handler.NewTemplateHandler(templateService, projectService)
 
 	redemptionRepo := repository.NewRedemptionCodeRepository()
@@ -62,12 +66,24 @@ func Setup() *gin.Engine {
 	redemptionHandler := handler.NewRedemptionCodeHandler(redemptionService, userService)
 
 	aiConfigRepo := repository.NewAIConfigRepository()
-	aiConfigService := service.NewAIConfigService(aiConfigRepo)
+	aiProviderKeyRepo := repository.NewAIProviderKeyRepository(db)
+	aiUserProviderKeyRepo := repository.NewAIUserProviderKeyRepository(db)
+	aiConfigService := service.NewAIConfigService(aiConfigRepo, aiProviderKeyRepo, aiUserProviderKeyRepo)
 	aiModelService := service.NewAIModelService(aiConfigRepo)
 	aiConfigHandler := handler.NewAIConfigHandler(aiConfigService)
 	aiModelHandler := handler.NewAIModelHandler(aiModelService)
-	aiProxyHandler := handler.NewAIProxyHandler(aiConfigService)
-	aiProxyStreamHandler := handler.NewAIProxyStreamHandler(aiConfigService)
+	aiUsageRepo := repository.NewAIUsageRepository(db)
+	aiBillingService := service.NewAIBillingService(userRepo, aiUsageRepo)
+	aiBillingHandler := handler.NewAIBillingHandler(aiBillingService)
+	aiCallLogRepo := repository.NewAICallLogRepository(db)
+	aiCallLogService := service.NewAICallLogService(aiCallLogRepo)
+	aiUsageService := service.NewAIUsageService(aiUsageRepo, aiBillingService, aiUserProviderKeyRepo, aiCallLogService)
+	aiUsageHandler := handler.NewAIUsageHandler(aiUsageService, projectService)
+	aiResponseCacheRepo := repository.NewAIResponseCacheRepository(db)
+	aiResponseCacheService := service.NewAIResponseCacheService(aiResponseCacheRepo)
+	aiResponseCacheHandler := handler.NewAIResponseCacheHandler(aiResponseCacheService)
+	aiProxyHandler := handler.NewAIProxyHandler(aiConfigService, aiUsageService)
+	aiProxyStreamHandler := handler.NewAIProxyStreamHandler(aiConfigService, aiUsageService)
 
 	pluginRepo := repository.NewPluginRepository(db)
 	pluginService := service.NewPluginService(pluginRepo)
@@ -76,20 +92,31 @@ func Setup() *gin.Engine {
 	sessionRepo := repository.NewSessionRepository(db)
 	sessionService := service.NewSessionService(sessionRepo)
 	sessionHandler := handler.NewSessionHandler(sessionService)
+	aiCallLogHandler := handler.NewAICallLogHandler(aiCallLogService, sessionService)
 
 	// Job 依赖
 	jobRepo := repository.NewJobRepository(db)
 	jobService := service.NewJobService(jobRepo, sessionRepo, pluginService, sessionService)
 	jobHandler := handler.NewJobHandler(jobService)
 
-	workflowService := service.NewWorkflowService(aiConfigService, sessionService, documentService, pluginService, jobService)
-	workflowStreamService := service.NewWorkflowStreamService(aiConfigService, sessionRepo)
+	qualityGateService := service.NewQualityGateService(*appCfg)
+	chapterBatchRepo := repository.NewChapterBatchRepository(db)
+	wizardRepo := repository.NewWizardRepository(db)
+	wizardService := service.NewWizardService(projectRepo, volumeRepo, entityRepo, documentRepo, wizardRepo)
+	workflowService := service.NewWorkflowService(aiConfigService, sessionService, documentService, pluginService, jobService, projectService, aiUsageService, aiModelService, aiResponseCacheService, contextBuilder, templateService, wizardService, chapterBatchRepo, documentProposalService, qualityGateService)
+	workflowStreamService := service.NewWorkflowStreamService(aiConfigService, sessionRepo, projectService, aiUsageService, aiModelService)
 	workflowHandler := handler.NewWorkflowHandler(workflowService, workflowStreamService, sessionService, documentService, projectService, volumeService)
 
 	// AgentWriter 依赖
-	agentWriterService := service.NewAgentWriterService(sessionService, documentService, aiConfigService)
+	agentWriterService := service.NewAgentWriterService(sessionService, documentService, aiConfigService, projectService, aiUsageService, aiModelService, contextBuilder, qualityGateService)
 	agentWriterHandler := handler.NewAgentWriterHandler(agentWriterService)
 
+	// 自定义工作流流水线
+	formattingService := service.NewFormattingService(*appCfg)
+	pipelineRepo := repository.NewPipelineRepository(db)
+	pipelineService := service.NewPipelineService(pipelineRepo, projectService, sessionService, jobService, aiConfigService, aiUsageService, aiModelService, aiResponseCacheService, qualityGateService, formattingService, pluginService, documentService, documentProposalService)
+	pipelineHandler := handler.NewPipelineHandler(pipelineService, projectService, sessionService)
+
 	pluginHandler := handler.NewPluginHandler(pluginService, jobService)
 
 	// Settlement 依赖
@@ -114,8 +141,6 @@ func Setup() *gin.Engine {
 	corpusHandler := handler.NewCorpusHandler(corpusService)
 
 	// Formatting & Quality 依赖
-	formattingService := service.NewFormattingService(*appCfg)
-	qualityGateService := service.NewQualityGateService(*appCfg)
 	formattingHandler := handler.NewFormattingHandler(formattingService)
 	qualityHandler := handler.NewQualityHandler(qualityGateService)
 
@@ -169,8 +194,24 @@ func Setup() *gin.Engine {
 			ai.PUT("/providers", middleware.JWTRequired("admin"), aiConfigHandler.UpdateProvider)
 			ai.GET("/providers", middleware.JWTRequired("admin"), aiConfigHandler.GetProvider)
 			ai.POST("/providers/test", middleware.JWTRequired("admin"), aiConfigHandler.TestProvider)
+			ai.GET("/providers/keys", middleware.JWTRequired("admin"), aiConfigHandler.ListProviderKeys)
+			ai.POST("/providers/keys", middleware.JWTRequired("admin"), aiConfigHandler.CreateProviderKey)
+			ai.PUT("/providers/keys/:id", middleware.JWTRequired("admin"), aiConfigHandler.UpdateProviderKey)
+			ai.DELETE("/providers/keys/:id", middleware.JWTRequired("admin"), aiConfigHandler.DeleteProviderKey)
+			ai.GET("/user-providers", middleware.JWTAuth(), aiConfigHandler.ListUserProviders)
+			ai.PUT("/user-providers", middleware.JWTAuth(), aiConfigHandler.SaveUserProvider)
+			ai.DELETE("/user-providers/:provider", middleware.JWTAuth(), aiConfigHandler.DeleteUserProvider)
 			ai.POST("/proxy", middleware.JWTAuth(), handler.RequireAIAccess(userService), aiProxyHandler.Proxy)
 			ai.POST("/proxy/stream", middleware.JWTAuth(), handler.RequireAIAccess(userService), aiProxyStreamHandler.ProxyStream)
+			ai.GET("/usage", middleware.JWTAuth(), aiUsageHandler.Summary)
+			ai.GET("/usage/records", middleware.JWTAuth(), aiUsageHandler.ListRecords)
+			ai.GET("/usage/admin", middleware.JWTRequired("admin"), aiUsageHandler.AdminSummary)
+			ai.GET("/usage/admin/records", middleware.JWTRequired("admin"), aiUsageHandler.AdminListRecords)
+			ai.GET("/billing", middleware.JWTAuth(), aiBillingHandler.GetStatus)
+			ai.GET("/quotas/:user_id", middleware.JWTRequired("admin"), aiBillingHandler.GetQuota)
+			ai.PUT("/quotas/:user_id", middleware.JWTRequired("admin"), aiBillingHandler.UpdateQuota)
+			ai.GET("/call-logs/:id", middleware.JWTAuth(), aiCallLogHandler.Get)
+			ai.GET("/cache/stats", middleware.JWTRequired("admin"), aiResponseCacheHandler.Stats)
 		}
 
 		// 项目路由
@@ -203,6 +244,10 @@ func Setup() *gin.Engine {
 			// 项目下的模板路由
 			projects.GET("/:project_id/templates", middleware.JWTAuth(), templateHandler.ListByProject)
 			projects.POST("/:project_id/templates", middleware.JWTAuth(), templateHandler.Create)
+
+			// 项目下的流水线路由
+			projects.GET("/:project_id/pipelines", middleware.JWTAuth(), pipelineHandler.ListByProject)
+			projects.POST("/:project_id/pipelines", middleware.JWTAuth(), pipelineHandler.Create)
 		}
 
 		// 卷路由
@@ -226,6 +271,15 @@ func Setup() *gin.Engine {
 			documents.DELETE("/:id/bookmarks/:index", middleware.JWTAuth(), documentHandler.RemoveBookmark)
 			documents.POST("/:id/entities", middleware.JWTAuth(), documentHandler.LinkEntity)
 			documents.DELETE("/:id/entities/:entity_id", middleware.JWTAuth(), documentHandler.UnlinkEntity)
+			documents.GET("/:id/revisions", middleware.JWTAuth(), documentHandler.ListRevisions)
+			documents.GET("/:id/revisions/diff", middleware.JWTAuth(), documentHandler.DiffRevisions)
+			documents.GET("/:id/revisions/:revision_id", middleware.JWTAuth(), documentHandler.GetRevision)
+			documents.POST("/:id/revisions/:revision_id/restore", middleware.JWTAuth(), documentHandler.RestoreRevision)
+			documents.GET("/:id/proposals", middleware.JWTAuth(), documentHandler.ListProposals)
+			documents.GET("/:id/proposals/:proposal_id", middleware.JWTAuth(), documentHandler.GetProposal)
+			documents.POST("/:id/proposals/:proposal_id/accept", middleware.JWTAuth(), documentHandler.AcceptProposal)
+			documents.POST("/:id/proposals/:proposal_id/accept-hunks", middleware.JWTAuth(), documentHandler.AcceptProposalHunks)
+			documents.POST("/:id/proposals/:proposal_id/reject", middleware.JWTAuth(), documentHandler.RejectProposal)
 		}
 
 		// 实体路由
@@ -245,6 +299,7 @@ func Setup() *gin.Engine {
 		{
 			templates.GET("/system", middleware.JWTAuth(), templateHandler.ListSystem)
 			templates.GET("/:id", middleware.JWTAuth(), templateHandler.GetByID)
+			templates.POST("/:id/render", middleware.JWTAuth(), templateHandler.Render)
 			templates.PUT("/:id", middleware.JWTAuth(), templateHandler.Update)
 			templates.DELETE("/:id", middleware.JWTAuth(), templateHandler.Delete)
 		}
@@ -285,6 +340,7 @@ func Setup() *gin.Engine {
 			sessions.GET("/:session_id", middleware.JWTAuth(), sessionHandler.GetSession)
 			sessions.PUT("/:session_id", middleware.JWTAuth(), sessionHandler.UpdateSession)
 			sessions.DELETE("/:session_id", middleware.JWTAuth(), sessionHandler.DeleteSession)
+			sessions.GET("/:session_id/ai-calls", middleware.JWTAuth(), aiCallLogHandler.ListBySession)
 
 			// SessionStep 路由
 			sessions.POST("/:session_id/steps", middleware.JWTAuth(), sessionHandler.CreateStep)
@@ -314,6 +370,10 @@ func Setup() *gin.Engine {
 				wizard.POST("/world", middleware.JWTAuth(), handler.RequireAIAccess(userService), workflowHandler.RunWizardWorld)
 				wizard.POST("/characters", middleware.JWTAuth(), handler.RequireAIAccess(userService), workflowHandler.RunWizardCharacters)
 				wizard.POST("/outline", middleware.JWTAuth(), handler.RequireAIAccess(userService), workflowHandler.RunWizardOutline)
+				wizard.POST("/world/structured", middleware.JWTAuth(), handler.RequireAIAccess(userService), workflowHandler.RunWizardWorldStructured)
+				wizard.POST("/characters/structured", middleware.JWTAuth(), handler.RequireAIAccess(userService), workflowHandler.RunWizardCharactersStructured)
+				wizard.POST("/outline/structured", middleware.JWTAuth(), handler.RequireAIAccess(userService), workflowHandler.RunWizardOutlineStructured)
+				wizard.POST("/commit", middleware.JWTAuth(), workflowHandler.CommitWizardProposal)
 			}
 
 			chapters := workflows.Group("/chapters")
@@ -322,9 +382,31 @@ func Setup() *gin.Engine {
 				chapters.POST("/analyze", middleware.JWTAuth(), handler.RequireAIAccess(userService), workflowHandler.RunChapterAnalyze)
 				chapters.POST("/rewrite", middleware.JWTAuth(), handler.RequireAIAccess(userService), workflowHandler.RunChapterRewrite)
 				chapters.POST("/batch", middleware.JWTAuth(), handler.RequireAIAccess(userService), workflowHandler.RunChapterBatch)
+				chapters.GET("/batch/:job_uuid", middleware.JWTAuth(), workflowHandler.GetChapterBatchJob)
+				chapters.POST("/batch/:job_uuid/retry", middleware.JWTAuth(), handler.RequireAIAccess(userService), workflowHandler.RetryChapterBatchJob)
+				chapters.POST("/batch/:job_uuid/resume", middleware.JWTAuth(), handler.RequireAIAccess(userService), workflowHandler.ResumeChapterBatchJob)
+				chapters.POST("/context/preview", middleware.JWTAuth(), workflowHandler.PreviewChapterContext)
 			}
 		}
 
+		// 流水线路由
+		pipelines := v1.Group("/pipelines")
+		{
+			pipelines.GET("/:id", middleware.JWTAuth(), pipelineHandler.GetByID)
+			pipelines.PUT("/:id", middleware.JWTAuth(), pipelineHandler.Update)
+			pipelines.DELETE("/:id", middleware.JWTAuth(), pipelineHandler.Delete)
+			pipelines.POST("/:id/runs", middleware.JWTAuth(), handler.RequireAIAccess(userService), pipelineHandler.Run)
+			pipelines.GET("/:id/runs", middleware.JWTAuth(), pipelineHandler.ListRuns)
+		}
+
+		pipelineRuns := v1.Group("/pipeline-runs")
+		{
+			pipelineRuns.GET("/:job_uuid", middleware.JWTAuth(), pipelineHandler.GetRun)
+			pipelineRuns.POST("/:job_uuid/pause", middleware.JWTAuth(), pipelineHandler.PauseRun)
+			pipelineRuns.POST("/:job_uuid/resume", middleware.JWTAuth(), handler.RequireAIAccess(userService), pipelineHandler.ResumeRun)
+			pipelineRuns.POST("/:job_uuid/cancel", middleware.JWTAuth(), pipelineHandler.CancelRun)
+		}
+
 		// AgentWriter 路由
 		agentWriter := v1.Group("/agent-writer")
 		{
@@ -406,9 +488,19 @@ func HealthCheck(c *gin.Context) {
 }
 
 // ReadinessCheck 就绪检查
+// 附带各 AI 供应商熔断状态；供应商熔断不影响本服务就绪，status 标记为 degraded
 func ReadinessCheck(c *gin.Context) {
+	status := "ready"
+	providers := service.AICircuitStatuses()
+	for _, provider := range providers {
+		if provider.State != service.AICircuitClosed {
+			status = "degraded"
+			break
+		}
+	}
 	response.SuccessWithData(c, gin.H{
-		"status":   "ready",
-		"database": "connected",
+		"status":       status,
+		"database":     "connected",
+		"ai_providers": providers,
 	})
 }
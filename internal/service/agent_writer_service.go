@@ -24,6 +24,7 @@ type ChapterOutline struct {
 // AgentWriterConfig 写作工作流配置
 type AgentWriterConfig struct {
 	ProjectID      uint             `json:"project_id"`
+	UserID         uint             `json:"user_id"`
 	DocumentID     uint             `json:"document_id"`
 	Prompt         string           `json:"prompt"`
 	Outline        []ChapterOutline `json:"outline"`
@@ -31,6 +32,11 @@ type AgentWriterConfig struct {
 	TotalChapters  int              `json:"total_chapters"`
 	Provider       string           `json:"provider"`
 	Path           string           `json:"path"`
+	Model          string           `json:"model"`
+	// Context 章节上下文注入选项，未指定时按 ai.context.auto_inject
+	Context *ChapterContextOptions `json:"context,omitempty"`
+	// QualityGate 质量门禁选项，未指定时按项目 ai_settings.quality_gate 与 ai.quality_gate
+	QualityGate *QualityGateOptions `json:"quality_gate,omitempty"`
 }
 
 // AgentWriterService 写作代理服务
@@ -38,25 +44,40 @@ type AgentWriterService struct {
 	sessionService  SessionService
 	documentService DocumentService
 	aiConfigService AIConfigService
+	projectService  ProjectService
+	usageService    AIUsageService
+	modelService    AIModelService
+	contextBuilder  ContextBuilder
+	qualityGate     QualityGateService
 	cancelFuncs     map[uint]context.CancelFunc
 	mu              sync.RWMutex
 }
 
 // NewAgentWriterService 创建写作代理服务
-func NewAgentWriterService(sessionService SessionService, documentService DocumentService, aiConfigService AIConfigService) *AgentWriterService {
+func NewAgentWriterService(sessionService SessionService, documentService DocumentService, aiConfigService AIConfigService, projectService ProjectService, usageService AIUsageService, modelService AIModelService, contextBuilder ContextBuilder, qualityGate QualityGateService) *AgentWriterService {
 	return &AgentWriterService{
 		sessionService:  sessionService,
 		documentService: documentService,
 		aiConfigService: aiConfigService,
+		projectService:  projectService,
+		usageService:    usageService,
+		modelService:    modelService,
+		contextBuilder:  contextBuilder,
+		qualityGate:     qualityGate,
 		cancelFuncs:     make(map[uint]context.CancelFunc),
 	}
 }
 
 // StartWritingTask 启动写作任务
-func (s *AgentWriterService) StartWritingTask(projectID, documentID uint, userID uint, prompt string, outline []ChapterOutline, provider, path string) (*model.Session, error) {
+func (s *AgentWriterService) StartWritingTask(projectID, documentID uint, userID uint, prompt string, outline []ChapterOutline, provider, path, modelName string, contextOpts *ChapterContextOptions, qualityOpts *QualityGateOptions) (*model.Session, error) {
+	if err := s.usageService.CheckBeforeCall(userID, provider, resolveAIFallbackChain(s.projectService, projectID, provider)); err != nil {
+		return nil, err
+	}
+
 	// 构建工作流配置
 	config := AgentWriterConfig{
 		ProjectID:      projectID,
+		UserID:         userID,
 		DocumentID:     documentID,
 		Prompt:         prompt,
 		Outline:        outline,
@@ -64,6 +85,9 @@ func (s *AgentWriterService) StartWritingTask(projectID, documentID uint, userID
 		TotalChapters:  len(outline),
 		Provider:       provider,
 		Path:           path,
+		Model:          modelName,
+		Context:        contextOpts,
+		QualityGate:    qualityOpts,
 	}
 
 	configJSON, err := json.Marshal(config)
@@ -133,6 +157,9 @@ func (s *AgentWriterService) executeWritingWorkflow(sessionID uint) {
 		s.updateWorkflowStatus(sessionID, "error")
 		return
 	}
+	if config.UserID == 0 {
+		config.UserID = session.UserID
+	}
 
 	// 更新状态为运行中
 	s.updateWorkflowStatus(sessionID, "running")
@@ -204,10 +231,10 @@ func (s *AgentWriterService) generateChapter(ctx context.Context, sessionID, doc
 		IsStreaming:  true,
 		StreamStatus: "streaming",
 		StepType:     "assistant",
-		OrderIndex:   chapterIndex + 1,
 	}
 
-	if err := s.sessionService.CreateStep(step); err != nil {
+	// 按会话内已有步骤顺延序号（质量检查会在章节之间追加步骤）
+	if err := s.sessionService.CreateStepAutoOrder(step); err != nil {
 		logger.Error("创建章节步骤失败", logger.Err(err))
 		return fmt.Errorf("failed to create chapter step")
 	}
@@ -219,7 +246,23 @@ func (s *AgentWriterService) generateChapter(ctx context.Context, sessionID, doc
 	lastUpdateTime := time.Now()
 
 	// 构建 AI 请求体
-	requestBody := s.buildChapterRequest(config.Prompt, chapter)
+	callReq, err := s.buildChapterRequest(config, chapter)
+	callReq.SessionID = sessionID
+	if err == nil {
+		err = checkAIStreamRequest(s.modelService, callReq)
+	}
+	if err == nil {
+		// 每章调用前做计费预检，余额或上限耗尽时中止后续章节
+		err = s.usageService.CheckBeforeCall(config.UserID, config.Provider, callReq.Fallback)
+	}
+	if err != nil {
+		step.StreamStatus = "error"
+		step.IsStreaming = false
+		if updateErr := s.sessionService.UpdateStep(step); updateErr != nil {
+			logger.Error("更新步骤状态失败", logger.Err(updateErr))
+		}
+		return err
+	}
 
 	// 流式生成章节内容
 	chunkHandler := func(chunk string) error {
@@ -253,10 +296,25 @@ func (s *AgentWriterService) generateChapter(ctx context.Context, sessionID, doc
 	}
 
 	// 调用 AI 流式生成
-	err := CallAIStream(ctx, s.aiConfigService, config.Provider, config.Path, requestBody, chunkHandler)
+	callResult, err := CallAIStream(ctx, s.aiConfigService, callReq, chunkHandler)
+	s.usageService.RecordCall(AIUsageScope{
+		UserID:    config.UserID,
+		ProjectID: config.ProjectID,
+		SessionID: sessionID,
+		Source:    "agent_writer",
+	}, callResult, err)
 
 	// 最终更新
 	step.Content = contentBuilder.String()
+	metadata := map[string]interface{}{
+		"provider":      config.Provider,
+		"path":          callReq.Path,
+		"attempts":      callResult.Attempts,
+		"provider_used": callResult.Provider,
+		"model":         callResult.Model,
+		"usage":         callResult.Usage,
+	}
+	step.Metadata = encodeMetadata(metadata)
 	if err != nil {
 		step.StreamStatus = "error"
 		step.IsStreaming = false
@@ -266,6 +324,11 @@ func (s *AgentWriterService) generateChapter(ctx context.Context, sessionID, doc
 		return err
 	}
 
+	if report := s.applyQualityGate(ctx, sessionID, config, callReq, step); report != nil {
+		metadata["quality"] = report
+		step.Metadata = encodeMetadata(metadata)
+	}
+
 	step.StreamStatus = "completed"
 	step.IsStreaming = false
 	if err := s.sessionService.UpdateStep(step); err != nil {
@@ -273,7 +336,13 @@ func (s *AgentWriterService) generateChapter(ctx context.Context, sessionID, doc
 	}
 
 	// 保存到文档
-	if err := s.documentService.AppendChapter(documentID, chapter.Title, step.Content); err != nil {
+	source := DocumentRevisionSource{
+		Source:    model.RevisionSourceAgentWriter,
+		SessionID: sessionID,
+		UserID:    config.UserID,
+		Note:      chapter.Title,
+	}
+	if err := s.documentService.AppendChapter(documentID, chapter.Title, step.Content, source); err != nil {
 		logger.Error("保存章节到文档失败", logger.Err(err))
 		return fmt.Errorf("failed to save chapter to document")
 	}
@@ -281,24 +350,118 @@ func (s *AgentWriterService) generateChapter(ctx context.Context, sessionID, doc
 	return nil
 }
 
-// buildChapterRequest 构建章节生成请求
-func (s *AgentWriterService) buildChapterRequest(prompt string, chapter ChapterOutline) string {
-	requestMap := map[string]interface{}{
-		"messages": []map[string]string{
+// applyQualityGate 启用质量门禁时检查章节正文，得分低于阈值时附上检测到的问题重新生成（重新生成的内容不逐块推送），
+// 每次检查记录为会话步骤，step.Content 替换为得分最高的版本；未启用时返回 nil
+func (s *AgentWriterService) applyQualityGate(ctx context.Context, sessionID uint, config AgentWriterConfig, callReq AICallRequest, step *model.SessionStep) *QualityGateReport {
+	settings := resolveQualityGateSettings(s.projectService, config.ProjectID, config.QualityGate)
+	if !settings.Enabled || s.qualityGate == nil {
+		return nil
+	}
+	hub := sse.GetHub()
+	sessionIDStr := fmt.Sprintf("%d", sessionID)
+
+	candidates := []string{step.Content}
+	regenerate := func(previous string, check *QualityCheckResult) (string, error) {
+		if err := s.usageService.CheckBeforeCall(config.UserID, config.Provider, callReq.Fallback); err != nil {
+			return "", err
+		}
+		chat := qualityRetryChat(callReq.Chat, previous, check, settings.MinScore)
+		path, body, err := buildChatCall(s.aiConfigService, callReq.Provider, callReq.Path, chat)
+		if err != nil {
+			return "", err
+		}
+		retryReq := callReq
+		retryReq.Path, retryReq.Body, retryReq.Chat = path, body, chat
+		if err := checkAIStreamRequest(s.modelService, retryReq); err != nil {
+			return "", err
+		}
+		var builder strings.Builder
+		callResult, err := CallAIStream(ctx, s.aiConfigService, retryReq, func(chunk string) error {
+			builder.WriteString(chunk)
+			return nil
+		})
+		s.usageService.RecordCall(AIUsageScope{
+			UserID:    config.UserID,
+			ProjectID: config.ProjectID,
+			SessionID: sessionID,
+			Source:    "agent_writer",
+		}, callResult, err)
+		if err != nil {
+			return "", err
+		}
+		candidates = append(candidates, builder.String())
+		return builder.String(), nil
+	}
+	best, report := runQualityGate(s.qualityGate, settings, step.Content, regenerate, func(attempt QualityGateAttempt, candidate string) {
+		qualityStep := &model.SessionStep{
+			SessionID:  sessionID,
+			Title:      fmt.Sprintf("%s 质量检查 第 %d 次（%d 分）", step.Title, attempt.Attempt, attempt.Score),
+			Content:    candidate,
+			FormatType: "agent_writer.quality",
+			Metadata:   encodeMetadata(qualityAttemptMetadata(attempt, settings)),
+		}
+		if err := s.sessionService.CreateStepAutoOrder(qualityStep); err != nil {
+			logger.Error("创建质量检查步骤失败", logger.Err(err))
+			return
+		}
+		hub.BroadcastToSession(sessionIDStr, sse.NewStepAppendedEvent(map[string]interface{}{
+			"step_id":   qualityStep.ID,
+			"title":     qualityStep.Title,
+			"content":   qualityStep.Content,
+			"timestamp": time.Now().Format(time.RFC3339),
+		}))
+	})
+
+	if best > 0 {
+		step.Content = candidates[best]
+		hub.BroadcastToSession(sessionIDStr, sse.NewStepCompletedEvent(map[string]interface{}{
+			"session_id": sessionID,
+			"step_id":    step.ID,
+			"content":    step.Content,
+		}))
+	}
+	return report
+}
+
+// buildChapterRequest 构建章节生成请求（按 provider 编码请求体，path 为空时自动推导，附带备用供应商链）
+func (s *AgentWriterService) buildChapterRequest(config AgentWriterConfig, chapter ChapterOutline) (AICallRequest, error) {
+	chat := &ChatRequest{
+		Model: config.Model,
+		Messages: []ChatMessage{
 			{
-				"role":    "system",
-				"content": "你是一个专业的小说写作助手，擅长根据大纲生成高质量的章节内容。",
+				Role:    "system",
+				Content: "你是一个专业的小说写作助手，擅长根据大纲生成高质量的章节内容。",
 			},
 			{
-				"role":    "user",
-				"content": fmt.Sprintf("根据以下要求生成章节内容：\n\n总体要求：%s\n\n章节标题：%s\n章节描述：%s\n\n请生成完整的章节内容。", prompt, chapter.Title, chapter.Description),
+				Role:    "user",
+				Content: fmt.Sprintf("根据以下要求生成章节内容：\n\n总体要求：%s\n\n章节标题：%s\n章节描述：%s\n\n请生成完整的章节内容。", config.Prompt, chapter.Title, chapter.Description),
 			},
 		},
-		"stream": true,
+		Stream: true,
 	}
-
-	requestJSON, _ := json.Marshal(requestMap)
-	return string(requestJSON)
+	if s.contextBuilder != nil && chapterContextEnabled(config.Context) {
+		target := ChapterContextRequest{ProjectID: config.ProjectID, DocumentID: config.DocumentID}
+		if config.Context != nil {
+			target.TokenBudget = config.Context.TokenBudget
+		}
+		if chapterCtx, err := s.contextBuilder.Build(target); err != nil {
+			logger.Warn("build chapter context failed", logger.Uint("project_id", config.ProjectID), logger.Err(err))
+		} else {
+			chat = injectChapterContext(chat, chapterCtx.Text)
+		}
+	}
+	path, body, err := buildChatCall(s.aiConfigService, config.Provider, config.Path, chat)
+	if err != nil {
+		return AICallRequest{}, err
+	}
+	return AICallRequest{
+		Provider: config.Provider,
+		Path:     path,
+		Body:     body,
+		Chat:     chat,
+		Fallback: resolveAIFallbackChain(s.projectService, config.ProjectID, config.Provider),
+		UserID:   config.UserID,
+	}, nil
 }
 
 // CancelWritingTask 取消写作任务
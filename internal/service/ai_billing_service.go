@@ -0,0 +1,241 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"novel-agent-os-backend/internal/config"
+	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// AI 计费业务错误码（调用上游前返回）
+const (
+	CodeAIInsufficientPoints   = 30001 // 积分余额不足
+	CodeAIDailyQuotaExceeded   = 30002 // 超出每日 token 上限
+	CodeAIMonthlyQuotaExceeded = 30003 // 超出每月 token 上限
+)
+
+// AIBillingError 计费预检失败，携带结构化信息供前端展示
+type AIBillingError struct {
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	Balance  int    `json:"balance,omitempty"`
+	Required int    `json:"required,omitempty"`
+	Limit    int64  `json:"limit,omitempty"`
+	Used     int64  `json:"used,omitempty"`
+}
+
+func (e *AIBillingError) Error() string {
+	return e.Message
+}
+
+// AIBillingStatus 用户计费概览
+type AIBillingStatus struct {
+	BillingEnabled     bool                  `json:"billing_enabled"`
+	Balance            int                   `json:"balance"`
+	MinBalance         int                   `json:"min_balance"`
+	DefaultPointsPer1K float64               `json:"default_points_per_1k"`
+	ModelPricing       []config.AIModelPrice `json:"model_pricing"`
+	DailyTokenLimit    int64                 `json:"daily_token_limit"`
+	MonthlyTokenLimit  int64                 `json:"monthly_token_limit"`
+	DailyTokensUsed    int64                 `json:"daily_tokens_used"`
+	MonthlyTokensUsed  int64                 `json:"monthly_tokens_used"`
+}
+
+type AIBillingService interface {
+	// CheckBeforeCall 调用上游前校验余额与 token 上限，失败返回 *AIBillingError
+	CheckBeforeCall(userID uint) error
+	// Charge 按实际用量扣除积分，返回扣除数；未开启计费或管理员返回 0
+	Charge(userID uint, modelName string, usage AIUsage) (int, error)
+	GetStatus(userID uint) (*AIBillingStatus, error)
+	GetQuota(userID uint) (*model.AIUserQuota, error)
+	SetQuota(userID uint, dailyLimit, monthlyLimit int64) (*model.AIUserQuota, error)
+}
+
+type aiBillingService struct {
+	userRepo  repository.UserRepository
+	usageRepo repository.AIUsageRepository
+}
+
+func NewAIBillingService(userRepo repository.UserRepository, usageRepo repository.AIUsageRepository) AIBillingService {
+	return &aiBillingService{
+		userRepo:  userRepo,
+		usageRepo: usageRepo,
+	}
+}
+
+func (s *aiBillingService) CheckBeforeCall(userID uint) error {
+	if userID == 0 {
+		return nil
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return fmt.Errorf("user not found: %w", err)
+	}
+	if user.Role == "admin" {
+		return nil
+	}
+
+	billing := config.Get().AI.Billing
+	if billing.Enabled && user.Points < billing.MinBalance {
+		return &AIBillingError{
+			Code:     CodeAIInsufficientPoints,
+			Message:  "积分余额不足",
+			Balance:  user.Points,
+			Required: billing.MinBalance,
+		}
+	}
+
+	quota, err := s.GetQuota(userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if quota.DailyTokenLimit > 0 {
+		used, err := s.usageRepo.SumUserTokens(userID, now.Format("2006-01-02"))
+		if err != nil {
+			return err
+		}
+		if used >= quota.DailyTokenLimit {
+			return &AIBillingError{
+				Code:    CodeAIDailyQuotaExceeded,
+				Message: "已达到每日 token 上限",
+				Limit:   quota.DailyTokenLimit,
+				Used:    used,
+			}
+		}
+	}
+	if quota.MonthlyTokenLimit > 0 {
+		used, err := s.usageRepo.SumUserTokens(userID, monthStart(now))
+		if err != nil {
+			return err
+		}
+		if used >= quota.MonthlyTokenLimit {
+			return &AIBillingError{
+				Code:    CodeAIMonthlyQuotaExceeded,
+				Message: "已达到每月 token 上限",
+				Limit:   quota.MonthlyTokenLimit,
+				Used:    used,
+			}
+		}
+	}
+	return nil
+}
+
+func (s *aiBillingService) Charge(userID uint, modelName string, usage AIUsage) (int, error) {
+	billing := config.Get().AI.Billing
+	if !billing.Enabled || userID == 0 || usage.TotalTokens <= 0 {
+		return 0, nil
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return 0, err
+	}
+	if user.Role == "admin" {
+		return 0, nil
+	}
+
+	points := calculateAIPoints(billing, modelName, usage.TotalTokens)
+	if points == 0 {
+		return 0, nil
+	}
+	// 调用已完成，允许余额扣为负数，下次预检时拦截
+	if err := s.userRepo.AddPoints(userID, -points); err != nil {
+		return 0, err
+	}
+	return points, nil
+}
+
+func (s *aiBillingService) GetStatus(userID uint) (*AIBillingStatus, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	quota, err := s.GetQuota(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	dailyUsed, err := s.usageRepo.SumUserTokens(userID, now.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	monthlyUsed, err := s.usageRepo.SumUserTokens(userID, monthStart(now))
+	if err != nil {
+		return nil, err
+	}
+
+	billing := config.Get().AI.Billing
+	return &AIBillingStatus{
+		BillingEnabled:     billing.Enabled,
+		Balance:            user.Points,
+		MinBalance:         billing.MinBalance,
+		DefaultPointsPer1K: billing.DefaultPointsPer1K,
+		ModelPricing:       billing.ModelPricing,
+		DailyTokenLimit:    quota.DailyTokenLimit,
+		MonthlyTokenLimit:  quota.MonthlyTokenLimit,
+		DailyTokensUsed:    dailyUsed,
+		MonthlyTokensUsed:  monthlyUsed,
+	}, nil
+}
+
+// GetQuota 获取用户上限，未设置时返回不限制的零值
+func (s *aiBillingService) GetQuota(userID uint) (*model.AIUserQuota, error) {
+	quota, err := s.usageRepo.GetQuota(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &model.AIUserQuota{UserID: userID}, nil
+		}
+		return nil, err
+	}
+	return quota, nil
+}
+
+func (s *aiBillingService) SetQuota(userID uint, dailyLimit, monthlyLimit int64) (*model.AIUserQuota, error) {
+	if dailyLimit < 0 || monthlyLimit < 0 {
+		return nil, fmt.Errorf("limit must not be negative")
+	}
+	if _, err := s.userRepo.FindByID(userID); err != nil {
+		return nil, err
+	}
+	quota, err := s.GetQuota(userID)
+	if err != nil {
+		return nil, err
+	}
+	quota.DailyTokenLimit = dailyLimit
+	quota.MonthlyTokenLimit = monthlyLimit
+	if err := s.usageRepo.SaveQuota(quota); err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+// calculateAIPoints 按模型单价计算积分，不足 1 积分按 1 计
+func calculateAIPoints(billing config.AIBillingConfig, modelName string, totalTokens int) int {
+	price := aiModelPointsPer1K(billing, modelName)
+	if price <= 0 || totalTokens <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(totalTokens) * price / 1000))
+}
+
+// aiModelPointsPer1K 模型单价，未配置时使用默认单价
+func aiModelPointsPer1K(billing config.AIBillingConfig, modelName string) float64 {
+	for _, item := range billing.ModelPricing {
+		if strings.EqualFold(strings.TrimSpace(item.Model), strings.TrimSpace(modelName)) {
+			return item.PointsPer1K
+		}
+	}
+	return billing.DefaultPointsPer1K
+}
+
+func monthStart(now time.Time) string {
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+}
@@ -1,10 +1,10 @@
 package service
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,56 +14,288 @@ import (
 	"novel-agent-os-backend/pkg/logger"
 )
 
-// callAI 统一的 AI 调用封装
-func callAI(aiConfigService AIConfigService, provider, path, body string) (json.RawMessage, string, error) {
-	if path == "" || strings.Contains(path, "..") {
-		return nil, "", fmt.Errorf("invalid path")
+// anthropicAPIVersion Anthropic Messages API 版本头
+const anthropicAPIVersion = "2023-06-01"
+
+// SetAIProviderHeaders 按供应商设置鉴权请求头
+// - gemini：x-goog-api-key
+// - anthropic：x-api-key + anthropic-version
+// - 其他：按 OpenAI 兼容接口使用 Bearer Token
+func SetAIProviderHeaders(req *http.Request, provider, apiKey string) {
+	cleanProvider := strings.ToLower(strings.TrimSpace(provider))
+	if cleanProvider == "anthropic" {
+		// anthropic-version 为必填头，即便未配置密钥（如本地网关）也需携带
+		req.Header.Set("anthropic-version", anthropicAPIVersion)
+	}
+	if apiKey == "" {
+		return
+	}
+	switch cleanProvider {
+	case "gemini":
+		req.Header.Set("x-goog-api-key", apiKey)
+	case "anthropic":
+		req.Header.Set("x-api-key", apiKey)
+	default:
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+}
+
+// AICallResult AI 调用结果
+type AICallResult struct {
+	Raw      json.RawMessage
+	Content  string
+	Attempts []AIAttempt
+	// Provider/Model/Path 为实际应答的供应商（发生 fallback 时与请求不同）
+	Provider string
+	Model    string
+	Path     string
+	// Usage 上游返回的用量，未返回时为估算值
+	Usage     AIUsage
+	LatencyMs int64
+	// UserKey 应答的供应商使用了用户自有密钥
+	UserKey bool
+	// RequestBody 最后一次发往上游的请求体（含注入的 tools 与备用供应商改写），用于调用审计
+	RequestBody string
+	// Cached 结果来自响应缓存（未请求上游）
+	Cached bool
+}
+
+// callAI 统一的 AI 调用封装：按 ai.retry 策略重试，连接失败或 5xx 时依次切换备用供应商
+// 返回结果及每次尝试记录（失败时同样返回已有记录）；cache 非空时确定性请求优先读取响应缓存
+// ctx 取消时放弃排队、退避等待与进行中的上游请求
+func callAI(ctx context.Context, aiConfigService AIConfigService, cache AIResponseCacheService, req AICallRequest) (*AICallResult, error) {
+	if cache != nil {
+		if cached, ok := cache.Lookup(req); ok {
+			return cached, nil
+		}
 	}
+	result := &AICallResult{}
+	start := time.Now()
+	defer func() {
+		result.LatencyMs = time.Since(start).Milliseconds()
+	}()
+	var lastErr error
+	for i := 0; i <= len(req.Fallback); i++ {
+		target := primaryAICallTarget(req)
+		if i > 0 {
+			var err error
+			target, err = fallbackAICallTarget(aiConfigService, req, req.Fallback[i-1], false)
+			if err != nil {
+				logger.Warn("skip AI fallback entry", logger.String("provider", req.Fallback[i-1].Provider), logger.Err(err))
+				continue
+			}
+			logger.Warn("AI provider failed, falling back",
+				logger.String("from", req.Provider),
+				logger.String("to", target.Provider),
+			)
+		}
 
-	providerCfg, err := aiConfigService.GetProviderConfigRaw(provider)
+		result.RequestBody = target.Body
+		raw, err := callAIProvider(ctx, aiConfigService, req, target, result)
+		if err == nil {
+			result.Raw = json.RawMessage(raw)
+			result.Content = extractAIText(raw)
+			result.Provider = target.Provider
+			result.Model = target.Model
+			result.Path = target.Path
+			result.Usage = finalizeAIUsage(extractAIUsage(raw), target.Body, result.Content)
+			if cache != nil {
+				cache.Store(req, result)
+			}
+			return result, nil
+		}
+		lastErr = err
+		if !isFallbackAIError(err) {
+			return result, err
+		}
+	}
+	return result, lastErr
+}
+
+// callAIProvider 对单个供应商按重试策略调用，尝试记录追加到 result.Attempts
+// 每次尝试占用一个供应商并发名额，重试退避期间归还
+func callAIProvider(ctx context.Context, aiConfigService AIConfigService, req AICallRequest, target aiCallTarget, result *AICallResult) ([]byte, error) {
+	if target.Path == "" || strings.Contains(target.Path, "..") {
+		return nil, fmt.Errorf("invalid path")
+	}
+	if isMockAIProvider(target.Provider) {
+		var raw []byte
+		attempt := 0
+		release, err := runAIWithRetry(ctx, req, target.Provider, false, result, func() error {
+			attempt++
+			return guardAICircuit(target.Provider, false, func() error {
+				var callErr error
+				raw, callErr = mockAIRequest(target.Body, attempt)
+				return callErr
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		release()
+		return raw, nil
+	}
+
+	providerCfg, userKey, err := ResolveAIProviderConfig(aiConfigService, req.UserID, target.Provider)
 	if err != nil {
-		return nil, "", fmt.Errorf("provider not found")
+		return nil, fmt.Errorf("provider not found")
 	}
-	if err := ValidateAIProxyTarget(provider, providerCfg.BaseURL, path); err != nil {
-		return nil, "", err
+	result.UserKey = userKey
+	if err := ValidateAIProxyTarget(target.Provider, providerCfg.BaseURL, target.Path); err != nil {
+		return nil, err
 	}
 
 	base := strings.TrimRight(providerCfg.BaseURL, "/")
-	url := base + "/" + strings.TrimLeft(path, "/")
+	url := base + "/" + strings.TrimLeft(target.Path, "/")
 
-	client := &http.Client{Timeout: 60 * time.Second}
-	proxyReq, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+	client := NewAIHTTPClient(currentAIRequestTimeout(), userKey)
+	var raw []byte
+	release, err := runAIWithRetry(ctx, req, target.Provider, userKey, result, func() error {
+		lease, apiKey, leaseErr := acquireAIKey(aiConfigService, target.Provider, providerCfg.APIKey, userKey)
+		if leaseErr != nil {
+			return leaseErr
+		}
+		return guardAICircuit(target.Provider, userKey, func() error {
+			var callErr error
+			raw, callErr = doAIRequest(ctx, client, url, target.Provider, apiKey, target.Body)
+			return aiConfigService.ReportAPIKeyResult(lease, callErr)
+		})
+	})
 	if err != nil {
-		return nil, "", fmt.Errorf("proxy request failed")
+		return nil, err
 	}
-	proxyReq.Header.Set("Content-Type", "application/json")
-	proxyReq.Header.Set("Accept", "application/json")
-	if providerCfg.APIKey != "" {
-		if provider == "gemini" {
-			proxyReq.Header.Set("x-goog-api-key", providerCfg.APIKey)
-		} else {
-			proxyReq.Header.Set("Authorization", "Bearer "+providerCfg.APIKey)
+	release()
+	return raw, nil
+}
+
+// runAIWithRetry 按 ai.retry 策略执行 do，每次尝试记录到 result.Attempts
+// 每次尝试前获取供应商并发名额，失败后先归还再退避等待；成功时返回仍占用名额的释放函数，由调用方归还
+// 换用密钥池中其他密钥的尝试不计入 max_attempts 且无需等待，次数以池中其余可用密钥数为上限
+func runAIWithRetry(ctx context.Context, req AICallRequest, provider string, userKey bool, result *AICallResult, do func() error) (func(), error) {
+	policy := currentAIRetryPolicy()
+	rotations := 0
+	for attempt := 1; ; attempt++ {
+		release, err := acquireAISlot(ctx, req, provider, userKey)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		err = do()
+		record := AIAttempt{Provider: provider, Attempt: attempt, LatencyMs: time.Since(start).Milliseconds()}
+		if err == nil {
+			result.Attempts = append(result.Attempts, record)
+			return release, nil
+		}
+		release()
+
+		record.StatusCode = attemptErrorStatus(err)
+		record.Error = truncateAttemptError(err.Error())
+		var rotateErr *AIKeyRotateError
+		if errors.As(err, &rotateErr) && rotations < rotateErr.Remaining {
+			rotations++
+			result.Attempts = append(result.Attempts, record)
+			logger.Warn("AI key rejected, rotating",
+				logger.String("provider", provider),
+				logger.Int("attempt", attempt),
+				logger.Int("status_code", record.StatusCode),
+			)
+			continue
 		}
+
+		retries := attempt - rotations
+		if retries >= policy.maxAttempts || !isRetryableAIError(err) {
+			result.Attempts = append(result.Attempts, record)
+			return nil, err
+		}
+		wait, ok := policy.backoff(retries, err)
+		if !ok {
+			result.Attempts = append(result.Attempts, record)
+			return nil, err
+		}
+		record.BackoffMs = wait.Milliseconds()
+		result.Attempts = append(result.Attempts, record)
+
+		logger.Warn("AI call failed, retrying",
+			logger.String("provider", provider),
+			logger.Int("attempt", attempt),
+			logger.Int("status_code", record.StatusCode),
+			logger.Int("backoff_ms", int(record.BackoffMs)),
+		)
+		if err := sleepWithContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// guardAICircuit 在供应商熔断器保护下执行一次尝试：熔断中直接返回 *AICircuitOpenError（不重试，可切换备用供应商）
+// 使用用户自有密钥时不计入平台熔断统计，避免单个用户的异常配置影响所有用户
+func guardAICircuit(provider string, userKey bool, do func() error) error {
+	if userKey {
+		return do()
 	}
+	permit, err := AcquireAICircuit(provider)
+	if err != nil {
+		return err
+	}
+	err = do()
+	ReportAICircuitResult(permit, err)
+	return err
+}
+
+// acquireAIKey 每次尝试重新选择密钥（被限流的密钥冷却后自动换下一个），未配置密钥池时使用 fallbackKey
+// 使用用户自有密钥时不经过平台密钥池
+func acquireAIKey(aiConfigService AIConfigService, provider, fallbackKey string, userKey bool) (*AIKeyLease, string, error) {
+	if userKey {
+		return nil, fallbackKey, nil
+	}
+	lease, err := aiConfigService.AcquireAPIKey(provider)
+	if err != nil {
+		return nil, "", fmt.Errorf("acquire api key failed: %w", err)
+	}
+	if lease == nil {
+		return nil, fallbackKey, nil
+	}
+	return lease, lease.APIKey, nil
+}
+
+// doAIRequest 执行单次非流式上游请求
+func doAIRequest(ctx context.Context, client *http.Client, url, provider, apiKey, body string) ([]byte, error) {
+	proxyReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, fmt.Errorf("proxy request failed")
+	}
+	proxyReq.Header.Set("Content-Type", "application/json")
+	proxyReq.Header.Set("Accept", "application/json")
+	SetAIProviderHeaders(proxyReq, provider, apiKey)
 
 	resp, err := client.Do(proxyReq)
 	if err != nil {
 		logger.Error("workflow proxy request failed", logger.Err(err))
-		return nil, "", fmt.Errorf("proxy request failed")
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &AITransportError{Err: err}
 	}
 	defer resp.Body.Close()
 
 	raw, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, "", fmt.Errorf("read response failed")
+		return nil, &AITransportError{Err: err}
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, "", fmt.Errorf("upstream error: %s", string(raw))
+		return nil, NewAIUpstreamError(resp, raw)
 	}
+	return raw, nil
+}
 
-	content := extractAIText(raw)
-	return json.RawMessage(raw), content, nil
+// truncateAttemptError 截断错误信息，避免上游错误体撑大步骤 metadata
+func truncateAttemptError(msg string) string {
+	const maxLen = 500
+	if len(msg) <= maxLen {
+		return msg
+	}
+	return msg[:maxLen] + "..."
 }
 
 // extractAIText 尝试从主流响应中提取文本
@@ -99,99 +331,195 @@ func extractAIText(raw []byte) string {
 		}
 	}
 
+	// OpenAI Responses: output[] 中 type=message 的 content[].text（仅拼接 output_text，忽略 reasoning/function_call 等）
+	if items, ok := payload["output"].([]interface{}); ok {
+		return extractResponsesOutputText(items)
+	}
+
+	// Anthropic: content[].text（仅拼接 type=text 的块，忽略 tool_use/thinking 等）
+	if blocks, ok := payload["content"].([]interface{}); ok && len(blocks) > 0 {
+		var builder strings.Builder
+		for _, item := range blocks {
+			block, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if blockType, _ := block["type"].(string); blockType != "text" {
+				continue
+			}
+			if text, ok := block["text"].(string); ok {
+				builder.WriteString(text)
+			}
+		}
+		return builder.String()
+	}
+
 	return ""
 }
 
+// extractResponsesOutputText 拼接 Responses API output[] 中的消息文本
+func extractResponsesOutputText(items []interface{}) string {
+	var builder strings.Builder
+	for _, item := range items {
+		output, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if outputType, _ := output["type"].(string); outputType != "message" {
+			continue
+		}
+		parts, ok := output["content"].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, part := range parts {
+			block, ok := part.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if blockType, _ := block["type"].(string); blockType != "output_text" {
+				continue
+			}
+			if text, ok := block["text"].(string); ok {
+				builder.WriteString(text)
+			}
+		}
+	}
+	return builder.String()
+}
+
 // CallAIStream 流式调用 AI 接口
-func CallAIStream(ctx context.Context, aiConfigService AIConfigService, provider, path, body string, chunkHandler func(chunk string) error) error {
-	if path == "" || strings.Contains(path, "..") {
-		return fmt.Errorf("invalid path")
+// 仅在收到首个响应前重试或切换备用供应商，已开始推送 chunk 后不再重放
+func CallAIStream(ctx context.Context, aiConfigService AIConfigService, req AICallRequest, chunkHandler func(chunk string) error) (*AICallResult, error) {
+	result := &AICallResult{}
+	start := time.Now()
+	defer func() {
+		result.LatencyMs = time.Since(start).Milliseconds()
+	}()
+	var lastErr error
+	for i := 0; i <= len(req.Fallback); i++ {
+		target := primaryAICallTarget(req)
+		if i > 0 {
+			var err error
+			target, err = fallbackAICallTarget(aiConfigService, req, req.Fallback[i-1], true)
+			if err != nil {
+				logger.Warn("skip AI fallback entry", logger.String("provider", req.Fallback[i-1].Provider), logger.Err(err))
+				continue
+			}
+			logger.Warn("AI stream provider failed, falling back",
+				logger.String("from", req.Provider),
+				logger.String("to", target.Provider),
+			)
+		}
+
+		result.RequestBody = target.Body
+		resp, err := openAIStreamWithRetry(ctx, aiConfigService, req, target, result)
+		if err != nil {
+			lastErr = err
+			if !isFallbackAIError(err) {
+				return result, err
+			}
+			continue
+		}
+
+		result.Provider = target.Provider
+		result.Model = target.Model
+		result.Path = target.Path
+		var contentBuilder strings.Builder
+		var usage AIUsage
+		err = parseAISSE(ctx, target.Provider, resp.Body, func(chunk string) error {
+			contentBuilder.WriteString(chunk)
+			return chunkHandler(chunk)
+		}, &usage)
+		resp.Body.Close()
+		result.Content = contentBuilder.String()
+		result.Usage = finalizeAIUsage(usage, target.Body, result.Content)
+		return result, err
+	}
+	return result, lastErr
+}
+
+// openAIStreamWithRetry 对单个供应商建立流式连接，尝试记录追加到 result.Attempts
+// 每次尝试占用供应商并发名额（重试退避期间归还），连接建立后占用至响应体关闭
+func openAIStreamWithRetry(ctx context.Context, aiConfigService AIConfigService, req AICallRequest, target aiCallTarget, result *AICallResult) (*http.Response, error) {
+	if target.Path == "" || strings.Contains(target.Path, "..") {
+		return nil, fmt.Errorf("invalid path")
+	}
+	if isMockAIProvider(target.Provider) {
+		var resp *http.Response
+		attempt := 0
+		release, err := runAIWithRetry(ctx, req, target.Provider, false, result, func() error {
+			attempt++
+			return guardAICircuit(target.Provider, false, func() error {
+				var openErr error
+				resp, openErr = openMockAIStream(ctx, target.Body, attempt)
+				return openErr
+			})
+		})
+		return holdAISlot(resp, err, release)
 	}
 
-	providerCfg, err := aiConfigService.GetProviderConfigRaw(provider)
+	providerCfg, userKey, err := ResolveAIProviderConfig(aiConfigService, req.UserID, target.Provider)
 	if err != nil {
-		return fmt.Errorf("provider not found")
+		return nil, fmt.Errorf("provider not found")
 	}
-	if err := ValidateAIProxyTarget(provider, providerCfg.BaseURL, path); err != nil {
-		return err
+	result.UserKey = userKey
+	path := normalizeStreamPath(target.Provider, target.Path)
+	if err := ValidateAIProxyTarget(target.Provider, providerCfg.BaseURL, path); err != nil {
+		return nil, err
 	}
 
 	base := strings.TrimRight(providerCfg.BaseURL, "/")
 	url := base + "/" + strings.TrimLeft(path, "/")
 
-	client := &http.Client{Timeout: 0}
+	client := NewAIHTTPClient(0, userKey)
+	var resp *http.Response
+	release, err := runAIWithRetry(ctx, req, target.Provider, userKey, result, func() error {
+		lease, apiKey, leaseErr := acquireAIKey(aiConfigService, target.Provider, providerCfg.APIKey, userKey)
+		if leaseErr != nil {
+			return leaseErr
+		}
+		return guardAICircuit(target.Provider, userKey, func() error {
+			var openErr error
+			resp, openErr = openAIStream(ctx, client, url, target.Provider, apiKey, target.Body)
+			return aiConfigService.ReportAPIKeyResult(lease, openErr)
+		})
+	})
+	return holdAISlot(resp, err, release)
+}
+
+// holdAISlot 连接建立成功时由响应体持有并发名额，关闭响应体时归还
+func holdAISlot(resp *http.Response, err error, release func()) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &aiSlotBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// openAIStream 建立流式连接，非 2xx 时读取错误体并关闭连接
+func openAIStream(ctx context.Context, client *http.Client, url, provider, apiKey, body string) (*http.Response, error) {
 	proxyReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBufferString(body))
 	if err != nil {
-		return fmt.Errorf("proxy request failed")
+		return nil, fmt.Errorf("proxy request failed")
 	}
 	proxyReq.Header.Set("Content-Type", "application/json")
 	proxyReq.Header.Set("Accept", "text/event-stream")
-	if providerCfg.APIKey != "" {
-		if provider == "gemini" {
-			proxyReq.Header.Set("x-goog-api-key", providerCfg.APIKey)
-		} else {
-			proxyReq.Header.Set("Authorization", "Bearer "+providerCfg.APIKey)
-		}
-	}
+	SetAIProviderHeaders(proxyReq, provider, apiKey)
 
 	resp, err := client.Do(proxyReq)
 	if err != nil {
 		logger.Error("stream request failed", logger.Err(err))
-		return fmt.Errorf("stream request failed")
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &AITransportError{Err: err}
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		raw, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("upstream error: %s", string(raw))
+		resp.Body.Close()
+		return nil, NewAIUpstreamError(resp, raw)
 	}
-
-	return parseOpenAISSE(ctx, resp.Body, chunkHandler)
-}
-
-// parseOpenAISSE 解析 OpenAI SSE 格式
-func parseOpenAISSE(ctx context.Context, reader io.Reader, chunkHandler func(chunk string) error) error {
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "[DONE]" {
-			return nil
-		}
-
-		var payload map[string]interface{}
-		if err := json.Unmarshal([]byte(data), &payload); err != nil {
-			logger.Warn("failed to parse SSE chunk", logger.String("data", data))
-			continue
-		}
-
-		// 提取 OpenAI 格式的 content
-		if choices, ok := payload["choices"].([]interface{}); ok && len(choices) > 0 {
-			if choice, ok := choices[0].(map[string]interface{}); ok {
-				if delta, ok := choice["delta"].(map[string]interface{}); ok {
-					if content, ok := delta["content"].(string); ok && content != "" {
-						if err := chunkHandler(content); err != nil {
-							return err
-						}
-					}
-				}
-			}
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("scanner error: %w", err)
-	}
-
-	return nil
+	return resp, nil
 }
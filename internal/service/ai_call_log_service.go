@@ -0,0 +1,187 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"novel-agent-os-backend/internal/config"
+	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/internal/repository"
+	"novel-agent-os-backend/pkg/logger"
+)
+
+const aiRedacted = "[REDACTED]"
+
+// aiSecretRedactions 始终生效的密钥脱敏规则
+var aiSecretRedactions = []struct {
+	pattern *regexp.Regexp
+	replace string
+}{
+	{regexp.MustCompile(`(?i)("(?:api[_-]?key|apikey|authorization|x-api-key|x-goog-api-key|access[_-]?token|secret)"\s*:\s*")[^"]*(")`), "${1}" + aiRedacted + "${2}"},
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`), "${1}" + aiRedacted},
+	{regexp.MustCompile(`([?&]key=)[^&"\s]+`), "${1}" + aiRedacted},
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`), aiRedacted},
+	{regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{30,}`), aiRedacted},
+	{regexp.MustCompile(`enc:v1:[A-Za-z0-9+/:=]+`), aiRedacted},
+}
+
+// aiRedactPatternCache 已编译的配置脱敏规则（编译失败记为 nil，只告警一次）
+var aiRedactPatternCache sync.Map
+
+// AICallLogService AI 调用审计日志
+type AICallLogService interface {
+	// Record 记录一次上游调用（ai.call_log.enabled 关闭时忽略），写入失败仅记日志
+	Record(scope AIUsageScope, result *AICallResult, callErr error)
+	GetByID(id uint) (*model.AICallLog, error)
+	ListBySession(sessionID uint, page, pageSize int) ([]*model.AICallLog, int64, error)
+}
+
+type aiCallLogService struct {
+	logRepo repository.AICallLogRepository
+}
+
+// NewAICallLogService 创建调用日志服务，并启动过期日志的定期清理
+func NewAICallLogService(logRepo repository.AICallLogRepository) AICallLogService {
+	s := &aiCallLogService{logRepo: logRepo}
+	go s.purgeLoop()
+	return s
+}
+
+func (s *aiCallLogService) Record(scope AIUsageScope, result *AICallResult, callErr error) {
+	logCfg := config.Get().AI.CallLog
+	if !logCfg.Enabled || result == nil || (len(result.Attempts) == 0 && !result.Cached) {
+		return
+	}
+
+	provider := result.Provider
+	statusCode := 0
+	if len(result.Attempts) > 0 {
+		last := result.Attempts[len(result.Attempts)-1]
+		if provider == "" {
+			provider = last.Provider
+		}
+		statusCode = last.StatusCode
+	}
+	if callErr == nil && statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	responseBody := string(result.Raw)
+	if responseBody == "" {
+		responseBody = result.Content
+	}
+	var upstreamErr *AIUpstreamError
+	if callErr != nil && errors.As(callErr, &upstreamErr) {
+		responseBody = upstreamErr.Body
+	}
+
+	maxBytes := logCfg.MaxBodyKB * 1024
+	requestBody, requestTruncated := truncateAICallLogBody(redactAICallLog(result.RequestBody, logCfg.RedactPatterns), maxBytes)
+	responseBody, responseTruncated := truncateAICallLogBody(redactAICallLog(responseBody, logCfg.RedactPatterns), maxBytes)
+	attempts, _ := json.Marshal(result.Attempts)
+
+	log := &model.AICallLog{
+		UserID:       scope.UserID,
+		ProjectID:    scope.ProjectID,
+		SessionID:    scope.SessionID,
+		Source:       scope.Source,
+		Provider:     provider,
+		Model:        result.Model,
+		Path:         result.Path,
+		StatusCode:   statusCode,
+		Success:      callErr == nil,
+		Cached:       result.Cached,
+		LatencyMs:    result.LatencyMs,
+		Attempts:     attempts,
+		RequestBody:  requestBody,
+		ResponseBody: responseBody,
+		Truncated:    requestTruncated || responseTruncated,
+	}
+	if callErr != nil {
+		log.Error = redactAICallLog(truncateAttemptError(callErr.Error()), logCfg.RedactPatterns)
+	}
+	if err := s.logRepo.Create(log); err != nil {
+		logger.Error("failed to record ai call log", logger.Err(err))
+	}
+}
+
+func (s *aiCallLogService) GetByID(id uint) (*model.AICallLog, error) {
+	return s.logRepo.GetByID(id)
+}
+
+func (s *aiCallLogService) ListBySession(sessionID uint, page, pageSize int) ([]*model.AICallLog, int64, error) {
+	return s.logRepo.ListBySession(sessionID, page, pageSize)
+}
+
+// purgeLoop 每小时清理超过保留天数的日志（关闭审计日志后仍清理存量）
+func (s *aiCallLogService) purgeLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		s.purge()
+		<-ticker.C
+	}
+}
+
+func (s *aiCallLogService) purge() {
+	days := config.Get().AI.CallLog.RetentionDays
+	if days <= 0 {
+		return
+	}
+	deleted, err := s.logRepo.DeleteBefore(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		logger.Warn("purge ai call logs failed", logger.Err(err))
+		return
+	}
+	if deleted > 0 {
+		logger.Info("purged ai call logs", logger.Int("deleted", int(deleted)), logger.Int("retention_days", days))
+	}
+}
+
+// redactAICallLog 脱敏 API Key 及配置的敏感信息
+func redactAICallLog(text string, patterns []string) string {
+	if text == "" {
+		return text
+	}
+	for _, rule := range aiSecretRedactions {
+		text = rule.pattern.ReplaceAllString(text, rule.replace)
+	}
+	for _, expr := range patterns {
+		if pattern := compileAIRedactPattern(expr); pattern != nil {
+			text = pattern.ReplaceAllString(text, aiRedacted)
+		}
+	}
+	return text
+}
+
+func compileAIRedactPattern(expr string) *regexp.Regexp {
+	if cached, ok := aiRedactPatternCache.Load(expr); ok {
+		pattern, _ := cached.(*regexp.Regexp)
+		return pattern
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		logger.Warn("invalid ai call log redact pattern", logger.String("pattern", expr), logger.Err(err))
+		aiRedactPatternCache.Store(expr, (*regexp.Regexp)(nil))
+		return nil
+	}
+	aiRedactPatternCache.Store(expr, pattern)
+	return pattern
+}
+
+// truncateAICallLogBody 按字节截断（不截断多字节字符），返回是否发生截断
+func truncateAICallLogBody(text string, maxBytes int) (string, bool) {
+	if maxBytes <= 0 || len(text) <= maxBytes {
+		return text, false
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut], true
+}
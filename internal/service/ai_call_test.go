@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const retrySlotTestConfig = `
+ai:
+  retry:
+    max_attempts: 2
+    initial_backoff_ms: 300
+    max_backoff_ms: 300
+  concurrency:
+    enabled: true
+    provider_limit: 1
+    per_user_limit: 1
+    queue_timeout_sec: 5
+`
+
+func TestRunAIWithRetryReleasesSlotDuringBackoff(t *testing.T) {
+	useTestConfig(t, retrySlotTestConfig)
+	const provider = "retry-slot-test"
+
+	failed := make(chan struct{})
+	type outcome struct {
+		release func()
+		err     error
+	}
+	done := make(chan outcome, 1)
+	result := &AICallResult{}
+	go func() {
+		calls := 0
+		release, err := runAIWithRetry(context.Background(), AICallRequest{UserID: 1}, provider, false, result, func() error {
+			calls++
+			if calls == 1 {
+				close(failed)
+				return &AIUpstreamError{StatusCode: http.StatusTooManyRequests}
+			}
+			return nil
+		})
+		done <- outcome{release: release, err: err}
+	}()
+
+	<-failed
+	// 退避等待期间其他用户应能拿到唯一的名额
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	release, err := acquireAISlot(ctx, AICallRequest{UserID: 2}, provider, false)
+	if err != nil {
+		t.Fatalf("slot still held during backoff: %v", err)
+	}
+	release()
+
+	out := <-done
+	if out.err != nil {
+		t.Fatalf("runAIWithRetry: %v", out.err)
+	}
+	if len(result.Attempts) != 2 || result.Attempts[0].StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected attempts %+v", result.Attempts)
+	}
+	// 成功后名额由调用方持有
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel2()
+	if _, err := acquireAISlot(ctx2, AICallRequest{UserID: 2}, provider, false); err == nil {
+		t.Fatal("slot should be held until the caller releases it")
+	}
+	out.release()
+}
+
+func TestRunAIWithRetryStopsOnCancel(t *testing.T) {
+	useTestConfig(t, `
+ai:
+  retry:
+    max_attempts: 3
+    initial_backoff_ms: 10000
+    max_backoff_ms: 10000
+`)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := runAIWithRetry(ctx, AICallRequest{}, "cancel-test", false, &AICallResult{}, func() error {
+		return &AIUpstreamError{StatusCode: http.StatusServiceUnavailable}
+	})
+	if !stderrors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("cancel took %s, backoff was not interrupted", elapsed)
+	}
+}
+
+func TestRunAIWithRetryKeyRotation(t *testing.T) {
+	useTestConfig(t, `
+ai:
+  retry:
+    max_attempts: 2
+    initial_backoff_ms: 1
+    max_backoff_ms: 1
+`)
+	limited := &AIUpstreamError{StatusCode: http.StatusTooManyRequests}
+	tests := []struct {
+		name      string
+		remaining int
+		calls     int
+	}{
+		// 池中还有 2 个可用密钥：换 2 次密钥后仍有 max_attempts 次常规尝试
+		{name: "rotations do not use attempts", remaining: 2, calls: 4},
+		// 只剩 1 个可用密钥：超出换密钥上限的失败计入 max_attempts
+		{name: "rotations capped by pool", remaining: 1, calls: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			result := &AICallResult{}
+			_, err := runAIWithRetry(context.Background(), AICallRequest{}, "rotate-test", false, result, func() error {
+				calls++
+				return &AIKeyRotateError{Err: limited, Remaining: tt.remaining}
+			})
+			if !stderrors.Is(err, limited) {
+				t.Fatalf("err = %v, want rate limited", err)
+			}
+			if calls != tt.calls || len(result.Attempts) != tt.calls {
+				t.Fatalf("calls = %d, attempts = %d, want %d", calls, len(result.Attempts), tt.calls)
+			}
+			for i, attempt := range result.Attempts[:tt.remaining] {
+				if attempt.BackoffMs != 0 {
+					t.Errorf("rotation %d waited %dms, want none", i+1, attempt.BackoffMs)
+				}
+			}
+		})
+	}
+}
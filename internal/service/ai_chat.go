@@ -0,0 +1,667 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// anthropicDefaultMaxTokens Anthropic 要求必填 max_tokens，未指定时的兜底值
+const anthropicDefaultMaxTokens = 4096
+
+// ChatMessage 供应商无关的对话消息
+type ChatMessage struct {
+	Role    string `json:"role"` // system/user/assistant
+	Content string `json:"content"`
+}
+
+// ChatTool 供应商无关的工具（函数）声明
+type ChatTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// ChatResponseFormat 结构化输出：要求模型返回符合 JSON Schema 的 JSON
+// OpenAI 兼容编码为 response_format，Gemini 编码为 responseSchema；Anthropic 无对应参数，依赖提示词约束
+type ChatResponseFormat struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+// ChatRequest 供应商无关的对话请求，由后端按 provider 编码为上游请求体
+type ChatRequest struct {
+	Model          string              `json:"model"`
+	Messages       []ChatMessage       `json:"messages"`
+	Temperature    *float64            `json:"temperature,omitempty"`
+	MaxTokens      int                 `json:"max_tokens,omitempty"`
+	Tools          []ChatTool          `json:"tools,omitempty"`
+	Stream         bool                `json:"stream,omitempty"`
+	ResponseFormat *ChatResponseFormat `json:"response_format,omitempty"`
+}
+
+// ChatResponse 供应商无关的对话响应
+type ChatResponse struct {
+	Content      string     `json:"content"`
+	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
+	FinishReason string     `json:"finish_reason,omitempty"`
+}
+
+// Validate 校验对话请求
+func (r *ChatRequest) Validate() error {
+	if len(r.Messages) == 0 {
+		return errors.New("messages required")
+	}
+	for _, msg := range r.Messages {
+		switch msg.Role {
+		case "system", "user", "assistant":
+		default:
+			return fmt.Errorf("invalid message role: %s", msg.Role)
+		}
+	}
+	if r.MaxTokens < 0 {
+		return errors.New("invalid max_tokens")
+	}
+	return nil
+}
+
+// Clone 复制对话请求（消息与工具切片独立，便于批量场景按条目替换变量）
+func (r *ChatRequest) Clone() *ChatRequest {
+	out := *r
+	out.Messages = append([]ChatMessage(nil), r.Messages...)
+	out.Tools = append([]ChatTool(nil), r.Tools...)
+	return &out
+}
+
+// ResolveChatPath 根据 provider/model 推导上游接口路径
+// - gemini：v1beta/models/{model}:generateContent 或 :streamGenerateContent?alt=sse
+// - anthropic：v1/messages
+// - 其他：OpenAI 兼容 chat/completions（baseURL 已带 /v1 时省略版本前缀）
+func ResolveChatPath(provider, baseURL, model string, stream bool) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(provider)) {
+	case "gemini":
+		cleanModel := strings.TrimPrefix(strings.TrimSpace(model), "models/")
+		if cleanModel == "" {
+			return "", errors.New("model required")
+		}
+		prefix := "v1beta/models/"
+		if strings.HasSuffix(strings.TrimRight(baseURL, "/"), "/v1beta") {
+			prefix = "models/"
+		}
+		if stream {
+			return prefix + cleanModel + ":streamGenerateContent?alt=sse", nil
+		}
+		return prefix + cleanModel + ":generateContent", nil
+	case "anthropic":
+		if baseHasVersionSuffix(baseURL) {
+			return "messages", nil
+		}
+		return "v1/messages", nil
+	default:
+		if baseHasVersionSuffix(baseURL) {
+			return "chat/completions", nil
+		}
+		return "v1/chat/completions", nil
+	}
+}
+
+func baseHasVersionSuffix(baseURL string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(baseURL))
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(strings.TrimRight(parsed.Path, "/"), "/v1")
+}
+
+// buildChatCall 将对话请求编码为上游 path/body；path 为空时按 provider 配置推导
+func buildChatCall(aiConfigService AIConfigService, provider, path string, chat *ChatRequest) (string, string, error) {
+	if strings.TrimSpace(path) == "" {
+		baseURL := ""
+		if !isMockAIProvider(provider) {
+			providerCfg, err := aiConfigService.GetProviderConfigRaw(provider)
+			if err != nil {
+				return "", "", err
+			}
+			baseURL = providerCfg.BaseURL
+		}
+		var err error
+		path, err = ResolveChatPath(provider, baseURL, chat.Model, chat.Stream)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	if isOpenAIResponsesPath(path) {
+		if providerFamily(provider) != "openai" {
+			return "", "", fmt.Errorf("responses path is not supported by provider %s", provider)
+		}
+		body, err := EncodeResponsesRequest(chat)
+		if err != nil {
+			return "", "", err
+		}
+		return path, body, nil
+	}
+	body, err := EncodeChatRequest(provider, chat)
+	if err != nil {
+		return "", "", err
+	}
+	return path, body, nil
+}
+
+// EncodeResponsesRequest 将对话请求编码为 OpenAI Responses API 请求体：system 消息合并为 instructions，其余消息作为 input
+func EncodeResponsesRequest(req *ChatRequest) (string, error) {
+	if req == nil {
+		return "", errors.New("chat request required")
+	}
+	if err := req.Validate(); err != nil {
+		return "", err
+	}
+
+	out, err := json.Marshal(encodeResponsesChat(req))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// EncodeChatRequest 将对话请求编码为指定供应商的请求体
+func EncodeChatRequest(provider string, req *ChatRequest) (string, error) {
+	if req == nil {
+		return "", errors.New("chat request required")
+	}
+	if err := req.Validate(); err != nil {
+		return "", err
+	}
+
+	var payload map[string]interface{}
+	switch strings.ToLower(strings.TrimSpace(provider)) {
+	case "gemini":
+		payload = encodeGeminiChat(req)
+	case "anthropic":
+		payload = encodeAnthropicChat(req)
+	default:
+		payload = encodeOpenAIChat(req)
+	}
+
+	out, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// DecodeChatResponse 按供应商解析上游响应
+func DecodeChatResponse(provider string, raw []byte) (*ChatResponse, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+
+	resp := &ChatResponse{Content: extractAIText(raw)}
+	switch strings.ToLower(strings.TrimSpace(provider)) {
+	case "gemini":
+		resp.ToolCalls = decodeGeminiToolCalls(payload)
+		resp.FinishReason = decodeGeminiFinishReason(payload)
+	case "anthropic":
+		resp.ToolCalls = decodeAnthropicToolCalls(payload)
+		resp.FinishReason, _ = payload["stop_reason"].(string)
+	default:
+		resp.ToolCalls = decodeOpenAIToolCalls(payload)
+		resp.FinishReason = decodeOpenAIFinishReason(payload)
+	}
+	return resp, nil
+}
+
+func encodeOpenAIChat(req *ChatRequest) map[string]interface{} {
+	messages := make([]map[string]interface{}, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		messages = append(messages, map[string]interface{}{
+			"role":    msg.Role,
+			"content": msg.Content,
+		})
+	}
+
+	payload := map[string]interface{}{
+		"messages": messages,
+	}
+	if req.Model != "" {
+		payload["model"] = req.Model
+	}
+	if req.Temperature != nil {
+		payload["temperature"] = *req.Temperature
+	}
+	if req.MaxTokens > 0 {
+		payload["max_tokens"] = req.MaxTokens
+	}
+	if req.Stream {
+		payload["stream"] = true
+		// 流式结束前返回用量块，用于计量
+		payload["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]interface{}, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			tools = append(tools, map[string]interface{}{
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":        tool.Name,
+					"description": tool.Description,
+					"parameters":  toolParametersOrDefault(tool.Parameters),
+				},
+			})
+		}
+		payload["tools"] = tools
+		payload["tool_choice"] = "auto"
+	}
+	if req.ResponseFormat != nil {
+		payload["response_format"] = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   req.ResponseFormat.Name,
+				"schema": req.ResponseFormat.Schema,
+			},
+		}
+	}
+	return payload
+}
+
+// isOpenAIResponsesPath 是否为 OpenAI Responses API 路径（/v1/responses）
+func isOpenAIResponsesPath(path string) bool {
+	clean := strings.TrimSuffix(strings.SplitN(path, "?", 2)[0], "/")
+	return clean == "responses" || strings.HasSuffix(clean, "/responses")
+}
+
+func encodeResponsesChat(req *ChatRequest) map[string]interface{} {
+	var instructions []string
+	input := make([]map[string]interface{}, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			instructions = append(instructions, msg.Content)
+			continue
+		}
+		input = append(input, map[string]interface{}{
+			"role":    msg.Role,
+			"content": msg.Content,
+		})
+	}
+
+	payload := map[string]interface{}{
+		"input": input,
+	}
+	if req.Model != "" {
+		payload["model"] = req.Model
+	}
+	if len(instructions) > 0 {
+		payload["instructions"] = strings.Join(instructions, "\n\n")
+	}
+	if req.Temperature != nil {
+		payload["temperature"] = *req.Temperature
+	}
+	if req.MaxTokens > 0 {
+		payload["max_output_tokens"] = req.MaxTokens
+	}
+	if req.Stream {
+		payload["stream"] = true
+	}
+	if len(req.Tools) > 0 {
+		payload["tools"] = encodeResponsesTools(req.Tools)
+		payload["tool_choice"] = "auto"
+	}
+	if req.ResponseFormat != nil {
+		payload["text"] = map[string]interface{}{
+			"format": map[string]interface{}{
+				"type":   "json_schema",
+				"name":   req.ResponseFormat.Name,
+				"schema": req.ResponseFormat.Schema,
+			},
+		}
+	}
+	return payload
+}
+
+// encodeResponsesTools Responses API 的 tools 为扁平结构：{type, name, description, parameters}
+func encodeResponsesTools(tools []ChatTool) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		out = append(out, map[string]interface{}{
+			"type":        "function",
+			"name":        tool.Name,
+			"description": tool.Description,
+			"parameters":  toolParametersOrDefault(tool.Parameters),
+		})
+	}
+	return out
+}
+
+func encodeGeminiChat(req *ChatRequest) map[string]interface{} {
+	var systemParts []map[string]interface{}
+	contents := make([]map[string]interface{}, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		part := map[string]interface{}{"text": msg.Content}
+		switch msg.Role {
+		case "system":
+			systemParts = append(systemParts, part)
+		case "assistant":
+			contents = append(contents, map[string]interface{}{
+				"role":  "model",
+				"parts": []map[string]interface{}{part},
+			})
+		default:
+			contents = append(contents, map[string]interface{}{
+				"role":  "user",
+				"parts": []map[string]interface{}{part},
+			})
+		}
+	}
+
+	payload := map[string]interface{}{
+		"contents": contents,
+	}
+	if len(systemParts) > 0 {
+		payload["systemInstruction"] = map[string]interface{}{"parts": systemParts}
+	}
+
+	generationConfig := map[string]interface{}{}
+	if req.Temperature != nil {
+		generationConfig["temperature"] = *req.Temperature
+	}
+	if req.MaxTokens > 0 {
+		generationConfig["maxOutputTokens"] = req.MaxTokens
+	}
+	if req.ResponseFormat != nil {
+		generationConfig["responseMimeType"] = "application/json"
+		generationConfig["responseSchema"] = sanitizeGeminiSchema(req.ResponseFormat.Schema)
+	}
+	if len(generationConfig) > 0 {
+		payload["generationConfig"] = generationConfig
+	}
+
+	if len(req.Tools) > 0 {
+		declarations := make([]map[string]interface{}, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			declarations = append(declarations, map[string]interface{}{
+				"name":        tool.Name,
+				"description": tool.Description,
+				"parameters":  sanitizeGeminiSchema(toolParametersOrDefault(tool.Parameters)),
+			})
+		}
+		payload["tools"] = []map[string]interface{}{
+			{"functionDeclarations": declarations},
+		}
+	}
+	return payload
+}
+
+func encodeAnthropicChat(req *ChatRequest) map[string]interface{} {
+	var systemTexts []string
+	messages := make([]map[string]interface{}, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			systemTexts = append(systemTexts, msg.Content)
+			continue
+		}
+		// Messages API 要求 user/assistant 交替出现，连续同角色消息合并
+		if n := len(messages); n > 0 && messages[n-1]["role"] == msg.Role {
+			messages[n-1]["content"] = messages[n-1]["content"].(string) + "\n\n" + msg.Content
+			continue
+		}
+		messages = append(messages, map[string]interface{}{
+			"role":    msg.Role,
+			"content": msg.Content,
+		})
+	}
+
+	maxTokens := req.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+
+	payload := map[string]interface{}{
+		"model":      req.Model,
+		"messages":   messages,
+		"max_tokens": maxTokens,
+	}
+	if len(systemTexts) > 0 {
+		payload["system"] = strings.Join(systemTexts, "\n\n")
+	}
+	if req.Temperature != nil {
+		payload["temperature"] = *req.Temperature
+	}
+	if req.Stream {
+		payload["stream"] = true
+	}
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]interface{}, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			tools = append(tools, map[string]interface{}{
+				"name":         tool.Name,
+				"description":  tool.Description,
+				"input_schema": toolParametersOrDefault(tool.Parameters),
+			})
+		}
+		payload["tools"] = tools
+	}
+	return payload
+}
+
+func toolParametersOrDefault(params map[string]interface{}) map[string]interface{} {
+	if len(params) == 0 {
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": true,
+		}
+	}
+	return params
+}
+
+// sanitizeGeminiSchema 去除 Gemini functionDeclarations 不支持的 JSON Schema 关键字
+func sanitizeGeminiSchema(schema map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		switch key {
+		case "additionalProperties", "$schema", "$id", "definitions", "$defs":
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			out[key] = sanitizeGeminiSchema(v)
+		case []interface{}:
+			items := make([]interface{}, 0, len(v))
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					items = append(items, sanitizeGeminiSchema(m))
+					continue
+				}
+				items = append(items, item)
+			}
+			out[key] = items
+		default:
+			out[key] = value
+		}
+	}
+	return out
+}
+
+func decodeOpenAIToolCalls(payload map[string]interface{}) []ToolCall {
+	if output, ok := payload["output"].([]interface{}); ok {
+		return decodeResponsesToolCalls(output)
+	}
+	choices, ok := payload["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return nil
+	}
+	choice0, ok := choices[0].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	message, ok := choice0["message"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	items, ok := message["tool_calls"].([]interface{})
+	if !ok || len(items) == 0 {
+		return nil
+	}
+
+	out := make([]ToolCall, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fn, ok := m["function"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := fn["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		id, _ := m["id"].(string)
+		argsStr, _ := fn["arguments"].(string)
+		args := map[string]interface{}{}
+		_ = json.Unmarshal([]byte(argsStr), &args)
+		out = append(out, ToolCall{ID: id, Name: name, Arguments: args})
+	}
+	return out
+}
+
+// decodeResponsesToolCalls 解析 Responses API output[] 中 type=function_call 的条目
+// ID 取 call_id（回传 function_call_output 时使用），缺失时退回条目 id
+func decodeResponsesToolCalls(output []interface{}) []ToolCall {
+	var out []ToolCall
+	for _, item := range output {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if itemType, _ := m["type"].(string); itemType != "function_call" {
+			continue
+		}
+		name, _ := m["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		id, _ := m["call_id"].(string)
+		if id == "" {
+			id, _ = m["id"].(string)
+		}
+		argsStr, _ := m["arguments"].(string)
+		args := map[string]interface{}{}
+		_ = json.Unmarshal([]byte(argsStr), &args)
+		out = append(out, ToolCall{ID: id, Name: name, Arguments: args})
+	}
+	return out
+}
+
+// decodeOpenAIFinishReason Chat Completions 取 choices[0].finish_reason；
+// Responses API 输出函数调用时为 tool_calls，未完成时取 incomplete_details.reason，其余取 status
+func decodeOpenAIFinishReason(payload map[string]interface{}) string {
+	if output, ok := payload["output"].([]interface{}); ok {
+		if len(decodeResponsesToolCalls(output)) > 0 {
+			return "tool_calls"
+		}
+		if details, ok := payload["incomplete_details"].(map[string]interface{}); ok {
+			if reason, _ := details["reason"].(string); reason != "" {
+				return reason
+			}
+		}
+		status, _ := payload["status"].(string)
+		return status
+	}
+	choices, ok := payload["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return ""
+	}
+	choice0, ok := choices[0].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	reason, _ := choice0["finish_reason"].(string)
+	return reason
+}
+
+func decodeGeminiToolCalls(payload map[string]interface{}) []ToolCall {
+	candidates, ok := payload["candidates"].([]interface{})
+	if !ok || len(candidates) == 0 {
+		return nil
+	}
+	cand, ok := candidates[0].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	content, ok := cand["content"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	parts, ok := content["parts"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var out []ToolCall
+	for _, item := range parts {
+		part, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fn, ok := part["functionCall"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := fn["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		id, _ := fn["id"].(string)
+		args, _ := fn["args"].(map[string]interface{})
+		if args == nil {
+			args = map[string]interface{}{}
+		}
+		out = append(out, ToolCall{ID: id, Name: name, Arguments: args})
+	}
+	return out
+}
+
+func decodeGeminiFinishReason(payload map[string]interface{}) string {
+	candidates, ok := payload["candidates"].([]interface{})
+	if !ok || len(candidates) == 0 {
+		return ""
+	}
+	cand, ok := candidates[0].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	reason, _ := cand["finishReason"].(string)
+	return reason
+}
+
+func decodeAnthropicToolCalls(payload map[string]interface{}) []ToolCall {
+	blocks, ok := payload["content"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var out []ToolCall
+	for _, item := range blocks {
+		block, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if blockType, _ := block["type"].(string); blockType != "tool_use" {
+			continue
+		}
+		name, _ := block["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		id, _ := block["id"].(string)
+		args, _ := block["input"].(map[string]interface{})
+		if args == nil {
+			args = map[string]interface{}{}
+		}
+		out = append(out, ToolCall{ID: id, Name: name, Arguments: args})
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBuildChatCallResponsesPath(t *testing.T) {
+	temperature := 0.3
+	chat := &ChatRequest{
+		Model: "gpt-4.1",
+		Messages: []ChatMessage{
+			{Role: "system", Content: "你是小说写作助手"},
+			{Role: "system", Content: "使用第三人称"},
+			{Role: "user", Content: "写一段开场"},
+			{Role: "assistant", Content: "夜色渐深。"},
+			{Role: "user", Content: "继续"},
+		},
+		Temperature: &temperature,
+		MaxTokens:   512,
+		Tools:       []ChatTool{{Name: "search_entities", Description: "搜索设定"}},
+	}
+
+	path, body, err := buildChatCall(nil, "openai", "v1/responses", chat)
+	if err != nil {
+		t.Fatalf("buildChatCall: %v", err)
+	}
+	if path != "v1/responses" {
+		t.Errorf("path = %q", path)
+	}
+
+	var payload struct {
+		Model           string  `json:"model"`
+		Instructions    string  `json:"instructions"`
+		MaxOutputTokens int     `json:"max_output_tokens"`
+		Temperature     float64 `json:"temperature"`
+		Input           []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"input"`
+		Messages []interface{} `json:"messages"`
+		Tools    []struct {
+			Type     string      `json:"type"`
+			Name     string      `json:"name"`
+			Function interface{} `json:"function"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("invalid body %s: %v", body, err)
+	}
+	if payload.Messages != nil {
+		t.Error("responses body must not contain chat completions messages")
+	}
+	if payload.Model != "gpt-4.1" || payload.MaxOutputTokens != 512 || payload.Temperature != 0.3 {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	if payload.Instructions != "你是小说写作助手\n\n使用第三人称" {
+		t.Errorf("instructions = %q", payload.Instructions)
+	}
+	if len(payload.Input) != 3 || payload.Input[0].Role != "user" || payload.Input[1].Role != "assistant" || payload.Input[2].Content != "继续" {
+		t.Errorf("input = %+v", payload.Input)
+	}
+	if len(payload.Tools) != 1 || payload.Tools[0].Type != "function" || payload.Tools[0].Name != "search_entities" || payload.Tools[0].Function != nil {
+		t.Errorf("tools = %+v, want flat responses tools", payload.Tools)
+	}
+}
+
+func TestBuildChatCallResponsesPathUnsupportedProvider(t *testing.T) {
+	chat := &ChatRequest{Model: "claude-sonnet-4-5", Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+	for _, provider := range []string{"anthropic", "gemini"} {
+		if _, _, err := buildChatCall(nil, provider, "v1/responses", chat); err == nil {
+			t.Errorf("provider %s: want error for responses path", provider)
+		}
+	}
+}
+
+func TestBuildChatCallChatCompletionsPath(t *testing.T) {
+	chat := &ChatRequest{Model: "gpt-4o", Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+	_, body, err := buildChatCall(nil, "openai", "v1/chat/completions", chat)
+	if err != nil {
+		t.Fatalf("buildChatCall: %v", err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	if _, ok := payload["messages"]; !ok {
+		t.Errorf("chat completions body missing messages: %s", body)
+	}
+	if _, ok := payload["input"]; ok {
+		t.Errorf("chat completions body must not contain input: %s", body)
+	}
+}
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"novel-agent-os-backend/internal/config"
+	"novel-agent-os-backend/pkg/logger"
+)
+
+// CodeAIProviderUnavailable 供应商已熔断
+const CodeAIProviderUnavailable = 30005
+
+// 熔断状态
+const (
+	AICircuitClosed   = "closed"
+	AICircuitOpen     = "open"
+	AICircuitHalfOpen = "half_open"
+)
+
+// AICircuitOpenError 供应商熔断中，请求未发往上游
+type AICircuitOpenError struct {
+	Code          int    `json:"code"`
+	Message       string `json:"message"`
+	Provider      string `json:"provider"`
+	State         string `json:"state"`
+	RetryAfterSec int    `json:"retry_after_sec"`
+}
+
+func (e *AICircuitOpenError) Error() string {
+	return e.Message
+}
+
+// AICircuitPermit 单次调用的熔断放行凭证，调用结束后通过 ReportAICircuitResult 归还
+type AICircuitPermit struct {
+	provider string
+	probe    bool
+}
+
+// AICircuitStatus 供应商熔断状态（/ready 与管理接口展示）
+type AICircuitStatus struct {
+	Provider      string     `json:"provider"`
+	State         string     `json:"state"`
+	Requests      int        `json:"requests"`
+	Failures      int        `json:"failures"`
+	ErrorRate     float64    `json:"error_rate"`
+	OpenedAt      *time.Time `json:"opened_at,omitempty"`
+	RetryAfterSec int        `json:"retry_after_sec,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+}
+
+// aiCircuitBucket 按秒聚合的调用结果
+type aiCircuitBucket struct {
+	second   int64
+	total    int
+	failures int
+}
+
+type aiCircuitBreaker struct {
+	state         string
+	buckets       []aiCircuitBucket
+	openedAt      time.Time
+	openUntil     time.Time
+	probes        int
+	lastError     string
+	lastFailureAt *time.Time
+}
+
+// aiCircuitBreakers 进程内各供应商的熔断器（多个服务共享同一份状态）
+var aiCircuitBreakers = struct {
+	sync.Mutex
+	byProvider map[string]*aiCircuitBreaker
+}{byProvider: make(map[string]*aiCircuitBreaker)}
+
+// AcquireAICircuit 调用前检查熔断状态：熔断中返回 *AICircuitOpenError；未开启熔断时返回 nil 凭证
+func AcquireAICircuit(provider string) (*AICircuitPermit, error) {
+	cfg := config.Get().AI.CircuitBreaker
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	key := strings.ToLower(strings.TrimSpace(provider))
+	now := time.Now()
+
+	aiCircuitBreakers.Lock()
+	defer aiCircuitBreakers.Unlock()
+	breaker := aiCircuitBreakerFor(key)
+
+	switch breaker.state {
+	case AICircuitOpen:
+		if now.Before(breaker.openUntil) {
+			return nil, newAICircuitOpenError(key, AICircuitOpen, breaker.openUntil.Sub(now))
+		}
+		breaker.state = AICircuitHalfOpen
+		breaker.probes = 0
+		logger.Info("AI circuit half-open", logger.String("provider", key))
+		fallthrough
+	case AICircuitHalfOpen:
+		if breaker.probes >= cfg.HalfOpenMaxRequests {
+			return nil, newAICircuitOpenError(key, AICircuitHalfOpen, 0)
+		}
+		breaker.probes++
+		return &AICircuitPermit{provider: key, probe: true}, nil
+	}
+	return &AICircuitPermit{provider: key}, nil
+}
+
+// ReportAICircuitResult 上报调用结果：连接失败、超时与 5xx 计为错误，其余响应（含 4xx）视为供应商可用
+// 半开探测成功后恢复，失败则重新熔断
+func ReportAICircuitResult(permit *AICircuitPermit, callErr error) {
+	if permit == nil {
+		return
+	}
+	counted, failed := classifyAICircuitResult(callErr)
+	cfg := config.Get().AI.CircuitBreaker
+	now := time.Now()
+
+	aiCircuitBreakers.Lock()
+	defer aiCircuitBreakers.Unlock()
+	breaker := aiCircuitBreakerFor(permit.provider)
+
+	if failed {
+		breaker.lastError = truncateAttemptError(callErr.Error())
+		failedAt := now
+		breaker.lastFailureAt = &failedAt
+	}
+
+	if permit.probe {
+		if breaker.probes > 0 {
+			breaker.probes--
+		}
+		if !counted || breaker.state != AICircuitHalfOpen {
+			return
+		}
+		if failed {
+			breaker.trip(permit.provider, cfg, now)
+			return
+		}
+		breaker.state = AICircuitClosed
+		breaker.buckets = nil
+		logger.Info("AI circuit closed", logger.String("provider", permit.provider))
+		return
+	}
+
+	// 熔断前已发出的请求结果不再影响状态
+	if !counted || breaker.state != AICircuitClosed {
+		return
+	}
+	breaker.record(cfg.WindowSec, now, failed)
+	if !failed {
+		return
+	}
+	total, failures := breaker.windowCounts(cfg.WindowSec, now)
+	if total >= cfg.MinRequests && float64(failures)/float64(total) >= cfg.ErrorRateThreshold {
+		breaker.trip(permit.provider, cfg, now)
+	}
+}
+
+// AICircuitStatuses 所有已产生调用的供应商熔断状态（按供应商排序）
+func AICircuitStatuses() []AICircuitStatus {
+	aiCircuitBreakers.Lock()
+	providers := make([]string, 0, len(aiCircuitBreakers.byProvider))
+	for provider := range aiCircuitBreakers.byProvider {
+		providers = append(providers, provider)
+	}
+	aiCircuitBreakers.Unlock()
+
+	sort.Strings(providers)
+	items := make([]AICircuitStatus, 0, len(providers))
+	for _, provider := range providers {
+		items = append(items, GetAICircuitStatus(provider))
+	}
+	return items
+}
+
+// GetAICircuitStatus 单个供应商的熔断状态
+func GetAICircuitStatus(provider string) AICircuitStatus {
+	cfg := config.Get().AI.CircuitBreaker
+	key := strings.ToLower(strings.TrimSpace(provider))
+	now := time.Now()
+
+	aiCircuitBreakers.Lock()
+	defer aiCircuitBreakers.Unlock()
+	breaker, ok := aiCircuitBreakers.byProvider[key]
+	if !ok {
+		return AICircuitStatus{Provider: key, State: AICircuitClosed}
+	}
+
+	total, failures := breaker.windowCounts(cfg.WindowSec, now)
+	status := AICircuitStatus{
+		Provider:      key,
+		State:         breaker.state,
+		Requests:      total,
+		Failures:      failures,
+		LastError:     breaker.lastError,
+		LastFailureAt: breaker.lastFailureAt,
+	}
+	if total > 0 {
+		status.ErrorRate = float64(failures) / float64(total)
+	}
+	if breaker.state != AICircuitClosed {
+		openedAt := breaker.openedAt
+		status.OpenedAt = &openedAt
+	}
+	if breaker.state == AICircuitOpen {
+		if now.Before(breaker.openUntil) {
+			status.RetryAfterSec = retryAfterSeconds(breaker.openUntil.Sub(now))
+		} else {
+			status.State = AICircuitHalfOpen
+		}
+	}
+	return status
+}
+
+func aiCircuitBreakerFor(provider string) *aiCircuitBreaker {
+	breaker, ok := aiCircuitBreakers.byProvider[provider]
+	if !ok {
+		breaker = &aiCircuitBreaker{state: AICircuitClosed}
+		aiCircuitBreakers.byProvider[provider] = breaker
+	}
+	return breaker
+}
+
+func (b *aiCircuitBreaker) record(windowSec int, now time.Time, failed bool) {
+	if windowSec <= 0 {
+		windowSec = 1
+	}
+	if len(b.buckets) != windowSec {
+		b.buckets = make([]aiCircuitBucket, windowSec)
+	}
+	second := now.Unix()
+	bucket := &b.buckets[second%int64(windowSec)]
+	if bucket.second != second {
+		*bucket = aiCircuitBucket{second: second}
+	}
+	bucket.total++
+	if failed {
+		bucket.failures++
+	}
+}
+
+func (b *aiCircuitBreaker) windowCounts(windowSec int, now time.Time) (int, int) {
+	oldest := now.Unix() - int64(windowSec)
+	total, failures := 0, 0
+	for _, bucket := range b.buckets {
+		if bucket.second > oldest {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	return total, failures
+}
+
+func (b *aiCircuitBreaker) trip(provider string, cfg config.AICircuitBreakerConfig, now time.Time) {
+	b.state = AICircuitOpen
+	b.openedAt = now
+	b.openUntil = now.Add(time.Duration(cfg.OpenDurationSec) * time.Second)
+	b.probes = 0
+	b.buckets = nil
+	logger.Warn("AI circuit opened",
+		logger.String("provider", provider),
+		logger.Int("open_duration_sec", cfg.OpenDurationSec),
+		logger.String("last_error", b.lastError),
+	)
+}
+
+// classifyAICircuitResult 返回结果是否计入统计及是否为错误；调用方取消的请求不计入
+func classifyAICircuitResult(err error) (bool, bool) {
+	if err == nil {
+		return true, false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false, false
+	}
+	var openErr *AICircuitOpenError
+	if errors.As(err, &openErr) {
+		return false, false
+	}
+	return true, isFallbackAIError(err)
+}
+
+func newAICircuitOpenError(provider, state string, wait time.Duration) *AICircuitOpenError {
+	return &AICircuitOpenError{
+		Code:          CodeAIProviderUnavailable,
+		Message:       fmt.Sprintf("AI 供应商 %s 暂时不可用，请稍后重试", provider),
+		Provider:      provider,
+		State:         state,
+		RetryAfterSec: retryAfterSeconds(wait),
+	}
+}
+
+func retryAfterSeconds(wait time.Duration) int {
+	if wait <= 0 {
+		return 0
+	}
+	return int((wait + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const circuitTestConfig = `
+ai:
+  circuit_breaker:
+    enabled: true
+    window_sec: 60
+    min_requests: 4
+    error_rate_threshold: 0.5
+    open_duration_sec: 30
+    half_open_max_requests: 1
+`
+
+var errCircuitUpstream = &AIUpstreamError{StatusCode: http.StatusBadGateway, Body: "bad gateway"}
+
+// reportCircuitResults 依次放行并上报调用结果
+func reportCircuitResults(t *testing.T, provider string, results ...error) {
+	t.Helper()
+	for i, result := range results {
+		permit, err := AcquireAICircuit(provider)
+		if err != nil {
+			t.Fatalf("acquire #%d: %v", i+1, err)
+		}
+		ReportAICircuitResult(permit, result)
+	}
+}
+
+// resetAICircuit 清除供应商的熔断状态（熔断器为进程级共享状态）
+func resetAICircuit(t *testing.T, provider string) {
+	t.Helper()
+	remove := func() {
+		aiCircuitBreakers.Lock()
+		delete(aiCircuitBreakers.byProvider, provider)
+		aiCircuitBreakers.Unlock()
+	}
+	remove()
+	t.Cleanup(remove)
+}
+
+// expireAICircuit 让熔断立即到期，下一次调用进入半开探测
+func expireAICircuit(provider string) {
+	aiCircuitBreakers.Lock()
+	defer aiCircuitBreakers.Unlock()
+	aiCircuitBreakerFor(provider).openUntil = time.Now().Add(-time.Second)
+}
+
+func TestAICircuitDisabled(t *testing.T) {
+	useTestConfig(t, "ai:\n  circuit_breaker:\n    enabled: false\n")
+	permit, err := AcquireAICircuit("circuit-disabled")
+	if permit != nil || err != nil {
+		t.Errorf("permit = %v, err = %v, want nil", permit, err)
+	}
+	ReportAICircuitResult(permit, errCircuitUpstream)
+}
+
+func TestAICircuitTrips(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []error
+		state   string
+	}{
+		{name: "below min requests", results: []error{errCircuitUpstream, errCircuitUpstream, errCircuitUpstream}, state: AICircuitClosed},
+		{name: "below threshold", results: []error{nil, nil, nil, errCircuitUpstream}, state: AICircuitClosed},
+		{name: "at threshold", results: []error{nil, nil, errCircuitUpstream, errCircuitUpstream}, state: AICircuitOpen},
+		{name: "transport errors", results: []error{nil, &AITransportError{Err: context.DeadlineExceeded}, &AITransportError{Err: context.DeadlineExceeded}, &AITransportError{Err: context.DeadlineExceeded}}, state: AICircuitOpen},
+		{name: "client errors are not failures", results: []error{
+			&AIUpstreamError{StatusCode: http.StatusBadRequest}, &AIUpstreamError{StatusCode: http.StatusTooManyRequests},
+			&AIUpstreamError{StatusCode: http.StatusUnauthorized}, &AIUpstreamError{StatusCode: http.StatusNotFound},
+		}, state: AICircuitClosed},
+		{name: "canceled calls are not counted", results: []error{context.Canceled, context.Canceled, context.Canceled, errCircuitUpstream}, state: AICircuitClosed},
+	}
+	useTestConfig(t, circuitTestConfig)
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := fmt.Sprintf("circuit-trip-%d", i)
+			resetAICircuit(t, provider)
+			reportCircuitResults(t, provider, tt.results...)
+			if status := GetAICircuitStatus(provider); status.State != tt.state {
+				t.Errorf("state = %s, want %s (status %+v)", status.State, tt.state, status)
+			}
+		})
+	}
+}
+
+func TestAICircuitOpenRejects(t *testing.T) {
+	useTestConfig(t, circuitTestConfig)
+	const provider = "circuit-open"
+	resetAICircuit(t, provider)
+	reportCircuitResults(t, provider, errCircuitUpstream, errCircuitUpstream, errCircuitUpstream, errCircuitUpstream)
+
+	permit, err := AcquireAICircuit(provider)
+	var openErr *AICircuitOpenError
+	if permit != nil || !stderrors.As(err, &openErr) {
+		t.Fatalf("permit = %v, err = %v, want AICircuitOpenError", permit, err)
+	}
+	if openErr.Code != CodeAIProviderUnavailable || openErr.State != AICircuitOpen || openErr.RetryAfterSec != 30 {
+		t.Errorf("open error = %+v", openErr)
+	}
+	if !isFallbackAIError(err) {
+		t.Error("open circuit must fall back to the next provider")
+	}
+
+	status := GetAICircuitStatus(provider)
+	if status.OpenedAt == nil || status.RetryAfterSec != 30 || status.LastError == "" || status.LastFailureAt == nil {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestAICircuitHalfOpen(t *testing.T) {
+	useTestConfig(t, circuitTestConfig)
+	const provider = "circuit-half-open"
+	resetAICircuit(t, provider)
+	trip := func() {
+		reportCircuitResults(t, provider, errCircuitUpstream, errCircuitUpstream, errCircuitUpstream, errCircuitUpstream)
+		expireAICircuit(provider)
+		if state := GetAICircuitStatus(provider).State; state != AICircuitHalfOpen {
+			t.Fatalf("expired circuit state = %s, want half_open", state)
+		}
+	}
+
+	trip()
+	probe, err := AcquireAICircuit(provider)
+	if err != nil || probe == nil || !probe.probe {
+		t.Fatalf("probe = %+v, err = %v", probe, err)
+	}
+	// 半开状态只放行 half_open_max_requests 个探测请求
+	var openErr *AICircuitOpenError
+	if _, err := AcquireAICircuit(provider); !stderrors.As(err, &openErr) || openErr.State != AICircuitHalfOpen {
+		t.Fatalf("second probe err = %v, want half-open rejection", err)
+	}
+	ReportAICircuitResult(probe, nil)
+	if state := GetAICircuitStatus(provider).State; state != AICircuitClosed {
+		t.Fatalf("state after successful probe = %s, want closed", state)
+	}
+
+	// 探测失败重新熔断
+	trip()
+	probe, err = AcquireAICircuit(provider)
+	if err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	ReportAICircuitResult(probe, errCircuitUpstream)
+	status := GetAICircuitStatus(provider)
+	if status.State != AICircuitOpen || status.RetryAfterSec != 30 {
+		t.Errorf("state after failed probe = %+v, want open", status)
+	}
+}
+
+func TestAICircuitIgnoresResultsWhileOpen(t *testing.T) {
+	useTestConfig(t, circuitTestConfig)
+	const provider = "circuit-in-flight"
+	resetAICircuit(t, provider)
+	// 熔断前已放行的请求在熔断后才返回，不影响状态
+	inFlight, err := AcquireAICircuit(provider)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	reportCircuitResults(t, provider, errCircuitUpstream, errCircuitUpstream, errCircuitUpstream, errCircuitUpstream)
+	ReportAICircuitResult(inFlight, nil)
+	if state := GetAICircuitStatus(provider).State; state != AICircuitOpen {
+		t.Errorf("state = %s, want open", state)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want int
+	}{
+		{wait: 0, want: 0},
+		{wait: -time.Second, want: 0},
+		{wait: time.Millisecond, want: 1},
+		{wait: time.Second, want: 1},
+		{wait: 1500 * time.Millisecond, want: 2},
+	}
+	for _, tt := range tests {
+		if got := retryAfterSeconds(tt.wait); got != tt.want {
+			t.Errorf("retryAfterSeconds(%v) = %d, want %d", tt.wait, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"novel-agent-os-backend/internal/config"
+	"novel-agent-os-backend/pkg/sse"
+)
+
+// CodeAIQueueBusy 供应商并发已满且排队已满或排队超时
+const CodeAIQueueBusy = 30006
+
+// AIQueueError 调用未获得供应商并发名额
+type AIQueueError struct {
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	Provider string `json:"provider"`
+	// Reason queue_full / timeout
+	Reason string `json:"reason"`
+}
+
+func (e *AIQueueError) Error() string {
+	return e.Message
+}
+
+// aiQueueWaiter 排队中的调用
+type aiQueueWaiter struct {
+	userID   uint
+	ready    chan struct{}
+	granted  bool
+	position int
+	notify   func(position int)
+}
+
+// aiProviderQueue 单个供应商的并发状态；等待者按用户分组，放行时在用户间轮转，保证每个用户获得公平份额
+type aiProviderQueue struct {
+	active       int
+	activeByUser map[uint]int
+	waiting      map[uint][]*aiQueueWaiter
+	// order 有等待者的用户轮转顺序，队首用户下一个被放行
+	order []uint
+	size  int
+}
+
+// aiConcurrency 进程内各供应商的并发状态
+var aiConcurrency = struct {
+	sync.Mutex
+	byProvider map[string]*aiProviderQueue
+}{byProvider: make(map[string]*aiProviderQueue)}
+
+// acquireAISlot 获取供应商调用名额，返回释放函数；名额已满时排队，排队位置变化时推送 progress.updated
+// 使用用户自有密钥的调用不占用平台名额
+func acquireAISlot(ctx context.Context, req AICallRequest, provider string, userKey bool) (func(), error) {
+	cfg := config.Get().AI.Concurrency
+	if !cfg.Enabled || userKey {
+		return func() {}, nil
+	}
+	key := strings.ToLower(strings.TrimSpace(provider))
+	limit, perUser := aiConcurrencyLimits(cfg, key)
+
+	waiter := &aiQueueWaiter{
+		userID: req.UserID,
+		ready:  make(chan struct{}),
+		notify: aiQueueNotifier(req.SessionID, key),
+	}
+
+	aiConcurrency.Lock()
+	queue := aiProviderQueueFor(key)
+	if cfg.MaxQueue > 0 && queue.size >= cfg.MaxQueue {
+		aiConcurrency.Unlock()
+		return nil, newAIQueueError(key, "queue_full")
+	}
+	queue.enqueue(waiter)
+	notifications := queue.dispatch(limit, perUser)
+	granted := waiter.granted
+	aiConcurrency.Unlock()
+	runAIQueueNotifications(notifications)
+
+	release := func() { releaseAISlot(key, req.UserID) }
+	if granted {
+		return release, nil
+	}
+
+	timer := time.NewTimer(time.Duration(cfg.QueueTimeoutSec) * time.Second)
+	defer timer.Stop()
+	var waitErr error
+	select {
+	case <-waiter.ready:
+		if waiter.notify != nil {
+			waiter.notify(0)
+		}
+		return release, nil
+	case <-ctx.Done():
+		waitErr = ctx.Err()
+	case <-timer.C:
+		waitErr = newAIQueueError(key, "timeout")
+	}
+
+	// 放弃排队：已在此刻被放行时归还名额
+	aiConcurrency.Lock()
+	if waiter.granted {
+		aiConcurrency.Unlock()
+		release()
+		return nil, waitErr
+	}
+	queue.remove(waiter)
+	notifications = queue.dispatch(limit, perUser)
+	aiConcurrency.Unlock()
+	runAIQueueNotifications(notifications)
+	return nil, waitErr
+}
+
+func releaseAISlot(provider string, userID uint) {
+	cfg := config.Get().AI.Concurrency
+	limit, perUser := aiConcurrencyLimits(cfg, provider)
+
+	aiConcurrency.Lock()
+	queue := aiProviderQueueFor(provider)
+	if queue.active > 0 {
+		queue.active--
+	}
+	if queue.activeByUser[userID] > 1 {
+		queue.activeByUser[userID]--
+	} else {
+		delete(queue.activeByUser, userID)
+	}
+	notifications := queue.dispatch(limit, perUser)
+	aiConcurrency.Unlock()
+	runAIQueueNotifications(notifications)
+}
+
+// aiConcurrencyLimits 供应商并发上限与单用户上限（ai.concurrency.providers 覆盖默认值）
+func aiConcurrencyLimits(cfg config.AIConcurrencyConfig, provider string) (int, int) {
+	limit, perUser := cfg.ProviderLimit, cfg.PerUserLimit
+	for _, entry := range cfg.Providers {
+		if !strings.EqualFold(strings.TrimSpace(entry.Provider), provider) {
+			continue
+		}
+		if entry.Limit > 0 {
+			limit = entry.Limit
+		}
+		if entry.PerUserLimit > 0 {
+			perUser = entry.PerUserLimit
+		}
+		break
+	}
+	return limit, perUser
+}
+
+func aiProviderQueueFor(provider string) *aiProviderQueue {
+	queue, ok := aiConcurrency.byProvider[provider]
+	if !ok {
+		queue = &aiProviderQueue{
+			activeByUser: make(map[uint]int),
+			waiting:      make(map[uint][]*aiQueueWaiter),
+		}
+		aiConcurrency.byProvider[provider] = queue
+	}
+	return queue
+}
+
+func (q *aiProviderQueue) enqueue(waiter *aiQueueWaiter) {
+	if len(q.waiting[waiter.userID]) == 0 {
+		q.order = append(q.order, waiter.userID)
+	}
+	q.waiting[waiter.userID] = append(q.waiting[waiter.userID], waiter)
+	q.size++
+}
+
+func (q *aiProviderQueue) remove(waiter *aiQueueWaiter) {
+	list := q.waiting[waiter.userID]
+	for i, item := range list {
+		if item == waiter {
+			q.waiting[waiter.userID] = append(list[:i], list[i+1:]...)
+			q.size--
+			break
+		}
+	}
+	if len(q.waiting[waiter.userID]) == 0 {
+		q.dropUser(waiter.userID)
+	}
+}
+
+func (q *aiProviderQueue) dropUser(userID uint) {
+	delete(q.waiting, userID)
+	for i, id := range q.order {
+		if id == userID {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch 按用户轮转放行等待者，直到供应商名额用尽或剩余用户均已达到单用户上限
+// 返回需要在锁外执行的排队位置通知
+func (q *aiProviderQueue) dispatch(limit, perUser int) []func() {
+	for limit <= 0 || q.active < limit {
+		index := -1
+		for i, userID := range q.order {
+			if perUser <= 0 || q.activeByUser[userID] < perUser {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			break
+		}
+
+		userID := q.order[index]
+		waiter := q.waiting[userID][0]
+		q.waiting[userID] = q.waiting[userID][1:]
+		q.size--
+		q.active++
+		q.activeByUser[userID]++
+		waiter.granted = true
+		close(waiter.ready)
+
+		// 被放行的用户移到队尾，其余用户依次前移
+		q.order = append(q.order[:index], q.order[index+1:]...)
+		if len(q.waiting[userID]) > 0 {
+			q.order = append(q.order, userID)
+		} else {
+			delete(q.waiting, userID)
+		}
+	}
+	return q.positionNotifications()
+}
+
+// positionNotifications 按轮转顺序计算每个等待者的位置（第 n 轮放行各用户的第 n 个等待者），位置变化时通知
+func (q *aiProviderQueue) positionNotifications() []func() {
+	var notifications []func()
+	position := 0
+	for round := 0; ; round++ {
+		found := false
+		for _, userID := range q.order {
+			list := q.waiting[userID]
+			if round >= len(list) {
+				continue
+			}
+			found = true
+			position++
+			waiter := list[round]
+			if waiter.position != position && waiter.notify != nil {
+				waiter.position = position
+				notify, current := waiter.notify, position
+				notifications = append(notifications, func() { notify(current) })
+			}
+		}
+		if !found {
+			return notifications
+		}
+	}
+}
+
+func runAIQueueNotifications(notifications []func()) {
+	for _, notify := range notifications {
+		notify()
+	}
+}
+
+// aiQueueNotifier 排队位置通过会话 SSE 推送 progress.updated；position 为 0 表示已开始调用
+func aiQueueNotifier(sessionID uint, provider string) func(position int) {
+	if sessionID == 0 {
+		return nil
+	}
+	return func(position int) {
+		data := map[string]interface{}{
+			"stage":          "queued",
+			"provider":       provider,
+			"queue_position": position,
+			"message":        fmt.Sprintf("等待模型空闲，前方还有 %d 个请求", position-1),
+			"timestamp":      time.Now().Format(time.RFC3339),
+		}
+		if position == 0 {
+			data["stage"] = "running"
+			data["message"] = "已开始调用模型"
+		}
+		sse.GetHub().BroadcastToSession(fmt.Sprintf("%d", sessionID), sse.NewProgressUpdatedEvent(data))
+	}
+}
+
+func newAIQueueError(provider, reason string) *AIQueueError {
+	message := fmt.Sprintf("AI 供应商 %s 请求繁忙，请稍后重试", provider)
+	if reason == "timeout" {
+		message = fmt.Sprintf("AI 供应商 %s 排队超时，请稍后重试", provider)
+	}
+	return &AIQueueError{
+		Code:     CodeAIQueueBusy,
+		Message:  message,
+		Provider: provider,
+		Reason:   reason,
+	}
+}
+
+// aiSlotBody 流式响应体关闭时归还并发名额
+type aiSlotBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *aiSlotBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
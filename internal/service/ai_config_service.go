@@ -2,12 +2,9 @@ package service
 
 import (
 	"errors"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 
-	"gopkg.in/yaml.v3"
 	"novel-agent-os-backend/internal/config"
 	"novel-agent-os-backend/internal/repository"
 	"novel-agent-os-backend/pkg/logger"
@@ -19,6 +16,19 @@ type AIConfigService interface {
 	GetProviderConfig(provider string) (*ProviderConfig, error)
 	GetProviderConfigRaw(provider string) (*ProviderConfig, error)
 	TestProvider(provider string) error
+	// AcquireAPIKey 从密钥池选择本次调用的密钥，未配置密钥池时返回 nil（使用供应商单一密钥）
+	AcquireAPIKey(provider string) (*AIKeyLease, error)
+	// ReportAPIKeyResult 上报调用结果，返回值可能被包装为 AIKeyRotateError
+	ReportAPIKeyResult(lease *AIKeyLease, err error) error
+	ListProviderKeys(provider string) ([]*ProviderKeyStatus, error)
+	AddProviderKey(provider string, input ProviderKeyInput) (*ProviderKeyStatus, error)
+	UpdateProviderKey(id uint, input ProviderKeyInput) (*ProviderKeyStatus, error)
+	DeleteProviderKey(id uint) error
+	// GetUserProviderConfigRaw 获取用户自有供应商配置（API Key 已解密），未配置时返回 nil
+	GetUserProviderConfigRaw(userID uint, provider string) (*ProviderConfig, error)
+	ListUserProviders(userID uint) ([]*UserProviderConfig, error)
+	SaveUserProvider(userID uint, provider, baseURL, apiKey string) (*UserProviderConfig, error)
+	DeleteUserProvider(userID uint, provider string) error
 }
 
 // ProviderConfig 供应商配置
@@ -28,21 +38,29 @@ type ProviderConfig struct {
 	APIKey      string   `yaml:"api_key" json:"api_key"`
 	ModelsCache []string `yaml:"models_cache" json:"models_cache"`
 	UpdatedAt   string   `yaml:"updated_at" json:"updated_at"`
+	// Keys 密钥池及各密钥健康状态（仅管理接口返回）
+	Keys []*ProviderKeyStatus `yaml:"-" json:"keys,omitempty"`
+	// Circuit 熔断状态（仅管理接口返回）
+	Circuit *AICircuitStatus `yaml:"-" json:"circuit,omitempty"`
 }
 
 type aiConfigService struct {
-	mu         sync.Mutex
-	configRepo repository.AIConfigRepository
+	mu          sync.Mutex
+	configRepo  repository.AIConfigRepository
+	keyPool     *aiKeyPool
+	userKeyRepo repository.AIUserProviderKeyRepository
 }
 
 // NewAIConfigService 创建AI配置服务
-func NewAIConfigService(configRepo repository.AIConfigRepository) AIConfigService {
+func NewAIConfigService(configRepo repository.AIConfigRepository, keyRepo repository.AIProviderKeyRepository, userKeyRepo repository.AIUserProviderKeyRepository) AIConfigService {
 	return &aiConfigService{
-		configRepo: configRepo,
+		configRepo:  configRepo,
+		keyPool:     newAIKeyPool(keyRepo),
+		userKeyRepo: userKeyRepo,
 	}
 }
 
-// UpdateProviderConfig 更新供应商配置（写入config.yaml + DB）
+// UpdateProviderConfig 更新供应商配置（写入 providers/<provider>.yaml + DB，API Key 由仓储层加密落盘）
 func (s *aiConfigService) UpdateProviderConfig(provider, baseURL, apiKey string) error {
 	cleanProvider := strings.TrimSpace(provider)
 	if cleanProvider == "" {
@@ -56,37 +74,11 @@ func (s *aiConfigService) UpdateProviderConfig(provider, baseURL, apiKey string)
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	providersPath := config.Get().AI.ProvidersPath
-	if providersPath == "" {
-		providersPath = "./configs/providers"
-	}
-	configPath := filepath.Join(providersPath, cleanProvider+".yaml")
-	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
-		return err
-	}
-
-	payload := ProviderConfig{
+	if err := s.configRepo.SaveProviderConfig(cleanProvider, repository.ProviderConfigPayload{
 		Provider:  cleanProvider,
 		BaseURL:   cleanBaseURL,
 		APIKey:    strings.TrimSpace(apiKey),
 		UpdatedAt: config.NowUTCString(),
-	}
-
-	data, err := yaml.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
-		return err
-	}
-
-	if err := s.configRepo.SaveProviderConfig(cleanProvider, repository.ProviderConfigPayload{
-		Provider:    payload.Provider,
-		BaseURL:     payload.BaseURL,
-		APIKey:      payload.APIKey,
-		ModelsCache: payload.ModelsCache,
-		UpdatedAt:   payload.UpdatedAt,
 	}); err != nil {
 		return err
 	}
@@ -110,15 +102,23 @@ func (s *aiConfigService) GetProviderConfig(provider string) (*ProviderConfig, e
 		return nil, err
 	}
 
+	keys, err := s.keyPool.list(cleanProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	circuit := GetAICircuitStatus(cleanProvider)
 	return &ProviderConfig{
 		Provider:  record.Provider,
 		BaseURL:   record.BaseURL,
 		APIKey:    maskAPIKey(record.APIKey),
 		UpdatedAt: "",
+		Keys:      keys,
+		Circuit:   &circuit,
 	}, nil
 }
 
-// GetProviderConfigRaw 获取供应商配置（用于内部调用）
+// GetProviderConfigRaw 获取供应商配置（用于内部调用，API Key 已解密）
 func (s *aiConfigService) GetProviderConfigRaw(provider string) (*ProviderConfig, error) {
 	cleanProvider := strings.TrimSpace(provider)
 	if cleanProvider == "" {
@@ -144,6 +144,9 @@ func (s *aiConfigService) TestProvider(provider string) error {
 	if cleanProvider == "" {
 		return errors.New("invalid provider")
 	}
+	if isMockAIProvider(cleanProvider) {
+		return nil
+	}
 
 	record, err := s.configRepo.GetProviderConfig(cleanProvider)
 	if err != nil {
@@ -158,6 +161,40 @@ func (s *aiConfigService) TestProvider(provider string) error {
 	return err
 }
 
+// AcquireAPIKey 从密钥池选择本次调用的密钥
+func (s *aiConfigService) AcquireAPIKey(provider string) (*AIKeyLease, error) {
+	return s.keyPool.acquire(strings.TrimSpace(provider))
+}
+
+// ReportAPIKeyResult 上报密钥调用结果（401/403/429 进入冷却）
+func (s *aiConfigService) ReportAPIKeyResult(lease *AIKeyLease, err error) error {
+	return s.keyPool.report(lease, err)
+}
+
+// ListProviderKeys 列出供应商密钥池
+func (s *aiConfigService) ListProviderKeys(provider string) ([]*ProviderKeyStatus, error) {
+	cleanProvider := strings.TrimSpace(provider)
+	if cleanProvider == "" {
+		return nil, errors.New("invalid provider")
+	}
+	return s.keyPool.list(cleanProvider)
+}
+
+// AddProviderKey 向密钥池添加密钥
+func (s *aiConfigService) AddProviderKey(provider string, input ProviderKeyInput) (*ProviderKeyStatus, error) {
+	return s.keyPool.add(provider, input)
+}
+
+// UpdateProviderKey 更新密钥（权重、启用状态、重置冷却）
+func (s *aiConfigService) UpdateProviderKey(id uint, input ProviderKeyInput) (*ProviderKeyStatus, error) {
+	return s.keyPool.update(id, input)
+}
+
+// DeleteProviderKey 删除密钥
+func (s *aiConfigService) DeleteProviderKey(id uint) error {
+	return s.keyPool.keyRepo.Delete(id)
+}
+
 func maskAPIKey(value string) string {
 	if len(value) <= 6 {
 		return value
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"novel-agent-os-backend/internal/config"
+)
+
+// AIFallbackEntry 备用供应商链条目
+type AIFallbackEntry = config.AIFallbackEntry
+
+// AICallRequest 上游 AI 调用请求
+type AICallRequest struct {
+	Provider string
+	Path     string
+	Body     string
+	// Chat 为供应商无关的原始请求，切换备用供应商时据此重新编码；为空时仅在同协议供应商间切换
+	Chat     *ChatRequest
+	Fallback []AIFallbackEntry
+	// UserID 发起调用的用户，配置了自有密钥（BYOK）的供应商优先使用用户密钥
+	UserID uint
+	// SessionID 排队等待供应商并发名额时向该会话推送排队位置
+	SessionID uint
+	// CacheMode 响应缓存模式：空（temperature 为 0 时缓存）/ force / bypass
+	CacheMode string
+}
+
+// aiCallTarget 单个供应商的实际调用目标
+type aiCallTarget struct {
+	Provider string
+	Model    string
+	Path     string
+	Body     string
+}
+
+var geminiModelPathPattern = regexp.MustCompile(`models/([^:/?]+):`)
+
+// resolveAIFallbackChain 解析备用供应商链：项目 ai_settings.fallback_chains 优先，其次全局 ai.fallback_chains
+func resolveAIFallbackChain(projectService ProjectService, projectID uint, provider string) []AIFallbackEntry {
+	key := strings.ToLower(strings.TrimSpace(provider))
+	if projectService != nil && projectID > 0 {
+		if project, err := projectService.GetByID(projectID); err == nil && len(project.AISettings) > 0 {
+			var settings struct {
+				FallbackChains map[string][]AIFallbackEntry `json:"fallback_chains"`
+			}
+			if err := json.Unmarshal(project.AISettings, &settings); err == nil {
+				if chain, ok := settings.FallbackChains[key]; ok {
+					return filterFallbackChain(key, chain)
+				}
+			}
+		}
+	}
+	return filterFallbackChain(key, config.Get().AI.FallbackChains[key])
+}
+
+// filterFallbackChain 去除空条目及与主供应商相同的条目
+func filterFallbackChain(primary string, chain []AIFallbackEntry) []AIFallbackEntry {
+	out := make([]AIFallbackEntry, 0, len(chain))
+	for _, entry := range chain {
+		entry.Provider = strings.ToLower(strings.TrimSpace(entry.Provider))
+		if entry.Provider == "" || entry.Provider == primary {
+			continue
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// isFallbackAIError 仅在连接失败、上游 5xx、供应商熔断或排队已满时切换备用供应商
+func isFallbackAIError(err error) bool {
+	var openErr *AICircuitOpenError
+	if errors.As(err, &openErr) {
+		return true
+	}
+	var queueErr *AIQueueError
+	if errors.As(err, &queueErr) {
+		return true
+	}
+	var transportErr *AITransportError
+	if errors.As(err, &transportErr) {
+		return true
+	}
+	var upstreamErr *AIUpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.StatusCode >= 500
+	}
+	return false
+}
+
+// providerFamily 供应商协议族：gemini / anthropic / openai（其余均按 OpenAI 兼容处理）
+func providerFamily(provider string) string {
+	switch strings.ToLower(strings.TrimSpace(provider)) {
+	case "gemini":
+		return "gemini"
+	case "anthropic":
+		return "anthropic"
+	default:
+		return "openai"
+	}
+}
+
+// primaryAICallTarget 主供应商调用目标
+func primaryAICallTarget(req AICallRequest) aiCallTarget {
+	return aiCallTarget{
+		Provider: req.Provider,
+		Model:    detectRequestModel(req),
+		Path:     req.Path,
+		Body:     req.Body,
+	}
+}
+
+// fallbackAICallTarget 按条目构建备用供应商调用目标
+// - 有 Chat 时按备用供应商重新编码请求体并推导 path
+// - 仅有原始 body 时要求协议族一致，替换模型后沿用请求体
+func fallbackAICallTarget(aiConfigService AIConfigService, req AICallRequest, entry AIFallbackEntry, stream bool) (aiCallTarget, error) {
+	primaryModel := detectRequestModel(req)
+	model := primaryModel
+	if mapped, ok := entry.ModelMap[primaryModel]; ok && mapped != "" {
+		model = mapped
+	} else if entry.Model != "" {
+		model = entry.Model
+	}
+
+	if req.Chat != nil {
+		chat := req.Chat.Clone()
+		chat.Model = model
+		chat.Stream = chat.Stream || stream
+		path, body, err := buildChatCall(aiConfigService, entry.Provider, "", chat)
+		if err != nil {
+			return aiCallTarget{}, err
+		}
+		return aiCallTarget{Provider: entry.Provider, Model: model, Path: path, Body: body}, nil
+	}
+
+	family := providerFamily(req.Provider)
+	if family != providerFamily(entry.Provider) {
+		return aiCallTarget{}, fmt.Errorf("raw body cannot fall back from %s to %s", req.Provider, entry.Provider)
+	}
+
+	path := req.Path
+	body := req.Body
+	if isChatPath(req.Path) {
+		providerCfg, err := aiConfigService.GetProviderConfigRaw(entry.Provider)
+		if err != nil {
+			return aiCallTarget{}, fmt.Errorf("provider not found")
+		}
+		isStream := stream || strings.Contains(req.Path, ":streamGenerateContent")
+		path, err = ResolveChatPath(entry.Provider, providerCfg.BaseURL, model, isStream)
+		if err != nil {
+			return aiCallTarget{}, err
+		}
+	}
+	if family != "gemini" && model != "" && model != primaryModel {
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &payload); err == nil {
+			payload["model"] = model
+			if out, err := json.Marshal(payload); err == nil {
+				body = string(out)
+			}
+		}
+	}
+	return aiCallTarget{Provider: entry.Provider, Model: model, Path: path, Body: body}, nil
+}
+
+// detectRequestModel 提取请求模型：优先 Chat.Model，其次请求体 model 字段或 Gemini path
+func detectRequestModel(req AICallRequest) string {
+	if req.Chat != nil && req.Chat.Model != "" {
+		return req.Chat.Model
+	}
+	if match := geminiModelPathPattern.FindStringSubmatch(req.Path); len(match) == 2 {
+		return match[1]
+	}
+	var payload struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal([]byte(req.Body), &payload); err == nil {
+		return payload.Model
+	}
+	return ""
+}
+
+func isChatPath(path string) bool {
+	clean := strings.TrimRight(strings.SplitN(path, "?", 2)[0], "/")
+	return strings.HasSuffix(clean, "chat/completions") ||
+		strings.HasSuffix(clean, "/messages") || clean == "messages" ||
+		strings.HasSuffix(clean, ":generateContent") ||
+		strings.HasSuffix(clean, ":streamGenerateContent")
+}
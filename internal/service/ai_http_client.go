@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// errAIInternalAddress 用户自有供应商的地址解析到内网、回环或保留地址
+var errAIInternalAddress = errors.New("upstream address is not allowed")
+
+// aiBlockedNetworks IP 自带判断之外需要拒绝的保留网段
+var aiBlockedNetworks = func() []*net.IPNet {
+	cidrs := []string{
+		"0.0.0.0/8",     // 本网络
+		"100.64.0.0/10", // 运营商级 NAT（CGNAT）
+	}
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// aiUserKeyTransport 用户自有密钥请求使用的连接：不走代理，建立连接时校验解析后的实际地址
+// （域名解析到内网地址同样拒绝，避免借用户配置的 BaseURL 访问内网）
+var aiUserKeyTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   rejectInternalAIDial,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// NewAIHTTPClient 创建上游请求客户端；使用用户自有密钥时只连接公网地址且不跟随重定向
+func NewAIHTTPClient(timeout time.Duration, userKey bool) *http.Client {
+	if !userKey {
+		return &http.Client{Timeout: timeout}
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: aiUserKeyTransport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// rejectInternalAIDial 在建立连接前检查解析后的地址
+func rejectInternalAIDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errAIInternalAddress
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isInternalAIAddress(ip) {
+		return errAIInternalAddress
+	}
+	return nil
+}
+
+// isInternalAIAddress 回环、私有、链路本地、CGNAT、未指定与组播地址
+func isInternalAIAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range aiBlockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsInternalAIAddress(t *testing.T) {
+	tests := []struct {
+		ip       string
+		internal bool
+	}{
+		{ip: "127.0.0.1", internal: true},
+		{ip: "::1", internal: true},
+		{ip: "10.1.2.3", internal: true},
+		{ip: "172.16.0.1", internal: true},
+		{ip: "192.168.1.10", internal: true},
+		{ip: "169.254.169.254", internal: true},
+		{ip: "fe80::1", internal: true},
+		{ip: "fd00::1", internal: true},
+		{ip: "100.64.0.1", internal: true},
+		{ip: "100.127.255.254", internal: true},
+		{ip: "0.0.0.0", internal: true},
+		{ip: "0.1.2.3", internal: true},
+		{ip: "::", internal: true},
+		{ip: "::ffff:127.0.0.1", internal: true},
+		{ip: "224.0.0.1", internal: true},
+		{ip: "8.8.8.8", internal: false},
+		{ip: "100.128.0.1", internal: false},
+		{ip: "2606:4700::1111", internal: false},
+	}
+	for _, tt := range tests {
+		if got := isInternalAIAddress(net.ParseIP(tt.ip)); got != tt.internal {
+			t.Errorf("isInternalAIAddress(%s) = %v, want %v", tt.ip, got, tt.internal)
+		}
+	}
+}
+
+func TestValidateUserProviderBaseURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{url: "https://api.openai.com", ok: true},
+		{url: "http://api.openai.com"},
+		{url: "https://localhost:8443"},
+		{url: "https://ai.localhost"},
+		{url: "https://127.0.0.1"},
+		{url: "https://[::1]"},
+		{url: "https://169.254.169.254"},
+		{url: "https://100.64.1.1"},
+	}
+	for _, tt := range tests {
+		if err := validateUserProviderBaseURL(tt.url); (err == nil) != tt.ok {
+			t.Errorf("validateUserProviderBaseURL(%s) err = %v, want ok %v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestNewAIHTTPClientUserKeyRejectsInternalAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// 平台配置的地址不受限制
+	resp, err := NewAIHTTPClient(0, false).Get(server.URL)
+	if err != nil {
+		t.Fatalf("platform client: %v", err)
+	}
+	resp.Body.Close()
+
+	// 用户自有密钥：解析后的回环地址在建立连接时被拒绝
+	if resp, err := NewAIHTTPClient(0, true).Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("user key client: want dial rejected for loopback address")
+	}
+}
+
+func TestNewAIHTTPClientUserKeyDoesNotFollowRedirects(t *testing.T) {
+	client := NewAIHTTPClient(0, true)
+	if client.CheckRedirect == nil {
+		t.Fatal("user key client must not follow redirects")
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data", nil)
+	if err := client.CheckRedirect(req, nil); err != http.ErrUseLastResponse {
+		t.Errorf("CheckRedirect err = %v, want ErrUseLastResponse", err)
+	}
+}
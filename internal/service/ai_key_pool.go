@@ -0,0 +1,322 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"novel-agent-os-backend/internal/config"
+	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/internal/repository"
+	"novel-agent-os-backend/pkg/logger"
+)
+
+// AIKeyLease 单次调用选中的密钥；KeyID 为 0 表示使用供应商单一密钥（未配置密钥池）
+type AIKeyLease struct {
+	KeyID     uint
+	Provider  string
+	APIKey    string
+	available int
+}
+
+// AIKeyRotateError 当前密钥鉴权失败或被限流，且池中仍有其他可用密钥：立即换密钥重试
+type AIKeyRotateError struct {
+	Err error
+	// Remaining 选中当前密钥时池中其余可用密钥数，即本次调用最多换密钥的次数
+	Remaining int
+}
+
+func (e *AIKeyRotateError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AIKeyRotateError) Unwrap() error {
+	return e.Err
+}
+
+// ProviderKeyInput 新增/更新密钥参数（更新时 nil 字段保持不变）
+type ProviderKeyInput struct {
+	Name          *string
+	APIKey        *string
+	Weight        *int
+	Enabled       *bool
+	ResetCooldown bool
+}
+
+// ProviderKeyStatus 密钥健康状态（API Key 脱敏）
+type ProviderKeyStatus struct {
+	ID             uint       `json:"id"`
+	Provider       string     `json:"provider"`
+	Name           string     `json:"name"`
+	APIKey         string     `json:"api_key"`
+	Weight         int        `json:"weight"`
+	Enabled        bool       `json:"enabled"`
+	Health         string     `json:"health"` // healthy/cooling_down/disabled
+	CooldownUntil  *time.Time `json:"cooldown_until,omitempty"`
+	SuccessCount   int64      `json:"success_count"`
+	ErrorCount     int64      `json:"error_count"`
+	RateLimitCount int64      `json:"rate_limit_count"`
+	LastStatus     int        `json:"last_status"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	LastLimitedAt  *time.Time `json:"last_limited_at,omitempty"`
+}
+
+// aiKeyPool 密钥选择与健康上报
+type aiKeyPool struct {
+	mu      sync.Mutex
+	keyRepo repository.AIProviderKeyRepository
+	// rrWeights 平滑加权轮询的当前权重：provider -> keyID -> weight
+	rrWeights map[string]map[uint]int
+}
+
+func newAIKeyPool(keyRepo repository.AIProviderKeyRepository) *aiKeyPool {
+	return &aiKeyPool{
+		keyRepo:   keyRepo,
+		rrWeights: make(map[string]map[uint]int),
+	}
+}
+
+// acquire 选择一个密钥；未配置密钥池时返回 nil
+// 全部密钥冷却中时选择最早结束冷却的密钥（由重试策略处理上游的 429）
+func (p *aiKeyPool) acquire(provider string) (*AIKeyLease, error) {
+	if p == nil || p.keyRepo == nil {
+		return nil, nil
+	}
+	keys, err := p.keyRepo.ListByProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var enabled, available []*model.AIProviderKey
+	for _, key := range keys {
+		if !key.Enabled || key.APIKey == "" {
+			continue
+		}
+		enabled = append(enabled, key)
+		if key.CooldownUntil == nil || !key.CooldownUntil.After(now) {
+			available = append(available, key)
+		}
+	}
+	if len(enabled) == 0 {
+		return nil, nil
+	}
+
+	var chosen *model.AIProviderKey
+	if len(available) == 0 {
+		chosen = enabled[0]
+		for _, key := range enabled[1:] {
+			if key.CooldownUntil.Before(*chosen.CooldownUntil) {
+				chosen = key
+			}
+		}
+	} else if config.Get().AI.KeyPool.Strategy == "least_recently_limited" {
+		chosen = pickLeastRecentlyLimited(available)
+	} else {
+		chosen = p.pickWeightedRoundRobin(provider, available)
+	}
+
+	return &AIKeyLease{
+		KeyID:     chosen.ID,
+		Provider:  provider,
+		APIKey:    chosen.APIKey,
+		available: len(available),
+	}, nil
+}
+
+// pickWeightedRoundRobin 平滑加权轮询（每轮各密钥加上自身权重，选最大者并减去总权重）
+func (p *aiKeyPool) pickWeightedRoundRobin(provider string, keys []*model.AIProviderKey) *model.AIProviderKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current, ok := p.rrWeights[provider]
+	if !ok {
+		current = make(map[uint]int)
+		p.rrWeights[provider] = current
+	}
+
+	total := 0
+	var best *model.AIProviderKey
+	for _, key := range keys {
+		weight := key.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		current[key.ID] += weight
+		if best == nil || current[key.ID] > current[best.ID] {
+			best = key
+		}
+	}
+	current[best.ID] -= total
+	return best
+}
+
+// pickLeastRecentlyLimited 优先从未被限流或最久之前被限流的密钥，其次权重高、最久未使用
+func pickLeastRecentlyLimited(keys []*model.AIProviderKey) *model.AIProviderKey {
+	sorted := make([]*model.AIProviderKey, len(keys))
+	copy(sorted, keys)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		li, lj := timeOrZero(sorted[i].LastLimitedAt), timeOrZero(sorted[j].LastLimitedAt)
+		if !li.Equal(lj) {
+			return li.Before(lj)
+		}
+		if sorted[i].Weight != sorted[j].Weight {
+			return sorted[i].Weight > sorted[j].Weight
+		}
+		return timeOrZero(sorted[i].LastUsedAt).Before(timeOrZero(sorted[j].LastUsedAt))
+	})
+	return sorted[0]
+}
+
+func timeOrZero(value *time.Time) time.Time {
+	if value == nil {
+		return time.Time{}
+	}
+	return *value
+}
+
+// report 上报调用结果：401/403/429 使密钥进入冷却；池中仍有其他可用密钥时包装为 AIKeyRotateError
+func (p *aiKeyPool) report(lease *AIKeyLease, callErr error) error {
+	if p == nil || lease == nil || lease.KeyID == 0 {
+		return callErr
+	}
+	if callErr == nil {
+		if err := p.keyRepo.RecordSuccess(lease.KeyID, time.Now()); err != nil {
+			logger.Warn("record ai key success failed", logger.Uint("key_id", lease.KeyID), logger.Err(err))
+		}
+		return nil
+	}
+
+	var upstreamErr *AIUpstreamError
+	status := 0
+	if errors.As(callErr, &upstreamErr) {
+		status = upstreamErr.StatusCode
+	}
+
+	poolCfg := config.Get().AI.KeyPool
+	var cooldownUntil *time.Time
+	limited := false
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		until := time.Now().Add(time.Duration(poolCfg.AuthCooldownSec) * time.Second)
+		cooldownUntil = &until
+	case http.StatusTooManyRequests:
+		wait := upstreamErr.RetryAfter
+		if wait <= 0 {
+			wait = time.Duration(poolCfg.RateLimitCooldownSec) * time.Second
+		}
+		until := time.Now().Add(wait)
+		cooldownUntil = &until
+		limited = true
+	}
+
+	if err := p.keyRepo.RecordFailure(lease.KeyID, status, truncateAttemptError(callErr.Error()), cooldownUntil, limited); err != nil {
+		logger.Warn("record ai key failure failed", logger.Uint("key_id", lease.KeyID), logger.Err(err))
+	}
+	if cooldownUntil != nil {
+		logger.Warn("AI key cooling down",
+			logger.String("provider", lease.Provider),
+			logger.Uint("key_id", lease.KeyID),
+			logger.Int("status_code", status),
+		)
+		if lease.available > 1 {
+			return &AIKeyRotateError{Err: callErr, Remaining: lease.available - 1}
+		}
+	}
+	return callErr
+}
+
+func (p *aiKeyPool) list(provider string) ([]*ProviderKeyStatus, error) {
+	keys, err := p.keyRepo.ListByProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*ProviderKeyStatus, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, toProviderKeyStatus(key))
+	}
+	return items, nil
+}
+
+func (p *aiKeyPool) add(provider string, input ProviderKeyInput) (*ProviderKeyStatus, error) {
+	cleanProvider := strings.TrimSpace(provider)
+	if cleanProvider == "" {
+		return nil, errors.New("invalid provider")
+	}
+	if input.APIKey == nil || strings.TrimSpace(*input.APIKey) == "" {
+		return nil, errors.New("api key required")
+	}
+
+	key := &model.AIProviderKey{
+		Provider: cleanProvider,
+		APIKey:   strings.TrimSpace(*input.APIKey),
+		Weight:   1,
+		Enabled:  true,
+	}
+	applyProviderKeyInput(key, input)
+	if err := p.keyRepo.Create(key); err != nil {
+		return nil, err
+	}
+	return toProviderKeyStatus(key), nil
+}
+
+func (p *aiKeyPool) update(id uint, input ProviderKeyInput) (*ProviderKeyStatus, error) {
+	key, err := p.keyRepo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	applyProviderKeyInput(key, input)
+	if err := p.keyRepo.Update(key); err != nil {
+		return nil, err
+	}
+	return toProviderKeyStatus(key), nil
+}
+
+func applyProviderKeyInput(key *model.AIProviderKey, input ProviderKeyInput) {
+	if input.Name != nil {
+		key.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.APIKey != nil && strings.TrimSpace(*input.APIKey) != "" {
+		key.APIKey = strings.TrimSpace(*input.APIKey)
+	}
+	if input.Weight != nil && *input.Weight > 0 {
+		key.Weight = *input.Weight
+	}
+	if input.Enabled != nil {
+		key.Enabled = *input.Enabled
+	}
+	if input.ResetCooldown {
+		key.CooldownUntil = nil
+	}
+}
+
+func toProviderKeyStatus(key *model.AIProviderKey) *ProviderKeyStatus {
+	health := "healthy"
+	if !key.Enabled {
+		health = "disabled"
+	} else if key.CooldownUntil != nil && key.CooldownUntil.After(time.Now()) {
+		health = "cooling_down"
+	}
+	return &ProviderKeyStatus{
+		ID:             key.ID,
+		Provider:       key.Provider,
+		Name:           key.Name,
+		APIKey:         maskAPIKey(key.APIKey),
+		Weight:         key.Weight,
+		Enabled:        key.Enabled,
+		Health:         health,
+		CooldownUntil:  key.CooldownUntil,
+		SuccessCount:   key.SuccessCount,
+		ErrorCount:     key.ErrorCount,
+		RateLimitCount: key.RateLimitCount,
+		LastStatus:     key.LastStatus,
+		LastError:      key.LastError,
+		LastUsedAt:     key.LastUsedAt,
+		LastLimitedAt:  key.LastLimitedAt,
+	}
+}
@@ -0,0 +1,304 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"novel-agent-os-backend/internal/config"
+	"novel-agent-os-backend/internal/repository"
+)
+
+// mockAIProvider 内置 mock 供应商：按 OpenAI 兼容协议在进程内应答，不访问网络
+// 回复内容只取决于请求体，便于在无模型环境下端到端验证章节生成、批量生成、AgentWriter 与 Job 流程
+const mockAIProvider = "mock"
+
+// mockDirectivePattern 消息中的控制指令：[mock:429] [mock:timeout] [mock:500x2] [mock:tool=name]
+var mockDirectivePattern = regexp.MustCompile(`\[mock:([a-z0-9_]+?)(?:x(\d+))?(?:=([^\]]*))?\]`)
+
+// isMockAIProvider 是否为已启用的 mock 供应商
+func isMockAIProvider(provider string) bool {
+	return strings.EqualFold(strings.TrimSpace(provider), mockAIProvider) && config.Get().AI.Mock.Enabled
+}
+
+// mockAIModels mock 供应商的模型列表（覆盖不同上下文窗口与能力组合）
+func mockAIModels() []repository.ProviderModelInfo {
+	yes, no := true, false
+	return []repository.ProviderModelInfo{
+		{ID: "mock-chat", Name: "Mock Chat", ContextWindow: 128000, MaxOutputTokens: 8192, SupportsTools: &yes, SupportsStreaming: &yes},
+		{ID: "mock-small", Name: "Mock Small Context", ContextWindow: 2048, MaxOutputTokens: 512, SupportsTools: &yes, SupportsStreaming: &yes},
+		{ID: "mock-no-tools", Name: "Mock Without Tools", ContextWindow: 32768, MaxOutputTokens: 4096, SupportsTools: &no, SupportsStreaming: &yes},
+	}
+}
+
+type mockChatRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+	Tools []struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	} `json:"tools"`
+}
+
+// mockCompletion mock 应答
+type mockCompletion struct {
+	Model            string
+	Content          string
+	ToolCalls        []config.AIMockToolCall
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// mockAIRequest 非流式调用，返回 OpenAI chat.completion 响应体；attempt 为本次调用链内的尝试序号（从 1 开始）
+func mockAIRequest(body string, attempt int) ([]byte, error) {
+	completion, err := buildMockCompletion(body, attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	message := map[string]interface{}{
+		"role":    "assistant",
+		"content": completion.Content,
+	}
+	finishReason := "stop"
+	if len(completion.ToolCalls) > 0 {
+		message["tool_calls"] = mockToolCallsPayload(completion.ToolCalls, false)
+		finishReason = "tool_calls"
+	}
+	return json.Marshal(map[string]interface{}{
+		"id":      "chatcmpl-mock",
+		"object":  "chat.completion",
+		"model":   completion.Model,
+		"choices": []interface{}{map[string]interface{}{"index": 0, "message": message, "finish_reason": finishReason}},
+		"usage":   mockUsagePayload(completion),
+	})
+}
+
+// openMockAIStream 流式调用，返回 OpenAI SSE 格式的响应（含用量块与 [DONE]）
+func openMockAIStream(ctx context.Context, body string, attempt int) (*http.Response, error) {
+	completion, err := buildMockCompletion(body, attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	mockCfg := config.Get().AI.Mock
+	chunkSize := mockCfg.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 8
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writeEvent := func(payload map[string]interface{}) error {
+			payload["id"] = "chatcmpl-mock"
+			payload["object"] = "chat.completion.chunk"
+			payload["model"] = completion.Model
+			data, _ := json.Marshal(payload)
+			_, err := fmt.Fprintf(writer, "data: %s\n\n", data)
+			return err
+		}
+
+		runes := []rune(completion.Content)
+		for start := 0; start < len(runes); start += chunkSize {
+			end := start + chunkSize
+			if end > len(runes) {
+				end = len(runes)
+			}
+			if start > 0 {
+				if err := sleepWithContext(ctx, time.Duration(mockCfg.ChunkDelayMs)*time.Millisecond); err != nil {
+					writer.CloseWithError(err)
+					return
+				}
+			}
+			delta := map[string]interface{}{"content": string(runes[start:end])}
+			if err := writeEvent(map[string]interface{}{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": delta}}}); err != nil {
+				return
+			}
+		}
+
+		finishReason := "stop"
+		if len(completion.ToolCalls) > 0 {
+			finishReason = "tool_calls"
+			delta := map[string]interface{}{"tool_calls": mockToolCallsPayload(completion.ToolCalls, true)}
+			if err := writeEvent(map[string]interface{}{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": delta}}}); err != nil {
+				return
+			}
+		}
+		_ = writeEvent(map[string]interface{}{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{}, "finish_reason": finishReason}}})
+		_ = writeEvent(map[string]interface{}{"choices": []interface{}{}, "usage": mockUsagePayload(completion)})
+		_, _ = io.WriteString(writer, "data: [DONE]\n\n")
+		writer.Close()
+	}()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       reader,
+	}, nil
+}
+
+// buildMockCompletion 解析请求、执行失败注入指令并生成确定性回复
+func buildMockCompletion(body string, attempt int) (*mockCompletion, error) {
+	var req mockChatRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return nil, &AIUpstreamError{StatusCode: http.StatusBadRequest, Body: `{"error":{"message":"mock: invalid request body"}}`}
+	}
+
+	var system, prompt string
+	var allText strings.Builder
+	for _, msg := range req.Messages {
+		text := mockMessageText(msg.Content)
+		allText.WriteString(text)
+		switch msg.Role {
+		case "system":
+			system = text
+		case "user":
+			prompt = text
+		}
+	}
+
+	completion := &mockCompletion{Model: req.Model, PromptTokens: estimateTokens(allText.String())}
+	if completion.Model == "" {
+		completion.Model = "mock-chat"
+	}
+
+	for _, match := range mockDirectivePattern.FindAllStringSubmatch(prompt, -1) {
+		name, times, value := match[1], match[2], match[3]
+		switch {
+		case name == "tool":
+			toolName := strings.TrimSpace(value)
+			if toolName == "" && len(req.Tools) > 0 {
+				toolName = req.Tools[0].Function.Name
+			}
+			if toolName != "" {
+				completion.ToolCalls = append(completion.ToolCalls, config.AIMockToolCall{Name: toolName, Arguments: "{}"})
+			}
+		case name == "timeout" || isMockStatusDirective(name):
+			if err := injectMockFailure(name, times, attempt); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cleanPrompt := strings.TrimSpace(mockDirectivePattern.ReplaceAllString(prompt, ""))
+	content, toolCalls, err := renderMockResponse(completion.Model, system, cleanPrompt)
+	if err != nil {
+		return nil, &AIUpstreamError{StatusCode: http.StatusInternalServerError, Body: fmt.Sprintf(`{"error":{"message":"mock: %s"}}`, err.Error())}
+	}
+	completion.Content = content
+	completion.ToolCalls = append(completion.ToolCalls, toolCalls...)
+	completion.CompletionTokens = estimateTokens(completion.Content)
+	return completion, nil
+}
+
+// renderMockResponse 优先使用 ai.mock.responses 中第一个命中的预设回复，否则生成默认回复
+func renderMockResponse(modelName, system, prompt string) (string, []config.AIMockToolCall, error) {
+	for _, preset := range config.Get().AI.Mock.Responses {
+		if preset.Match != "" && !strings.Contains(prompt, preset.Match) {
+			continue
+		}
+		if preset.Content == "" {
+			return "", preset.ToolCalls, nil
+		}
+		tmpl, err := template.New("mock").Parse(preset.Content)
+		if err != nil {
+			return "", nil, err
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, map[string]string{"Model": modelName, "Prompt": prompt, "System": system}); err != nil {
+			return "", nil, err
+		}
+		return out.String(), preset.ToolCalls, nil
+	}
+
+	summary := []rune(prompt)
+	if len(summary) > 200 {
+		summary = summary[:200]
+	}
+	return fmt.Sprintf("这是 mock 供应商（模型 %s）生成的确定性回复。\n\n请求摘要：%s", modelName, string(summary)), nil, nil
+}
+
+// injectMockFailure 注入失败；times 非空时仅调用链内前 times 次尝试失败，之后正常应答
+// 按尝试序号判断而不在进程内计数，调用链提前放弃时不会残留状态影响后续请求
+func injectMockFailure(name, times string, attempt int) error {
+	if times != "" {
+		if limit, _ := strconv.Atoi(times); attempt > limit {
+			return nil
+		}
+	}
+
+	if name == "timeout" {
+		return &AITransportError{Err: fmt.Errorf("mock: %w", context.DeadlineExceeded)}
+	}
+	status, _ := strconv.Atoi(name)
+	return &AIUpstreamError{
+		StatusCode: status,
+		Body:       fmt.Sprintf(`{"error":{"message":"mock: injected %d"}}`, status),
+	}
+}
+
+func isMockStatusDirective(name string) bool {
+	status, err := strconv.Atoi(name)
+	return err == nil && status >= 400 && status <= 599
+}
+
+// mockMessageText 兼容字符串与 [{type:text,text}] 两种 content
+func mockMessageText(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return ""
+	}
+	var builder strings.Builder
+	for _, part := range parts {
+		builder.WriteString(part.Text)
+	}
+	return builder.String()
+}
+
+func mockToolCallsPayload(calls []config.AIMockToolCall, stream bool) []interface{} {
+	items := make([]interface{}, 0, len(calls))
+	for i, call := range calls {
+		arguments := call.Arguments
+		if arguments == "" {
+			arguments = "{}"
+		}
+		item := map[string]interface{}{
+			"id":   fmt.Sprintf("call_mock_%d", i+1),
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":      call.Name,
+				"arguments": arguments,
+			},
+		}
+		if stream {
+			item["index"] = i
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func mockUsagePayload(completion *mockCompletion) map[string]interface{} {
+	return map[string]interface{}{
+		"prompt_tokens":     completion.PromptTokens,
+		"completion_tokens": completion.CompletionTokens,
+		"total_tokens":      completion.PromptTokens + completion.CompletionTokens,
+	}
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"strings"
+	"testing"
+)
+
+const mockTestConfig = `
+ai:
+  mock:
+    enabled: true
+    chunk_size: 4
+`
+
+func mockChatBody(t *testing.T, prompt string, tools ...string) string {
+	t.Helper()
+	req := map[string]interface{}{
+		"model": "mock-chat",
+		"messages": []map[string]string{
+			{"role": "system", "content": "你是小说写作助手"},
+			{"role": "user", "content": prompt},
+		},
+	}
+	if len(tools) > 0 {
+		items := make([]interface{}, 0, len(tools))
+		for _, name := range tools {
+			items = append(items, map[string]interface{}{"type": "function", "function": map[string]string{"name": name}})
+		}
+		req["tools"] = items
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal body: %v", err)
+	}
+	return string(body)
+}
+
+func TestIsMockAIProvider(t *testing.T) {
+	useTestConfig(t, "ai:\n  mock:\n    enabled: false\n")
+	if isMockAIProvider("mock") {
+		t.Fatal("mock provider should be unavailable when ai.mock.enabled is false")
+	}
+
+	useTestConfig(t, mockTestConfig)
+	for _, provider := range []string{"mock", " Mock "} {
+		if !isMockAIProvider(provider) {
+			t.Errorf("isMockAIProvider(%q) = false, want true", provider)
+		}
+	}
+	if isMockAIProvider("openai") {
+		t.Error("isMockAIProvider(openai) = true, want false")
+	}
+}
+
+func TestMockAIRequestDeterministic(t *testing.T) {
+	useTestConfig(t, mockTestConfig)
+	body := mockChatBody(t, "写一段开场")
+
+	first, err := mockAIRequest(body, 1)
+	if err != nil {
+		t.Fatalf("mockAIRequest: %v", err)
+	}
+	second, err := mockAIRequest(body, 1)
+	if err != nil {
+		t.Fatalf("mockAIRequest: %v", err)
+	}
+	if string(first) != string(second) {
+		t.Fatalf("responses differ:\n%s\n%s", first, second)
+	}
+
+	content := extractAIText(first)
+	if !strings.Contains(content, "mock-chat") || !strings.Contains(content, "写一段开场") {
+		t.Errorf("unexpected content %q", content)
+	}
+	usage := extractAIUsage(first)
+	if usage.PromptTokens <= 0 || usage.CompletionTokens <= 0 || usage.TotalTokens != usage.PromptTokens+usage.CompletionTokens {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	other, err := mockAIRequest(mockChatBody(t, "写一段结尾"), 1)
+	if err != nil {
+		t.Fatalf("mockAIRequest: %v", err)
+	}
+	if extractAIText(other) == content {
+		t.Error("different prompts should produce different content")
+	}
+}
+
+func TestMockAIRequestDirectives(t *testing.T) {
+	useTestConfig(t, mockTestConfig)
+
+	tests := []struct {
+		name       string
+		prompt     string
+		tools      []string
+		wantStatus int
+		wantTime   bool
+		wantTool   string
+	}{
+		{name: "rate limited", prompt: "生成 [mock:429]", wantStatus: 429},
+		{name: "server error", prompt: "生成 [mock:503]", wantStatus: 503},
+		{name: "timeout", prompt: "生成 [mock:timeout]", wantTime: true},
+		{name: "named tool", prompt: "调用 [mock:tool=search_entities]", wantTool: "search_entities"},
+		{name: "first request tool", prompt: "调用 [mock:tool]", tools: []string{"create_entity", "search_entities"}, wantTool: "create_entity"},
+		{name: "plain request", prompt: "普通请求"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := mockAIRequest(mockChatBody(t, tt.prompt, tt.tools...), 1)
+			switch {
+			case tt.wantStatus > 0:
+				var upstreamErr *AIUpstreamError
+				if !stderrors.As(err, &upstreamErr) || upstreamErr.StatusCode != tt.wantStatus {
+					t.Fatalf("err = %v, want upstream status %d", err, tt.wantStatus)
+				}
+				if !isRetryableAIError(err) {
+					t.Errorf("status %d should be retryable", tt.wantStatus)
+				}
+			case tt.wantTime:
+				var transportErr *AITransportError
+				if !stderrors.As(err, &transportErr) || !stderrors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("err = %v, want transport timeout", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("mockAIRequest: %v", err)
+				}
+				if strings.Contains(extractAIText(raw), "[mock:") {
+					t.Errorf("directive leaked into content %q", extractAIText(raw))
+				}
+				var resp struct {
+					Choices []struct {
+						Message struct {
+							ToolCalls []struct {
+								Function struct {
+									Name string `json:"name"`
+								} `json:"function"`
+							} `json:"tool_calls"`
+						} `json:"message"`
+						FinishReason string `json:"finish_reason"`
+					} `json:"choices"`
+				}
+				if err := json.Unmarshal(raw, &resp); err != nil || len(resp.Choices) != 1 {
+					t.Fatalf("invalid response %s: %v", raw, err)
+				}
+				choice := resp.Choices[0]
+				if tt.wantTool == "" {
+					if len(choice.Message.ToolCalls) != 0 || choice.FinishReason != "stop" {
+						t.Errorf("unexpected tool calls %+v, finish_reason %q", choice.Message.ToolCalls, choice.FinishReason)
+					}
+					return
+				}
+				if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Name != tt.wantTool {
+					t.Fatalf("tool calls = %+v, want %s", choice.Message.ToolCalls, tt.wantTool)
+				}
+				if choice.FinishReason != "tool_calls" {
+					t.Errorf("finish_reason = %q, want tool_calls", choice.FinishReason)
+				}
+			}
+		})
+	}
+}
+
+func TestMockAIRequestFailTimes(t *testing.T) {
+	useTestConfig(t, mockTestConfig)
+	body := mockChatBody(t, "重试 [mock:429x2]")
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		var upstreamErr *AIUpstreamError
+		if _, err := mockAIRequest(body, attempt); !stderrors.As(err, &upstreamErr) || upstreamErr.StatusCode != 429 {
+			t.Fatalf("attempt %d: err = %v, want 429", attempt, err)
+		}
+	}
+	if _, err := mockAIRequest(body, 3); err != nil {
+		t.Fatalf("attempt 3: %v", err)
+	}
+	// 不保留跨调用的状态，新的调用链从第 1 次尝试重新注入失败
+	if _, err := mockAIRequest(body, 1); err == nil {
+		t.Fatal("new call chain: want injected failure")
+	}
+}
+
+func TestCallAIProviderMockFailTimes(t *testing.T) {
+	target := aiCallTarget{Provider: mockAIProvider, Model: "mock-chat", Path: "/chat/completions", Body: mockChatBody(t, "重试 [mock:500x2]")}
+	call := func() (*AICallResult, error) {
+		result := &AICallResult{}
+		_, err := callAIProvider(context.Background(), nil, AICallRequest{Provider: mockAIProvider}, target, result)
+		return result, err
+	}
+
+	// 调用链在用尽注入的失败前放弃，不影响之后的调用
+	useTestConfig(t, mockTestConfig+"  retry:\n    max_attempts: 1\n")
+	if _, err := call(); err == nil {
+		t.Fatal("single attempt: want injected failure")
+	}
+
+	useTestConfig(t, mockTestConfig+"  retry:\n    max_attempts: 3\n    initial_backoff_ms: 1\n    max_backoff_ms: 1\n")
+	for i := 1; i <= 2; i++ {
+		result, err := call()
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if len(result.Attempts) != 3 || result.Attempts[0].StatusCode != 500 || result.Attempts[2].StatusCode != 0 {
+			t.Fatalf("call %d: attempts = %+v, want two failures then success", i, result.Attempts)
+		}
+	}
+}
+
+func TestOpenMockAIStream(t *testing.T) {
+	useTestConfig(t, mockTestConfig)
+	body := mockChatBody(t, "流式生成一段描写")
+
+	raw, err := mockAIRequest(body, 1)
+	if err != nil {
+		t.Fatalf("mockAIRequest: %v", err)
+	}
+	resp, err := openMockAIStream(context.Background(), body, 1)
+	if err != nil {
+		t.Fatalf("openMockAIStream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var chunks []string
+	var usage AIUsage
+	if err := parseAISSE(context.Background(), mockAIProvider, resp.Body, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	}, &usage); err != nil {
+		t.Fatalf("parseAISSE: %v", err)
+	}
+
+	if got, want := strings.Join(chunks, ""), extractAIText(raw); got != want {
+		t.Fatalf("stream content = %q, want %q", got, want)
+	}
+	for _, chunk := range chunks[:len(chunks)-1] {
+		if n := len([]rune(chunk)); n != 4 {
+			t.Errorf("chunk %q has %d runes, want chunk_size 4", chunk, n)
+		}
+	}
+	if usage != extractAIUsage(raw) {
+		t.Errorf("stream usage = %+v, want %+v", usage, extractAIUsage(raw))
+	}
+}
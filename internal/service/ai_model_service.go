@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"novel-agent-os-backend/internal/config"
@@ -14,26 +16,61 @@ import (
 	"novel-agent-os-backend/pkg/logger"
 )
 
+// AI 请求校验错误码（接续 ai_billing_service.go 的 300xx）
+const CodeAIContextWindowExceeded = 30004 // 超出模型上下文窗口
+
 // AIModelService AI模型服务接口
 type AIModelService interface {
 	ListModels(provider string) ([]ModelInfo, error)
+	// GetModelInfo 查询模型能力（上游元数据 + 配置覆盖），未知模型返回默认能力
+	GetModelInfo(provider, modelID string) ModelInfo
+	// CheckContextWindow 估算请求 token 数（含 max_tokens），超出上下文窗口时返回 *AIContextWindowError；上下文长度未知时放行
+	CheckContextWindow(req AICallRequest) error
 }
 
-// ModelInfo 模型信息
+// ModelInfo 模型信息与能力
 type ModelInfo struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Provider string `json:"provider"`
+	ID                string  `json:"id"`
+	Name              string  `json:"name"`
+	Provider          string  `json:"provider"`
+	ContextWindow     int     `json:"context_window,omitempty"`
+	MaxOutputTokens   int     `json:"max_output_tokens,omitempty"`
+	SupportsTools     bool    `json:"supports_tools"`
+	SupportsStreaming bool    `json:"supports_streaming"`
+	InputPricePer1M   float64 `json:"input_price_per_1m,omitempty"`
+	OutputPricePer1M  float64 `json:"output_price_per_1m,omitempty"`
+	// PointsPer1K 本平台计费单价（ai.billing）
+	PointsPer1K float64 `json:"points_per_1k"`
+	// Overridden 命中 ai.model_overrides
+	Overridden bool `json:"overridden,omitempty"`
+}
+
+// AIContextWindowError 请求超出模型上下文窗口
+type AIContextWindowError struct {
+	Code            int    `json:"code"`
+	Message         string `json:"message"`
+	Provider        string `json:"provider"`
+	Model           string `json:"model"`
+	ContextWindow   int    `json:"context_window"`
+	EstimatedTokens int    `json:"estimated_tokens"`
+}
+
+func (e *AIContextWindowError) Error() string {
+	return e.Message
 }
 
 type aiModelService struct {
 	configRepo repository.AIConfigRepository
+	mu         sync.RWMutex
+	// upstream 上游模型元数据：provider -> modelID -> meta（首次查询时从模型缓存加载）
+	upstream map[string]map[string]repository.ProviderModelInfo
 }
 
 // NewAIModelService 创建AI模型服务
 func NewAIModelService(configRepo repository.AIConfigRepository) AIModelService {
 	return &aiModelService{
 		configRepo: configRepo,
+		upstream:   make(map[string]map[string]repository.ProviderModelInfo),
 	}
 }
 
@@ -43,6 +80,9 @@ func (s *aiModelService) ListModels(provider string) ([]ModelInfo, error) {
 	if cleanProvider == "" {
 		return nil, errors.New("invalid provider")
 	}
+	if isMockAIProvider(cleanProvider) {
+		return s.rememberModels(cleanProvider, mockAIModels()), nil
+	}
 
 	cfg, err := s.configRepo.GetProviderConfig(cleanProvider)
 	if err != nil {
@@ -59,15 +99,7 @@ func (s *aiModelService) ListModels(provider string) ([]ModelInfo, error) {
 
 		cachedModels, err := s.configRepo.GetModelsCache(cleanProvider, cfg.BaseURL, cfg.APIKey)
 		if err == nil && len(cachedModels) > 0 {
-			models := make([]ModelInfo, 0, len(cachedModels))
-			for _, item := range cachedModels {
-				models = append(models, ModelInfo{
-					ID:       item.ID,
-					Name:     item.ID,
-					Provider: cleanProvider,
-				})
-			}
-			return models, nil
+			return s.rememberModels(cleanProvider, cachedModels), nil
 		}
 		logger.Warn("缓存读取失败，尝试请求上游",
 			logger.String("provider", cleanProvider),
@@ -92,15 +124,7 @@ func (s *aiModelService) ListModels(provider string) ([]ModelInfo, error) {
 					logger.String("provider", cleanProvider),
 					logger.Int("models_count", len(cachedModels)))
 
-				models := make([]ModelInfo, 0, len(cachedModels))
-				for _, item := range cachedModels {
-					models = append(models, ModelInfo{
-						ID:       item.ID,
-						Name:     item.ID,
-						Provider: cleanProvider,
-					})
-				}
-				return models, nil
+				return s.rememberModels(cleanProvider, cachedModels), nil
 			}
 			logger.Error("无可用缓存",
 				logger.String("provider", cleanProvider),
@@ -110,13 +134,8 @@ func (s *aiModelService) ListModels(provider string) ([]ModelInfo, error) {
 		return nil, err
 	}
 
-	// 更新缓存
-	cacheModels := make([]repository.ProviderModelInfo, 0, len(models))
-	for _, item := range models {
-		cacheModels = append(cacheModels, repository.ProviderModelInfo{ID: item.ID})
-	}
-
-	if err := s.configRepo.UpdateModelsCache(cleanProvider, cfg.BaseURL, cfg.APIKey, cacheModels); err != nil {
+	// 更新缓存（含上游元数据）
+	if err := s.configRepo.UpdateModelsCache(cleanProvider, cfg.BaseURL, cfg.APIKey, models); err != nil {
 		logger.Warn("更新缓存失败",
 			logger.String("provider", cleanProvider),
 			logger.Err(err))
@@ -126,7 +145,179 @@ func (s *aiModelService) ListModels(provider string) ([]ModelInfo, error) {
 			logger.Int("models_count", len(models)))
 	}
 
-	return models, nil
+	return s.rememberModels(cleanProvider, models), nil
+}
+
+// rememberModels 记录上游元数据并合并配置覆盖
+func (s *aiModelService) rememberModels(provider string, models []repository.ProviderModelInfo) []ModelInfo {
+	byID := make(map[string]repository.ProviderModelInfo, len(models))
+	items := make([]ModelInfo, 0, len(models))
+	for _, item := range models {
+		byID[item.ID] = item
+		items = append(items, buildModelInfo(provider, item))
+	}
+	s.mu.Lock()
+	s.upstream[provider] = byID
+	s.mu.Unlock()
+	return items
+}
+
+// GetModelInfo 查询模型能力，不请求上游（仅使用已缓存的元数据）
+func (s *aiModelService) GetModelInfo(provider, modelID string) ModelInfo {
+	cleanProvider := strings.TrimSpace(provider)
+	s.mu.RLock()
+	byID, loaded := s.upstream[cleanProvider]
+	s.mu.RUnlock()
+
+	if !loaded {
+		byID = make(map[string]repository.ProviderModelInfo)
+		if isMockAIProvider(cleanProvider) {
+			for _, item := range mockAIModels() {
+				byID[item.ID] = item
+			}
+		} else if cfg, err := s.configRepo.GetProviderConfig(cleanProvider); err == nil {
+			if cachedModels, err := s.configRepo.GetModelsCache(cleanProvider, cfg.BaseURL, cfg.APIKey); err == nil {
+				for _, item := range cachedModels {
+					byID[item.ID] = item
+				}
+			}
+		}
+		s.mu.Lock()
+		s.upstream[cleanProvider] = byID
+		s.mu.Unlock()
+	}
+
+	meta, ok := byID[modelID]
+	if !ok {
+		meta = repository.ProviderModelInfo{ID: modelID}
+	}
+	return buildModelInfo(cleanProvider, meta)
+}
+
+// CheckContextWindow 按主供应商模型校验上下文窗口
+func (s *aiModelService) CheckContextWindow(req AICallRequest) error {
+	modelID := detectRequestModel(req)
+	if modelID == "" {
+		return nil
+	}
+	info := s.GetModelInfo(req.Provider, modelID)
+	if info.ContextWindow <= 0 {
+		return nil
+	}
+
+	estimated := estimateRequestTokens(req)
+	if estimated <= info.ContextWindow {
+		return nil
+	}
+	return &AIContextWindowError{
+		Code:            CodeAIContextWindowExceeded,
+		Message:         fmt.Sprintf("请求约 %d tokens，超出模型 %s 的上下文窗口（%d）", estimated, modelID, info.ContextWindow),
+		Provider:        req.Provider,
+		Model:           modelID,
+		ContextWindow:   info.ContextWindow,
+		EstimatedTokens: estimated,
+	}
+}
+
+// checkAIStreamRequest 流式调用前校验：上下文窗口与模型是否支持流式输出
+func checkAIStreamRequest(modelService AIModelService, req AICallRequest) error {
+	if modelService == nil {
+		return nil
+	}
+	if err := modelService.CheckContextWindow(req); err != nil {
+		return err
+	}
+	if modelID := detectRequestModel(req); modelID != "" && !modelService.GetModelInfo(req.Provider, modelID).SupportsStreaming {
+		return fmt.Errorf("model %s does not support streaming", modelID)
+	}
+	return nil
+}
+
+// estimateRequestTokens 估算输入 token 数与预留的输出 token 数之和
+func estimateRequestTokens(req AICallRequest) int {
+	if req.Chat != nil {
+		total := req.Chat.MaxTokens
+		for _, msg := range req.Chat.Messages {
+			// 每条消息的角色与分隔符约 4 tokens
+			total += estimateTokens(msg.Content) + 4
+		}
+		return total
+	}
+	var payload struct {
+		MaxTokens int `json:"max_tokens"`
+	}
+	_ = json.Unmarshal([]byte(req.Body), &payload)
+	return estimateTokens(req.Body) + payload.MaxTokens
+}
+
+// buildModelInfo 合并默认能力、上游元数据与 ai.model_overrides
+// 上游未声明能力时默认支持工具调用与流式输出
+func buildModelInfo(provider string, meta repository.ProviderModelInfo) ModelInfo {
+	info := ModelInfo{
+		ID:                meta.ID,
+		Name:              meta.Name,
+		Provider:          provider,
+		ContextWindow:     meta.ContextWindow,
+		MaxOutputTokens:   meta.MaxOutputTokens,
+		SupportsTools:     true,
+		SupportsStreaming: true,
+		InputPricePer1M:   meta.InputPricePer1M,
+		OutputPricePer1M:  meta.OutputPricePer1M,
+	}
+	if info.Name == "" {
+		info.Name = meta.ID
+	}
+	if meta.SupportsTools != nil {
+		info.SupportsTools = *meta.SupportsTools
+	}
+	if meta.SupportsStreaming != nil {
+		info.SupportsStreaming = *meta.SupportsStreaming
+	}
+
+	aiCfg := config.Get().AI
+	for _, override := range aiCfg.ModelOverrides {
+		if !matchModelOverride(override, provider, meta.ID) {
+			continue
+		}
+		info.Overridden = true
+		if override.ContextWindow > 0 {
+			info.ContextWindow = override.ContextWindow
+		}
+		if override.MaxOutputTokens > 0 {
+			info.MaxOutputTokens = override.MaxOutputTokens
+		}
+		if override.SupportsTools != nil {
+			info.SupportsTools = *override.SupportsTools
+		}
+		if override.SupportsStreaming != nil {
+			info.SupportsStreaming = *override.SupportsStreaming
+		}
+		if override.InputPricePer1M > 0 {
+			info.InputPricePer1M = override.InputPricePer1M
+		}
+		if override.OutputPricePer1M > 0 {
+			info.OutputPricePer1M = override.OutputPricePer1M
+		}
+		break
+	}
+
+	info.PointsPer1K = aiModelPointsPer1K(aiCfg.Billing, meta.ID)
+	return info
+}
+
+// matchModelOverride 首个匹配的覆盖生效；Model 以 * 结尾时按前缀匹配
+func matchModelOverride(override config.AIModelOverride, provider, modelID string) bool {
+	if override.Provider != "" && !strings.EqualFold(override.Provider, provider) {
+		return false
+	}
+	pattern := strings.TrimSpace(override.Model)
+	if pattern == "" {
+		return false
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(strings.ToLower(modelID), strings.ToLower(strings.TrimSuffix(pattern, "*")))
+	}
+	return strings.EqualFold(pattern, modelID)
 }
 
 type openAIModelsResponse struct {
@@ -137,18 +328,34 @@ type openAIModelsResponse struct {
 
 type openRouterModelsResponse struct {
 	Data []struct {
-		ID   string `json:"id"`
-		Name string `json:"name"`
+		ID            string `json:"id"`
+		Name          string `json:"name"`
+		ContextLength int    `json:"context_length"`
+		Pricing       struct {
+			Prompt     string `json:"prompt"`
+			Completion string `json:"completion"`
+		} `json:"pricing"`
+		TopProvider struct {
+			MaxCompletionTokens int `json:"max_completion_tokens"`
+		} `json:"top_provider"`
+		SupportedParameters []string `json:"supported_parameters"`
 	} `json:"data"`
 }
 
 type anthropicModelsResponse struct {
+	Data []struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"display_name"`
+	} `json:"data"`
 	Models []struct {
 		ID string `json:"id"`
 	} `json:"models"`
 }
 
-func fetchModelsFromProvider(provider, baseURL, apiKey string) ([]ModelInfo, error) {
+func fetchModelsFromProvider(provider, baseURL, apiKey string) ([]repository.ProviderModelInfo, error) {
+	if isMockAIProvider(provider) {
+		return mockAIModels(), nil
+	}
 	endpoint := buildModelsEndpoint(provider, baseURL)
 	if endpoint == "" {
 		return nil, errors.New("invalid provider endpoint")
@@ -160,16 +367,7 @@ func fetchModelsFromProvider(provider, baseURL, apiKey string) ([]ModelInfo, err
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	if apiKey != "" {
-		if provider == "gemini" {
-			req.Header.Set("x-goog-api-key", apiKey)
-		} else if provider == "anthropic" {
-			req.Header.Set("x-api-key", apiKey)
-			req.Header.Set("anthropic-version", "2023-06-01")
-		} else {
-			req.Header.Set("Authorization", "Bearer "+apiKey)
-		}
-	}
+	SetAIProviderHeaders(req, provider, apiKey)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -187,13 +385,13 @@ func fetchModelsFromProvider(provider, baseURL, apiKey string) ([]ModelInfo, err
 	}
 
 	if provider == "gemini" {
-		return parseGeminiModels(provider, body)
+		return parseGeminiModels(body)
 	}
 	if provider == "openrouter" {
-		return parseOpenRouterModels(provider, body)
+		return parseOpenRouterModels(body)
 	}
 	if provider == "anthropic" {
-		return parseAnthropicModels(provider, body)
+		return parseAnthropicModels(body)
 	}
 
 	var parsed openAIModelsResponse
@@ -201,74 +399,110 @@ func fetchModelsFromProvider(provider, baseURL, apiKey string) ([]ModelInfo, err
 		return nil, err
 	}
 
-	models := make([]ModelInfo, 0, len(parsed.Data))
+	models := make([]repository.ProviderModelInfo, 0, len(parsed.Data))
 	for _, item := range parsed.Data {
 		if item.ID == "" {
 			continue
 		}
-		models = append(models, ModelInfo{ID: item.ID, Name: item.ID, Provider: provider})
+		models = append(models, repository.ProviderModelInfo{ID: item.ID})
 	}
 	return models, nil
 }
 
 type geminiModelsResponse struct {
 	Models []struct {
-		Name string `json:"name"`
+		Name             string `json:"name"`
+		DisplayName      string `json:"displayName"`
+		InputTokenLimit  int    `json:"inputTokenLimit"`
+		OutputTokenLimit int    `json:"outputTokenLimit"`
 	} `json:"models"`
 }
 
-func parseGeminiModels(provider string, body []byte) ([]ModelInfo, error) {
+func parseGeminiModels(body []byte) ([]repository.ProviderModelInfo, error) {
 	var parsed geminiModelsResponse
 	if err := json.Unmarshal(body, &parsed); err != nil {
 		return nil, err
 	}
 
-	models := make([]ModelInfo, 0, len(parsed.Models))
+	models := make([]repository.ProviderModelInfo, 0, len(parsed.Models))
 	for _, item := range parsed.Models {
 		if item.Name == "" {
 			continue
 		}
-		id := strings.TrimPrefix(item.Name, "models/")
-		name := id
-		models = append(models, ModelInfo{ID: id, Name: name, Provider: provider})
+		models = append(models, repository.ProviderModelInfo{
+			ID:              strings.TrimPrefix(item.Name, "models/"),
+			Name:            item.DisplayName,
+			ContextWindow:   item.InputTokenLimit,
+			MaxOutputTokens: item.OutputTokenLimit,
+		})
 	}
 	return models, nil
 }
 
-func parseOpenRouterModels(provider string, body []byte) ([]ModelInfo, error) {
+func parseOpenRouterModels(body []byte) ([]repository.ProviderModelInfo, error) {
 	var parsed openRouterModelsResponse
 	if err := json.Unmarshal(body, &parsed); err != nil {
 		return nil, err
 	}
-	models := make([]ModelInfo, 0, len(parsed.Data))
+	models := make([]repository.ProviderModelInfo, 0, len(parsed.Data))
 	for _, item := range parsed.Data {
 		if item.ID == "" {
 			continue
 		}
-		name := item.Name
-		if name == "" {
-			name = item.ID
+		info := repository.ProviderModelInfo{
+			ID:              item.ID,
+			Name:            item.Name,
+			ContextWindow:   item.ContextLength,
+			MaxOutputTokens: item.TopProvider.MaxCompletionTokens,
+			// OpenRouter 价格单位为 USD/token
+			InputPricePer1M:  parsePricePerToken(item.Pricing.Prompt) * 1e6,
+			OutputPricePer1M: parsePricePerToken(item.Pricing.Completion) * 1e6,
+		}
+		if len(item.SupportedParameters) > 0 {
+			supportsTools := false
+			for _, param := range item.SupportedParameters {
+				if param == "tools" {
+					supportsTools = true
+					break
+				}
+			}
+			info.SupportsTools = &supportsTools
 		}
-		models = append(models, ModelInfo{ID: item.ID, Name: name, Provider: provider})
+		models = append(models, info)
 	}
 	return models, nil
 }
 
-func parseAnthropicModels(provider string, body []byte) ([]ModelInfo, error) {
+// parseAnthropicModels 兼容 data（Models API）与 models 两种返回结构
+func parseAnthropicModels(body []byte) ([]repository.ProviderModelInfo, error) {
 	var parsed anthropicModelsResponse
 	if err := json.Unmarshal(body, &parsed); err != nil {
 		return nil, err
 	}
-	models := make([]ModelInfo, 0, len(parsed.Models))
+	models := make([]repository.ProviderModelInfo, 0, len(parsed.Data)+len(parsed.Models))
+	for _, item := range parsed.Data {
+		if item.ID == "" {
+			continue
+		}
+		models = append(models, repository.ProviderModelInfo{ID: item.ID, Name: item.DisplayName})
+	}
 	for _, item := range parsed.Models {
 		if item.ID == "" {
 			continue
 		}
-		models = append(models, ModelInfo{ID: item.ID, Name: item.ID, Provider: provider})
+		models = append(models, repository.ProviderModelInfo{ID: item.ID})
 	}
 	return models, nil
 }
 
+func parsePricePerToken(value string) float64 {
+	price, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || price < 0 {
+		return 0
+	}
+	return price
+}
+
 func buildModelsEndpoint(provider, baseURL string) string {
 	if baseURL == "" {
 		return ""
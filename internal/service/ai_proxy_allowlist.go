@@ -56,10 +56,10 @@ func ValidateAIProxyTarget(provider, baseURL, path string) error {
 			return errors.New("invalid base url")
 		}
 		host := base.Hostname()
-		
+
 		// 获取配置
 		cfg := config.Get()
-		
+
 		// 开发环境或显式允许不安全HTTP：允许内网HTTP地址
 		if cfg.App.Env == "development" || cfg.AI.AllowInsecureHTTP {
 			// 开发模式或配置允许时，允许内网地址
@@ -83,6 +83,7 @@ func ValidateAIProxyTarget(provider, baseURL, path string) error {
 
 	// provider 白名单：
 	// - gemini：仅放行 generateContent/streamGenerateContent
+	// - anthropic：仅放行 Messages API（/v1/messages）
 	// - 其他：按 OpenAI 兼容接口放行（workflow 依赖 chat/completions 注入 tools）
 	if cleanProvider == "gemini" {
 		if !strings.HasPrefix(normalized, "/v1beta/models/") {
@@ -93,6 +94,16 @@ func ValidateAIProxyTarget(provider, baseURL, path string) error {
 		}
 		return nil
 	}
+	if cleanProvider == "anthropic" {
+		if normalized == "/v1/messages" {
+			return nil
+		}
+		// 兼容 baseURL 已包含 /v1 的情况
+		if normalized == "/messages" && strings.HasSuffix(strings.TrimRight(base.Path, "/"), "/v1") {
+			return nil
+		}
+		return errors.New("path not allowed")
+	}
 
 	allowedExact := map[string]struct{}{
 		"/v1/chat/completions": {},
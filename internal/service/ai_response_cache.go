@@ -0,0 +1,280 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"novel-agent-os-backend/internal/config"
+	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/internal/repository"
+	"novel-agent-os-backend/pkg/logger"
+
+	"gorm.io/gorm"
+)
+
+// 响应缓存模式（工作流请求的 cache 字段）
+const (
+	// AICacheAuto 默认：仅 temperature 为 0 的请求使用缓存
+	AICacheAuto = ""
+	// AICacheForce 忽略 temperature，按请求体命中或写入缓存
+	AICacheForce = "force"
+	// AICacheBypass 本次调用不读也不写缓存
+	AICacheBypass = "bypass"
+)
+
+// aiCacheIgnoredFields 不影响输出、不参与缓存键的请求字段
+var aiCacheIgnoredFields = []string{"stream", "stream_options", "user"}
+
+// IsValidAICacheMode 校验请求的缓存模式
+func IsValidAICacheMode(mode string) bool {
+	switch mode {
+	case AICacheAuto, "auto", AICacheForce, AICacheBypass:
+		return true
+	}
+	return false
+}
+
+// AIResponseCacheProviderStats 单个供应商的缓存命中统计
+type AIResponseCacheProviderStats struct {
+	Provider string  `json:"provider"`
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	Stores   int64   `json:"stores"`
+	Bypassed int64   `json:"bypassed"`
+	HitRate  float64 `json:"hit_rate"`
+}
+
+// AIResponseCacheStats 进程启动以来的缓存命中统计
+type AIResponseCacheStats struct {
+	Enabled   bool                           `json:"enabled"`
+	Entries   int64                          `json:"entries"`
+	Hits      int64                          `json:"hits"`
+	Misses    int64                          `json:"misses"`
+	Stores    int64                          `json:"stores"`
+	Bypassed  int64                          `json:"bypassed"`
+	HitRate   float64                        `json:"hit_rate"`
+	Providers []AIResponseCacheProviderStats `json:"providers"`
+}
+
+// AIResponseCacheService 确定性请求的响应缓存
+type AIResponseCacheService interface {
+	// Lookup 查询缓存；命中时返回的结果没有尝试记录（未到达上游，台账记零消耗、不计费）
+	Lookup(req AICallRequest) (*AICallResult, bool)
+	// Store 写入成功的调用结果；含工具调用或使用用户自有密钥的响应不缓存
+	Store(req AICallRequest, result *AICallResult)
+	Stats() (*AIResponseCacheStats, error)
+}
+
+type aiResponseCacheService struct {
+	cacheRepo repository.AIResponseCacheRepository
+}
+
+// NewAIResponseCacheService 创建响应缓存服务；缓存开启时启动过期条目的定期清理
+func NewAIResponseCacheService(cacheRepo repository.AIResponseCacheRepository) AIResponseCacheService {
+	s := &aiResponseCacheService{cacheRepo: cacheRepo}
+	if config.Get().AI.ResponseCache.Enabled {
+		go s.purgeLoop()
+	}
+	return s
+}
+
+// aiResponseCacheMetrics 进程内各供应商的缓存命中计数
+var aiResponseCacheMetrics = struct {
+	sync.Mutex
+	byProvider map[string]*AIResponseCacheProviderStats
+}{byProvider: make(map[string]*AIResponseCacheProviderStats)}
+
+func (s *aiResponseCacheService) Lookup(req AICallRequest) (*AICallResult, bool) {
+	provider := strings.ToLower(strings.TrimSpace(req.Provider))
+	if req.CacheMode == AICacheBypass && config.Get().AI.ResponseCache.Enabled {
+		recordAIResponseCacheMetric(provider, func(stats *AIResponseCacheProviderStats) { stats.Bypassed++ })
+		return nil, false
+	}
+	if !aiResponseCacheable(req) {
+		return nil, false
+	}
+	start := time.Now()
+	entry, err := s.cacheRepo.GetValid(aiResponseCacheKey(req), start)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Warn("ai response cache lookup failed", logger.String("provider", provider), logger.Err(err))
+		}
+		recordAIResponseCacheMetric(provider, func(stats *AIResponseCacheProviderStats) { stats.Misses++ })
+		return nil, false
+	}
+	recordAIResponseCacheMetric(provider, func(stats *AIResponseCacheProviderStats) { stats.Hits++ })
+	if err := s.cacheRepo.IncrementHit(entry.ID); err != nil {
+		logger.Warn("ai response cache hit count failed", logger.Uint("id", entry.ID), logger.Err(err))
+	}
+
+	raw := []byte(entry.Response)
+	content := extractAIText(raw)
+	return &AICallResult{
+		Raw:         json.RawMessage(raw),
+		Content:     content,
+		Provider:    entry.ProviderUsed,
+		Model:       entry.ModelUsed,
+		Path:        entry.Path,
+		Usage:       extractAIUsage(raw),
+		LatencyMs:   time.Since(start).Milliseconds(),
+		RequestBody: req.Body,
+		Cached:      true,
+	}, true
+}
+
+func (s *aiResponseCacheService) Store(req AICallRequest, result *AICallResult) {
+	if result == nil || len(result.Raw) == 0 || result.UserKey || !aiResponseCacheable(req) {
+		return
+	}
+	cfg := config.Get().AI.ResponseCache
+	if cfg.MaxEntryKB > 0 && len(result.Raw) > cfg.MaxEntryKB*1024 {
+		return
+	}
+	if decoded, err := DecodeChatResponse(result.Provider, result.Raw); err != nil || len(decoded.ToolCalls) > 0 {
+		return
+	}
+
+	provider := strings.ToLower(strings.TrimSpace(req.Provider))
+	entry := &model.AIResponseCache{
+		CacheKey:     aiResponseCacheKey(req),
+		Provider:     provider,
+		Model:        detectRequestModel(req),
+		Path:         req.Path,
+		ProviderUsed: result.Provider,
+		ModelUsed:    result.Model,
+		Response:     string(result.Raw),
+		ExpiresAt:    time.Now().Add(time.Duration(cfg.TTLSec) * time.Second),
+	}
+	if err := s.cacheRepo.Save(entry); err != nil {
+		logger.Warn("ai response cache store failed", logger.String("provider", provider), logger.Err(err))
+		return
+	}
+	recordAIResponseCacheMetric(provider, func(stats *AIResponseCacheProviderStats) { stats.Stores++ })
+}
+
+func (s *aiResponseCacheService) Stats() (*AIResponseCacheStats, error) {
+	entries, err := s.cacheRepo.Count()
+	if err != nil {
+		return nil, err
+	}
+	stats := &AIResponseCacheStats{
+		Enabled:   config.Get().AI.ResponseCache.Enabled,
+		Entries:   entries,
+		Providers: make([]AIResponseCacheProviderStats, 0),
+	}
+
+	aiResponseCacheMetrics.Lock()
+	for _, item := range aiResponseCacheMetrics.byProvider {
+		stats.Providers = append(stats.Providers, *item)
+		stats.Hits += item.Hits
+		stats.Misses += item.Misses
+		stats.Stores += item.Stores
+		stats.Bypassed += item.Bypassed
+	}
+	aiResponseCacheMetrics.Unlock()
+
+	sort.Slice(stats.Providers, func(i, j int) bool { return stats.Providers[i].Provider < stats.Providers[j].Provider })
+	for i := range stats.Providers {
+		stats.Providers[i].HitRate = aiCacheHitRate(stats.Providers[i].Hits, stats.Providers[i].Misses)
+	}
+	stats.HitRate = aiCacheHitRate(stats.Hits, stats.Misses)
+	return stats, nil
+}
+
+// purgeLoop 每小时清理过期条目
+func (s *aiResponseCacheService) purgeLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		deleted, err := s.cacheRepo.DeleteExpired(time.Now())
+		if err != nil {
+			logger.Warn("purge ai response cache failed", logger.Err(err))
+		} else if deleted > 0 {
+			logger.Info("purged ai response cache", logger.Int("deleted", int(deleted)))
+		}
+		<-ticker.C
+	}
+}
+
+// aiResponseCacheable 缓存开启且请求为确定性请求（temperature 为 0）或显式要求缓存
+func aiResponseCacheable(req AICallRequest) bool {
+	if !config.Get().AI.ResponseCache.Enabled {
+		return false
+	}
+	switch req.CacheMode {
+	case AICacheBypass:
+		return false
+	case AICacheForce:
+		return true
+	}
+	temperature, ok := aiRequestTemperature(req)
+	return ok && temperature == 0
+}
+
+// aiRequestTemperature 读取请求的 temperature（chat 优先，其次请求体顶层或 Gemini generationConfig）
+func aiRequestTemperature(req AICallRequest) (float64, bool) {
+	if req.Chat != nil && req.Chat.Temperature != nil {
+		return *req.Chat.Temperature, true
+	}
+	var payload struct {
+		Temperature      *float64 `json:"temperature"`
+		GenerationConfig struct {
+			Temperature *float64 `json:"temperature"`
+		} `json:"generationConfig"`
+	}
+	if err := json.Unmarshal([]byte(req.Body), &payload); err != nil {
+		return 0, false
+	}
+	if payload.Temperature != nil {
+		return *payload.Temperature, true
+	}
+	if payload.GenerationConfig.Temperature != nil {
+		return *payload.GenerationConfig.Temperature, true
+	}
+	return 0, false
+}
+
+// aiResponseCacheKey 供应商 + 模型 + path + 规范化请求体（键排序、去除不影响输出的字段）的 SHA-256
+func aiResponseCacheKey(req AICallRequest) string {
+	body := strings.TrimSpace(req.Body)
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &payload); err == nil {
+		for _, field := range aiCacheIgnoredFields {
+			delete(payload, field)
+		}
+		if normalized, err := json.Marshal(payload); err == nil {
+			body = string(normalized)
+		}
+	}
+	digest := sha256.Sum256([]byte(strings.Join([]string{
+		strings.ToLower(strings.TrimSpace(req.Provider)),
+		detectRequestModel(req),
+		strings.Trim(req.Path, "/"),
+		body,
+	}, "\n")))
+	return hex.EncodeToString(digest[:])
+}
+
+func recordAIResponseCacheMetric(provider string, update func(stats *AIResponseCacheProviderStats)) {
+	aiResponseCacheMetrics.Lock()
+	defer aiResponseCacheMetrics.Unlock()
+	stats, ok := aiResponseCacheMetrics.byProvider[provider]
+	if !ok {
+		stats = &AIResponseCacheProviderStats{Provider: provider}
+		aiResponseCacheMetrics.byProvider[provider] = stats
+	}
+	update(stats)
+}
+
+func aiCacheHitRate(hits, misses int64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
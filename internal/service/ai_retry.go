@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"novel-agent-os-backend/internal/config"
+)
+
+// AIUpstreamError 上游返回非 2xx 状态码
+type AIUpstreamError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *AIUpstreamError) Error() string {
+	return fmt.Sprintf("upstream error: %s", e.Body)
+}
+
+// NewAIUpstreamError 由上游非 2xx 响应构造错误（解析 Retry-After）
+func NewAIUpstreamError(resp *http.Response, body []byte) *AIUpstreamError {
+	return &AIUpstreamError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// AITransportError 请求未得到上游响应（连接失败、超时等）
+type AITransportError struct {
+	Err error
+}
+
+func (e *AITransportError) Error() string {
+	return "proxy request failed"
+}
+
+func (e *AITransportError) Unwrap() error {
+	return e.Err
+}
+
+// AIAttempt 单次上游调用记录（写入步骤 metadata.attempts）
+type AIAttempt struct {
+	Provider   string `json:"provider"`
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+	BackoffMs  int64  `json:"backoff_ms,omitempty"`
+}
+
+// aiRetryPolicy 重试策略（来源于 ai.retry 配置）
+type aiRetryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+	jitter         float64
+	maxRetryAfter  time.Duration
+}
+
+func currentAIRetryPolicy() aiRetryPolicy {
+	retryCfg := config.Get().AI.Retry
+	policy := aiRetryPolicy{
+		maxAttempts:    retryCfg.MaxAttempts,
+		initialBackoff: time.Duration(retryCfg.InitialBackoffMs) * time.Millisecond,
+		maxBackoff:     time.Duration(retryCfg.MaxBackoffMs) * time.Millisecond,
+		multiplier:     retryCfg.Multiplier,
+		jitter:         retryCfg.Jitter,
+		maxRetryAfter:  time.Duration(retryCfg.MaxRetryAfterMs) * time.Millisecond,
+	}
+	if policy.maxAttempts <= 0 {
+		policy.maxAttempts = 1
+	}
+	if policy.multiplier < 1 {
+		policy.multiplier = 1
+	}
+	if policy.jitter < 0 {
+		policy.jitter = 0
+	}
+	if policy.jitter > 1 {
+		policy.jitter = 1
+	}
+	return policy
+}
+
+func currentAIRequestTimeout() time.Duration {
+	seconds := config.Get().AI.RequestTimeout
+	if seconds <= 0 {
+		seconds = 60
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// backoff 计算第 attempt 次失败后的等待时间：指数退避 + 抖动，上游给出 Retry-After 时优先采用
+// 返回 false 表示 Retry-After 超出上限，不再重试
+func (p aiRetryPolicy) backoff(attempt int, err error) (time.Duration, bool) {
+	var upstreamErr *AIUpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.RetryAfter > 0 {
+		if p.maxRetryAfter > 0 && upstreamErr.RetryAfter > p.maxRetryAfter {
+			return 0, false
+		}
+		return upstreamErr.RetryAfter, true
+	}
+
+	wait := float64(p.initialBackoff) * math.Pow(p.multiplier, float64(attempt-1))
+	if p.maxBackoff > 0 && wait > float64(p.maxBackoff) {
+		wait = float64(p.maxBackoff)
+	}
+	if p.jitter > 0 {
+		wait = wait * (1 - p.jitter + rand.Float64()*2*p.jitter)
+	}
+	return time.Duration(wait), true
+}
+
+// isRetryableAIError 仅对可安全重放的失败类型重试：
+// 连接失败/超时、408、425、429、5xx（不含 501）、Anthropic 529 overloaded，
+// 以及密钥鉴权失败/限流后可换用其他密钥的情况
+func isRetryableAIError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var rotateErr *AIKeyRotateError
+	if errors.As(err, &rotateErr) {
+		return true
+	}
+
+	var transportErr *AITransportError
+	if errors.As(err, &transportErr) {
+		return !errors.Is(transportErr.Err, context.Canceled)
+	}
+
+	var upstreamErr *AIUpstreamError
+	if errors.As(err, &upstreamErr) {
+		switch upstreamErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+			return true
+		case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+			return false
+		}
+		return upstreamErr.StatusCode >= 500
+	}
+	return false
+}
+
+// parseRetryAfter 解析 Retry-After（秒数或 HTTP 日期）
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// sleepWithContext 等待指定时长，ctx 取消时提前返回
+func sleepWithContext(ctx context.Context, wait time.Duration) error {
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// attemptErrorStatus 从错误中提取状态码（用于 attempts 记录）
+func attemptErrorStatus(err error) int {
+	var upstreamErr *AIUpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.StatusCode
+	}
+	return 0
+}
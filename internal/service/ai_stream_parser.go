@@ -0,0 +1,295 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"novel-agent-os-backend/pkg/logger"
+)
+
+// sseMaxLineSize 单行 SSE 数据上限（Gemini 单个 chunk 可能超过 bufio 默认 64KB）
+const sseMaxLineSize = 1024 * 1024
+
+// geminiBlockedFinishReasons Gemini 因安全/合规策略中断输出的 finishReason
+var geminiBlockedFinishReasons = map[string]struct{}{
+	"SAFETY":             {},
+	"RECITATION":         {},
+	"BLOCKLIST":          {},
+	"PROHIBITED_CONTENT": {},
+	"SPII":               {},
+	"IMAGE_SAFETY":       {},
+}
+
+// normalizeStreamPath 规范化流式请求路径
+// Gemini 的 streamGenerateContent 不带 alt=sse 时返回 JSON 数组而非 SSE，这里自动补齐。
+func normalizeStreamPath(provider, path string) string {
+	if strings.ToLower(strings.TrimSpace(provider)) != "gemini" {
+		return path
+	}
+	if !strings.Contains(path, ":streamGenerateContent") || strings.Contains(path, "?") {
+		return path
+	}
+	return path + "?alt=sse"
+}
+
+// parseAISSE 按供应商选择 SSE 解析器，流中携带的用量写入 usage
+func parseAISSE(ctx context.Context, provider string, reader io.Reader, chunkHandler func(chunk string) error, usage *AIUsage) error {
+	switch strings.ToLower(strings.TrimSpace(provider)) {
+	case "gemini":
+		return parseGeminiSSE(ctx, reader, chunkHandler, usage)
+	case "anthropic":
+		return parseAnthropicSSE(ctx, reader, chunkHandler, usage)
+	default:
+		return parseOpenAISSE(ctx, reader, chunkHandler, usage)
+	}
+}
+
+// parseOpenAISSE 解析 OpenAI SSE 格式
+// 请求携带 stream_options.include_usage 时，[DONE] 前的最后一个 chunk 为 choices 为空的 usage 块。
+// 同时兼容 Responses API 的流式事件：文本增量位于 response.output_text.delta 事件的 delta，
+// response.completed 表示结束（携带 response.usage），response.failed / error 转为错误返回。
+func parseOpenAISSE(ctx context.Context, reader io.Reader, chunkHandler func(chunk string) error, usage *AIUsage) error {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &payload); err != nil {
+			logger.Warn("failed to parse SSE chunk", logger.String("data", data))
+			continue
+		}
+		mergeAIUsage(usage, payload)
+
+		// Responses API 事件
+		if eventType, _ := payload["type"].(string); strings.HasPrefix(eventType, "response.") || eventType == "error" {
+			done, err := handleResponsesStreamEvent(eventType, payload, chunkHandler)
+			if err != nil || done {
+				return err
+			}
+			continue
+		}
+
+		// 提取 OpenAI 格式的 content
+		if choices, ok := payload["choices"].([]interface{}); ok && len(choices) > 0 {
+			if choice, ok := choices[0].(map[string]interface{}); ok {
+				if delta, ok := choice["delta"].(map[string]interface{}); ok {
+					if content, ok := delta["content"].(string); ok && content != "" {
+						if err := chunkHandler(content); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("scanner error: %w", err)
+	}
+
+	return nil
+}
+
+// handleResponsesStreamEvent 处理单个 Responses API 流式事件，返回流是否已结束
+func handleResponsesStreamEvent(eventType string, payload map[string]interface{}, chunkHandler func(chunk string) error) (bool, error) {
+	switch eventType {
+	case "response.output_text.delta":
+		if delta, ok := payload["delta"].(string); ok && delta != "" {
+			return false, chunkHandler(delta)
+		}
+	case "response.completed", "response.incomplete":
+		return true, nil
+	case "response.failed", "error":
+		message := "unknown error"
+		errObj, _ := payload["error"].(map[string]interface{})
+		if response, ok := payload["response"].(map[string]interface{}); ok {
+			if obj, ok := response["error"].(map[string]interface{}); ok {
+				errObj = obj
+			}
+		}
+		if msg, ok := errObj["message"].(string); ok && msg != "" {
+			message = msg
+		} else if msg, ok := payload["message"].(string); ok && msg != "" {
+			message = msg
+		}
+		return true, fmt.Errorf("upstream error: %s", message)
+	}
+	return false, nil
+}
+
+// parseAnthropicSSE 解析 Anthropic Messages SSE 格式
+// 文本增量位于 content_block_delta 事件的 delta.text（delta.type=text_delta），
+// message_stop 表示结束，error 事件转为错误返回。
+func parseAnthropicSSE(ctx context.Context, reader io.Reader, chunkHandler func(chunk string) error, usage *AIUsage) error {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" {
+			continue
+		}
+
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &payload); err != nil {
+			logger.Warn("failed to parse SSE chunk", logger.String("data", data))
+			continue
+		}
+		mergeAIUsage(usage, payload)
+
+		eventType, _ := payload["type"].(string)
+		switch eventType {
+		case "content_block_delta":
+			delta, ok := payload["delta"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if deltaType, _ := delta["type"].(string); deltaType != "text_delta" {
+				continue
+			}
+			if text, ok := delta["text"].(string); ok && text != "" {
+				if err := chunkHandler(text); err != nil {
+					return err
+				}
+			}
+		case "message_stop":
+			return nil
+		case "error":
+			message := "unknown error"
+			if errObj, ok := payload["error"].(map[string]interface{}); ok {
+				if msg, ok := errObj["message"].(string); ok && msg != "" {
+					message = msg
+				}
+			}
+			return fmt.Errorf("upstream error: %s", message)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("scanner error: %w", err)
+	}
+
+	return nil
+}
+
+// parseGeminiSSE 解析 Gemini streamGenerateContent?alt=sse 格式
+// 每个 chunk 为完整的 GenerateContentResponse：文本位于 candidates[0].content.parts[].text；
+// promptFeedback.blockReason 或安全类 finishReason 视为错误返回。
+func parseGeminiSSE(ctx context.Context, reader io.Reader, chunkHandler func(chunk string) error, usage *AIUsage) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), sseMaxLineSize)
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" {
+			continue
+		}
+
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &payload); err != nil {
+			logger.Warn("failed to parse SSE chunk", logger.String("data", data))
+			continue
+		}
+		mergeAIUsage(usage, payload)
+
+		// 上游错误对象
+		if errObj, ok := payload["error"].(map[string]interface{}); ok {
+			message, _ := errObj["message"].(string)
+			if message == "" {
+				message = "unknown error"
+			}
+			return fmt.Errorf("upstream error: %s", message)
+		}
+
+		// 提示词被拦截：无 candidates，仅有 promptFeedback.blockReason
+		if feedback, ok := payload["promptFeedback"].(map[string]interface{}); ok {
+			if reason, _ := feedback["blockReason"].(string); reason != "" {
+				return fmt.Errorf("prompt blocked by safety filter: %s", reason)
+			}
+		}
+
+		candidates, ok := payload["candidates"].([]interface{})
+		if !ok || len(candidates) == 0 {
+			continue
+		}
+		cand, ok := candidates[0].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if content, ok := cand["content"].(map[string]interface{}); ok {
+			if parts, ok := content["parts"].([]interface{}); ok {
+				for _, item := range parts {
+					part, ok := item.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					// thought=true 为思考摘要，不计入正文
+					if thought, _ := part["thought"].(bool); thought {
+						continue
+					}
+					if text, ok := part["text"].(string); ok && text != "" {
+						if err := chunkHandler(text); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+
+		finishReason, _ := cand["finishReason"].(string)
+		if finishReason == "" || finishReason == "FINISH_REASON_UNSPECIFIED" {
+			continue
+		}
+		if _, blocked := geminiBlockedFinishReasons[finishReason]; blocked {
+			return fmt.Errorf("response blocked by safety filter: %s", finishReason)
+		}
+		if finishReason == "MAX_TOKENS" {
+			logger.Warn("gemini stream truncated by max tokens")
+		}
+		return nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("scanner error: %w", err)
+	}
+
+	return nil
+}
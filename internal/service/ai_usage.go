@@ -0,0 +1,103 @@
+package service
+
+import (
+	"encoding/json"
+	"unicode"
+)
+
+// AIUsage 单次调用的 token 用量
+type AIUsage struct {
+	PromptTokens     int  `json:"prompt_tokens"`
+	CompletionTokens int  `json:"completion_tokens"`
+	TotalTokens      int  `json:"total_tokens"`
+	Estimated        bool `json:"estimated"`
+}
+
+// IsZero 上游未返回用量
+func (u AIUsage) IsZero() bool {
+	return u.PromptTokens == 0 && u.CompletionTokens == 0 && u.TotalTokens == 0
+}
+
+// extractAIUsage 从非流式响应中提取用量
+func extractAIUsage(raw []byte) AIUsage {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return AIUsage{}
+	}
+	var usage AIUsage
+	mergeAIUsage(&usage, payload)
+	return usage
+}
+
+// mergeAIUsage 合并响应/chunk 中的用量字段（后出现的非零值覆盖前值，流式场景下为累计值）
+// - OpenAI：usage.prompt_tokens/completion_tokens/total_tokens
+// - Gemini：usageMetadata.promptTokenCount/candidatesTokenCount/totalTokenCount
+// - Anthropic：usage.input_tokens/output_tokens（流式 message_start 位于 message.usage）
+func mergeAIUsage(usage *AIUsage, payload map[string]interface{}) {
+	if usage == nil {
+		return
+	}
+	if message, ok := payload["message"].(map[string]interface{}); ok {
+		if block, ok := message["usage"].(map[string]interface{}); ok {
+			applyUsageFields(usage, block, "input_tokens", "output_tokens", "")
+		}
+	}
+	// Responses API 流式：response.completed 事件的 response.usage
+	if response, ok := payload["response"].(map[string]interface{}); ok {
+		if block, ok := response["usage"].(map[string]interface{}); ok {
+			applyUsageFields(usage, block, "input_tokens", "output_tokens", "total_tokens")
+		}
+	}
+	if block, ok := payload["usage"].(map[string]interface{}); ok {
+		applyUsageFields(usage, block, "prompt_tokens", "completion_tokens", "total_tokens")
+		applyUsageFields(usage, block, "input_tokens", "output_tokens", "")
+	}
+	if block, ok := payload["usageMetadata"].(map[string]interface{}); ok {
+		applyUsageFields(usage, block, "promptTokenCount", "candidatesTokenCount", "totalTokenCount")
+	}
+}
+
+func applyUsageFields(usage *AIUsage, block map[string]interface{}, promptKey, completionKey, totalKey string) {
+	if v, ok := block[promptKey].(float64); ok && v > 0 {
+		usage.PromptTokens = int(v)
+	}
+	if v, ok := block[completionKey].(float64); ok && v > 0 {
+		usage.CompletionTokens = int(v)
+	}
+	if totalKey != "" {
+		if v, ok := block[totalKey].(float64); ok && v > 0 {
+			usage.TotalTokens = int(v)
+		}
+	}
+}
+
+// finalizeAIUsage 补齐 total；上游未返回用量时按请求体与输出文本估算
+func finalizeAIUsage(usage AIUsage, requestBody, content string) AIUsage {
+	if usage.IsZero() {
+		usage = AIUsage{
+			PromptTokens:     estimateTokens(requestBody),
+			CompletionTokens: estimateTokens(content),
+			Estimated:        true,
+		}
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	return usage
+}
+
+// estimateTokens 粗略估算 token 数：CJK 字符按 1 token/字，其余按 4 字符/token
+func estimateTokens(text string) int {
+	cjk := 0
+	other := 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r), unicode.Is(unicode.Hangul, r):
+			cjk++
+		case unicode.IsSpace(r):
+		default:
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/internal/repository"
+	"novel-agent-os-backend/pkg/logger"
+)
+
+// AIUsageScope 用量归属
+type AIUsageScope struct {
+	UserID    uint
+	ProjectID uint
+	SessionID uint
+	Source    string // workflow/agent_writer/stream/proxy
+	// UserKey 代理接口使用了用户自有密钥（其余调用以 AICallResult.UserKey 为准）
+	UserKey bool
+}
+
+type AIUsageService interface {
+	// CheckBeforeCall 调用上游前的计费预检（余额与 token 上限）
+	// 仅当主供应商与备用链中的所有供应商都配置了用户自有密钥时跳过，否则 fallback 到平台密钥时会扣费
+	CheckBeforeCall(userID uint, provider string, fallback []AIFallbackEntry) error
+	// RecordCall 记录一次上游调用并按用量扣费（未到达上游的调用不记录，命中缓存记零消耗），同时写入调用审计日志；写入失败仅记日志
+	RecordCall(scope AIUsageScope, result *AICallResult, callErr error)
+	// RecordProxyCall 记录透传代理调用，用量从原始响应（流式为 SSE 数据）中解析
+	RecordProxyCall(scope AIUsageScope, provider, path, requestBody string, responseBody []byte, stream bool, latency time.Duration, success bool)
+	ListRecords(filter repository.AIUsageFilter, page, pageSize int) ([]*model.AIUsageRecord, int64, error)
+	Summary(filter repository.AIUsageFilter, groupBy string) ([]*repository.AIUsageAggregate, error)
+}
+
+type aiUsageService struct {
+	usageRepo      repository.AIUsageRepository
+	billingService AIBillingService
+	userKeyRepo    repository.AIUserProviderKeyRepository
+	callLogService AICallLogService
+}
+
+func NewAIUsageService(usageRepo repository.AIUsageRepository, billingService AIBillingService, userKeyRepo repository.AIUserProviderKeyRepository, callLogService AICallLogService) AIUsageService {
+	return &aiUsageService{
+		usageRepo:      usageRepo,
+		billingService: billingService,
+		userKeyRepo:    userKeyRepo,
+		callLogService: callLogService,
+	}
+}
+
+func (s *aiUsageService) CheckBeforeCall(userID uint, provider string, fallback []AIFallbackEntry) error {
+	providers := make([]string, 0, len(fallback)+1)
+	providers = append(providers, provider)
+	for _, entry := range fallback {
+		providers = append(providers, entry.Provider)
+	}
+	if s.allUserKeys(userID, providers) {
+		return nil
+	}
+	return s.billingService.CheckBeforeCall(userID)
+}
+
+// allUserKeys 所有供应商均配置了用户自有密钥（调用不会使用平台密钥）
+func (s *aiUsageService) allUserKeys(userID uint, providers []string) bool {
+	if s.userKeyRepo == nil {
+		return false
+	}
+	for _, provider := range providers {
+		provider = strings.TrimSpace(provider)
+		if provider == "" {
+			return false
+		}
+		exists, err := s.userKeyRepo.Exists(userID, provider)
+		if err != nil {
+			logger.Warn("check user provider key failed", logger.Uint("user_id", userID), logger.Err(err))
+			return false
+		}
+		if !exists {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *aiUsageService) RecordCall(scope AIUsageScope, result *AICallResult, callErr error) {
+	if result == nil || (len(result.Attempts) == 0 && !result.Cached) {
+		return
+	}
+
+	provider := result.Provider
+	if provider == "" && len(result.Attempts) > 0 {
+		provider = result.Attempts[len(result.Attempts)-1].Provider
+	}
+	record := &model.AIUsageRecord{
+		UserID:           scope.UserID,
+		ProjectID:        scope.ProjectID,
+		SessionID:        scope.SessionID,
+		Source:           scope.Source,
+		Provider:         provider,
+		Model:            result.Model,
+		PromptTokens:     result.Usage.PromptTokens,
+		CompletionTokens: result.Usage.CompletionTokens,
+		TotalTokens:      result.Usage.TotalTokens,
+		Estimated:        result.Usage.Estimated,
+		LatencyMs:        result.LatencyMs,
+		Success:          callErr == nil,
+		UserKey:          result.UserKey || scope.UserKey,
+		UsageDate:        time.Now().UTC().Format("2006-01-02"),
+	}
+	// 命中缓存：记一条零消耗的台账，不计入 token 上限、不扣费
+	if result.Cached {
+		record.PromptTokens, record.CompletionTokens, record.TotalTokens = 0, 0, 0
+		record.Estimated = false
+		record.Cached = true
+	}
+	if callErr == nil && !record.UserKey && !record.Cached {
+		points, err := s.billingService.Charge(scope.UserID, result.Model, result.Usage)
+		if err != nil {
+			logger.Error("failed to charge ai usage", logger.Uint("user_id", scope.UserID), logger.Err(err))
+		}
+		record.PointsCharged = points
+	}
+	if err := s.usageRepo.Create(record); err != nil {
+		logger.Error("failed to record ai usage", logger.Err(err))
+	}
+	if s.callLogService != nil {
+		s.callLogService.Record(scope, result, callErr)
+	}
+}
+
+func (s *aiUsageService) RecordProxyCall(scope AIUsageScope, provider, path, requestBody string, responseBody []byte, stream bool, latency time.Duration, success bool) {
+	result := &AICallResult{
+		Attempts:    []AIAttempt{{Provider: provider, Attempt: 1, LatencyMs: latency.Milliseconds()}},
+		Provider:    provider,
+		Model:       detectRequestModel(AICallRequest{Path: path, Body: requestBody}),
+		Path:        path,
+		LatencyMs:   latency.Milliseconds(),
+		RequestBody: requestBody,
+	}
+	// 审计日志保存原始响应；流式成功时保存拼接后的文本
+	if !stream || !success {
+		result.Raw = json.RawMessage(responseBody)
+	}
+
+	var usage AIUsage
+	if success {
+		if stream {
+			var contentBuilder strings.Builder
+			_ = parseAISSE(context.Background(), provider, bytes.NewReader(responseBody), func(chunk string) error {
+				contentBuilder.WriteString(chunk)
+				return nil
+			}, &usage)
+			result.Content = contentBuilder.String()
+		} else {
+			usage = extractAIUsage(responseBody)
+			result.Content = extractAIText(responseBody)
+		}
+		result.Usage = finalizeAIUsage(usage, requestBody, result.Content)
+	}
+
+	var callErr error
+	if !success {
+		callErr = fmt.Errorf("proxy call failed")
+	}
+	s.RecordCall(scope, result, callErr)
+}
+
+func (s *aiUsageService) ListRecords(filter repository.AIUsageFilter, page, pageSize int) ([]*model.AIUsageRecord, int64, error) {
+	return s.usageRepo.List(filter, page, pageSize)
+}
+
+func (s *aiUsageService) Summary(filter repository.AIUsageFilter, groupBy string) ([]*repository.AIUsageAggregate, error) {
+	switch groupBy {
+	case "day", "project", "model", "provider", "user", "session":
+	default:
+		return nil, fmt.Errorf("invalid group_by")
+	}
+	return s.usageRepo.Aggregate(filter, groupBy)
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/pkg/logger"
+
+	"gorm.io/gorm"
+)
+
+// UserProviderConfig 用户自有供应商配置（API Key 脱敏）
+type UserProviderConfig struct {
+	Provider  string    `json:"provider"`
+	BaseURL   string    `json:"base_url"`
+	APIKey    string    `json:"api_key"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetUserProviderConfigRaw 获取用户自有供应商配置，BaseURL 未设置时沿用平台配置
+func (s *aiConfigService) GetUserProviderConfigRaw(userID uint, provider string) (*ProviderConfig, error) {
+	cleanProvider := strings.TrimSpace(provider)
+	if s.userKeyRepo == nil || userID == 0 || cleanProvider == "" {
+		return nil, nil
+	}
+
+	key, err := s.userKeyRepo.Get(userID, cleanProvider)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.APIKey == "" {
+		return nil, nil
+	}
+
+	baseURL := key.BaseURL
+	if baseURL == "" {
+		platform, err := s.configRepo.GetProviderConfig(cleanProvider)
+		if err != nil {
+			return nil, err
+		}
+		baseURL = platform.BaseURL
+	}
+	return &ProviderConfig{
+		Provider: cleanProvider,
+		BaseURL:  baseURL,
+		APIKey:   key.APIKey,
+	}, nil
+}
+
+// ListUserProviders 列出用户自有供应商配置
+func (s *aiConfigService) ListUserProviders(userID uint) ([]*UserProviderConfig, error) {
+	keys, err := s.userKeyRepo.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*UserProviderConfig, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, toUserProviderConfig(key))
+	}
+	return items, nil
+}
+
+// SaveUserProvider 新增或覆盖用户自有供应商配置
+func (s *aiConfigService) SaveUserProvider(userID uint, provider, baseURL, apiKey string) (*UserProviderConfig, error) {
+	cleanProvider := strings.TrimSpace(provider)
+	if cleanProvider == "" {
+		return nil, errors.New("invalid provider")
+	}
+	cleanAPIKey := strings.TrimSpace(apiKey)
+	if cleanAPIKey == "" {
+		return nil, errors.New("api key required")
+	}
+	cleanBaseURL := strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if cleanBaseURL != "" {
+		if err := validateUserProviderBaseURL(cleanBaseURL); err != nil {
+			return nil, err
+		}
+	} else if _, err := s.configRepo.GetProviderConfig(cleanProvider); err != nil {
+		// 平台未配置该供应商时必须提供 BaseURL
+		return nil, errors.New("base url required")
+	}
+
+	key := &model.AIUserProviderKey{
+		UserID:   userID,
+		Provider: cleanProvider,
+		BaseURL:  cleanBaseURL,
+		APIKey:   cleanAPIKey,
+	}
+	if err := s.userKeyRepo.Save(key); err != nil {
+		return nil, err
+	}
+	logger.Info("user provider key saved", logger.Uint("user_id", userID), logger.String("provider", cleanProvider))
+	return toUserProviderConfig(key), nil
+}
+
+// DeleteUserProvider 删除用户自有供应商配置（之后回落到平台密钥）
+func (s *aiConfigService) DeleteUserProvider(userID uint, provider string) error {
+	cleanProvider := strings.TrimSpace(provider)
+	if cleanProvider == "" {
+		return errors.New("invalid provider")
+	}
+	return s.userKeyRepo.Delete(userID, cleanProvider)
+}
+
+// ResolveAIProviderConfig 解析调用使用的供应商配置：用户配置了自有密钥时优先使用，否则使用平台配置
+// 返回值 userKey 表示使用了用户自有密钥（不走平台密钥池、不扣积分）
+func ResolveAIProviderConfig(aiConfigService AIConfigService, userID uint, provider string) (*ProviderConfig, bool, error) {
+	if userID > 0 {
+		userCfg, err := aiConfigService.GetUserProviderConfigRaw(userID, provider)
+		if err != nil {
+			logger.Warn("load user provider key failed, using platform key",
+				logger.Uint("user_id", userID),
+				logger.String("provider", provider),
+				logger.Err(err),
+			)
+		} else if userCfg != nil {
+			return userCfg, true, nil
+		}
+	}
+	providerCfg, err := aiConfigService.GetProviderConfigRaw(provider)
+	if err != nil {
+		return nil, false, err
+	}
+	return providerCfg, false, nil
+}
+
+// validateUserProviderBaseURL 用户自定义 BaseURL 仅允许 HTTPS 公网地址，防止借用户配置访问内网
+// 域名解析结果在建立连接时由 NewAIHTTPClient 再次校验
+func validateUserProviderBaseURL(baseURL string) error {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return errors.New("invalid base url")
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("invalid base url")
+	}
+	if ip := net.ParseIP(host); ip != nil && isInternalAIAddress(ip) {
+		return errors.New("invalid base url")
+	}
+	return nil
+}
+
+func toUserProviderConfig(key *model.AIUserProviderKey) *UserProviderConfig {
+	return &UserProviderConfig{
+		Provider:  key.Provider,
+		BaseURL:   key.BaseURL,
+		APIKey:    maskAPIKey(key.APIKey),
+		UpdatedAt: key.UpdatedAt,
+	}
+}
@@ -0,0 +1,441 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"novel-agent-os-backend/internal/config"
+	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/internal/repository"
+)
+
+// 上下文片段类型（同时决定渲染顺序）
+const (
+	ContextKindWorldRules    = "world_rules"
+	ContextKindCoreConflict  = "core_conflict"
+	ContextKindCharacterArc  = "character_arc"
+	ContextKindUltimateValue = "ultimate_value"
+	ContextKindPlotRoadmap   = "plot_roadmap"
+	ContextKindVolumeGoal    = "volume_goal"
+	ContextKindEntity        = "entity"
+	ContextKindPrevious      = "previous_summary"
+	ContextKindChapterPlan   = "chapter_plan"
+)
+
+// contextKindOrder 渲染顺序与分组标题
+var contextKindOrder = []struct {
+	kind   string
+	header string
+}{
+	{ContextKindWorldRules, "世界观规则"},
+	{ContextKindCoreConflict, "核心冲突"},
+	{ContextKindCharacterArc, "人物弧光"},
+	{ContextKindUltimateValue, "终极价值"},
+	{ContextKindPlotRoadmap, "本卷剧情路线"},
+	{ContextKindVolumeGoal, "本卷目标"},
+	{ContextKindEntity, "相关角色与设定"},
+	{ContextKindPrevious, "前情提要"},
+	{ContextKindChapterPlan, "本章规划"},
+}
+
+// contextMinSectionTokens 剩余预算低于该值时不再截断纳入
+const contextMinSectionTokens = 64
+
+var contextEntityTypeNames = map[string]string{
+	"character":    "角色",
+	"setting":      "设定",
+	"organization": "组织",
+	"item":         "物品",
+	"magic":        "能力体系",
+	"event":        "事件",
+}
+
+// contextEntityImportanceScores 关联实体按重要程度排序
+var contextEntityImportanceScores = map[string]float64{
+	"main":      70,
+	"secondary": 55,
+	"minor":     40,
+}
+
+// ChapterContextRequest 章节上下文组装参数
+type ChapterContextRequest struct {
+	ProjectID  uint
+	VolumeID   uint
+	DocumentID uint
+	// OrderIndex 目标章节排序，前情摘要只取排序在其之前的章节；为 0 时取目标文档的排序
+	OrderIndex  int
+	TokenBudget int
+}
+
+// ChapterContextOptions 工作流请求中的上下文注入选项
+type ChapterContextOptions struct {
+	// Inject 是否注入，未指定时按 ai.context.auto_inject
+	Inject      *bool `json:"inject"`
+	TokenBudget int   `json:"token_budget"`
+}
+
+// ChapterContextSection 候选上下文片段及取舍原因
+type ChapterContextSection struct {
+	Kind      string  `json:"kind"`
+	Title     string  `json:"title"`
+	SourceID  uint    `json:"source_id,omitempty"`
+	Score     float64 `json:"score"`
+	Tokens    int     `json:"tokens"`
+	Included  bool    `json:"included"`
+	Truncated bool    `json:"truncated"`
+	Reason    string  `json:"reason"`
+	Content   string  `json:"content,omitempty"`
+
+	order int
+}
+
+// ChapterContext 组装结果：Text 为注入模型的上下文，Sections 含全部候选片段（按得分排序）
+type ChapterContext struct {
+	Text        string                  `json:"text"`
+	TokenBudget int                     `json:"token_budget"`
+	TokensUsed  int                     `json:"tokens_used"`
+	Sections    []ChapterContextSection `json:"sections"`
+}
+
+// ContextBuilder 章节工作流的服务端上下文组装
+type ContextBuilder interface {
+	Build(req ChapterContextRequest) (*ChapterContext, error)
+}
+
+type contextBuilder struct {
+	projectRepo  repository.ProjectRepository
+	volumeRepo   repository.VolumeRepository
+	documentRepo repository.DocumentRepository
+}
+
+// NewContextBuilder 创建上下文组装服务
+func NewContextBuilder(projectRepo repository.ProjectRepository, volumeRepo repository.VolumeRepository, documentRepo repository.DocumentRepository) ContextBuilder {
+	return &contextBuilder{
+		projectRepo:  projectRepo,
+		volumeRepo:   volumeRepo,
+		documentRepo: documentRepo,
+	}
+}
+
+// Build 收集项目设定、卷规划、关联实体、前情摘要与本章规划，按得分在 token 预算内取舍
+func (b *contextBuilder) Build(req ChapterContextRequest) (*ChapterContext, error) {
+	cfg := config.Get().AI.Context
+	budget := req.TokenBudget
+	if budget <= 0 {
+		budget = cfg.TokenBudget
+	}
+
+	project, err := b.projectRepo.FindByID(req.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("project not found")
+	}
+
+	var doc *model.Document
+	if req.DocumentID > 0 {
+		doc, err = b.documentRepo.FindByID(req.DocumentID)
+		if err != nil || doc.ProjectID != project.ID {
+			return nil, fmt.Errorf("document not found")
+		}
+		if req.VolumeID == 0 {
+			req.VolumeID = doc.VolumeID
+		}
+		if req.OrderIndex == 0 {
+			req.OrderIndex = doc.OrderIndex
+		}
+	}
+
+	var sections []ChapterContextSection
+	add := func(kind, title, content string, sourceID uint, score float64) {
+		content = strings.TrimSpace(content)
+		if content == "" {
+			return
+		}
+		sections = append(sections, ChapterContextSection{
+			Kind:     kind,
+			Title:    title,
+			SourceID: sourceID,
+			Score:    score,
+			Content:  content,
+			order:    len(sections),
+		})
+	}
+
+	add(ContextKindWorldRules, "世界观规则", project.WorldRules, project.ID, 90)
+	add(ContextKindCoreConflict, "核心冲突", project.CoreConflict, project.ID, 85)
+	add(ContextKindCharacterArc, "人物弧光", project.CharacterArc, project.ID, 50)
+	add(ContextKindUltimateValue, "终极价值", project.UltimateValue, project.ID, 35)
+
+	if req.VolumeID > 0 {
+		volume, err := b.volumeRepo.FindByID(req.VolumeID)
+		if err != nil || volume.ProjectID != project.ID {
+			return nil, fmt.Errorf("volume not found")
+		}
+		add(ContextKindPlotRoadmap, volume.Title+" · 剧情路线", volume.PlotRoadmap, volume.ID, 80)
+		add(ContextKindVolumeGoal, volume.Title+" · 目标", joinContextFields(
+			"主题", volume.Theme,
+			"核心目标", volume.CoreGoal,
+			"边界", volume.Boundaries,
+			"章节衔接", volume.ChapterLinkageLogic,
+		), volume.ID, 60)
+	}
+
+	if doc != nil {
+		add(ContextKindChapterPlan, doc.Title, joinContextFields(
+			"章节目标", doc.ChapterGoal,
+			"核心情节", doc.CorePlot,
+			"钩子", doc.Hook,
+			"因果", doc.CauseEffect,
+			"伏笔", doc.ForeshadowingDetails,
+		), doc.ID, 100)
+
+		refs, err := b.documentRepo.GetEntityRefs(doc.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, entity := range rankContextEntities(refs, project.ID) {
+			add(ContextKindEntity, entity.Title, formatContextEntity(entity), entity.ID, contextEntityScore(entity))
+		}
+	}
+
+	previous, err := b.documentRepo.FindPreviousWithSummary(project.ID, req.VolumeID, req.OrderIndex, req.DocumentID, cfg.PreviousChapters)
+	if err != nil {
+		return nil, err
+	}
+	for i, prev := range previous {
+		// 越近的章节越重要
+		add(ContextKindPrevious, prev.Title, prev.Summary, prev.ID, 78-float64(i)*4)
+	}
+
+	return fitChapterContext(sections, budget, cfg.MaxEntities), nil
+}
+
+// fitChapterContext 按得分从高到低纳入片段；超出剩余预算时截断，剩余预算过少时舍弃
+func fitChapterContext(sections []ChapterContextSection, budget, maxEntities int) *ChapterContext {
+	sort.SliceStable(sections, func(i, j int) bool { return sections[i].Score > sections[j].Score })
+
+	remaining := budget
+	entities := 0
+	headed := map[string]bool{}
+	for i := range sections {
+		section := &sections[i]
+		if section.Kind == ContextKindEntity {
+			if maxEntities > 0 && entities >= maxEntities {
+				section.Reason = fmt.Sprintf("超过关联实体上限 %d", maxEntities)
+				section.Content = ""
+				continue
+			}
+		}
+
+		cost := estimateTokens(section.Content)
+		header := 0
+		if !headed[section.Kind] {
+			header = estimateTokens(contextKindHeader(section.Kind)) + 2
+		}
+		switch {
+		case cost+header <= remaining:
+			section.Reason = fmt.Sprintf("得分 %.0f，完整纳入", section.Score)
+		case remaining-header >= contextMinSectionTokens:
+			cost = remaining - header
+			section.Content = truncateToTokens(section.Content, cost)
+			section.Truncated = true
+			section.Reason = fmt.Sprintf("得分 %.0f，超出剩余预算，截断至约 %d tokens", section.Score, cost)
+		default:
+			section.Tokens = cost
+			section.Reason = fmt.Sprintf("超出 token 预算（需要 %d，剩余 %d）", cost+header, remaining)
+			section.Content = ""
+			continue
+		}
+
+		section.Included = true
+		section.Tokens = cost
+		remaining -= cost + header
+		headed[section.Kind] = true
+		if section.Kind == ContextKindEntity {
+			entities++
+		}
+	}
+
+	text := renderChapterContext(sections)
+	return &ChapterContext{
+		Text:        text,
+		TokenBudget: budget,
+		TokensUsed:  estimateTokens(text),
+		Sections:    sections,
+	}
+}
+
+// renderChapterContext 按固定分组顺序渲染已纳入的片段（前情提要按章节先后排列）
+func renderChapterContext(sections []ChapterContextSection) string {
+	var builder strings.Builder
+	for _, group := range contextKindOrder {
+		var items []ChapterContextSection
+		for _, section := range sections {
+			if section.Included && section.Kind == group.kind {
+				items = append(items, section)
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+		sort.SliceStable(items, func(i, j int) bool { return items[i].order < items[j].order })
+		if group.kind == ContextKindPrevious {
+			for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+				items[i], items[j] = items[j], items[i]
+			}
+		}
+
+		if builder.Len() > 0 {
+			builder.WriteString("\n\n")
+		}
+		builder.WriteString("【" + group.header + "】")
+		for _, item := range items {
+			builder.WriteString("\n")
+			switch group.kind {
+			case ContextKindEntity, ContextKindPrevious:
+				builder.WriteString("- " + item.Title + "：" + item.Content)
+			default:
+				builder.WriteString(item.Content)
+			}
+		}
+	}
+	if builder.Len() == 0 {
+		return ""
+	}
+	return "以下为项目资料，写作时请保持设定、人物与情节的一致性：\n\n" + builder.String()
+}
+
+func contextKindHeader(kind string) string {
+	for _, group := range contextKindOrder {
+		if group.kind == kind {
+			return group.header
+		}
+	}
+	return kind
+}
+
+// rankContextEntities 去重并过滤其他项目的实体，按重要程度与引用次数排序
+func rankContextEntities(refs []*model.DocumentEntityRef, projectID uint) []model.Entity {
+	seen := map[uint]bool{}
+	var entities []model.Entity
+	for _, ref := range refs {
+		if ref.Entity.ID == 0 || ref.Entity.ProjectID != projectID || seen[ref.Entity.ID] {
+			continue
+		}
+		seen[ref.Entity.ID] = true
+		entities = append(entities, ref.Entity)
+	}
+	sort.SliceStable(entities, func(i, j int) bool {
+		return contextEntityScore(entities[i]) > contextEntityScore(entities[j])
+	})
+	return entities
+}
+
+// contextEntityScore 重要程度为主，引用次数（上限 10 次）为辅
+func contextEntityScore(entity model.Entity) float64 {
+	score, ok := contextEntityImportanceScores[entity.Importance]
+	if !ok {
+		score = contextEntityImportanceScores["secondary"]
+	}
+	refs := entity.ReferenceCount
+	if refs > 10 {
+		refs = 10
+	}
+	return score + float64(refs)*0.5
+}
+
+func formatContextEntity(entity model.Entity) string {
+	var parts []string
+	if typeName, ok := contextEntityTypeNames[entity.EntityType]; ok {
+		parts = append(parts, "（"+typeName+"）")
+	}
+	if entity.Subtitle != "" {
+		parts = append(parts, entity.Subtitle+"。")
+	}
+	parts = append(parts, strings.TrimSpace(entity.Content))
+	if entity.VoiceStyle != "" {
+		parts = append(parts, "语言风格："+entity.VoiceStyle)
+	}
+	return strings.TrimSpace(strings.Join(parts, ""))
+}
+
+// joinContextFields 按「标签：内容」逐行拼接非空字段，参数为 label, value 交替
+func joinContextFields(pairs ...string) string {
+	var lines []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if value := strings.TrimSpace(pairs[i+1]); value != "" {
+			lines = append(lines, pairs[i]+"："+value)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// truncateToTokens 按 estimateTokens 的口径截断到约 maxTokens，末尾追加省略号
+func truncateToTokens(text string, maxTokens int) string {
+	if estimateTokens(text) <= maxTokens {
+		return text
+	}
+	cjk, other := 0, 0
+	for i, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r), unicode.Is(unicode.Hangul, r):
+			cjk++
+		case unicode.IsSpace(r):
+		default:
+			other++
+		}
+		if cjk+(other+3)/4 > maxTokens-1 {
+			return strings.TrimSpace(text[:i]) + "……"
+		}
+	}
+	return text
+}
+
+// injectChapterContext 在开头的 system 消息之后插入上下文 system 消息（返回副本，不修改原请求）
+func injectChapterContext(chat *ChatRequest, text string) *ChatRequest {
+	if chat == nil || strings.TrimSpace(text) == "" {
+		return chat
+	}
+	out := chat.Clone()
+	index := 0
+	for index < len(out.Messages) && out.Messages[index].Role == "system" {
+		index++
+	}
+	messages := make([]ChatMessage, 0, len(out.Messages)+1)
+	messages = append(messages, out.Messages[:index]...)
+	messages = append(messages, ChatMessage{Role: "system", Content: text})
+	messages = append(messages, out.Messages[index:]...)
+	out.Messages = messages
+	return out
+}
+
+// chapterContextEnabled 请求显式指定优先，否则按 ai.context.auto_inject
+func chapterContextEnabled(opts *ChapterContextOptions) bool {
+	if opts != nil && opts.Inject != nil {
+		return *opts.Inject
+	}
+	return config.Get().AI.Context.AutoInject
+}
+
+// chapterContextMetadata 结果步骤记录的上下文摘要（仅列出已纳入的片段）
+func chapterContextMetadata(ctx *ChapterContext) map[string]interface{} {
+	included := make([]map[string]interface{}, 0)
+	for _, section := range ctx.Sections {
+		if !section.Included {
+			continue
+		}
+		included = append(included, map[string]interface{}{
+			"kind":      section.Kind,
+			"title":     section.Title,
+			"source_id": section.SourceID,
+			"tokens":    section.Tokens,
+			"truncated": section.Truncated,
+		})
+	}
+	return map[string]interface{}{
+		"token_budget": ctx.TokenBudget,
+		"tokens_used":  ctx.TokensUsed,
+		"sections":     included,
+	}
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	stderrors "errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/internal/repository"
+	"novel-agent-os-backend/pkg/errors"
+	"novel-agent-os-backend/pkg/logger"
+)
+
+// WriteBackModePropose 章节生成/重写不直接写回，生成待审阅提案
+const WriteBackModePropose = "propose"
+
+var (
+	// ErrDocumentProposalNotFound 提案不存在或不属于该文档
+	ErrDocumentProposalNotFound = stderrors.New("document proposal not found")
+	// ErrDocumentProposalResolved 提案已被采纳、拒绝或被新提案取代
+	ErrDocumentProposalResolved = stderrors.New("document proposal already resolved")
+	// ErrDocumentProposalStale 提案生成后文档正文已被修改，改动块无法对应
+	ErrDocumentProposalStale = stderrors.New("document changed since proposal was created")
+	// ErrDocumentProposalHunk 改动块下标无效
+	ErrDocumentProposalHunk = stderrors.New("invalid proposal hunk index")
+)
+
+// DocumentProposalRequest 创建提案请求
+type DocumentProposalRequest struct {
+	DocumentID uint
+	SessionID  uint
+	UserID     uint
+	Source     string
+	Content    string
+	SetStatus  string
+	SetSummary bool
+}
+
+// DocumentProposalDetail 提案及其相对基准正文的段落级改动块
+type DocumentProposalDetail struct {
+	Proposal *model.DocumentProposal `json:"proposal"`
+	Hunks    []ParagraphHunk         `json:"hunks"`
+	// Stale 文档正文已不同于提案的基准正文，此时无法采纳
+	Stale bool `json:"stale"`
+}
+
+// DocumentProposalAcceptResult 采纳结果
+type DocumentProposalAcceptResult struct {
+	Proposal *model.DocumentProposal `json:"proposal"`
+	Document *model.Document         `json:"document"`
+}
+
+// DocumentProposalService 文档提案服务：AI 输出先作为提案保存，审阅后再写回文档
+type DocumentProposalService interface {
+	// Propose 以文档当前正文为基准创建提案，同一文档此前待审阅的提案标记为 superseded
+	Propose(req DocumentProposalRequest) (*DocumentProposalDetail, error)
+	// List 查询文档的提案（不含正文），status 为空时返回全部
+	List(documentID uint, status string) ([]*model.DocumentProposal, error)
+	Get(documentID, proposalID uint) (*DocumentProposalDetail, error)
+	// AcceptAll 采纳全部改动
+	AcceptAll(documentID, proposalID, userID uint) (*DocumentProposalAcceptResult, error)
+	// AcceptHunks 只采纳选中的改动块，其余保留原文
+	AcceptHunks(documentID, proposalID uint, hunks []int, userID uint) (*DocumentProposalAcceptResult, error)
+	Reject(documentID, proposalID uint) (*model.DocumentProposal, error)
+}
+
+type documentProposalService struct {
+	proposalRepo    repository.DocumentProposalRepository
+	documentService DocumentService
+	// mu 串行化提案的采纳与拒绝，避免同一提案被重复写回
+	mu sync.Mutex
+}
+
+// NewDocumentProposalService 创建文档提案服务
+func NewDocumentProposalService(proposalRepo repository.DocumentProposalRepository, documentService DocumentService) DocumentProposalService {
+	return &documentProposalService{
+		proposalRepo:    proposalRepo,
+		documentService: documentService,
+	}
+}
+
+// Propose 创建提案
+func (s *documentProposalService) Propose(req DocumentProposalRequest) (*DocumentProposalDetail, error) {
+	document, err := s.documentService.GetByID(req.DocumentID)
+	if err != nil {
+		return nil, err
+	}
+	proposal := &model.DocumentProposal{
+		DocumentID:  req.DocumentID,
+		SessionID:   req.SessionID,
+		UserID:      req.UserID,
+		Source:      req.Source,
+		BaseContent: document.Content,
+		Content:     req.Content,
+		SetStatus:   req.SetStatus,
+		SetSummary:  req.SetSummary,
+		Status:      model.ProposalStatusPending,
+	}
+	if err := s.proposalRepo.Create(proposal); err != nil {
+		logger.Error("创建文档提案失败", logger.Err(err))
+		return nil, errors.ErrInternalServer
+	}
+	return &DocumentProposalDetail{
+		Proposal: proposal,
+		Hunks:    ParagraphHunks(proposal.BaseContent, proposal.Content),
+	}, nil
+}
+
+// List 查询文档的提案
+func (s *documentProposalService) List(documentID uint, status string) ([]*model.DocumentProposal, error) {
+	proposals, err := s.proposalRepo.ListByDocumentID(documentID, status)
+	if err != nil {
+		logger.Error("获取文档提案列表失败", logger.Err(err))
+		return nil, errors.ErrInternalServer
+	}
+	return proposals, nil
+}
+
+// Get 获取提案详情及改动块
+func (s *documentProposalService) Get(documentID, proposalID uint) (*DocumentProposalDetail, error) {
+	proposal, err := s.load(documentID, proposalID)
+	if err != nil {
+		return nil, err
+	}
+	detail := &DocumentProposalDetail{
+		Proposal: proposal,
+		Hunks:    ParagraphHunks(proposal.BaseContent, proposal.Content),
+	}
+	if proposal.Status == model.ProposalStatusPending {
+		document, err := s.documentService.GetByID(documentID)
+		if err != nil {
+			return nil, err
+		}
+		detail.Stale = document.Content != proposal.BaseContent
+	}
+	return detail, nil
+}
+
+// AcceptAll 采纳全部改动
+func (s *documentProposalService) AcceptAll(documentID, proposalID, userID uint) (*DocumentProposalAcceptResult, error) {
+	return s.accept(documentID, proposalID, nil, userID)
+}
+
+// AcceptHunks 只采纳选中的改动块
+func (s *documentProposalService) AcceptHunks(documentID, proposalID uint, hunks []int, userID uint) (*DocumentProposalAcceptResult, error) {
+	if len(hunks) == 0 {
+		return nil, ErrDocumentProposalHunk
+	}
+	selected := make(map[int]bool, len(hunks))
+	for _, index := range hunks {
+		selected[index] = true
+	}
+	return s.accept(documentID, proposalID, selected, userID)
+}
+
+// accept 按选中的改动块合并正文，经文档更新写回（产生修订）；selected 为 nil 时采纳全部
+func (s *documentProposalService) accept(documentID, proposalID uint, selected map[int]bool, userID uint) (*DocumentProposalAcceptResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	proposal, err := s.loadPending(documentID, proposalID)
+	if err != nil {
+		return nil, err
+	}
+	document, err := s.documentService.GetByID(documentID)
+	if err != nil {
+		return nil, err
+	}
+	if document.Content != proposal.BaseContent {
+		return nil, ErrDocumentProposalStale
+	}
+
+	content := proposal.Content
+	if selected != nil {
+		total := len(ParagraphHunks(proposal.BaseContent, proposal.Content))
+		for index := range selected {
+			if index < 0 || index >= total {
+				return nil, ErrDocumentProposalHunk
+			}
+		}
+		content = ApplyParagraphHunks(proposal.BaseContent, proposal.Content, selected)
+	}
+
+	updates := map[string]interface{}{
+		"content": content,
+	}
+	if proposal.SetStatus != "" {
+		updates["status"] = proposal.SetStatus
+	}
+	if proposal.SetSummary {
+		updates["summary"] = content
+	}
+	updated, err := s.documentService.Update(documentID, updates, DocumentRevisionSource{
+		Source:    model.RevisionSourceWorkflow,
+		SessionID: proposal.SessionID,
+		UserID:    userID,
+		Note:      fmt.Sprintf("%s 采纳提案 #%d", proposal.Source, proposal.ID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.resolve(proposal, model.ProposalStatusAccepted); err != nil {
+		return nil, err
+	}
+	return &DocumentProposalAcceptResult{Proposal: proposal, Document: updated}, nil
+}
+
+// Reject 拒绝提案，文档不变
+func (s *documentProposalService) Reject(documentID, proposalID uint) (*model.DocumentProposal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	proposal, err := s.loadPending(documentID, proposalID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.resolve(proposal, model.ProposalStatusRejected); err != nil {
+		return nil, err
+	}
+	return proposal, nil
+}
+
+func (s *documentProposalService) load(documentID, proposalID uint) (*model.DocumentProposal, error) {
+	proposal, err := s.proposalRepo.FindByID(proposalID)
+	if err != nil || proposal.DocumentID != documentID {
+		return nil, ErrDocumentProposalNotFound
+	}
+	return proposal, nil
+}
+
+func (s *documentProposalService) loadPending(documentID, proposalID uint) (*model.DocumentProposal, error) {
+	proposal, err := s.load(documentID, proposalID)
+	if err != nil {
+		return nil, err
+	}
+	if proposal.Status != model.ProposalStatusPending {
+		return nil, ErrDocumentProposalResolved
+	}
+	return proposal, nil
+}
+
+func (s *documentProposalService) resolve(proposal *model.DocumentProposal, status string) error {
+	now := time.Now()
+	proposal.Status = status
+	proposal.ResolvedAt = &now
+	if err := s.proposalRepo.Update(proposal); err != nil {
+		logger.Error("更新文档提案状态失败", logger.Err(err))
+		return errors.ErrInternalServer
+	}
+	return nil
+}
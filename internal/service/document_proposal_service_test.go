@@ -0,0 +1,218 @@
+package service
+
+import (
+	stderrors "errors"
+	"reflect"
+	"testing"
+
+	"novel-agent-os-backend/internal/model"
+)
+
+// memoryProposalRepository 内存提案仓库
+type memoryProposalRepository struct {
+	proposals map[uint]*model.DocumentProposal
+}
+
+func (r *memoryProposalRepository) Create(proposal *model.DocumentProposal) error {
+	for _, existing := range r.proposals {
+		if existing.DocumentID == proposal.DocumentID && existing.Status == model.ProposalStatusPending {
+			existing.Status = model.ProposalStatusSuperseded
+		}
+	}
+	proposal.ID = uint(len(r.proposals) + 1)
+	r.proposals[proposal.ID] = proposal
+	return nil
+}
+
+func (r *memoryProposalRepository) ListByDocumentID(documentID uint, status string) ([]*model.DocumentProposal, error) {
+	var list []*model.DocumentProposal
+	for _, proposal := range r.proposals {
+		if proposal.DocumentID == documentID && (status == "" || proposal.Status == status) {
+			list = append(list, proposal)
+		}
+	}
+	return list, nil
+}
+
+func (r *memoryProposalRepository) FindByID(id uint) (*model.DocumentProposal, error) {
+	proposal, ok := r.proposals[id]
+	if !ok {
+		return nil, stderrors.New("record not found")
+	}
+	return proposal, nil
+}
+
+func (r *memoryProposalRepository) Update(proposal *model.DocumentProposal) error {
+	r.proposals[proposal.ID] = proposal
+	return nil
+}
+
+// memoryDocumentService 只实现提案服务用到的读取与更新
+type memoryDocumentService struct {
+	DocumentService
+	documents map[uint]*model.Document
+}
+
+func (s *memoryDocumentService) GetByID(id uint) (*model.Document, error) {
+	document, ok := s.documents[id]
+	if !ok {
+		return nil, stderrors.New("record not found")
+	}
+	copied := *document
+	return &copied, nil
+}
+
+func (s *memoryDocumentService) Update(id uint, updates map[string]interface{}, source DocumentRevisionSource) (*model.Document, error) {
+	document := s.documents[id]
+	if content, ok := updates["content"].(string); ok {
+		document.Content = content
+	}
+	if summary, ok := updates["summary"].(string); ok {
+		document.Summary = summary
+	}
+	copied := *document
+	return &copied, nil
+}
+
+const (
+	proposalBase    = "第一段。\n第二段。\n第三段。\n第四段。\n"
+	proposalContent = "第一段改。\n第二段。\n第三段。\n第四段改。\n新增第五段。\n"
+)
+
+func newProposalTestService(content string) (DocumentProposalService, *memoryDocumentService) {
+	documents := &memoryDocumentService{documents: map[uint]*model.Document{
+		1: {BaseModel: model.BaseModel{ID: 1}, Content: content},
+	}}
+	repo := &memoryProposalRepository{proposals: make(map[uint]*model.DocumentProposal)}
+	return NewDocumentProposalService(repo, documents), documents
+}
+
+func TestParagraphHunks(t *testing.T) {
+	hunks := ParagraphHunks(proposalBase, proposalContent)
+	want := []ParagraphHunk{
+		{Index: 0, Start: 0, Before: "第一段。\n", After: "第一段改。\n"},
+		{Index: 1, Start: 3, Before: "第四段。\n", After: "第四段改。\n新增第五段。\n"},
+	}
+	if !reflect.DeepEqual(hunks, want) {
+		t.Errorf("hunks = %+v, want %+v", hunks, want)
+	}
+	if hunks := ParagraphHunks(proposalBase, proposalBase); len(hunks) != 0 {
+		t.Errorf("identical text: hunks = %+v, want none", hunks)
+	}
+}
+
+func TestApplyParagraphHunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		selected map[int]bool
+		want     string
+	}{
+		{name: "none", selected: nil, want: proposalBase},
+		{name: "first", selected: map[int]bool{0: true}, want: "第一段改。\n第二段。\n第三段。\n第四段。\n"},
+		{name: "second", selected: map[int]bool{1: true}, want: "第一段。\n第二段。\n第三段。\n第四段改。\n新增第五段。\n"},
+		{name: "all", selected: map[int]bool{0: true, 1: true}, want: proposalContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ApplyParagraphHunks(proposalBase, proposalContent, tt.selected); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocumentProposalAcceptHunks(t *testing.T) {
+	svc, documents := newProposalTestService(proposalBase)
+	detail, err := svc.Propose(DocumentProposalRequest{DocumentID: 1, Source: "chapter.rewrite", Content: proposalContent, SetSummary: true})
+	if err != nil {
+		t.Fatalf("Propose: %v", err)
+	}
+	if len(detail.Hunks) != 2 {
+		t.Fatalf("hunks = %d, want 2", len(detail.Hunks))
+	}
+
+	result, err := svc.AcceptHunks(1, detail.Proposal.ID, []int{1}, 7)
+	if err != nil {
+		t.Fatalf("AcceptHunks: %v", err)
+	}
+	want := "第一段。\n第二段。\n第三段。\n第四段改。\n新增第五段。\n"
+	if result.Document.Content != want || documents.documents[1].Summary != want {
+		t.Errorf("document = %q / summary %q, want %q", result.Document.Content, documents.documents[1].Summary, want)
+	}
+	if result.Proposal.Status != model.ProposalStatusAccepted || result.Proposal.ResolvedAt == nil {
+		t.Errorf("proposal status = %s", result.Proposal.Status)
+	}
+
+	if _, err := svc.AcceptAll(1, detail.Proposal.ID, 7); !stderrors.Is(err, ErrDocumentProposalResolved) {
+		t.Errorf("accept twice: err = %v, want ErrDocumentProposalResolved", err)
+	}
+}
+
+func TestDocumentProposalAcceptInvalidHunk(t *testing.T) {
+	svc, documents := newProposalTestService(proposalBase)
+	detail, err := svc.Propose(DocumentProposalRequest{DocumentID: 1, Content: proposalContent})
+	if err != nil {
+		t.Fatalf("Propose: %v", err)
+	}
+	for _, hunks := range [][]int{nil, {2}, {-1}, {0, 5}} {
+		if _, err := svc.AcceptHunks(1, detail.Proposal.ID, hunks, 7); !stderrors.Is(err, ErrDocumentProposalHunk) {
+			t.Errorf("hunks %v: err = %v, want ErrDocumentProposalHunk", hunks, err)
+		}
+	}
+	if documents.documents[1].Content != proposalBase {
+		t.Error("document must not change on invalid hunks")
+	}
+}
+
+func TestDocumentProposalStale(t *testing.T) {
+	svc, documents := newProposalTestService(proposalBase)
+	detail, err := svc.Propose(DocumentProposalRequest{DocumentID: 1, Content: proposalContent})
+	if err != nil {
+		t.Fatalf("Propose: %v", err)
+	}
+
+	// 提案生成后文档被编辑，改动块不再对应当前正文
+	documents.documents[1].Content = proposalBase + "作者追加的段落。\n"
+	got, err := svc.Get(1, detail.Proposal.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !got.Stale {
+		t.Error("Get: stale = false, want true")
+	}
+	if _, err := svc.AcceptAll(1, detail.Proposal.ID, 7); !stderrors.Is(err, ErrDocumentProposalStale) {
+		t.Errorf("AcceptAll: err = %v, want ErrDocumentProposalStale", err)
+	}
+	if _, err := svc.AcceptHunks(1, detail.Proposal.ID, []int{0}, 7); !stderrors.Is(err, ErrDocumentProposalStale) {
+		t.Errorf("AcceptHunks: err = %v, want ErrDocumentProposalStale", err)
+	}
+
+	// 拒绝不受正文变化影响
+	rejected, err := svc.Reject(1, detail.Proposal.ID)
+	if err != nil {
+		t.Fatalf("Reject: %v", err)
+	}
+	if rejected.Status != model.ProposalStatusRejected {
+		t.Errorf("status = %s, want rejected", rejected.Status)
+	}
+	if got, _ := svc.Get(1, detail.Proposal.ID); got.Stale {
+		t.Error("resolved proposal must not be reported as stale")
+	}
+}
+
+func TestDocumentProposalSuperseded(t *testing.T) {
+	svc, _ := newProposalTestService(proposalBase)
+	first, err := svc.Propose(DocumentProposalRequest{DocumentID: 1, Content: proposalContent})
+	if err != nil {
+		t.Fatalf("Propose: %v", err)
+	}
+	if _, err := svc.Propose(DocumentProposalRequest{DocumentID: 1, Content: "另一版。\n"}); err != nil {
+		t.Fatalf("Propose: %v", err)
+	}
+	if _, err := svc.AcceptAll(1, first.Proposal.ID, 7); !stderrors.Is(err, ErrDocumentProposalResolved) {
+		t.Errorf("accept superseded: err = %v, want ErrDocumentProposalResolved", err)
+	}
+	if _, err := svc.Get(2, first.Proposal.ID); !stderrors.Is(err, ErrDocumentProposalNotFound) {
+		t.Errorf("other document: err = %v, want ErrDocumentProposalNotFound", err)
+	}
+}
@@ -1,29 +1,60 @@
 package service
 
 import (
+	stderrors "errors"
+	"fmt"
+
 	"novel-agent-os-backend/internal/model"
 	"novel-agent-os-backend/internal/repository"
 	"novel-agent-os-backend/pkg/errors"
 	"novel-agent-os-backend/pkg/logger"
 )
 
+// ErrDocumentRevisionNotFound 修订不存在或不属于该文档
+var ErrDocumentRevisionNotFound = stderrors.New("document revision not found")
+
+// DocumentRevisionSource 正文变更来源，写入修订记录
+type DocumentRevisionSource struct {
+	Source    string // model.RevisionSource*
+	SessionID uint
+	UserID    uint
+	Note      string
+}
+
+// DocumentRevisionDiff 两个修订之间的字符级差异
+type DocumentRevisionDiff struct {
+	From     *model.DocumentRevision `json:"from"`
+	To       *model.DocumentRevision `json:"to"` // 为空表示与当前正文比较
+	Segments []DiffSegment           `json:"segments"`
+	Stats    DiffStats               `json:"stats"`
+}
+
 // DocumentService 文档服务接口
+// 写入正文的方法需传入变更来源，正文变化时在同一事务中追加修订记录
 type DocumentService interface {
-	Create(projectID uint, title string, content, summary, status string, orderIndex int, timeNode, duration string, targetWordCount int, chapterGoal, corePlot, hook, causeEffect, foreshadowingDetails string, volumeID uint) (*model.Document, error)
+	Create(projectID uint, title string, content, summary, status string, orderIndex int, timeNode, duration string, targetWordCount int, chapterGoal, corePlot, hook, causeEffect, foreshadowingDetails string, volumeID uint, source DocumentRevisionSource) (*model.Document, error)
 	GetByID(id uint) (*model.Document, error)
 	ListByProjectID(projectID uint, page, size int) ([]*model.Document, int64, error)
 	ListByVolumeID(volumeID uint, page, size int) ([]*model.Document, int64, error)
 	GetNextOrderIndex(projectID uint, volumeID uint) (int, error)
-	Update(id uint, updates map[string]interface{}) (*model.Document, error)
+	Update(id uint, updates map[string]interface{}, source DocumentRevisionSource) (*model.Document, error)
 	Delete(id uint) error
 	AddBookmark(id uint, title string, position int, note string) error
 	RemoveBookmark(id uint, index int) error
 	LinkEntity(id, entityID uint, refType string, metadata map[string]interface{}) error
 	UnlinkEntity(id, entityID uint) error
 	GetEntityRefs(id uint) ([]*model.DocumentEntityRef, error)
-	AppendChapter(documentID uint, chapterTitle, content string) error
-	UpdateChapter(documentID uint, chapterID string, content string) error
-	AppendContent(documentID uint, content string) error
+	AppendChapter(documentID uint, chapterTitle, content string, source DocumentRevisionSource) error
+	UpdateChapter(documentID uint, chapterID string, content string, source DocumentRevisionSource) error
+	AppendContent(documentID uint, content string, source DocumentRevisionSource) error
+
+	// ListRevisions 按版本倒序分页查询修订（不含正文）
+	ListRevisions(documentID uint, page, size int) ([]*model.DocumentRevision, int64, error)
+	GetRevision(documentID, revisionID uint) (*model.DocumentRevision, error)
+	// DiffRevisions 比较两个修订；toID 为 0 时与当前正文比较
+	DiffRevisions(documentID, fromID, toID uint) (*DocumentRevisionDiff, error)
+	// RestoreRevision 将正文恢复为指定修订（产生一条 restore 修订）
+	RestoreRevision(documentID, revisionID uint, source DocumentRevisionSource) (*model.Document, error)
 }
 
 // documentService 文档服务实现
@@ -31,19 +62,21 @@ type documentService struct {
 	documentRepo repository.DocumentRepository
 	projectRepo  repository.ProjectRepository
 	volumeRepo   repository.VolumeRepository
+	revisionRepo repository.DocumentRevisionRepository
 }
 
 // NewDocumentService 创建文档服务实例
-func NewDocumentService(documentRepo repository.DocumentRepository, projectRepo repository.ProjectRepository, volumeRepo repository.VolumeRepository) DocumentService {
+func NewDocumentService(documentRepo repository.DocumentRepository, projectRepo repository.ProjectRepository, volumeRepo repository.VolumeRepository, revisionRepo repository.DocumentRevisionRepository) DocumentService {
 	return &documentService{
 		documentRepo: documentRepo,
 		projectRepo:  projectRepo,
 		volumeRepo:   volumeRepo,
+		revisionRepo: revisionRepo,
 	}
 }
 
 // Create 创建文档
-func (s *documentService) Create(projectID uint, title string, content, summary, status string, orderIndex int, timeNode, duration string, targetWordCount int, chapterGoal, corePlot, hook, causeEffect, foreshadowingDetails string, volumeID uint) (*model.Document, error) {
+func (s *documentService) Create(projectID uint, title string, content, summary, status string, orderIndex int, timeNode, duration string, targetWordCount int, chapterGoal, corePlot, hook, causeEffect, foreshadowingDetails string, volumeID uint, source DocumentRevisionSource) (*model.Document, error) {
 	// 验证项目是否存在
 	_, err := s.projectRepo.FindByID(projectID)
 	if err != nil {
@@ -76,7 +109,11 @@ func (s *documentService) Create(projectID uint, title string, content, summary,
 		ForeshadowingDetails: foreshadowingDetails,
 	}
 
-	if err := s.documentRepo.Create(document); err != nil {
+	var revisions []*model.DocumentRevision
+	if content != "" {
+		revisions = append(revisions, newDocumentRevision(content, source))
+	}
+	if err := s.revisionRepo.CreateDocument(document, revisions); err != nil {
 		logger.Error("创建文档失败", logger.Err(err))
 		return nil, errors.ErrInternalServer
 	}
@@ -141,11 +178,12 @@ func (s *documentService) GetNextOrderIndex(projectID uint, volumeID uint) (int,
 }
 
 // Update 更新文档
-func (s *documentService) Update(id uint, updates map[string]interface{}) (*model.Document, error) {
+func (s *documentService) Update(id uint, updates map[string]interface{}, source DocumentRevisionSource) (*model.Document, error) {
 	document, err := s.documentRepo.FindByID(id)
 	if err != nil {
 		return nil, errors.ErrDocumentNotFound
 	}
+	previous := document.Content
 
 	// 应用更新
 	if title, ok := updates["title"].(string); ok {
@@ -191,7 +229,7 @@ func (s *documentService) Update(id uint, updates map[string]interface{}) (*mode
 		document.VolumeID = volumeID
 	}
 
-	if err := s.documentRepo.Update(document); err != nil {
+	if err := s.saveWithRevision(document, previous, source); err != nil {
 		logger.Error("更新文档失败", logger.Err(err))
 		return nil, errors.ErrInternalServer
 	}
@@ -291,11 +329,12 @@ func (s *documentService) GetEntityRefs(id uint) ([]*model.DocumentEntityRef, er
 }
 
 // AppendChapter 追加章节
-func (s *documentService) AppendChapter(documentID uint, chapterTitle, content string) error {
+func (s *documentService) AppendChapter(documentID uint, chapterTitle, content string, source DocumentRevisionSource) error {
 	document, err := s.documentRepo.FindByID(documentID)
 	if err != nil {
 		return errors.ErrDocumentNotFound
 	}
+	previous := document.Content
 
 	// 构建章节内容
 	chapterContent := "\n\n## " + chapterTitle + "\n\n" + content
@@ -303,7 +342,7 @@ func (s *documentService) AppendChapter(documentID uint, chapterTitle, content s
 	// 追加到文档内容
 	document.Content += chapterContent
 
-	if err := s.documentRepo.Update(document); err != nil {
+	if err := s.saveWithRevision(document, previous, source); err != nil {
 		logger.Error("追加章节失败", logger.Err(err))
 		return errors.ErrInternalServer
 	}
@@ -312,24 +351,134 @@ func (s *documentService) AppendChapter(documentID uint, chapterTitle, content s
 }
 
 // UpdateChapter 更新章节内容（暂不实现复杂的章节定位逻辑）
-func (s *documentService) UpdateChapter(documentID uint, chapterID string, content string) error {
+func (s *documentService) UpdateChapter(documentID uint, chapterID string, content string, source DocumentRevisionSource) error {
 	// 简化实现：直接追加内容
-	return s.AppendContent(documentID, content)
+	return s.AppendContent(documentID, content, source)
 }
 
 // AppendContent 追加内容到文档
-func (s *documentService) AppendContent(documentID uint, content string) error {
+func (s *documentService) AppendContent(documentID uint, content string, source DocumentRevisionSource) error {
 	document, err := s.documentRepo.FindByID(documentID)
 	if err != nil {
 		return errors.ErrDocumentNotFound
 	}
+	previous := document.Content
 
 	document.Content += content
 
-	if err := s.documentRepo.Update(document); err != nil {
+	if err := s.saveWithRevision(document, previous, source); err != nil {
 		logger.Error("追加内容失败", logger.Err(err))
 		return errors.ErrInternalServer
 	}
 
 	return nil
 }
+
+// ListRevisions 按版本倒序分页查询修订（不含正文）
+func (s *documentService) ListRevisions(documentID uint, page, size int) ([]*model.DocumentRevision, int64, error) {
+	if _, err := s.documentRepo.FindByID(documentID); err != nil {
+		return nil, 0, errors.ErrDocumentNotFound
+	}
+	revisions, total, err := s.revisionRepo.ListByDocumentID(documentID, page, size)
+	if err != nil {
+		logger.Error("获取文档修订列表失败", logger.Err(err))
+		return nil, 0, errors.ErrInternalServer
+	}
+	return revisions, total, nil
+}
+
+// GetRevision 获取修订详情（含正文）
+func (s *documentService) GetRevision(documentID, revisionID uint) (*model.DocumentRevision, error) {
+	revision, err := s.revisionRepo.FindByID(revisionID)
+	if err != nil || revision.DocumentID != documentID {
+		return nil, ErrDocumentRevisionNotFound
+	}
+	return revision, nil
+}
+
+// DiffRevisions 比较两个修订；toID 为 0 时与当前正文比较
+func (s *documentService) DiffRevisions(documentID, fromID, toID uint) (*DocumentRevisionDiff, error) {
+	document, err := s.documentRepo.FindByID(documentID)
+	if err != nil {
+		return nil, errors.ErrDocumentNotFound
+	}
+	from, err := s.GetRevision(documentID, fromID)
+	if err != nil {
+		return nil, err
+	}
+
+	target := document.Content
+	var to *model.DocumentRevision
+	if toID > 0 {
+		to, err = s.GetRevision(documentID, toID)
+		if err != nil {
+			return nil, err
+		}
+		target = to.Content
+	}
+
+	segments, stats := DiffText(from.Content, target)
+	return &DocumentRevisionDiff{
+		From:     revisionHeader(from),
+		To:       revisionHeader(to),
+		Segments: segments,
+		Stats:    stats,
+	}, nil
+}
+
+// RestoreRevision 将正文恢复为指定修订；正文相同时不产生新修订
+func (s *documentService) RestoreRevision(documentID, revisionID uint, source DocumentRevisionSource) (*model.Document, error) {
+	revision, err := s.GetRevision(documentID, revisionID)
+	if err != nil {
+		return nil, err
+	}
+	source.Source = model.RevisionSourceRestore
+	if source.Note == "" {
+		source.Note = fmt.Sprintf("恢复到版本 %d", revision.Version)
+	}
+	return s.Update(documentID, map[string]interface{}{"content": revision.Content}, source)
+}
+
+// saveWithRevision 保存文档；正文有变化时追加修订。文档首次产生修订且原正文非空时，先补记原正文快照
+func (s *documentService) saveWithRevision(document *model.Document, previous string, source DocumentRevisionSource) error {
+	if document.Content == previous {
+		return s.documentRepo.Update(document)
+	}
+
+	var revisions []*model.DocumentRevision
+	if previous != "" {
+		count, err := s.revisionRepo.CountByDocumentID(document.ID)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			revisions = append(revisions, newDocumentRevision(previous, DocumentRevisionSource{Source: model.RevisionSourceInitial}))
+		}
+	}
+	revisions = append(revisions, newDocumentRevision(document.Content, source))
+	return s.revisionRepo.UpdateDocument(document, revisions)
+}
+
+func newDocumentRevision(content string, source DocumentRevisionSource) *model.DocumentRevision {
+	if source.Source == "" {
+		source.Source = model.RevisionSourceUser
+	}
+	return &model.DocumentRevision{
+		Content:   content,
+		CharCount: len([]rune(content)),
+		Source:    source.Source,
+		SessionID: source.SessionID,
+		UserID:    source.UserID,
+		Note:      source.Note,
+	}
+}
+
+// revisionHeader 差异结果中的修订信息（不重复返回正文）
+func revisionHeader(revision *model.DocumentRevision) *model.DocumentRevision {
+	if revision == nil {
+		return nil
+	}
+	header := *revision
+	header.Content = ""
+	return &header
+}
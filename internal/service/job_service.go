@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -16,11 +17,34 @@ import (
 	"gorm.io/datatypes"
 )
 
+var (
+	// ErrJobAccessDenied 任务不属于当前用户
+	ErrJobAccessDenied = errors.New("access denied")
+	// ErrJobActive 任务已在本进程排队或执行
+	ErrJobActive = errors.New("job is active")
+	// ErrJobNotFound 任务不存在或类型不符
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobPaused 执行函数返回该错误时任务记为暂停（不写入结束时间），可通过 Requeue 继续
+	ErrJobPaused = errors.New("job paused")
+)
+
+// JobRunner 非插件类任务的执行函数：authorizationHeader 为提交任务时的请求头（仅进程内保存，重启后为空），
+// report 上报进度（0-100）；返回的结果写入 Job.Result，返回 error 时任务记为失败，返回 ErrJobPaused 时记为暂停
+type JobRunner func(ctx context.Context, job *model.Job, authorizationHeader string, report func(progress int)) (interface{}, error)
+
 type JobService interface {
 	CreatePluginInvokeJob(userID uint, sessionID uint, projectID *uint, pluginID uint, method string, payload map[string]interface{}, authorizationHeader string) (*model.Job, error)
 	CreatePluginInvokeJobFromSession(userID uint, sessionID uint, pluginID uint, method string, payload map[string]interface{}, authorizationHeader string) (*model.Job, error)
 	GetJobByUUID(jobUUID string) (*model.Job, error)
 	CancelJob(userID uint, jobUUID string) (*model.Job, error)
+	// RegisterRunner 注册指定任务类型的执行函数（插件调用之外的任务类型）
+	RegisterRunner(jobType model.JobType, runner JobRunner)
+	// Enqueue 将已创建的任务加入执行队列并推送 job.created
+	Enqueue(job *model.Job, authorizationHeader string) error
+	// Requeue 重新排队已结束或因进程重启中断的任务；任务仍在本进程排队或执行时返回 ErrJobActive
+	Requeue(job *model.Job, authorizationHeader string) error
+	// IsActive 任务是否在本进程排队或执行中
+	IsActive(jobUUID string) bool
 }
 
 type jobService struct {
@@ -34,6 +58,8 @@ type jobService struct {
 	mu        sync.RWMutex
 	authByJob map[string]string
 	cancelBy  map[string]context.CancelFunc
+	active    map[string]bool
+	runners   map[model.JobType]JobRunner
 }
 
 func NewJobService(jobRepo repository.JobRepository, sessionRepo repository.SessionRepository, pluginSvc PluginService, sessionSvc SessionService) JobService {
@@ -45,6 +71,8 @@ func NewJobService(jobRepo repository.JobRepository, sessionRepo repository.Sess
 		queue:       make(chan string, 1000),
 		authByJob:   make(map[string]string),
 		cancelBy:    make(map[string]context.CancelFunc),
+		active:      make(map[string]bool),
+		runners:     make(map[model.JobType]JobRunner),
 	}
 
 	// MVP：进程内后台 worker
@@ -102,9 +130,7 @@ func (s *jobService) CreatePluginInvokeJob(userID uint, sessionID uint, projectI
 	})
 
 	// 入队
-	select {
-	case s.queue <- jobUUID:
-	default:
+	if err := s.push(jobUUID); err != nil {
 		logger.Warn("job queue full, dropping job", logger.String("job_uuid", jobUUID))
 	}
 
@@ -131,7 +157,7 @@ func (s *jobService) CancelJob(userID uint, jobUUID string) (*model.Job, error)
 		return nil, err
 	}
 	if job.UserID != userID {
-		return nil, fmt.Errorf("access denied")
+		return nil, ErrJobAccessDenied
 	}
 
 	// 若已结束则直接返回
@@ -172,6 +198,11 @@ func (s *jobService) worker() {
 			continue
 		}
 		if job.Status != model.JobStatusQueued {
+			s.cleanupJobMemory(jobUUID)
+			continue
+		}
+		if job.Type != model.JobTypePluginInvoke {
+			s.startRunnerJob(job)
 			continue
 		}
 
@@ -283,10 +314,184 @@ func (s *jobService) worker() {
 	}
 }
 
+func (s *jobService) RegisterRunner(jobType model.JobType, runner JobRunner) {
+	s.mu.Lock()
+	s.runners[jobType] = runner
+	s.mu.Unlock()
+}
+
+func (s *jobService) Enqueue(job *model.Job, authorizationHeader string) error {
+	if authorizationHeader != "" {
+		s.mu.Lock()
+		s.authByJob[job.JobUUID] = authorizationHeader
+		s.mu.Unlock()
+	}
+
+	s.broadcastJobEvent(job.SessionID, sse.EventType("job.created"), map[string]interface{}{
+		"job_uuid":   job.JobUUID,
+		"type":       job.Type,
+		"status":     job.Status,
+		"progress":   job.Progress,
+		"session_id": job.SessionID,
+	})
+
+	if err := s.push(job.JobUUID); err != nil {
+		// 任务保持 queued，可通过 Requeue 重新入队
+		s.cleanupJobMemory(job.JobUUID)
+		return err
+	}
+	return nil
+}
+
+func (s *jobService) Requeue(job *model.Job, authorizationHeader string) error {
+	if s.IsActive(job.JobUUID) {
+		return ErrJobActive
+	}
+
+	job.Status = model.JobStatusQueued
+	job.ErrorMessage = ""
+	job.FinishedAt = nil
+	if err := s.jobRepo.Update(job); err != nil {
+		return err
+	}
+	return s.Enqueue(job, authorizationHeader)
+}
+
+func (s *jobService) IsActive(jobUUID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active[jobUUID]
+}
+
+// push 标记任务为活动状态并写入队列
+func (s *jobService) push(jobUUID string) error {
+	s.mu.Lock()
+	s.active[jobUUID] = true
+	s.mu.Unlock()
+
+	select {
+	case s.queue <- jobUUID:
+		return nil
+	default:
+		s.mu.Lock()
+		delete(s.active, jobUUID)
+		s.mu.Unlock()
+		return fmt.Errorf("job queue full")
+	}
+}
+
+// startRunnerJob 在独立 goroutine 中执行已注册的任务类型，避免长任务阻塞插件调用队列
+func (s *jobService) startRunnerJob(job *model.Job) {
+	s.mu.RLock()
+	runner, ok := s.runners[job.Type]
+	s.mu.RUnlock()
+	if !ok {
+		s.finishJob(job, nil, fmt.Errorf("unsupported job type: %s", job.Type))
+		s.cleanupJobMemory(job.JobUUID)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancelBy[job.JobUUID] = cancel
+	authHeader := s.authByJob[job.JobUUID]
+	s.mu.Unlock()
+
+	now := time.Now()
+	job.Status = model.JobStatusRunning
+	job.StartedAt = &now
+	_ = s.jobRepo.Update(job)
+	s.broadcastJobEvent(job.SessionID, sse.EventType("job.started"), map[string]interface{}{
+		"job_uuid":   job.JobUUID,
+		"type":       job.Type,
+		"status":     job.Status,
+		"progress":   job.Progress,
+		"session_id": job.SessionID,
+	})
+
+	go func() {
+		defer func() {
+			cancel()
+			s.cleanupJobMemory(job.JobUUID)
+		}()
+
+		var progressMu sync.Mutex
+		report := func(progress int) {
+			progressMu.Lock()
+			defer progressMu.Unlock()
+			job.Progress = progress
+			_ = s.jobRepo.UpdateProgress(job.ID, progress)
+			s.broadcastJobEvent(job.SessionID, sse.EventType("job.progress"), map[string]interface{}{
+				"job_uuid":   job.JobUUID,
+				"type":       job.Type,
+				"status":     job.Status,
+				"progress":   progress,
+				"session_id": job.SessionID,
+			})
+		}
+
+		result, runErr := runner(ctx, job, authHeader, report)
+		if ctx.Err() != nil {
+			// 已被取消：状态由 CancelJob 写入，这里只保存结果
+			if result != nil {
+				resultJSON, _ := json.Marshal(result)
+				if current, err := s.jobRepo.GetByUUID(job.JobUUID); err == nil {
+					current.Result = datatypes.JSON(resultJSON)
+					_ = s.jobRepo.Update(current)
+				}
+			}
+			return
+		}
+		s.finishJob(job, result, runErr)
+	}()
+}
+
+// finishJob 写入执行结果并推送 job.succeeded / job.failed / job.paused
+func (s *jobService) finishJob(job *model.Job, result interface{}, runErr error) {
+	if result != nil {
+		resultJSON, _ := json.Marshal(result)
+		job.Result = datatypes.JSON(resultJSON)
+	}
+	end := time.Now()
+	job.FinishedAt = &end
+	eventType := sse.EventType("job.succeeded")
+	if errors.Is(runErr, ErrJobPaused) {
+		job.Status = model.JobStatusPaused
+		job.FinishedAt = nil
+		job.ErrorMessage = ""
+		eventType = sse.EventType("job.paused")
+		runErr = nil
+	} else if runErr != nil {
+		job.Status = model.JobStatusFailed
+		job.ErrorMessage = runErr.Error()
+		eventType = sse.EventType("job.failed")
+	} else {
+		job.Status = model.JobStatusSucceeded
+		job.Progress = 100
+		job.ErrorMessage = ""
+	}
+	if err := s.jobRepo.Update(job); err != nil {
+		logger.Error("update job failed", logger.Err(err), logger.String("job_uuid", job.JobUUID))
+	}
+
+	data := map[string]interface{}{
+		"job_uuid":   job.JobUUID,
+		"type":       job.Type,
+		"status":     job.Status,
+		"progress":   job.Progress,
+		"session_id": job.SessionID,
+	}
+	if runErr != nil {
+		data["error"] = job.ErrorMessage
+	}
+	s.broadcastJobEvent(job.SessionID, eventType, data)
+}
+
 func (s *jobService) cleanupJobMemory(jobUUID string) {
 	s.mu.Lock()
 	delete(s.authByJob, jobUUID)
 	delete(s.cancelBy, jobUUID)
+	delete(s.active, jobUUID)
 	s.mu.Unlock()
 }
 
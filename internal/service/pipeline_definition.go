@@ -0,0 +1,527 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"novel-agent-os-backend/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 流水线步骤类型
+const (
+	PipelineStepGenerate      = "generate"
+	PipelineStepRewrite       = "rewrite"
+	PipelineStepQualityCheck  = "quality_check"
+	PipelineStepFormat        = "format"
+	PipelineStepPlugin        = "plugin"
+	PipelineStepWriteDocument = "write_document"
+)
+
+const (
+	// PipelineNextEnd next/branches 指向该值时结束执行
+	PipelineNextEnd = "end"
+	// pipelineLastVar 最近一个产出正文的步骤（generate/rewrite/format）的结果，未指定 input 的步骤以此为输入
+	pipelineLastVar = "last"
+)
+
+// 流水线定义格式
+const (
+	PipelineFormatJSON = "json"
+	PipelineFormatYAML = "yaml"
+)
+
+// PipelineValidationError 流水线定义格式或内容不合法
+type PipelineValidationError struct {
+	Message string
+}
+
+func (e *PipelineValidationError) Error() string {
+	return e.Message
+}
+
+func newPipelineValidationError(format string, args ...interface{}) *PipelineValidationError {
+	return &PipelineValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// PipelineDefinition 流水线定义：steps 默认按顺序执行，next/branches 可跳转到任意步骤
+type PipelineDefinition struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Provider/Model AI 步骤未单独指定时使用的供应商与模型
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+	// Variables 变量默认值，启动时传入的 inputs 覆盖同名变量
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	// Start 第一个执行的步骤，默认 steps[0]
+	Start string         `json:"start,omitempty"`
+	Steps []PipelineStep `json:"steps"`
+}
+
+// PipelineStep 流水线步骤；prompt/system/input/payload/document_id 为模板，可通过 .vars.<name> 引用变量、.project 引用项目
+type PipelineStep struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Title string `json:"title,omitempty"`
+
+	// generate/rewrite
+	Provider    string   `json:"provider,omitempty"`
+	Model       string   `json:"model,omitempty"`
+	System      string   `json:"system,omitempty"`
+	Prompt      string   `json:"prompt,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+
+	// Input rewrite/quality_check/format/write_document 的输入正文，默认 .vars.last
+	Input string `json:"input,omitempty"`
+	// MinScore quality_check 的及格分，默认取项目/全局质量门禁 min_score
+	MinScore int `json:"min_score,omitempty"`
+	// Style format 的排版风格
+	Style string `json:"style,omitempty"`
+
+	// plugin
+	PluginID uint                   `json:"plugin_id,omitempty"`
+	Method   string                 `json:"method,omitempty"`
+	Payload  map[string]interface{} `json:"payload,omitempty"`
+
+	// write_document：document_id 可为数字或模板；mode 为 propose 时保存为待审阅提案，默认直接写回
+	DocumentID interface{} `json:"document_id,omitempty"`
+	Mode       string      `json:"mode,omitempty"`
+	SetStatus  string      `json:"set_status,omitempty"`
+	SetSummary bool        `json:"set_summary,omitempty"`
+
+	// Output 步骤输出写入的变量名，默认为步骤 ID
+	Output string `json:"output,omitempty"`
+	// Next 未命中任何分支时的下一步，默认为定义中的下一个步骤，end 结束
+	Next     string           `json:"next,omitempty"`
+	Branches []PipelineBranch `json:"branches,omitempty"`
+}
+
+// PipelineBranch 条件分支：按顺序匹配，第一个满足条件的分支决定下一步
+type PipelineBranch struct {
+	When PipelineCondition `json:"when"`
+	Next string            `json:"next"`
+}
+
+// PipelineCondition 分支条件：var 为变量路径（如 check.score），op 为 == != > >= < <= contains empty not_empty
+type PipelineCondition struct {
+	Var   string      `json:"var"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+var pipelineStepTypes = map[string]bool{
+	PipelineStepGenerate:      true,
+	PipelineStepRewrite:       true,
+	PipelineStepQualityCheck:  true,
+	PipelineStepFormat:        true,
+	PipelineStepPlugin:        true,
+	PipelineStepWriteDocument: true,
+}
+
+var pipelineConditionOps = map[string]bool{
+	"==": true, "!=": true, ">": true, ">=": true, "<": true, "<=": true,
+	"contains": true, "empty": true, "not_empty": true,
+}
+
+// parsePipelineSource 解析 JSON/YAML 定义文本；format 为空时按首字符识别（{ 开头为 JSON）
+func parsePipelineSource(source, format string) (*PipelineDefinition, string, error) {
+	trimmed := strings.TrimSpace(source)
+	if trimmed == "" {
+		return nil, "", newPipelineValidationError("流水线定义不能为空")
+	}
+	if format == "" {
+		format = PipelineFormatYAML
+		if strings.HasPrefix(trimmed, "{") {
+			format = PipelineFormatJSON
+		}
+	}
+
+	raw := []byte(trimmed)
+	switch format {
+	case PipelineFormatJSON:
+	case PipelineFormatYAML:
+		var doc interface{}
+		if err := yaml.Unmarshal(raw, &doc); err != nil {
+			return nil, "", newPipelineValidationError("YAML 解析失败：%s", err.Error())
+		}
+		converted, err := json.Marshal(doc)
+		if err != nil {
+			return nil, "", newPipelineValidationError("YAML 只支持字符串键：%s", err.Error())
+		}
+		raw = converted
+	default:
+		return nil, "", newPipelineValidationError("不支持的定义格式：%s", format)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	var def PipelineDefinition
+	if err := decoder.Decode(&def); err != nil {
+		return nil, "", newPipelineValidationError("流水线定义格式错误：%s", err.Error())
+	}
+	return &def, format, nil
+}
+
+// validatePipelineDefinition 校验定义并补全默认值（output 默认步骤 ID）；styles 为 format 步骤可用的排版风格
+func validatePipelineDefinition(def *PipelineDefinition, styles []string) error {
+	maxSteps := config.Get().AI.Pipeline.MaxSteps
+	if len(def.Steps) == 0 {
+		return newPipelineValidationError("至少需要一个步骤")
+	}
+	if len(def.Steps) > maxSteps {
+		return newPipelineValidationError("步骤数超过上限 %d", maxSteps)
+	}
+	for name := range def.Variables {
+		if !templateVarNamePattern.MatchString(name) || name == pipelineLastVar {
+			return newPipelineValidationError("变量名不合法：%s", name)
+		}
+	}
+
+	ids := make(map[string]bool, len(def.Steps))
+	for i := range def.Steps {
+		step := &def.Steps[i]
+		if !templateVarNamePattern.MatchString(step.ID) || step.ID == PipelineNextEnd {
+			return newPipelineValidationError("第 %d 个步骤的 id 不合法：%q", i+1, step.ID)
+		}
+		if ids[step.ID] {
+			return newPipelineValidationError("步骤 id 重复：%s", step.ID)
+		}
+		ids[step.ID] = true
+	}
+	if def.Start != "" && !ids[def.Start] {
+		return newPipelineValidationError("start 指向不存在的步骤：%s", def.Start)
+	}
+
+	validStyles := make(map[string]bool, len(styles))
+	for _, style := range styles {
+		validStyles[style] = true
+	}
+	for i := range def.Steps {
+		step := &def.Steps[i]
+		if err := validatePipelineStep(def, step, ids, validStyles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validatePipelineStep(def *PipelineDefinition, step *PipelineStep, ids, styles map[string]bool) error {
+	fail := func(format string, args ...interface{}) error {
+		return newPipelineValidationError("步骤 %s：%s", step.ID, fmt.Sprintf(format, args...))
+	}
+	if !pipelineStepTypes[step.Type] {
+		return fail("不支持的步骤类型 %q", step.Type)
+	}
+	if step.Output == "" {
+		step.Output = step.ID
+	}
+	if !templateVarNamePattern.MatchString(step.Output) || step.Output == pipelineLastVar {
+		return fail("output 变量名不合法：%s", step.Output)
+	}
+	if step.Next != "" && step.Next != PipelineNextEnd && !ids[step.Next] {
+		return fail("next 指向不存在的步骤：%s", step.Next)
+	}
+	for i, branch := range step.Branches {
+		if branch.Next == "" || (branch.Next != PipelineNextEnd && !ids[branch.Next]) {
+			return fail("第 %d 个分支的 next 指向不存在的步骤：%q", i+1, branch.Next)
+		}
+		if err := validatePipelineCondition(branch.When); err != nil {
+			return fail("第 %d 个分支%s", i+1, err.Error())
+		}
+	}
+
+	templates := map[string]string{"system": step.System, "prompt": step.Prompt, "input": step.Input}
+	switch step.Type {
+	case PipelineStepGenerate, PipelineStepRewrite:
+		if strings.TrimSpace(step.Prompt) == "" {
+			return fail("prompt 不能为空")
+		}
+		if step.Provider == "" && def.Provider == "" {
+			return fail("未指定 provider")
+		}
+		if step.MaxTokens < 0 {
+			return fail("max_tokens 不合法")
+		}
+		if step.Temperature != nil && *step.Temperature < 0 {
+			return fail("temperature 不合法")
+		}
+	case PipelineStepQualityCheck:
+		if step.MinScore < 0 || step.MinScore > 100 {
+			return fail("min_score 应在 0-100 之间")
+		}
+	case PipelineStepFormat:
+		if step.Style == "" {
+			return fail("style 不能为空")
+		}
+		if !styles[step.Style] {
+			return fail("不支持的排版风格 %q", step.Style)
+		}
+	case PipelineStepPlugin:
+		if step.PluginID == 0 || strings.TrimSpace(step.Method) == "" {
+			return fail("plugin_id 与 method 不能为空")
+		}
+		if err := walkPipelinePayload(step.Payload, func(text string) error {
+			_, err := parsePromptTemplate(text)
+			return err
+		}); err != nil {
+			return fail("payload %s", err.Error())
+		}
+	case PipelineStepWriteDocument:
+		switch step.Mode {
+		case "", WriteBackModePropose:
+		default:
+			return fail("不支持的写回模式 %q", step.Mode)
+		}
+		switch id := step.DocumentID.(type) {
+		case float64:
+			if id <= 0 || id != float64(uint(id)) {
+				return fail("document_id 不合法")
+			}
+		case string:
+			if strings.TrimSpace(id) == "" {
+				return fail("document_id 不能为空")
+			}
+			templates["document_id"] = id
+		default:
+			return fail("document_id 不能为空")
+		}
+	}
+	for field, text := range templates {
+		if text == "" {
+			continue
+		}
+		if _, err := parsePromptTemplate(text); err != nil {
+			return fail("%s %s", field, err.Error())
+		}
+	}
+	return nil
+}
+
+func validatePipelineCondition(cond PipelineCondition) error {
+	if strings.TrimSpace(cond.Var) == "" {
+		return fmt.Errorf("的 when.var 不能为空")
+	}
+	if !pipelineConditionOps[cond.Op] {
+		return fmt.Errorf("的 when.op 不支持：%q", cond.Op)
+	}
+	switch cond.Op {
+	case "empty", "not_empty":
+	case ">", ">=", "<", "<=":
+		if _, ok := pipelineNumber(cond.Value); !ok {
+			return fmt.Errorf("的 when.value 需为数字")
+		}
+	default:
+		if cond.Value == nil {
+			return fmt.Errorf("的 when.value 不能为空")
+		}
+	}
+	return nil
+}
+
+// pipelineStartStep 第一个执行的步骤
+func pipelineStartStep(def *PipelineDefinition) string {
+	if def.Start != "" {
+		return def.Start
+	}
+	if len(def.Steps) == 0 {
+		return ""
+	}
+	return def.Steps[0].ID
+}
+
+// resolvePipelineNext 按分支条件、next 与定义顺序决定下一步；返回空字符串表示结束
+func resolvePipelineNext(def *PipelineDefinition, step *PipelineStep, vars map[string]interface{}) string {
+	next := step.Next
+	for _, branch := range step.Branches {
+		if branch.When.match(vars) {
+			next = branch.Next
+			break
+		}
+	}
+	if next == PipelineNextEnd {
+		return ""
+	}
+	if next != "" {
+		return next
+	}
+	for i := range def.Steps {
+		if def.Steps[i].ID == step.ID && i+1 < len(def.Steps) {
+			return def.Steps[i+1].ID
+		}
+	}
+	return ""
+}
+
+// match 判断条件是否成立；变量不存在时只有 empty 与 != 成立
+func (c PipelineCondition) match(vars map[string]interface{}) bool {
+	value, ok := lookupPipelineVar(vars, c.Var)
+	switch c.Op {
+	case "empty":
+		return !ok || isEmptyTemplateValue(value)
+	case "not_empty":
+		return ok && !isEmptyTemplateValue(value)
+	}
+	if !ok {
+		return c.Op == "!="
+	}
+
+	switch c.Op {
+	case "==", "!=":
+		equal := pipelineValuesEqual(value, c.Value)
+		return equal == (c.Op == "==")
+	case "contains":
+		if list, isList := value.([]interface{}); isList {
+			for _, item := range list {
+				if pipelineValuesEqual(item, c.Value) {
+					return true
+				}
+			}
+			return false
+		}
+		return strings.Contains(fmt.Sprint(value), fmt.Sprint(c.Value))
+	}
+
+	left, okLeft := pipelineNumber(value)
+	right, okRight := pipelineNumber(c.Value)
+	if !okLeft || !okRight {
+		return false
+	}
+	switch c.Op {
+	case ">":
+		return left > right
+	case ">=":
+		return left >= right
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	}
+	return false
+}
+
+// lookupPipelineVar 按点分路径读取变量（如 check.score）
+func lookupPipelineVar(vars map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = vars
+	for _, key := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = object[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// pipelineValuesEqual 数字按数值比较，其余按字符串形式比较
+func pipelineValuesEqual(a, b interface{}) bool {
+	if left, ok := pipelineNumber(a); ok {
+		if right, ok := pipelineNumber(b); ok {
+			return left == right
+		}
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func pipelineNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// walkPipelinePayload 依次访问插件 payload 中的字符串
+func walkPipelinePayload(value interface{}, visit func(text string) error) error {
+	switch v := value.(type) {
+	case string:
+		return visit(v)
+	case map[string]interface{}:
+		for _, item := range v {
+			if err := walkPipelinePayload(item, visit); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := walkPipelinePayload(item, visit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renderPipelinePayload 复制插件 payload 并渲染其中的字符串模板
+func renderPipelinePayload(value interface{}, data map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return renderPipelineTemplate(v, data)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			rendered, err := renderPipelinePayload(item, data)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered, err := renderPipelinePayload(item, data)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+func renderPipelineTemplate(text string, data map[string]interface{}) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	tmpl, err := parsePromptTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	return executePromptTemplate(tmpl, data)
+}
+
+// normalizePipelineValue 经 JSON 往返转换为通用结构，保证模板与条件在恢复执行前后看到相同的变量形态
+func normalizePipelineValue(value interface{}) interface{} {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil
+	}
+	return out
+}
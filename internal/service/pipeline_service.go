@@ -0,0 +1,827 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"novel-agent-os-backend/internal/config"
+	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/internal/repository"
+	"novel-agent-os-backend/pkg/errors"
+	"novel-agent-os-backend/pkg/logger"
+	"novel-agent-os-backend/pkg/sse"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrPipelineNotFound 流水线不存在
+	ErrPipelineNotFound = stderrors.New("pipeline not found")
+	// ErrPipelineRunNotRunning 执行未在本进程排队或执行，无法暂停
+	ErrPipelineRunNotRunning = stderrors.New("pipeline run is not running")
+	// ErrPipelineRunNotResumable 执行已完成或已取消，无法继续
+	ErrPipelineRunNotResumable = stderrors.New("pipeline run cannot be resumed")
+)
+
+// PipelineSaveRequest 创建/更新流水线请求：definition（JSON 对象）与 source（JSON/YAML 文本）二选一
+type PipelineSaveRequest struct {
+	Name        string
+	Description string
+	Definition  json.RawMessage
+	Source      string
+	// Format source 的格式（json/yaml），为空时自动识别
+	Format string
+}
+
+// PipelineRunRequest 启动流水线请求
+type PipelineRunRequest struct {
+	PipelineID uint
+	UserID     uint
+	// Session 为空时新建会话
+	Session             *model.Session
+	Inputs              map[string]interface{}
+	AuthorizationHeader string
+}
+
+// PipelineRunResult 流水线执行（后台 Job，步骤与进度通过会话 SSE 推送）
+type PipelineRunResult struct {
+	Session *model.Session
+	Job     *model.Job
+	Run     *model.PipelineRun
+}
+
+// PipelineRunSummary 执行摘要，写入 Job.Result
+type PipelineRunSummary struct {
+	StepsRun int    `json:"steps_run"`
+	LastStep string `json:"last_step,omitempty"`
+	NextStep string `json:"next_step,omitempty"`
+}
+
+// PipelineService 项目自定义工作流流水线：定义管理与后台执行（可暂停、继续、取消）
+type PipelineService interface {
+	Create(projectID, userID uint, req PipelineSaveRequest) (*model.Pipeline, error)
+	GetByID(id uint) (*model.Pipeline, error)
+	ListByProjectID(projectID uint, page, size int) ([]*model.Pipeline, int64, error)
+	// Update 更新名称/描述；传入 definition 或 source 时整体替换定义
+	Update(id uint, req PipelineSaveRequest) (*model.Pipeline, error)
+	Delete(id uint) error
+
+	// Run 以当前定义的快照创建执行任务并加入后台队列
+	Run(req PipelineRunRequest) (*PipelineRunResult, error)
+	ListRuns(pipelineID uint, page, size int) ([]*model.Job, int64, error)
+	GetRun(userID uint, jobUUID string) (*PipelineRunResult, error)
+	// PauseRun 请求暂停：当前步骤完成后任务记为 paused
+	PauseRun(userID uint, jobUUID string) (*PipelineRunResult, error)
+	// ResumeRun 从下一个待执行的步骤继续已暂停、失败或因进程重启中断的执行
+	ResumeRun(userID uint, jobUUID, authorizationHeader string) (*PipelineRunResult, error)
+	// CancelRun 取消执行：中断进行中的 AI 步骤与插件调用，不再继续后续步骤
+	CancelRun(userID uint, jobUUID string) (*PipelineRunResult, error)
+}
+
+type pipelineService struct {
+	pipelineRepo      repository.PipelineRepository
+	projectService    ProjectService
+	sessionService    SessionService
+	jobService        JobService
+	aiConfigService   AIConfigService
+	usageService      AIUsageService
+	modelService      AIModelService
+	cacheService      AIResponseCacheService
+	qualityGate       QualityGateService
+	formattingService FormattingService
+	pluginService     PluginService
+	documentService   DocumentService
+	proposalService   DocumentProposalService
+
+	// pauseMu 保护 pauseRequested：按 job_uuid 记录待生效的暂停请求
+	pauseMu        sync.Mutex
+	pauseRequested map[string]bool
+}
+
+// NewPipelineService 创建流水线服务并注册 pipeline 任务的执行函数
+func NewPipelineService(pipelineRepo repository.PipelineRepository, projectService ProjectService, sessionService SessionService, jobService JobService, aiConfigService AIConfigService, usageService AIUsageService, modelService AIModelService, cacheService AIResponseCacheService, qualityGate QualityGateService, formattingService FormattingService, pluginService PluginService, documentService DocumentService, proposalService DocumentProposalService) PipelineService {
+	s := &pipelineService{
+		pipelineRepo:      pipelineRepo,
+		projectService:    projectService,
+		sessionService:    sessionService,
+		jobService:        jobService,
+		aiConfigService:   aiConfigService,
+		usageService:      usageService,
+		modelService:      modelService,
+		cacheService:      cacheService,
+		qualityGate:       qualityGate,
+		formattingService: formattingService,
+		pluginService:     pluginService,
+		documentService:   documentService,
+		proposalService:   proposalService,
+		pauseRequested:    make(map[string]bool),
+	}
+	jobService.RegisterRunner(model.JobTypePipeline, s.runPipelineJob)
+	return s
+}
+
+// Create 创建流水线
+func (s *pipelineService) Create(projectID, userID uint, req PipelineSaveRequest) (*model.Pipeline, error) {
+	if _, err := s.projectService.GetByID(projectID); err != nil {
+		return nil, errors.ErrProjectNotFound
+	}
+	pipeline := &model.Pipeline{
+		ProjectID:   projectID,
+		UserID:      userID,
+		Description: req.Description,
+	}
+	if err := s.applyDefinition(pipeline, req); err != nil {
+		return nil, err
+	}
+	pipeline.Name = firstNonEmpty(req.Name, pipeline.Name)
+	if pipeline.Name == "" {
+		return nil, newPipelineValidationError("流水线名称不能为空")
+	}
+
+	if err := s.pipelineRepo.Create(pipeline); err != nil {
+		logger.Error("创建流水线失败", logger.Err(err))
+		return nil, errors.ErrInternalServer
+	}
+	return pipeline, nil
+}
+
+// GetByID 获取流水线
+func (s *pipelineService) GetByID(id uint) (*model.Pipeline, error) {
+	pipeline, err := s.pipelineRepo.FindByID(id)
+	if err != nil {
+		return nil, ErrPipelineNotFound
+	}
+	return pipeline, nil
+}
+
+// ListByProjectID 分页查询项目的流水线
+func (s *pipelineService) ListByProjectID(projectID uint, page, size int) ([]*model.Pipeline, int64, error) {
+	pipelines, total, err := s.pipelineRepo.ListByProjectID(projectID, page, size)
+	if err != nil {
+		logger.Error("获取流水线列表失败", logger.Err(err))
+		return nil, 0, errors.ErrInternalServer
+	}
+	return pipelines, total, nil
+}
+
+// Update 更新流水线
+func (s *pipelineService) Update(id uint, req PipelineSaveRequest) (*model.Pipeline, error) {
+	pipeline, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Definition) > 0 || strings.TrimSpace(req.Source) != "" {
+		if err := s.applyDefinition(pipeline, req); err != nil {
+			return nil, err
+		}
+	}
+	if req.Name != "" {
+		pipeline.Name = req.Name
+	}
+	if req.Description != "" {
+		pipeline.Description = req.Description
+	}
+
+	if err := s.pipelineRepo.Update(pipeline); err != nil {
+		logger.Error("更新流水线失败", logger.Err(err))
+		return nil, errors.ErrInternalServer
+	}
+	return pipeline, nil
+}
+
+// Delete 删除流水线（已创建的执行保留定义快照，不受影响）
+func (s *pipelineService) Delete(id uint) error {
+	if _, err := s.GetByID(id); err != nil {
+		return err
+	}
+	if err := s.pipelineRepo.Delete(id); err != nil {
+		logger.Error("删除流水线失败", logger.Err(err))
+		return errors.ErrInternalServer
+	}
+	return nil
+}
+
+// applyDefinition 解析并校验定义，写入规范化定义与原文；定义中的 name/description 作为未填写时的默认值
+func (s *pipelineService) applyDefinition(pipeline *model.Pipeline, req PipelineSaveRequest) error {
+	source, format := req.Source, req.Format
+	if len(req.Definition) > 0 {
+		source, format = string(req.Definition), PipelineFormatJSON
+	}
+	def, format, err := parsePipelineSource(source, format)
+	if err != nil {
+		return err
+	}
+	if err := validatePipelineDefinition(def, s.formattingService.GetAvailableStyles()); err != nil {
+		return err
+	}
+	normalized, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	pipeline.Definition = datatypes.JSON(normalized)
+	pipeline.Source = source
+	pipeline.SourceFormat = format
+	if pipeline.Name == "" {
+		pipeline.Name = def.Name
+	}
+	if pipeline.Description == "" {
+		pipeline.Description = def.Description
+	}
+	return nil
+}
+
+// Run 启动流水线
+func (s *pipelineService) Run(req PipelineRunRequest) (*PipelineRunResult, error) {
+	pipeline, err := s.GetByID(req.PipelineID)
+	if err != nil {
+		return nil, err
+	}
+	var def PipelineDefinition
+	if err := json.Unmarshal(pipeline.Definition, &def); err != nil {
+		return nil, fmt.Errorf("invalid pipeline definition: %w", err)
+	}
+
+	vars := make(map[string]interface{}, len(def.Variables)+len(req.Inputs))
+	for name, value := range def.Variables {
+		vars[name] = value
+	}
+	for name, value := range req.Inputs {
+		if !templateVarNamePattern.MatchString(name) || name == pipelineLastVar {
+			return nil, newPipelineValidationError("变量名不合法：%s", name)
+		}
+		vars[name] = value
+	}
+	variables, err := json.Marshal(vars)
+	if err != nil {
+		return nil, newPipelineValidationError("inputs 无法序列化：%s", err.Error())
+	}
+
+	session := req.Session
+	if session == nil {
+		session = &model.Session{
+			Title:     pipeline.Name + " " + time.Now().Format("2006-01-02 15:04"),
+			Mode:      "pipeline",
+			ProjectID: pipeline.ProjectID,
+			UserID:    req.UserID,
+		}
+		if err := s.sessionService.CreateSession(session); err != nil {
+			return nil, err
+		}
+	}
+
+	projectID := pipeline.ProjectID
+	job := &model.Job{
+		JobUUID:   uuid.New().String(),
+		Type:      model.JobTypePipeline,
+		Status:    model.JobStatusQueued,
+		UserID:    req.UserID,
+		SessionID: session.ID,
+		ProjectID: &projectID,
+		Method:    string(model.JobTypePipeline),
+	}
+	run := &model.PipelineRun{
+		PipelineID: pipeline.ID,
+		Definition: pipeline.Definition,
+		Variables:  datatypes.JSON(variables),
+		NextStep:   pipelineStartStep(&def),
+	}
+	if err := s.pipelineRepo.CreateRun(job, run); err != nil {
+		return nil, err
+	}
+	if err := s.jobService.Enqueue(job, req.AuthorizationHeader); err != nil {
+		logger.Warn("enqueue pipeline job failed", logger.String("job_uuid", job.JobUUID), logger.Err(err))
+	}
+
+	return &PipelineRunResult{Session: session, Job: job, Run: run}, nil
+}
+
+// ListRuns 分页查询流水线的执行任务
+func (s *pipelineService) ListRuns(pipelineID uint, page, size int) ([]*model.Job, int64, error) {
+	return s.pipelineRepo.ListRunJobs(pipelineID, page, size)
+}
+
+// GetRun 查询执行任务及执行位置、变量
+func (s *pipelineService) GetRun(userID uint, jobUUID string) (*PipelineRunResult, error) {
+	job, err := s.loadPipelineJob(userID, jobUUID)
+	if err != nil {
+		return nil, err
+	}
+	return s.runResult(job)
+}
+
+// PauseRun 请求暂停执行
+func (s *pipelineService) PauseRun(userID uint, jobUUID string) (*PipelineRunResult, error) {
+	job, err := s.loadPipelineJob(userID, jobUUID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != model.JobStatusPaused {
+		if !s.jobService.IsActive(job.JobUUID) {
+			return nil, ErrPipelineRunNotRunning
+		}
+		s.setPauseRequested(job.JobUUID, true)
+	}
+	return s.runResult(job)
+}
+
+// ResumeRun 继续执行
+func (s *pipelineService) ResumeRun(userID uint, jobUUID, authorizationHeader string) (*PipelineRunResult, error) {
+	job, err := s.loadPipelineJob(userID, jobUUID)
+	if err != nil {
+		return nil, err
+	}
+	if s.jobService.IsActive(job.JobUUID) {
+		return nil, ErrJobActive
+	}
+	if job.Status == model.JobStatusSucceeded || job.Status == model.JobStatusCanceled {
+		return nil, ErrPipelineRunNotResumable
+	}
+	result, err := s.runResult(job)
+	if err != nil {
+		return nil, err
+	}
+	if result.Run.NextStep == "" {
+		return nil, ErrPipelineRunNotResumable
+	}
+
+	s.setPauseRequested(job.JobUUID, false)
+	if err := s.jobService.Requeue(job, authorizationHeader); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// CancelRun 取消执行
+func (s *pipelineService) CancelRun(userID uint, jobUUID string) (*PipelineRunResult, error) {
+	if _, err := s.loadPipelineJob(userID, jobUUID); err != nil {
+		return nil, err
+	}
+	job, err := s.jobService.CancelJob(userID, jobUUID)
+	if err != nil {
+		return nil, err
+	}
+	s.setPauseRequested(jobUUID, false)
+	return s.runResult(job)
+}
+
+func (s *pipelineService) loadPipelineJob(userID uint, jobUUID string) (*model.Job, error) {
+	job, err := s.jobService.GetJobByUUID(jobUUID)
+	if err != nil {
+		if stderrors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	if job.Type != model.JobTypePipeline {
+		return nil, ErrJobNotFound
+	}
+	if job.UserID != userID {
+		return nil, ErrJobAccessDenied
+	}
+	return job, nil
+}
+
+func (s *pipelineService) runResult(job *model.Job) (*PipelineRunResult, error) {
+	run, err := s.pipelineRepo.FindRunByJobID(job.ID)
+	if err != nil {
+		if stderrors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return &PipelineRunResult{Job: job, Run: run}, nil
+}
+
+func (s *pipelineService) setPauseRequested(jobUUID string, requested bool) {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if requested {
+		s.pauseRequested[jobUUID] = true
+	} else {
+		delete(s.pauseRequested, jobUUID)
+	}
+}
+
+func (s *pipelineService) isPauseRequested(jobUUID string) bool {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	return s.pauseRequested[jobUUID]
+}
+
+// runPipelineJob 流水线执行函数：从 NextStep 起逐步执行，每步完成后保存执行位置与变量并记录会话步骤；
+// 步骤之间检查取消与暂停请求
+func (s *pipelineService) runPipelineJob(ctx context.Context, job *model.Job, authorizationHeader string, report func(progress int)) (interface{}, error) {
+	defer s.setPauseRequested(job.JobUUID, false)
+
+	run, err := s.pipelineRepo.FindRunByJobID(job.ID)
+	if err != nil {
+		return nil, err
+	}
+	var def PipelineDefinition
+	if err := json.Unmarshal(run.Definition, &def); err != nil {
+		return nil, fmt.Errorf("invalid pipeline definition: %w", err)
+	}
+	vars := map[string]interface{}{}
+	if len(run.Variables) > 0 {
+		if err := json.Unmarshal(run.Variables, &vars); err != nil {
+			return nil, fmt.Errorf("invalid pipeline variables: %w", err)
+		}
+	}
+	session, err := s.sessionService.GetSession(job.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	var project *model.Project
+	if job.ProjectID != nil {
+		project, _ = s.projectService.GetByID(*job.ProjectID)
+	}
+
+	steps := make(map[string]*PipelineStep, len(def.Steps))
+	for i := range def.Steps {
+		steps[def.Steps[i].ID] = &def.Steps[i]
+	}
+	summary := PipelineRunSummary{StepsRun: run.StepsRun}
+	maxStepRuns := config.Get().AI.Pipeline.MaxStepRuns
+	executed := 0
+
+	for run.NextStep != "" {
+		summary.NextStep = run.NextStep
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		if s.isPauseRequested(job.JobUUID) {
+			s.broadcastProgress(session.ID, job.Progress, "流水线已暂停")
+			return summary, ErrJobPaused
+		}
+		if executed >= maxStepRuns {
+			return summary, fmt.Errorf("步骤执行次数超过上限 %d", maxStepRuns)
+		}
+		step, ok := steps[run.NextStep]
+		if !ok {
+			return summary, fmt.Errorf("步骤不存在：%s", run.NextStep)
+		}
+
+		s.broadcastProgress(session.ID, job.Progress, "执行步骤 "+pipelineStepTitle(step))
+		outcome, err := s.runStep(ctx, session, project, &def, step, vars, authorizationHeader)
+		if err != nil {
+			if ctx.Err() != nil {
+				return summary, ctx.Err()
+			}
+			metadata := map[string]interface{}{
+				"job_uuid": job.JobUUID,
+				"step_id":  step.ID,
+				"type":     step.Type,
+				"error":    err.Error(),
+			}
+			if _, stepErr := s.appendStep(session.ID, pipelineStepTitle(step)+" 失败", err.Error(), "pipeline.step.failed", metadata); stepErr != nil {
+				logger.Warn("append pipeline step failed", logger.Uint("session_id", session.ID), logger.Err(stepErr))
+			}
+			return summary, fmt.Errorf("步骤 %s 失败：%w", step.ID, err)
+		}
+
+		vars[step.Output] = outcome.output
+		if outcome.text != nil {
+			vars[pipelineLastVar] = *outcome.text
+		}
+		next := resolvePipelineNext(&def, step, vars)
+		variables, err := json.Marshal(vars)
+		if err != nil {
+			return summary, fmt.Errorf("步骤 %s 的输出无法保存：%w", step.ID, err)
+		}
+		run.Variables = datatypes.JSON(variables)
+		run.NextStep = next
+		run.StepsRun++
+		executed++
+		if err := s.pipelineRepo.UpdateRun(run); err != nil {
+			return summary, err
+		}
+		summary.StepsRun, summary.LastStep, summary.NextStep = run.StepsRun, step.ID, next
+
+		outcome.metadata["job_uuid"] = job.JobUUID
+		outcome.metadata["step_id"] = step.ID
+		outcome.metadata["type"] = step.Type
+		outcome.metadata["output"] = step.Output
+		outcome.metadata["next"] = next
+		if _, err := s.appendStep(session.ID, pipelineStepTitle(step), outcome.content, "pipeline."+step.Type, outcome.metadata); err != nil {
+			logger.Warn("append pipeline step failed", logger.Uint("session_id", session.ID), logger.Err(err))
+		}
+
+		progress := run.StepsRun * 100 / len(def.Steps)
+		if progress > 99 {
+			progress = 99
+		}
+		report(progress)
+	}
+
+	s.broadcastProgress(session.ID, 100, "流水线执行完成")
+	s.broadcastDone(session.ID)
+	return summary, nil
+}
+
+// pipelineStepOutcome 单个步骤的执行结果
+type pipelineStepOutcome struct {
+	// output 写入 vars[step.output] 的值
+	output interface{}
+	// text 产出的正文（generate/rewrite/format），非空时更新 vars.last
+	text *string
+	// content 会话步骤的内容
+	content  string
+	metadata map[string]interface{}
+}
+
+func (s *pipelineService) runStep(ctx context.Context, session *model.Session, project *model.Project, def *PipelineDefinition, step *PipelineStep, vars map[string]interface{}, authorizationHeader string) (*pipelineStepOutcome, error) {
+	data := map[string]interface{}{
+		"vars":    vars,
+		"project": projectTemplateBinding(project),
+	}
+	render := func(field, text string) (string, error) {
+		out, err := renderPipelineTemplate(text, data)
+		if err != nil {
+			return "", fmt.Errorf("%s %w", field, err)
+		}
+		return out, nil
+	}
+	input := func() (string, error) {
+		if step.Input != "" {
+			return render("input", step.Input)
+		}
+		last, ok := vars[pipelineLastVar].(string)
+		if !ok {
+			return "", fmt.Errorf("未指定 input，且此前没有产出正文的步骤")
+		}
+		return last, nil
+	}
+	textOutcome := func(text string, metadata map[string]interface{}) *pipelineStepOutcome {
+		return &pipelineStepOutcome{output: text, text: &text, content: text, metadata: metadata}
+	}
+
+	switch step.Type {
+	case PipelineStepGenerate, PipelineStepRewrite:
+		system, err := render("system", step.System)
+		if err != nil {
+			return nil, err
+		}
+		prompt, err := render("prompt", step.Prompt)
+		if err != nil {
+			return nil, err
+		}
+		if step.Type == PipelineStepRewrite {
+			original, err := input()
+			if err != nil {
+				return nil, err
+			}
+			prompt += "\n\n原文：\n" + original
+		}
+		provider := firstNonEmpty(step.Provider, def.Provider)
+		chat := &ChatRequest{
+			Model:       firstNonEmpty(step.Model, def.Model),
+			Temperature: step.Temperature,
+			MaxTokens:   step.MaxTokens,
+		}
+		if strings.TrimSpace(system) != "" {
+			chat.Messages = append(chat.Messages, ChatMessage{Role: "system", Content: system})
+		}
+		chat.Messages = append(chat.Messages, ChatMessage{Role: "user", Content: prompt})
+
+		result, err := s.callChat(ctx, session, provider, chat)
+		if err != nil {
+			return nil, err
+		}
+		content := result.Content
+		if content == "" {
+			content = string(result.Raw)
+		}
+		return textOutcome(content, map[string]interface{}{
+			"provider":      provider,
+			"provider_used": result.Provider,
+			"model":         result.Model,
+			"usage":         result.Usage,
+			"cached":        result.Cached,
+		}), nil
+
+	case PipelineStepQualityCheck:
+		text, err := input()
+		if err != nil {
+			return nil, err
+		}
+		check, err := s.qualityGate.CheckQuality(text)
+		if err != nil {
+			return nil, err
+		}
+		minScore := step.MinScore
+		if minScore == 0 {
+			projectID := uint(0)
+			if project != nil {
+				projectID = project.ID
+			}
+			minScore = resolveQualityGateSettings(s.projectService, projectID, nil).MinScore
+		}
+		output := normalizePipelineValue(map[string]interface{}{
+			"score":     check.Score,
+			"passed":    check.Passed && check.Score >= minScore,
+			"min_score": minScore,
+			"issues":    check.Issues,
+		})
+		raw, _ := json.Marshal(output)
+		return &pipelineStepOutcome{output: output, content: string(raw), metadata: map[string]interface{}{
+			"score":     check.Score,
+			"min_score": minScore,
+		}}, nil
+
+	case PipelineStepFormat:
+		text, err := input()
+		if err != nil {
+			return nil, err
+		}
+		formatted, err := s.formattingService.FormatText(text, step.Style)
+		if err != nil {
+			return nil, err
+		}
+		return textOutcome(formatted, map[string]interface{}{"style": step.Style}), nil
+
+	case PipelineStepPlugin:
+		rendered, err := renderPipelinePayload(step.Payload, data)
+		if err != nil {
+			return nil, fmt.Errorf("payload %w", err)
+		}
+		payload, _ := rendered.(map[string]interface{})
+		result, err := s.pluginService.InvokePlugin(ctx, step.PluginID, step.Method, payload, authorizationHeader)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Success {
+			return nil, fmt.Errorf("插件调用失败：%s", result.Error)
+		}
+		output := normalizePipelineValue(result.Data)
+		raw, _ := json.Marshal(output)
+		return &pipelineStepOutcome{output: output, content: string(raw), metadata: map[string]interface{}{
+			"plugin_id": step.PluginID,
+			"method":    step.Method,
+		}}, nil
+
+	case PipelineStepWriteDocument:
+		documentID, err := s.resolveDocumentID(step, data)
+		if err != nil {
+			return nil, err
+		}
+		document, err := s.documentService.GetByID(documentID)
+		if err != nil {
+			return nil, err
+		}
+		if project == nil || document.ProjectID != project.ID {
+			return nil, fmt.Errorf("文档 %d 不属于该项目", documentID)
+		}
+		content, err := input()
+		if err != nil {
+			return nil, err
+		}
+		output := map[string]interface{}{"document_id": documentID}
+		if step.Mode == WriteBackModePropose {
+			proposal, err := s.proposalService.Propose(DocumentProposalRequest{
+				DocumentID: documentID,
+				SessionID:  session.ID,
+				UserID:     session.UserID,
+				Source:     "pipeline",
+				Content:    content,
+				SetStatus:  step.SetStatus,
+				SetSummary: step.SetSummary,
+			})
+			if err != nil {
+				return nil, err
+			}
+			output["proposal_id"] = proposal.Proposal.ID
+		} else {
+			updates := map[string]interface{}{"content": content}
+			if step.SetStatus != "" {
+				updates["status"] = step.SetStatus
+			}
+			if step.SetSummary {
+				updates["summary"] = content
+			}
+			if _, err := s.documentService.Update(documentID, updates, workflowRevisionSource(session, "pipeline."+step.ID)); err != nil {
+				return nil, err
+			}
+		}
+		output = normalizePipelineValue(output).(map[string]interface{})
+		return &pipelineStepOutcome{output: output, content: content, metadata: map[string]interface{}{
+			"document_id": documentID,
+			"mode":        step.Mode,
+			"proposal_id": output["proposal_id"],
+		}}, nil
+	}
+	return nil, fmt.Errorf("不支持的步骤类型：%s", step.Type)
+}
+
+// resolveDocumentID 解析 write_document 的目标文档（数字或渲染后为数字的模板）
+func (s *pipelineService) resolveDocumentID(step *PipelineStep, data map[string]interface{}) (uint, error) {
+	switch id := step.DocumentID.(type) {
+	case float64:
+		return uint(id), nil
+	case string:
+		rendered, err := renderPipelineTemplate(id, data)
+		if err != nil {
+			return 0, fmt.Errorf("document_id %w", err)
+		}
+		parsed, err := strconv.ParseUint(strings.TrimSpace(rendered), 10, 64)
+		if err != nil || parsed == 0 {
+			return 0, fmt.Errorf("document_id 不合法：%q", rendered)
+		}
+		return uint(parsed), nil
+	}
+	return 0, fmt.Errorf("document_id 不能为空")
+}
+
+// callChat 编码对话请求并调用 AI（含上下文窗口检查、额度预检与用量记录）；执行取消时中断排队、重试等待与进行中的请求
+func (s *pipelineService) callChat(ctx context.Context, session *model.Session, provider string, chat *ChatRequest) (*AICallResult, error) {
+	path, body, err := buildChatCall(s.aiConfigService, provider, "", chat)
+	if err != nil {
+		return nil, err
+	}
+	callReq := AICallRequest{
+		Provider:  provider,
+		Path:      path,
+		Body:      body,
+		Chat:      chat,
+		Fallback:  resolveAIFallbackChain(s.projectService, session.ProjectID, provider),
+		UserID:    session.UserID,
+		SessionID: session.ID,
+	}
+	if err := s.modelService.CheckContextWindow(callReq); err != nil {
+		return nil, err
+	}
+	if err := s.usageService.CheckBeforeCall(session.UserID, provider, callReq.Fallback); err != nil {
+		return nil, err
+	}
+	result, err := callAI(ctx, s.aiConfigService, s.cacheService, callReq)
+	s.usageService.RecordCall(AIUsageScope{
+		UserID:    session.UserID,
+		ProjectID: session.ProjectID,
+		SessionID: session.ID,
+		Source:    "pipeline",
+	}, result, err)
+	return result, err
+}
+
+func (s *pipelineService) appendStep(sessionID uint, title, content, formatType string, metadata map[string]interface{}) (*model.SessionStep, error) {
+	step := &model.SessionStep{
+		Title:      title,
+		Content:    content,
+		FormatType: formatType,
+		SessionID:  sessionID,
+		Metadata:   encodeMetadata(metadata),
+	}
+	if err := s.sessionService.CreateStepAutoOrder(step); err != nil {
+		return nil, err
+	}
+	hub := sse.GetHub()
+	hub.BroadcastToSession(fmt.Sprintf("%d", sessionID), sse.NewStepAppendedEvent(map[string]interface{}{
+		"step_id":   step.ID,
+		"title":     step.Title,
+		"content":   step.Content,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}))
+	return step, nil
+}
+
+func (s *pipelineService) broadcastProgress(sessionID uint, progress int, message string) {
+	hub := sse.GetHub()
+	hub.BroadcastToSession(fmt.Sprintf("%d", sessionID), sse.NewProgressUpdatedEvent(map[string]interface{}{
+		"progress":  progress,
+		"message":   message,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}))
+}
+
+func (s *pipelineService) broadcastDone(sessionID uint) {
+	hub := sse.GetHub()
+	hub.BroadcastToSession(fmt.Sprintf("%d", sessionID), sse.NewWorkflowDoneEvent(map[string]interface{}{
+		"mode":      "pipeline",
+		"timestamp": time.Now().Format(time.RFC3339),
+	}))
+}
+
+// pipelineStepTitle 会话步骤标题：优先使用步骤 title
+func pipelineStepTitle(step *PipelineStep) string {
+	if step.Title != "" {
+		return step.Title
+	}
+	return step.ID
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -2,6 +2,8 @@
 package service
 
 import (
+	"encoding/json"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -377,3 +379,158 @@ func (s *qualityGateService) getMinParagraphLength() int {
 func (s *qualityGateService) getMaxParagraphLength() int {
 	return 800
 }
+
+// QualityGateOptions 请求级质量门禁选项
+type QualityGateOptions struct {
+	// Enabled 是否启用，未指定时按项目 ai_settings.quality_gate.enabled，其次 ai.quality_gate.enabled
+	Enabled *bool `json:"enabled"`
+	// MaxAttempts 最多生成次数（含首次），0 使用项目或全局配置
+	MaxAttempts int `json:"max_attempts"`
+}
+
+// QualityGateAttempt 单次生成的质量检查结果
+type QualityGateAttempt struct {
+	Attempt int            `json:"attempt"`
+	Score   int            `json:"score"`
+	Passed  bool           `json:"passed"`
+	Issues  []QualityIssue `json:"issues"`
+}
+
+// QualityGateReport 质量门禁循环结果
+type QualityGateReport struct {
+	MinScore    int                  `json:"min_score"`
+	MaxAttempts int                  `json:"max_attempts"`
+	Attempts    []QualityGateAttempt `json:"attempts"`
+	// BestAttempt 采用的候选（得分最高，同分取较早的一次），从 1 开始
+	BestAttempt int  `json:"best_attempt"`
+	Passed      bool `json:"passed"`
+	// Error 重新生成失败时的错误，此时采用已有候选中得分最高的一次
+	Error string `json:"error,omitempty"`
+}
+
+// qualityGateSettings 生效的质量门禁配置
+type qualityGateSettings struct {
+	Enabled     bool
+	MinScore    int
+	MaxAttempts int
+}
+
+// resolveQualityGateSettings 合并质量门禁配置：请求选项 > 项目 ai_settings.quality_gate > 全局 ai.quality_gate
+func resolveQualityGateSettings(projectService ProjectService, projectID uint, opts *QualityGateOptions) qualityGateSettings {
+	cfg := config.Get().AI.QualityGate
+	settings := qualityGateSettings{
+		Enabled:     cfg.Enabled,
+		MinScore:    cfg.MinScore,
+		MaxAttempts: cfg.MaxAttempts,
+	}
+	if projectService != nil && projectID > 0 {
+		if project, err := projectService.GetByID(projectID); err == nil && len(project.AISettings) > 0 {
+			var aiSettings struct {
+				QualityGate *struct {
+					Enabled     *bool `json:"enabled"`
+					MinScore    *int  `json:"min_score"`
+					MaxAttempts int   `json:"max_attempts"`
+				} `json:"quality_gate"`
+			}
+			if err := json.Unmarshal(project.AISettings, &aiSettings); err == nil && aiSettings.QualityGate != nil {
+				if aiSettings.QualityGate.Enabled != nil {
+					settings.Enabled = *aiSettings.QualityGate.Enabled
+				}
+				if aiSettings.QualityGate.MinScore != nil {
+					settings.MinScore = *aiSettings.QualityGate.MinScore
+				}
+				if aiSettings.QualityGate.MaxAttempts > 0 {
+					settings.MaxAttempts = aiSettings.QualityGate.MaxAttempts
+				}
+			}
+		}
+	}
+	if opts != nil {
+		if opts.Enabled != nil {
+			settings.Enabled = *opts.Enabled
+		}
+		if opts.MaxAttempts > 0 {
+			settings.MaxAttempts = opts.MaxAttempts
+		}
+	}
+	if settings.MaxAttempts > cfg.MaxAttemptsLimit {
+		settings.MaxAttempts = cfg.MaxAttemptsLimit
+	}
+	if settings.MaxAttempts < 1 {
+		settings.MaxAttempts = 1
+	}
+	return settings
+}
+
+// runQualityGate 检查首个候选，未达标时调用 regenerate 按检测到的问题重新生成，直到达标或用尽次数；
+// 每次检查后回调 record。返回采用的候选下标（0 为首个候选，与 regenerate 调用顺序一致）
+// regenerate 为空时（无法重新提示）只检查一次
+func runQualityGate(gate QualityGateService, settings qualityGateSettings, first string, regenerate func(previous string, check *QualityCheckResult) (string, error), record func(attempt QualityGateAttempt, content string)) (int, *QualityGateReport) {
+	report := &QualityGateReport{MinScore: settings.MinScore, MaxAttempts: settings.MaxAttempts}
+	best, bestScore := 0, -1
+	content := first
+	for attempt := 1; ; attempt++ {
+		check, err := gate.CheckQuality(content)
+		if err != nil {
+			report.Error = err.Error()
+			break
+		}
+		passed := check.Passed && check.Score >= settings.MinScore
+		item := QualityGateAttempt{
+			Attempt: attempt,
+			Score:   check.Score,
+			Passed:  passed,
+			Issues:  check.Issues,
+		}
+		report.Attempts = append(report.Attempts, item)
+		if record != nil {
+			record(item, content)
+		}
+		if check.Score > bestScore {
+			best, bestScore = attempt-1, check.Score
+			report.Passed = passed
+		}
+		if passed || regenerate == nil || attempt >= settings.MaxAttempts {
+			break
+		}
+
+		next, err := regenerate(content, check)
+		if err != nil {
+			logger.Warn("质量门禁重新生成失败", logger.Int("attempt", attempt+1), logger.Err(err))
+			report.Error = err.Error()
+			break
+		}
+		content = next
+	}
+	report.BestAttempt = best + 1
+	return best, report
+}
+
+// qualityRetryChat 在原请求后追加上一版正文及其质量问题，要求模型修正后输出完整正文
+func qualityRetryChat(base *ChatRequest, previous string, check *QualityCheckResult, minScore int) *ChatRequest {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "上面的正文质量评分为 %d 分，未达到 %d 分的要求，检测到以下问题：\n", check.Score, minScore)
+	for _, issue := range check.Issues {
+		fmt.Fprintf(&prompt, "- [%s] %s\n", issue.Severity, issue.Message)
+	}
+	prompt.WriteString("\n请在保持情节与设定不变的前提下修正这些问题，直接输出修改后的完整正文，不要附加说明。")
+
+	chat := base.Clone()
+	chat.Messages = append(chat.Messages,
+		ChatMessage{Role: "assistant", Content: previous},
+		ChatMessage{Role: "user", Content: prompt.String()},
+	)
+	return chat
+}
+
+// qualityAttemptMetadata 质量检查步骤的 metadata
+func qualityAttemptMetadata(attempt QualityGateAttempt, settings qualityGateSettings) map[string]interface{} {
+	return map[string]interface{}{
+		"attempt":      attempt.Attempt,
+		"score":        attempt.Score,
+		"passed":       attempt.Passed,
+		"issues":       attempt.Issues,
+		"min_score":    settings.MinScore,
+		"max_attempts": settings.MaxAttempts,
+	}
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"novel-agent-os-backend/internal/config"
+)
+
+// useTestConfig 以给定 YAML 初始化全局配置，未配置的项使用默认值
+func useTestConfig(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := config.Init(path, ""); err != nil {
+		t.Fatalf("init config: %v", err)
+	}
+}
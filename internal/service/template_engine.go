@@ -0,0 +1,463 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"novel-agent-os-backend/internal/model"
+)
+
+// 模板变量类型
+const (
+	TemplateVarString  = "string"
+	TemplateVarNumber  = "number"
+	TemplateVarBoolean = "boolean"
+	TemplateVarList    = "list"
+)
+
+const (
+	// templateRenderMaxBytes 渲染结果上限，防止循环展开出超大提示词
+	templateRenderMaxBytes = 256 * 1024
+	// templateRenderMaxEntities 未指定实体时 .entities 最多绑定的项目实体数
+	templateRenderMaxEntities = 50
+)
+
+var templateVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// templateLegacyVars 批量生成旧模板变量，渲染前改写为 .item 绑定
+var templateLegacyVars = strings.NewReplacer("{{title}}", "{{.item.title}}", "{{outline}}", "{{.item.outline}}")
+
+var errTemplateOutputTooLarge = errors.New("渲染结果超过长度上限")
+
+// TemplateValidationError 模板语法、变量声明或渲染参数不合法
+type TemplateValidationError struct {
+	Message string
+}
+
+func (e *TemplateValidationError) Error() string {
+	return e.Message
+}
+
+func newTemplateValidationError(message string) *TemplateValidationError {
+	return &TemplateValidationError{Message: message}
+}
+
+// templateFuncs 模板可用的辅助函数
+var templateFuncs = template.FuncMap{
+	// default 值为空时使用备选值：{{default "未命名" .volume.title}}
+	"default": func(fallback, value interface{}) interface{} {
+		if isEmptyTemplateValue(value) {
+			return fallback
+		}
+		return value
+	},
+	// join 拼接列表：{{join "、" .vars.keywords}}
+	"join": func(sep string, list interface{}) string {
+		value := reflect.ValueOf(list)
+		if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+			return fmt.Sprint(list)
+		}
+		parts := make([]string, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			parts = append(parts, fmt.Sprint(value.Index(i).Interface()))
+		}
+		return strings.Join(parts, sep)
+	},
+	// truncate 按字符截断：{{truncate 200 .document.content}}
+	"truncate": func(n int, text string) string {
+		runes := []rune(text)
+		if n < 0 || len(runes) <= n {
+			return text
+		}
+		return string(runes[:n]) + "…"
+	},
+	"trim": strings.TrimSpace,
+}
+
+// TemplateRenderRequest 模板渲染参数：绑定的项目/卷/章节/实体与自定义变量
+type TemplateRenderRequest struct {
+	ProjectID  uint
+	VolumeID   uint
+	DocumentID uint
+	// EntityIDs .entities 绑定的实体；为空时取章节关联实体，未指定章节时取项目实体
+	EntityIDs []uint
+	Vars      map[string]interface{}
+	// Item 批量生成的单项（.item.title / .item.outline）
+	Item map[string]string
+}
+
+// TemplateRenderResult 渲染结果
+type TemplateRenderResult struct {
+	Prompt    string                   `json:"prompt"`
+	Tokens    int                      `json:"tokens"`
+	Variables []model.TemplateVariable `json:"variables"`
+	// Vars 合并默认值后的自定义变量
+	Vars     map[string]interface{} `json:"vars"`
+	Entities int                    `json:"entities"`
+}
+
+// PromptTemplateRef 工作流请求引用的提示词模板：渲染结果作为一条消息追加到 chat
+type PromptTemplateRef struct {
+	ID        uint                   `json:"id"`
+	Vars      map[string]interface{} `json:"vars"`
+	EntityIDs []uint                 `json:"entity_ids"`
+	// Role 追加消息的角色（system/user/assistant），默认 user
+	Role string `json:"role"`
+}
+
+// IsValidPromptTemplateRole 校验模板消息角色
+func IsValidPromptTemplateRole(role string) bool {
+	switch role {
+	case "", "system", "user", "assistant":
+		return true
+	}
+	return false
+}
+
+// appendPromptTemplateMessage 复制 chat 并追加渲染后的模板消息
+func appendPromptTemplateMessage(chat *ChatRequest, ref *PromptTemplateRef, prompt string) *ChatRequest {
+	role := ref.Role
+	if role == "" {
+		role = "user"
+	}
+	out := chat.Clone()
+	out.Messages = append(out.Messages, ChatMessage{Role: role, Content: prompt})
+	return out
+}
+
+// promptTemplateMetadata 会话步骤元数据中记录的模板渲染摘要
+func promptTemplateMetadata(ref *PromptTemplateRef, rendered *TemplateRenderResult) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       ref.ID,
+		"vars":     rendered.Vars,
+		"entities": rendered.Entities,
+		"tokens":   rendered.Tokens,
+	}
+}
+
+// parsePromptTemplate 解析模板（Go text/template 语法），并拒绝可能失控的写法：
+// 嵌套定义/引用其他模板、遍历数字或函数结果
+func parsePromptTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("prompt").
+		Funcs(templateFuncs).
+		Option("missingkey=error").
+		Parse(templateLegacyVars.Replace(text))
+	if err != nil {
+		return nil, newTemplateValidationError("模板语法错误：" + err.Error())
+	}
+	if len(tmpl.Templates()) > 1 {
+		return nil, newTemplateValidationError("模板不支持 define/block")
+	}
+	if tmpl.Tree != nil {
+		if err := checkPromptTemplateNode(tmpl.Tree.Root); err != nil {
+			return nil, newTemplateValidationError(err.Error())
+		}
+	}
+	return tmpl, nil
+}
+
+func checkPromptTemplateNode(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkPromptTemplateNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.TemplateNode:
+		return fmt.Errorf("模板不支持 template 引用")
+	case *parse.IfNode:
+		return checkPromptTemplateBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return checkPromptTemplateBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		if !isRangeablePipe(n.Pipe) {
+			return fmt.Errorf("range 只能遍历字段或变量中的列表：%s", n.Pipe.String())
+		}
+		return checkPromptTemplateBranch(&n.BranchNode)
+	}
+	return nil
+}
+
+func checkPromptTemplateBranch(branch *parse.BranchNode) error {
+	if err := checkPromptTemplateNode(branch.List); err != nil {
+		return err
+	}
+	return checkPromptTemplateNode(branch.ElseList)
+}
+
+// isRangeablePipe range 的对象只能是字段（.entities、$.vars.items、$e.tags），不能是数字字面量或函数调用
+func isRangeablePipe(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode, *parse.ChainNode:
+		return true
+	case *parse.VariableNode:
+		return len(arg.Ident) > 1
+	}
+	return false
+}
+
+// executePromptTemplate 执行模板，输出超过上限时报错
+func executePromptTemplate(tmpl *template.Template, data map[string]interface{}) (string, error) {
+	out := &templateOutputLimit{max: templateRenderMaxBytes}
+	if err := tmpl.Execute(out, data); err != nil {
+		if errors.Is(err, errTemplateOutputTooLarge) {
+			return "", newTemplateValidationError(fmt.Sprintf("渲染结果超过 %d KB", templateRenderMaxBytes/1024))
+		}
+		return "", newTemplateValidationError("模板渲染失败：" + err.Error())
+	}
+	return out.buf.String(), nil
+}
+
+type templateOutputLimit struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (w *templateOutputLimit) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.max {
+		return 0, errTemplateOutputTooLarge
+	}
+	return w.buf.Write(p)
+}
+
+// decodeTemplateVariables 读取模板声明的变量
+func decodeTemplateVariables(raw []byte) []model.TemplateVariable {
+	variables := make([]model.TemplateVariable, 0)
+	if len(raw) == 0 {
+		return variables
+	}
+	_ = json.Unmarshal(raw, &variables)
+	return variables
+}
+
+// normalizeTemplateVariables 校验变量声明：名称合法且不重复，类型合法，默认值与类型匹配
+func normalizeTemplateVariables(variables []model.TemplateVariable) ([]model.TemplateVariable, error) {
+	seen := make(map[string]bool, len(variables))
+	normalized := make([]model.TemplateVariable, 0, len(variables))
+	for _, variable := range variables {
+		variable.Name = strings.TrimSpace(variable.Name)
+		if !templateVarNamePattern.MatchString(variable.Name) {
+			return nil, newTemplateValidationError(fmt.Sprintf("变量名不合法：%q", variable.Name))
+		}
+		if seen[variable.Name] {
+			return nil, newTemplateValidationError("变量重复声明：" + variable.Name)
+		}
+		seen[variable.Name] = true
+		if variable.Type == "" {
+			variable.Type = TemplateVarString
+		}
+		if variable.Default != nil {
+			value, ok := coerceTemplateVar(variable.Type, variable.Default)
+			if !ok {
+				return nil, newTemplateValidationError(fmt.Sprintf("变量 %s 的默认值不是 %s 类型", variable.Name, variable.Type))
+			}
+			variable.Default = value
+		} else if _, ok := zeroTemplateVar(variable.Type); !ok {
+			return nil, newTemplateValidationError(fmt.Sprintf("变量 %s 的类型不支持：%s", variable.Name, variable.Type))
+		}
+		normalized = append(normalized, variable)
+	}
+	return normalized, nil
+}
+
+// resolveTemplateVars 按声明合并传入值与默认值；未声明的变量、缺少必填变量或类型不符时报错
+func resolveTemplateVars(variables []model.TemplateVariable, input map[string]interface{}) (map[string]interface{}, error) {
+	declared := make(map[string]bool, len(variables))
+	for _, variable := range variables {
+		declared[variable.Name] = true
+	}
+	for name := range input {
+		if !declared[name] {
+			return nil, newTemplateValidationError("模板未声明变量：" + name)
+		}
+	}
+
+	vars := make(map[string]interface{}, len(variables))
+	for _, variable := range variables {
+		value, provided := input[variable.Name]
+		if !provided || value == nil {
+			if variable.Required && variable.Default == nil {
+				return nil, newTemplateValidationError("缺少必填变量：" + variable.Name)
+			}
+			value = variable.Default
+		}
+		if value == nil {
+			value, _ = zeroTemplateVar(variable.Type)
+			vars[variable.Name] = value
+			continue
+		}
+		coerced, ok := coerceTemplateVar(variable.Type, value)
+		if !ok {
+			return nil, newTemplateValidationError(fmt.Sprintf("变量 %s 应为 %s 类型", variable.Name, variable.Type))
+		}
+		vars[variable.Name] = coerced
+	}
+	return vars, nil
+}
+
+func coerceTemplateVar(varType string, value interface{}) (interface{}, bool) {
+	switch varType {
+	case TemplateVarString:
+		text, ok := value.(string)
+		return text, ok
+	case TemplateVarNumber:
+		switch number := value.(type) {
+		case float64:
+			return number, true
+		case int:
+			return float64(number), true
+		case json.Number:
+			parsed, err := number.Float64()
+			return parsed, err == nil
+		}
+	case TemplateVarBoolean:
+		flag, ok := value.(bool)
+		return flag, ok
+	case TemplateVarList:
+		list, ok := value.([]interface{})
+		return list, ok
+	}
+	return nil, false
+}
+
+func zeroTemplateVar(varType string) (interface{}, bool) {
+	switch varType {
+	case TemplateVarString:
+		return "", true
+	case TemplateVarNumber:
+		return float64(0), true
+	case TemplateVarBoolean:
+		return false, true
+	case TemplateVarList:
+		return []interface{}{}, true
+	}
+	return nil, false
+}
+
+func isEmptyTemplateValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Float64:
+		return v.IsZero()
+	}
+	return false
+}
+
+// 模板绑定：字段名与接口返回的 JSON 字段一致；未指定的对象绑定为空值，{{if .volume.title}} 可直接判断
+
+func projectTemplateBinding(project *model.Project) map[string]interface{} {
+	if project == nil {
+		project = &model.Project{}
+	}
+	var tags []string
+	if len(project.Tags) > 0 {
+		_ = json.Unmarshal(project.Tags, &tags)
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	return map[string]interface{}{
+		"id":             project.ID,
+		"title":          project.Title,
+		"genre":          project.Genre,
+		"tags":           tags,
+		"core_conflict":  project.CoreConflict,
+		"character_arc":  project.CharacterArc,
+		"ultimate_value": project.UltimateValue,
+		"world_rules":    project.WorldRules,
+	}
+}
+
+func volumeTemplateBinding(volume *model.Volume) map[string]interface{} {
+	if volume == nil {
+		volume = &model.Volume{}
+	}
+	return map[string]interface{}{
+		"id":                       volume.ID,
+		"title":                    volume.Title,
+		"order_index":              volume.OrderIndex,
+		"theme":                    volume.Theme,
+		"core_goal":                volume.CoreGoal,
+		"boundaries":               volume.Boundaries,
+		"chapter_linkage_logic":    volume.ChapterLinkageLogic,
+		"volume_specific_settings": volume.VolumeSpecificSettings,
+		"plot_roadmap":             volume.PlotRoadmap,
+	}
+}
+
+func documentTemplateBinding(doc *model.Document) map[string]interface{} {
+	if doc == nil {
+		doc = &model.Document{}
+	}
+	return map[string]interface{}{
+		"id":                    doc.ID,
+		"title":                 doc.Title,
+		"content":               doc.Content,
+		"summary":               doc.Summary,
+		"status":                doc.Status,
+		"order_index":           doc.OrderIndex,
+		"time_node":             doc.TimeNode,
+		"duration":              doc.Duration,
+		"target_word_count":     doc.TargetWordCount,
+		"chapter_goal":          doc.ChapterGoal,
+		"core_plot":             doc.CorePlot,
+		"hook":                  doc.Hook,
+		"cause_effect":          doc.CauseEffect,
+		"foreshadowing_details": doc.ForeshadowingDetails,
+	}
+}
+
+func entityTemplateBinding(entity model.Entity) map[string]interface{} {
+	tags := make([]string, 0, len(entity.Tags))
+	for _, tag := range entity.Tags {
+		tags = append(tags, tag.Tag)
+	}
+	fields := make(map[string]interface{})
+	var customFields []model.EntityCustomField
+	if len(entity.CustomFields) > 0 && json.Unmarshal(entity.CustomFields, &customFields) == nil {
+		for _, field := range customFields {
+			fields[field.Key] = field.Value
+		}
+	}
+	return map[string]interface{}{
+		"id":              entity.ID,
+		"entity_type":     entity.EntityType,
+		"title":           entity.Title,
+		"subtitle":        entity.Subtitle,
+		"content":         entity.Content,
+		"voice_style":     entity.VoiceStyle,
+		"importance":      entity.Importance,
+		"reference_count": entity.ReferenceCount,
+		"tags":            tags,
+		"fields":          fields,
+	}
+}
+
+func itemTemplateBinding(item map[string]string) map[string]interface{} {
+	binding := map[string]interface{}{"title": "", "outline": ""}
+	for key, value := range item {
+		binding[key] = value
+	}
+	return binding
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	stderrors "errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"novel-agent-os-backend/internal/model"
+)
+
+func renderTestTemplate(t *testing.T, text string, data map[string]interface{}) (string, error) {
+	t.Helper()
+	tmpl, err := parsePromptTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	return executePromptTemplate(tmpl, data)
+}
+
+func TestParsePromptTemplateRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{name: "syntax error", text: "{{if .project.title}}未闭合"},
+		{name: "define", text: `{{define "x"}}a{{end}}{{template "x"}}`},
+		{name: "block", text: `{{block "x" .}}a{{end}}`},
+		{name: "template reference", text: `{{template "prompt" .}}`},
+		{name: "range number", text: "{{range 100000}}x{{end}}"},
+		{name: "range function result", text: `{{range (join "," .vars.items)}}x{{end}}`},
+		{name: "range root variable", text: "{{range $}}x{{end}}"},
+		{name: "nested in if", text: "{{if .vars.on}}{{range 10}}x{{end}}{{end}}"},
+		{name: "nested in else", text: `{{with .vars.on}}a{{else}}{{template "prompt"}}{{end}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePromptTemplate(tt.text)
+			var validationErr *TemplateValidationError
+			if !stderrors.As(err, &validationErr) {
+				t.Errorf("err = %v, want TemplateValidationError", err)
+			}
+		})
+	}
+}
+
+func TestExecutePromptTemplate(t *testing.T) {
+	data := map[string]interface{}{
+		"project": map[string]interface{}{"title": "星海", "tags": []string{"科幻", "群像"}},
+		"volume":  map[string]interface{}{"title": ""},
+		"item":    map[string]interface{}{"title": "第一章", "outline": "启程"},
+		"entities": []map[string]interface{}{
+			{"title": "林舟", "tags": []string{"主角"}},
+			{"title": "白鸦", "tags": []string{}},
+		},
+		"vars": map[string]interface{}{
+			"style":    "  冷峻  ",
+			"keywords": []interface{}{"雨", "灯塔"},
+			"long":     "一二三四五六",
+		},
+	}
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "field", text: "《{{.project.title}}》", want: "《星海》"},
+		{name: "default on empty", text: `{{default "未命名" .volume.title}}`, want: "未命名"},
+		{name: "default keeps value", text: `{{default "未命名" .project.title}}`, want: "星海"},
+		{name: "join", text: `{{join "、" .vars.keywords}}`, want: "雨、灯塔"},
+		{name: "join non list", text: `{{join "、" .project.title}}`, want: "星海"},
+		{name: "truncate", text: "{{truncate 3 .vars.long}}", want: "一二三…"},
+		{name: "truncate short", text: "{{truncate 10 .vars.long}}", want: "一二三四五六"},
+		{name: "trim", text: "[{{trim .vars.style}}]", want: "[冷峻]"},
+		{name: "legacy vars", text: "{{title}}：{{outline}}", want: "第一章：启程"},
+		{name: "range entities", text: "{{range .entities}}{{.title}}{{if .tags}}({{join \",\" .tags}}){{end}};{{end}}", want: "林舟(主角);白鸦;"},
+		{name: "range root vars", text: "{{range $k := $.vars.keywords}}<{{$k}}>{{end}}", want: "<雨><灯塔>"},
+		{name: "if else", text: "{{if .volume.title}}有卷{{else}}无卷{{end}}", want: "无卷"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderTestTemplate(t, tt.text, data)
+			if err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutePromptTemplateErrors(t *testing.T) {
+	t.Run("missing key", func(t *testing.T) {
+		_, err := renderTestTemplate(t, "{{.vars.unknown}}", map[string]interface{}{"vars": map[string]interface{}{}})
+		var validationErr *TemplateValidationError
+		if !stderrors.As(err, &validationErr) || !strings.Contains(validationErr.Message, "模板渲染失败") {
+			t.Errorf("err = %v, want render failure", err)
+		}
+	})
+
+	t.Run("output limit", func(t *testing.T) {
+		items := make([]interface{}, templateRenderMaxBytes/1024+1)
+		data := map[string]interface{}{"vars": map[string]interface{}{
+			"items": items,
+			"block": strings.Repeat("x", 1024),
+		}}
+		_, err := renderTestTemplate(t, "{{range .vars.items}}{{$.vars.block}}{{end}}", data)
+		var validationErr *TemplateValidationError
+		if !stderrors.As(err, &validationErr) || !strings.Contains(validationErr.Message, "渲染结果超过") {
+			t.Errorf("err = %v, want output limit error", err)
+		}
+	})
+}
+
+func TestNormalizeTemplateVariables(t *testing.T) {
+	tests := []struct {
+		name      string
+		variables []model.TemplateVariable
+		want      []model.TemplateVariable
+		wantErr   bool
+	}{
+		{
+			name:      "default type and coerced default",
+			variables: []model.TemplateVariable{{Name: " style "}, {Name: "count", Type: TemplateVarNumber, Default: 3}},
+			want:      []model.TemplateVariable{{Name: "style", Type: TemplateVarString}, {Name: "count", Type: TemplateVarNumber, Default: float64(3)}},
+		},
+		{name: "invalid name", variables: []model.TemplateVariable{{Name: "1st"}}, wantErr: true},
+		{name: "duplicate", variables: []model.TemplateVariable{{Name: "a"}, {Name: "a"}}, wantErr: true},
+		{name: "unknown type", variables: []model.TemplateVariable{{Name: "a", Type: "date"}}, wantErr: true},
+		{name: "default type mismatch", variables: []model.TemplateVariable{{Name: "a", Type: TemplateVarBoolean, Default: "yes"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeTemplateVariables(tt.variables)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveTemplateVars(t *testing.T) {
+	variables := []model.TemplateVariable{
+		{Name: "style", Type: TemplateVarString, Default: "白描"},
+		{Name: "words", Type: TemplateVarNumber, Required: true},
+		{Name: "dialogue", Type: TemplateVarBoolean},
+		{Name: "keywords", Type: TemplateVarList},
+	}
+	tests := []struct {
+		name    string
+		input   map[string]interface{}
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name:  "defaults and zero values",
+			input: map[string]interface{}{"words": 3000},
+			want:  map[string]interface{}{"style": "白描", "words": float64(3000), "dialogue": false, "keywords": []interface{}{}},
+		},
+		{
+			name:  "provided values",
+			input: map[string]interface{}{"style": "意识流", "words": float64(2000), "dialogue": true, "keywords": []interface{}{"雨"}},
+			want:  map[string]interface{}{"style": "意识流", "words": float64(2000), "dialogue": true, "keywords": []interface{}{"雨"}},
+		},
+		{name: "missing required", input: map[string]interface{}{}, wantErr: "缺少必填变量"},
+		{name: "undeclared", input: map[string]interface{}{"words": 1, "mood": "x"}, wantErr: "模板未声明变量"},
+		{name: "type mismatch", input: map[string]interface{}{"words": "很多"}, wantErr: "应为 number 类型"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveTemplateVars(variables, tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolve: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package service
 
 import (
+	"encoding/json"
+	"sort"
+
 	"novel-agent-os-backend/internal/model"
 	"novel-agent-os-backend/internal/repository"
 	"novel-agent-os-backend/pkg/errors"
@@ -9,31 +12,39 @@ import (
 
 // TemplateService 模板服务接口
 type TemplateService interface {
-	Create(projectID uint, name, description, category, template string) (*model.Template, error)
+	Create(projectID uint, name, description, category, template string, variables []model.TemplateVariable) (*model.Template, error)
 	GetByID(id uint) (*model.Template, error)
 	ListByProjectID(projectID uint, page, size int) ([]*model.Template, int64, error)
 	ListSystemTemplates(page, size int) ([]*model.Template, int64, error)
 	ListByCategory(projectID uint, category string, page, size int) ([]*model.Template, int64, error)
 	Update(id uint, updates map[string]interface{}) (*model.Template, error)
 	Delete(id uint) error
+	// Render 绑定项目/卷/章节/实体与自定义变量后渲染模板，返回最终提示词
+	Render(id uint, req TemplateRenderRequest) (*TemplateRenderResult, error)
 }
 
 // templateService 模板服务实现
 type templateService struct {
 	templateRepo repository.TemplateRepository
 	projectRepo  repository.ProjectRepository
+	volumeRepo   repository.VolumeRepository
+	documentRepo repository.DocumentRepository
+	entityRepo   repository.EntityRepository
 }
 
 // NewTemplateService 创建模板服务实例
-func NewTemplateService(templateRepo repository.TemplateRepository, projectRepo repository.ProjectRepository) TemplateService {
+func NewTemplateService(templateRepo repository.TemplateRepository, projectRepo repository.ProjectRepository, volumeRepo repository.VolumeRepository, documentRepo repository.DocumentRepository, entityRepo repository.EntityRepository) TemplateService {
 	return &templateService{
 		templateRepo: templateRepo,
 		projectRepo:  projectRepo,
+		volumeRepo:   volumeRepo,
+		documentRepo: documentRepo,
+		entityRepo:   entityRepo,
 	}
 }
 
 // Create 创建模板
-func (s *templateService) Create(projectID uint, name, description, category, template string) (*model.Template, error) {
+func (s *templateService) Create(projectID uint, name, description, category, template string, variables []model.TemplateVariable) (*model.Template, error) {
 	// 验证项目是否存在（projectID为0表示系统模板）
 	if projectID > 0 {
 		_, err := s.projectRepo.FindByID(projectID)
@@ -53,12 +64,22 @@ func (s *templateService) Create(projectID uint, name, description, category, te
 		return nil, errors.ErrValidationError
 	}
 
+	// 验证模板语法与变量声明
+	if _, err := parsePromptTemplate(template); err != nil {
+		return nil, err
+	}
+	variablesJSON, err := encodeTemplateVariables(variables)
+	if err != nil {
+		return nil, err
+	}
+
 	tmpl := &model.Template{
 		ProjectID:   projectID,
 		Name:        name,
 		Description: description,
 		Category:    category,
 		Template:    template,
+		Variables:   variablesJSON,
 	}
 
 	if err := s.templateRepo.Create(tmpl); err != nil {
@@ -123,8 +144,18 @@ func (s *templateService) Update(id uint, updates map[string]interface{}) (*mode
 		template.Category = category
 	}
 	if tmpl, ok := updates["template"].(string); ok {
+		if _, err := parsePromptTemplate(tmpl); err != nil {
+			return nil, err
+		}
 		template.Template = tmpl
 	}
+	if variables, ok := updates["variables"].([]model.TemplateVariable); ok {
+		variablesJSON, err := encodeTemplateVariables(variables)
+		if err != nil {
+			return nil, err
+		}
+		template.Variables = variablesJSON
+	}
 
 	if err := s.templateRepo.Update(template); err != nil {
 		logger.Error("更新模板失败", logger.Err(err))
@@ -148,3 +179,136 @@ func (s *templateService) Delete(id uint) error {
 
 	return nil
 }
+
+// Render 渲染模板：项目模板只能绑定所属项目，卷/章节/实体须属于绑定的项目
+func (s *templateService) Render(id uint, req TemplateRenderRequest) (*TemplateRenderResult, error) {
+	tmpl, err := s.templateRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.ErrTemplateNotFound
+	}
+	if tmpl.ProjectID != 0 && tmpl.ProjectID != req.ProjectID {
+		return nil, errors.ErrForbidden
+	}
+	if req.ProjectID == 0 && (req.VolumeID > 0 || req.DocumentID > 0 || len(req.EntityIDs) > 0) {
+		return nil, newTemplateValidationError("绑定卷、章节或实体时需要指定 project_id")
+	}
+
+	parsed, err := parsePromptTemplate(tmpl.Template)
+	if err != nil {
+		return nil, err
+	}
+	variables := decodeTemplateVariables(tmpl.Variables)
+	vars, err := resolveTemplateVars(variables, req.Vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var project *model.Project
+	if req.ProjectID > 0 {
+		if project, err = s.projectRepo.FindByID(req.ProjectID); err != nil {
+			return nil, errors.ErrProjectNotFound
+		}
+	}
+	var doc *model.Document
+	if req.DocumentID > 0 {
+		if doc, err = s.documentRepo.FindByID(req.DocumentID); err != nil {
+			return nil, errors.ErrDocumentNotFound
+		}
+		if doc.ProjectID != req.ProjectID {
+			return nil, errors.ErrForbidden
+		}
+	}
+	volumeID := req.VolumeID
+	if volumeID == 0 && doc != nil {
+		volumeID = doc.VolumeID
+	}
+	var volume *model.Volume
+	if volumeID > 0 {
+		if volume, err = s.volumeRepo.FindByID(volumeID); err != nil {
+			return nil, errors.ErrVolumeNotFound
+		}
+		if volume.ProjectID != req.ProjectID {
+			return nil, errors.ErrForbidden
+		}
+	}
+	entities, err := s.renderEntities(req, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	entityBindings := make([]map[string]interface{}, 0, len(entities))
+	for _, entity := range entities {
+		entityBindings = append(entityBindings, entityTemplateBinding(entity))
+	}
+	prompt, err := executePromptTemplate(parsed, map[string]interface{}{
+		"project":  projectTemplateBinding(project),
+		"volume":   volumeTemplateBinding(volume),
+		"document": documentTemplateBinding(doc),
+		"entities": entityBindings,
+		"vars":     vars,
+		"item":     itemTemplateBinding(req.Item),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TemplateRenderResult{
+		Prompt:    prompt,
+		Tokens:    estimateTokens(prompt),
+		Variables: variables,
+		Vars:      vars,
+		Entities:  len(entities),
+	}, nil
+}
+
+// renderEntities .entities 的绑定范围：指定实体 > 章节关联实体 > 项目实体（按重要程度与引用次数排序）
+func (s *templateService) renderEntities(req TemplateRenderRequest, doc *model.Document) ([]model.Entity, error) {
+	entities := make([]model.Entity, 0)
+	switch {
+	case len(req.EntityIDs) > 0:
+		for _, entityID := range req.EntityIDs {
+			entity, err := s.entityRepo.FindByID(entityID)
+			if err != nil {
+				return nil, errors.ErrEntityNotFound
+			}
+			if entity.ProjectID != req.ProjectID {
+				return nil, errors.ErrForbidden
+			}
+			entities = append(entities, *entity)
+		}
+		return entities, nil
+	case doc != nil:
+		refs, err := s.documentRepo.GetEntityRefs(doc.ID)
+		if err != nil {
+			logger.Error("获取章节关联实体失败", logger.Err(err))
+			return nil, errors.ErrInternalServer
+		}
+		return rankContextEntities(refs, req.ProjectID), nil
+	case req.ProjectID > 0:
+		items, _, err := s.entityRepo.FindByProjectID(req.ProjectID, 1, templateRenderMaxEntities)
+		if err != nil {
+			logger.Error("获取项目实体失败", logger.Err(err))
+			return nil, errors.ErrInternalServer
+		}
+		for _, item := range items {
+			entities = append(entities, *item)
+		}
+		sort.SliceStable(entities, func(i, j int) bool {
+			return contextEntityScore(entities[i]) > contextEntityScore(entities[j])
+		})
+	}
+	return entities, nil
+}
+
+// encodeTemplateVariables 校验并序列化变量声明
+func encodeTemplateVariables(variables []model.TemplateVariable) ([]byte, error) {
+	normalized, err := normalizeTemplateVariables(variables)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(normalized)
+	if err != nil {
+		return nil, errors.ErrInternalServer
+	}
+	return raw, nil
+}
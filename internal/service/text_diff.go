@@ -0,0 +1,374 @@
+package service
+
+// 差异片段类型
+const (
+	DiffOpEqual  = "equal"
+	DiffOpInsert = "insert"
+	DiffOpDelete = "delete"
+)
+
+const (
+	// diffCharMaxBlock 逐字比对的最大块长度（两侧字符数之和），超出时先按行比对
+	diffCharMaxBlock = 2000
+	// diffMaxEdits Myers 算法的最大编辑距离，超出时该块整体记为删除 + 插入
+	diffMaxEdits = 1500
+	// diffMaxLines 按行比对的最大总行数
+	diffMaxLines = 20000
+)
+
+// DiffSegment 字符级差异片段
+type DiffSegment struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffStats 差异统计（按字符计）
+type DiffStats struct {
+	Inserted  int `json:"inserted"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+}
+
+// DiffText 计算 a 到 b 的字符级差异：去掉公共前后缀后，短文本逐字比对；
+// 长文本先按行比对，再对相邻的删除/插入行块逐字比对
+func DiffText(a, b string) ([]DiffSegment, DiffStats) {
+	ar, br := []rune(a), []rune(b)
+
+	prefix := 0
+	for prefix < len(ar) && prefix < len(br) && ar[prefix] == br[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(ar)-prefix && suffix < len(br)-prefix && ar[len(ar)-1-suffix] == br[len(br)-1-suffix] {
+		suffix++
+	}
+
+	builder := &diffBuilder{}
+	builder.add(DiffOpEqual, ar[:prefix])
+	diffMiddle(builder, ar[prefix:len(ar)-suffix], br[prefix:len(br)-suffix])
+	builder.add(DiffOpEqual, ar[len(ar)-suffix:])
+	return builder.segments, builder.stats
+}
+
+func diffMiddle(builder *diffBuilder, a, b []rune) {
+	if len(a) == 0 || len(b) == 0 || len(a)+len(b) <= diffCharMaxBlock {
+		diffRunes(builder, a, b)
+		return
+	}
+
+	aLines, bLines := splitDiffLines(a), splitDiffLines(b)
+	if len(aLines)+len(bLines) > diffMaxLines {
+		builder.add(DiffOpDelete, a)
+		builder.add(DiffOpInsert, b)
+		return
+	}
+	ids := make(map[string]int)
+	encode := func(lines [][]rune) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			key := string(line)
+			id, ok := ids[key]
+			if !ok {
+				id = len(ids)
+				ids[key] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	ops, ok := myersDiff(encode(aLines), encode(bLines), diffMaxEdits)
+	if !ok {
+		builder.add(DiffOpDelete, a)
+		builder.add(DiffOpInsert, b)
+		return
+	}
+
+	var deleted, inserted []rune
+	flush := func() {
+		if len(deleted) > 0 && len(inserted) > 0 {
+			diffRunes(builder, deleted, inserted)
+		} else {
+			builder.add(DiffOpDelete, deleted)
+			builder.add(DiffOpInsert, inserted)
+		}
+		deleted, inserted = nil, nil
+	}
+	for _, op := range ops {
+		switch op.kind {
+		case DiffOpDelete:
+			deleted = append(deleted, aLines[op.index]...)
+		case DiffOpInsert:
+			inserted = append(inserted, bLines[op.index]...)
+		default:
+			flush()
+			builder.add(DiffOpEqual, aLines[op.index])
+		}
+	}
+	flush()
+}
+
+// diffRunes 逐字比对；块过长或编辑距离过大时整体记为删除 + 插入
+func diffRunes(builder *diffBuilder, a, b []rune) {
+	if len(a) == 0 || len(b) == 0 || len(a)+len(b) > diffCharMaxBlock {
+		builder.add(DiffOpDelete, a)
+		builder.add(DiffOpInsert, b)
+		return
+	}
+	ai, bi := make([]int, len(a)), make([]int, len(b))
+	for i, r := range a {
+		ai[i] = int(r)
+	}
+	for i, r := range b {
+		bi[i] = int(r)
+	}
+	ops, ok := myersDiff(ai, bi, diffMaxEdits)
+	if !ok {
+		builder.add(DiffOpDelete, a)
+		builder.add(DiffOpInsert, b)
+		return
+	}
+	for _, op := range ops {
+		if op.kind == DiffOpInsert {
+			builder.add(op.kind, b[op.index:op.index+1])
+		} else {
+			builder.add(op.kind, a[op.index:op.index+1])
+		}
+	}
+}
+
+// splitDiffLines 按行切分并保留换行符
+func splitDiffLines(text []rune) [][]rune {
+	var lines [][]rune
+	start := 0
+	for i, r := range text {
+		if r == '\n' {
+			lines = append(lines, text[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(text) {
+		lines = append(lines, text[start:])
+	}
+	return lines
+}
+
+type diffOp struct {
+	kind  string
+	index int // equal/delete 为 a 中下标，insert 为 b 中下标
+}
+
+// myersDiff Myers O(ND) 差异算法；编辑距离超过 maxEdits 时返回 false
+func myersDiff(a, b []int, maxEdits int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	if maxEdits > n+m {
+		maxEdits = n + m
+	}
+	offset := maxEdits + 1
+	v := make([]int32, 2*maxEdits+3)
+	trace := make([][]int32, 0, 16)
+
+	found := false
+	for d := 0; d <= maxEdits && !found; d++ {
+		// 保存本轮开始前 k ∈ [-d-1, d+1] 的状态供回溯
+		snapshot := make([]int32, 2*d+3)
+		copy(snapshot, v[offset-d-1:offset+d+2])
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = int(v[offset+k+1])
+			} else {
+				x = int(v[offset+k-1]) + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = int32(x)
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	ops := make([]diffOp, 0, n+m)
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		snapshot := trace[d]
+		at := func(k int) int { return int(snapshot[k+d+1]) }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{kind: DiffOpEqual, index: x})
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{kind: DiffOpInsert, index: prevY})
+			} else {
+				ops = append(ops, diffOp{kind: DiffOpDelete, index: prevX})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
+
+// diffBuilder 合并相邻同类片段并统计字符数
+type diffBuilder struct {
+	segments []DiffSegment
+	stats    DiffStats
+}
+
+func (b *diffBuilder) add(op string, text []rune) {
+	if len(text) == 0 {
+		return
+	}
+	switch op {
+	case DiffOpInsert:
+		b.stats.Inserted += len(text)
+	case DiffOpDelete:
+		b.stats.Deleted += len(text)
+	default:
+		b.stats.Unchanged += len(text)
+	}
+	if n := len(b.segments); n > 0 && b.segments[n-1].Op == op {
+		b.segments[n-1].Text += string(text)
+		return
+	}
+	b.segments = append(b.segments, DiffSegment{Op: op, Text: string(text)})
+}
+
+// ParagraphHunk 段落级改动块：基准正文从第 Start 段起的 Before 被替换为 After（段落按行切分，含换行符）
+type ParagraphHunk struct {
+	Index  int    `json:"index"`
+	Start  int    `json:"start"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// paragraphBlock 段落比对结果中的一段：相同段落或改动块
+type paragraphBlock struct {
+	changed bool
+	lines   int // 基准正文中的段落数
+	before  []rune
+	after   []rune
+}
+
+// ParagraphHunks 计算 a 到 b 的段落级改动块
+func ParagraphHunks(a, b string) []ParagraphHunk {
+	hunks := make([]ParagraphHunk, 0)
+	start := 0
+	for _, block := range diffParagraphs(a, b) {
+		if block.changed {
+			hunks = append(hunks, ParagraphHunk{
+				Index:  len(hunks),
+				Start:  start,
+				Before: string(block.before),
+				After:  string(block.after),
+			})
+		}
+		start += block.lines
+	}
+	return hunks
+}
+
+// ApplyParagraphHunks 在 a 上只应用选中的改动块（下标同 ParagraphHunks），未选中的保留原文
+func ApplyParagraphHunks(a, b string, selected map[int]bool) string {
+	var out []rune
+	index := 0
+	for _, block := range diffParagraphs(a, b) {
+		if !block.changed {
+			out = append(out, block.before...)
+			continue
+		}
+		if selected[index] {
+			out = append(out, block.after...)
+		} else {
+			out = append(out, block.before...)
+		}
+		index++
+	}
+	return string(out)
+}
+
+// diffParagraphs 按行比对；行数或编辑距离超限时整体作为一个改动块
+func diffParagraphs(a, b string) []paragraphBlock {
+	if a == b {
+		if a == "" {
+			return nil
+		}
+		ar := []rune(a)
+		return []paragraphBlock{{lines: len(splitDiffLines(ar)), before: ar, after: ar}}
+	}
+	ar, br := []rune(a), []rune(b)
+	aLines, bLines := splitDiffLines(ar), splitDiffLines(br)
+	whole := []paragraphBlock{{changed: true, lines: len(aLines), before: ar, after: br}}
+	if len(aLines)+len(bLines) > diffMaxLines {
+		return whole
+	}
+
+	ids := make(map[string]int)
+	encode := func(lines [][]rune) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			key := string(line)
+			id, ok := ids[key]
+			if !ok {
+				id = len(ids)
+				ids[key] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	ops, ok := myersDiff(encode(aLines), encode(bLines), diffMaxEdits)
+	if !ok {
+		return whole
+	}
+
+	var blocks []paragraphBlock
+	push := func(changed bool, line []rune, inBase bool) {
+		n := len(blocks)
+		if n == 0 || blocks[n-1].changed != changed {
+			blocks = append(blocks, paragraphBlock{changed: changed})
+			n++
+		}
+		block := &blocks[n-1]
+		if inBase {
+			block.lines++
+			block.before = append(block.before, line...)
+		}
+		if !changed || !inBase {
+			block.after = append(block.after, line...)
+		}
+	}
+	for _, op := range ops {
+		switch op.kind {
+		case DiffOpDelete:
+			push(true, aLines[op.index], true)
+		case DiffOpInsert:
+			push(true, bLines[op.index], false)
+		default:
+			push(false, aLines[op.index], true)
+		}
+	}
+	return blocks
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// replayDiff 由差异片段还原两侧文本
+func replayDiff(segments []DiffSegment) (string, string) {
+	var a, b strings.Builder
+	for _, seg := range segments {
+		switch seg.Op {
+		case DiffOpDelete:
+			a.WriteString(seg.Text)
+		case DiffOpInsert:
+			b.WriteString(seg.Text)
+		default:
+			a.WriteString(seg.Text)
+			b.WriteString(seg.Text)
+		}
+	}
+	return a.String(), b.String()
+}
+
+func TestDiffText(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		segments []DiffSegment
+		stats    DiffStats
+	}{
+		{
+			name: "identical",
+			a:    "夜色渐深。",
+			b:    "夜色渐深。",
+			segments: []DiffSegment{
+				{Op: DiffOpEqual, Text: "夜色渐深。"},
+			},
+			stats: DiffStats{Unchanged: 5},
+		},
+		{
+			name: "both empty",
+		},
+		{
+			name:     "insert into empty",
+			b:        "新段落",
+			segments: []DiffSegment{{Op: DiffOpInsert, Text: "新段落"}},
+			stats:    DiffStats{Inserted: 3},
+		},
+		{
+			name:     "delete everything",
+			a:        "旧段落",
+			segments: []DiffSegment{{Op: DiffOpDelete, Text: "旧段落"}},
+			stats:    DiffStats{Deleted: 3},
+		},
+		{
+			name: "replace middle character",
+			a:    "他走进房间。",
+			b:    "她走进房间。",
+			segments: []DiffSegment{
+				{Op: DiffOpDelete, Text: "他"},
+				{Op: DiffOpInsert, Text: "她"},
+				{Op: DiffOpEqual, Text: "走进房间。"},
+			},
+			stats: DiffStats{Inserted: 1, Deleted: 1, Unchanged: 5},
+		},
+		{
+			name: "insert inside",
+			a:    "abcd",
+			b:    "abXYcd",
+			segments: []DiffSegment{
+				{Op: DiffOpEqual, Text: "ab"},
+				{Op: DiffOpInsert, Text: "XY"},
+				{Op: DiffOpEqual, Text: "cd"},
+			},
+			stats: DiffStats{Inserted: 2, Unchanged: 4},
+		},
+		{
+			name: "scattered edits",
+			a:    "abcabba",
+			b:    "cbabac",
+			stats: DiffStats{
+				Inserted:  2,
+				Deleted:   3,
+				Unchanged: 4,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments, stats := DiffText(tt.a, tt.b)
+			if tt.segments != nil && !reflect.DeepEqual(segments, tt.segments) {
+				t.Errorf("segments = %+v, want %+v", segments, tt.segments)
+			}
+			if stats != tt.stats {
+				t.Errorf("stats = %+v, want %+v", stats, tt.stats)
+			}
+			if a, b := replayDiff(segments); a != tt.a || b != tt.b {
+				t.Errorf("replay = (%q, %q), want (%q, %q)", a, b, tt.a, tt.b)
+			}
+		})
+	}
+}
+
+func TestDiffTextLongTextDiffsByLine(t *testing.T) {
+	// 首尾都有改动，去掉公共前后缀后仍超过 diffCharMaxBlock：先按行比对，只有改动的行逐字比对
+	line := strings.Repeat("字", 40) + "\n"
+	body := func(who string) string {
+		return strings.Repeat(line, 30) + who + "走进房间。\n" + strings.Repeat(line, 30)
+	}
+	a := "甲" + body("他") + "甲"
+	b := "乙" + body("她") + "乙"
+	if len([]rune(a))+len([]rune(b)) <= diffCharMaxBlock {
+		t.Fatal("test input must exceed diffCharMaxBlock")
+	}
+
+	segments, stats := DiffText(a, b)
+	if stats.Inserted != 3 || stats.Deleted != 3 {
+		t.Errorf("stats = %+v, want three single characters replaced", stats)
+	}
+	if ra, rb := replayDiff(segments); ra != a || rb != b {
+		t.Error("replay does not reproduce inputs")
+	}
+}
+
+func TestDiffTextEditLimit(t *testing.T) {
+	// 逐字比对的编辑距离超过 diffMaxEdits 时整体记为删除 + 插入
+	a := strings.Repeat("甲", diffMaxEdits/2+100)
+	b := strings.Repeat("乙", diffMaxEdits/2+100)
+	if len([]rune(a))+len([]rune(b)) > diffCharMaxBlock {
+		t.Fatal("test input must stay within diffCharMaxBlock")
+	}
+	segments, _ := DiffText(a, b)
+	want := []DiffSegment{{Op: DiffOpDelete, Text: a}, {Op: DiffOpInsert, Text: b}}
+	if !reflect.DeepEqual(segments, want) {
+		t.Errorf("segments = %d items, want whole delete + insert", len(segments))
+	}
+}
+
+func TestDiffTextCharBlockLimit(t *testing.T) {
+	// 改动的行块超过 diffCharMaxBlock 时不逐字比对，整体记为删除 + 插入
+	a := strings.Repeat("甲", diffCharMaxBlock/2+1)
+	b := strings.Repeat("乙", diffCharMaxBlock/2+1)
+	segments, stats := DiffText(a, b)
+	want := []DiffSegment{{Op: DiffOpDelete, Text: a}, {Op: DiffOpInsert, Text: b}}
+	if !reflect.DeepEqual(segments, want) {
+		t.Errorf("segments = %d items, want whole delete + insert", len(segments))
+	}
+	if stats.Deleted != len([]rune(a)) || stats.Inserted != len([]rune(b)) || stats.Unchanged != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestDiffTextLineLimit(t *testing.T) {
+	// 两侧总行数超过 diffMaxLines 时不再比对，中间部分整体替换
+	a := "开头\n" + strings.Repeat("a\n", diffMaxLines/2+1) + "结尾\n"
+	b := "开头\n" + strings.Repeat("b\n", diffMaxLines/2+1) + "结尾\n"
+	segments, stats := DiffText(a, b)
+	var ops []string
+	for _, seg := range segments {
+		ops = append(ops, seg.Op)
+	}
+	if want := []string{DiffOpEqual, DiffOpDelete, DiffOpInsert, DiffOpEqual}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("ops = %v, want %v", ops, want)
+	}
+	// 只有公共前缀“开头\n”与公共后缀“\n结尾\n”保持不变
+	if stats.Unchanged != 7 {
+		t.Errorf("unchanged = %d, want 7", stats.Unchanged)
+	}
+	if ra, rb := replayDiff(segments); ra != a || rb != b {
+		t.Error("replay does not reproduce inputs")
+	}
+}
+
+func TestMyersDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     []int
+		maxEdits int
+		edits    int
+		ok       bool
+	}{
+		{name: "equal", a: []int{1, 2, 3}, b: []int{1, 2, 3}, maxEdits: 10, edits: 0, ok: true},
+		{name: "empty a", a: nil, b: []int{1, 2}, maxEdits: 10, edits: 2, ok: true},
+		{name: "empty b", a: []int{1, 2}, b: nil, maxEdits: 10, edits: 2, ok: true},
+		{name: "classic", a: []int{1, 2, 3, 1, 2, 2, 1}, b: []int{3, 2, 1, 2, 1, 3}, maxEdits: 10, edits: 5, ok: true},
+		{name: "at limit", a: []int{1, 2}, b: []int{3, 4}, maxEdits: 4, edits: 4, ok: true},
+		{name: "over limit", a: []int{1, 2, 3}, b: []int{4, 5, 6}, maxEdits: 5, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, ok := myersDiff(tt.a, tt.b, tt.maxEdits)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			var gotA, gotB []int
+			edits := 0
+			for _, op := range ops {
+				switch op.kind {
+				case DiffOpDelete:
+					gotA = append(gotA, tt.a[op.index])
+					edits++
+				case DiffOpInsert:
+					gotB = append(gotB, tt.b[op.index])
+					edits++
+				default:
+					gotA = append(gotA, tt.a[op.index])
+					gotB = append(gotB, tt.a[op.index])
+				}
+			}
+			if edits != tt.edits {
+				t.Errorf("edits = %d, want %d", edits, tt.edits)
+			}
+			if !reflect.DeepEqual(gotA, tt.a) || !reflect.DeepEqual(gotB, tt.b) {
+				t.Errorf("ops %+v do not transform %v into %v", ops, tt.a, tt.b)
+			}
+		})
+	}
+}
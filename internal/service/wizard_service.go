@@ -0,0 +1,798 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/internal/repository"
+	"novel-agent-os-backend/pkg/logger"
+)
+
+// 向导结构化输出类型
+const (
+	WizardKindWorld      = "world"
+	WizardKindCharacters = "characters"
+	WizardKindOutline    = "outline"
+)
+
+// 变更操作
+const (
+	WizardOpCreate    = "create"
+	WizardOpUpdate    = "update"
+	WizardOpUnchanged = "unchanged"
+)
+
+// wizardEntityScanLimit 比对已有实体时最多读取的项目实体数
+const wizardEntityScanLimit = 1000
+
+// ErrWizardProposalCommitted 提案已提交过
+var ErrWizardProposalCommitted = errors.New("wizard proposal already committed")
+
+// wizardEntityTypes 与实体服务一致的实体类型
+var wizardEntityTypes = map[string]bool{
+	"character":    true,
+	"setting":      true,
+	"organization": true,
+	"item":         true,
+	"magic":        true,
+	"event":        true,
+}
+
+var wizardImportances = map[string]bool{"main": true, "secondary": true, "minor": true}
+
+// WizardValidationError 模型输出不是合法 JSON 或不符合结构要求
+type WizardValidationError struct {
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+}
+
+func (e *WizardValidationError) Error() string {
+	if len(e.Details) == 0 {
+		return e.Message
+	}
+	return e.Message + "：" + strings.Join(e.Details, "；")
+}
+
+// WizardWorldProposal 世界观：写入项目设定
+type WizardWorldProposal struct {
+	WorldRules    string `json:"world_rules"`
+	CoreConflict  string `json:"core_conflict"`
+	CharacterArc  string `json:"character_arc"`
+	UltimateValue string `json:"ultimate_value"`
+}
+
+// WizardEntityLinkProposal 实体关联，target 为实体名称（本次提案或项目已有实体）
+type WizardEntityLinkProposal struct {
+	Target       string `json:"target"`
+	Type         string `json:"type"`
+	RelationName string `json:"relation_name"`
+}
+
+// WizardEntityProposal 角色/设定实体，按名称匹配项目已有实体
+type WizardEntityProposal struct {
+	EntityType string                     `json:"entity_type"`
+	Title      string                     `json:"title"`
+	Subtitle   string                     `json:"subtitle"`
+	Content    string                     `json:"content"`
+	VoiceStyle string                     `json:"voice_style"`
+	Importance string                     `json:"importance"`
+	Tags       []string                   `json:"tags"`
+	Links      []WizardEntityLinkProposal `json:"links"`
+}
+
+// WizardCharactersProposal 角色设定
+type WizardCharactersProposal struct {
+	Entities []WizardEntityProposal `json:"entities"`
+}
+
+// WizardChapterProposal 章节骨架（不含正文）
+type WizardChapterProposal struct {
+	Title       string `json:"title"`
+	ChapterGoal string `json:"chapter_goal"`
+	CorePlot    string `json:"core_plot"`
+	Hook        string `json:"hook"`
+}
+
+// WizardVolumeProposal 卷规划，按名称匹配项目已有卷
+type WizardVolumeProposal struct {
+	Title       string                  `json:"title"`
+	Theme       string                  `json:"theme"`
+	CoreGoal    string                  `json:"core_goal"`
+	PlotRoadmap string                  `json:"plot_roadmap"`
+	Chapters    []WizardChapterProposal `json:"chapters"`
+}
+
+// WizardOutlineProposal 大纲
+type WizardOutlineProposal struct {
+	Volumes []WizardVolumeProposal `json:"volumes"`
+}
+
+// WizardFieldChange 字段变更前后值
+type WizardFieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// WizardChange 一条记录级变更
+type WizardChange struct {
+	Op string `json:"op"`
+	// Kind project/entity/entity_tag/entity_link/volume/document
+	Kind     string              `json:"kind"`
+	Key      string              `json:"key"`
+	TargetID uint                `json:"target_id,omitempty"`
+	Fields   []WizardFieldChange `json:"fields,omitempty"`
+
+	// ref 提交后回填 TargetID（新建记录的主键）
+	ref *uint
+}
+
+// WizardChangeSummary 变更计数
+type WizardChangeSummary struct {
+	Create    int `json:"create"`
+	Update    int `json:"update"`
+	Unchanged int `json:"unchanged"`
+}
+
+// WizardProposal 校验后的结构化结果及其与项目现状的差异
+type WizardProposal struct {
+	Kind    string              `json:"kind"`
+	Data    json.RawMessage     `json:"data"`
+	Changes []WizardChange      `json:"changes"`
+	Summary WizardChangeSummary `json:"summary"`
+}
+
+// WizardService 向导结构化输出：生成 Schema、校验模型输出、计算差异并写入
+type WizardService interface {
+	// ResponseFormat 指定类型的结构化输出 Schema
+	ResponseFormat(kind string) (*ChatResponseFormat, error)
+	// Propose 解析并校验模型输出，返回与项目现状的差异（不写入）
+	Propose(projectID uint, kind, content string) (*WizardProposal, error)
+	// Commit 按当前项目状态重新计算差异并在同一事务中写入
+	Commit(projectID uint, kind string, data json.RawMessage) (*WizardProposal, error)
+}
+
+type wizardService struct {
+	projectRepo  repository.ProjectRepository
+	volumeRepo   repository.VolumeRepository
+	entityRepo   repository.EntityRepository
+	documentRepo repository.DocumentRepository
+	wizardRepo   repository.WizardRepository
+}
+
+// NewWizardService 创建向导结构化输出服务
+func NewWizardService(projectRepo repository.ProjectRepository, volumeRepo repository.VolumeRepository, entityRepo repository.EntityRepository, documentRepo repository.DocumentRepository, wizardRepo repository.WizardRepository) WizardService {
+	return &wizardService{
+		projectRepo:  projectRepo,
+		volumeRepo:   volumeRepo,
+		entityRepo:   entityRepo,
+		documentRepo: documentRepo,
+		wizardRepo:   wizardRepo,
+	}
+}
+
+func (s *wizardService) ResponseFormat(kind string) (*ChatResponseFormat, error) {
+	schema, ok := wizardSchemas[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown wizard kind: %s", kind)
+	}
+	return &ChatResponseFormat{Name: "wizard_" + kind, Schema: schema}, nil
+}
+
+func (s *wizardService) Propose(projectID uint, kind, content string) (*WizardProposal, error) {
+	raw, err := extractWizardJSON(content)
+	if err != nil {
+		return nil, err
+	}
+	proposal, _, err := s.plan(projectID, kind, raw)
+	return proposal, err
+}
+
+func (s *wizardService) Commit(projectID uint, kind string, data json.RawMessage) (*WizardProposal, error) {
+	proposal, plan, err := s.plan(projectID, kind, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.wizardRepo.Commit(plan); err != nil {
+		logger.Error("提交向导结果失败", logger.Uint("project_id", projectID), logger.String("kind", kind), logger.Err(err))
+		return nil, err
+	}
+	for i := range proposal.Changes {
+		if ref := proposal.Changes[i].ref; ref != nil {
+			proposal.Changes[i].TargetID = *ref
+		}
+	}
+	return proposal, nil
+}
+
+// plan 解码并校验提案，对比项目现状生成变更列表与写入计划
+func (s *wizardService) plan(projectID uint, kind string, data json.RawMessage) (*WizardProposal, *repository.WizardCommitPlan, error) {
+	project, err := s.projectRepo.FindByID(projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	planner := &wizardPlanner{plan: &repository.WizardCommitPlan{ProjectID: projectID}}
+
+	var normalized interface{}
+	switch kind {
+	case WizardKindWorld:
+		var proposal WizardWorldProposal
+		if err := decodeWizardProposal(data, &proposal); err != nil {
+			return nil, nil, err
+		}
+		if err := normalizeWizardWorld(&proposal); err != nil {
+			return nil, nil, err
+		}
+		planner.planWorld(project, proposal)
+		normalized = proposal
+	case WizardKindCharacters:
+		var proposal WizardCharactersProposal
+		if err := decodeWizardProposal(data, &proposal); err != nil {
+			return nil, nil, err
+		}
+		if err := normalizeWizardCharacters(&proposal); err != nil {
+			return nil, nil, err
+		}
+		existing, _, err := s.entityRepo.FindByProjectID(projectID, 1, wizardEntityScanLimit)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := planner.planCharacters(projectID, proposal, existing, s.entityRepo.GetLinks); err != nil {
+			return nil, nil, err
+		}
+		normalized = proposal
+	case WizardKindOutline:
+		var proposal WizardOutlineProposal
+		if err := decodeWizardProposal(data, &proposal); err != nil {
+			return nil, nil, err
+		}
+		if err := normalizeWizardOutline(&proposal); err != nil {
+			return nil, nil, err
+		}
+		volumes, err := s.volumeRepo.FindByProjectIDWithDocuments(projectID)
+		if err != nil {
+			return nil, nil, err
+		}
+		planner.planOutline(projectID, proposal, volumes)
+		normalized = proposal
+	default:
+		return nil, nil, fmt.Errorf("unknown wizard kind: %s", kind)
+	}
+
+	raw, err := json.Marshal(normalized)
+	if err != nil {
+		return nil, nil, err
+	}
+	proposal := &WizardProposal{Kind: kind, Data: raw, Changes: planner.changes}
+	for _, change := range planner.changes {
+		switch change.Op {
+		case WizardOpCreate:
+			proposal.Summary.Create++
+		case WizardOpUpdate:
+			proposal.Summary.Update++
+		default:
+			proposal.Summary.Unchanged++
+		}
+	}
+	return proposal, planner.plan, nil
+}
+
+// wizardPlanner 累积变更列表与写入计划
+type wizardPlanner struct {
+	plan    *repository.WizardCommitPlan
+	changes []WizardChange
+}
+
+func (p *wizardPlanner) add(change WizardChange) {
+	if change.Op == "" {
+		change.Op = WizardOpUpdate
+		if len(change.Fields) == 0 {
+			change.Op = WizardOpUnchanged
+		}
+	}
+	p.changes = append(p.changes, change)
+}
+
+func (p *wizardPlanner) planWorld(project *model.Project, proposal WizardWorldProposal) {
+	fields := make(map[string]interface{})
+	var diff []WizardFieldChange
+	for _, item := range []struct {
+		field         string
+		before, after string
+	}{
+		{"world_rules", project.WorldRules, proposal.WorldRules},
+		{"core_conflict", project.CoreConflict, proposal.CoreConflict},
+		{"character_arc", project.CharacterArc, proposal.CharacterArc},
+		{"ultimate_value", project.UltimateValue, proposal.UltimateValue},
+	} {
+		// 提案未给出的字段保留原值
+		if item.after == "" || item.after == item.before {
+			continue
+		}
+		fields[item.field] = item.after
+		diff = append(diff, WizardFieldChange{Field: item.field, Before: item.before, After: item.after})
+	}
+	p.plan.ProjectFields = fields
+	p.add(WizardChange{Kind: "project", Key: project.Title, TargetID: project.ID, Fields: diff})
+}
+
+func (p *wizardPlanner) planCharacters(projectID uint, proposal WizardCharactersProposal, existing []*model.Entity, getLinks func(uint) ([]*model.EntityLink, error)) error {
+	byTitle := make(map[string]*model.Entity, len(existing))
+	for _, entity := range existing {
+		key := wizardKey(entity.Title)
+		if _, ok := byTitle[key]; !ok {
+			byTitle[key] = entity
+		}
+	}
+
+	// 先确定每个提案实体对应的记录，关联可指向本次新建的实体
+	targets := make([]*model.Entity, len(proposal.Entities))
+	for i, item := range proposal.Entities {
+		entity, found := byTitle[wizardKey(item.Title)]
+		if !found {
+			entity = &model.Entity{
+				ProjectID:  projectID,
+				EntityType: item.EntityType,
+				Title:      item.Title,
+				Subtitle:   item.Subtitle,
+				Content:    item.Content,
+				VoiceStyle: item.VoiceStyle,
+				Importance: item.Importance,
+			}
+			if entity.Importance == "" {
+				entity.Importance = "secondary"
+			}
+			byTitle[wizardKey(item.Title)] = entity
+			p.plan.Entities = append(p.plan.Entities, repository.WizardEntity{Entity: entity})
+			p.add(WizardChange{
+				Op:   WizardOpCreate,
+				Kind: "entity",
+				Key:  item.Title,
+				Fields: wizardCreateFields(
+					"entity_type", entity.EntityType,
+					"subtitle", entity.Subtitle,
+					"content", entity.Content,
+					"voice_style", entity.VoiceStyle,
+					"importance", entity.Importance,
+				),
+				ref: &entity.ID,
+			})
+			targets[i] = entity
+			continue
+		}
+
+		var diff []WizardFieldChange
+		diff = wizardSetField(diff, "subtitle", &entity.Subtitle, item.Subtitle)
+		diff = wizardSetField(diff, "content", &entity.Content, item.Content)
+		diff = wizardSetField(diff, "voice_style", &entity.VoiceStyle, item.VoiceStyle)
+		diff = wizardSetField(diff, "importance", &entity.Importance, item.Importance)
+		if len(diff) > 0 {
+			p.plan.Entities = append(p.plan.Entities, repository.WizardEntity{Entity: entity, Fields: wizardDiffFields(diff)})
+		}
+		p.add(WizardChange{Kind: "entity", Key: entity.Title, TargetID: entity.ID, Fields: diff})
+		targets[i] = entity
+	}
+
+	for i, item := range proposal.Entities {
+		entity := targets[i]
+		tags := make(map[string]bool, len(entity.Tags))
+		for _, tag := range entity.Tags {
+			tags[tag.Tag] = true
+		}
+		for _, tag := range item.Tags {
+			if tags[tag] {
+				continue
+			}
+			tags[tag] = true
+			p.plan.EntityTags = append(p.plan.EntityTags, repository.WizardEntityTag{Entity: entity, Tag: tag})
+			p.add(WizardChange{Op: WizardOpCreate, Kind: "entity_tag", Key: entity.Title + "#" + tag, ref: &entity.ID})
+		}
+
+		linked := make(map[string]bool)
+		if entity.ID > 0 {
+			links, err := getLinks(entity.ID)
+			if err != nil {
+				return err
+			}
+			for _, link := range links {
+				linked[fmt.Sprintf("%d|%s", link.TargetID, link.RelationName)] = true
+			}
+		}
+		for _, link := range item.Links {
+			target, ok := byTitle[wizardKey(link.Target)]
+			if !ok {
+				return &WizardValidationError{Message: "实体关联无效", Details: []string{fmt.Sprintf("%s 关联的实体 %s 不存在", item.Title, link.Target)}}
+			}
+			if target == entity {
+				continue
+			}
+			key := fmt.Sprintf("%d|%s", target.ID, link.RelationName)
+			if target.ID == 0 {
+				key = "new:" + wizardKey(target.Title) + "|" + link.RelationName
+			}
+			if linked[key] {
+				p.add(WizardChange{Op: WizardOpUnchanged, Kind: "entity_link", Key: entity.Title + " -> " + target.Title})
+				continue
+			}
+			linked[key] = true
+			p.plan.EntityLinks = append(p.plan.EntityLinks, repository.WizardEntityLink{
+				Source:       entity,
+				Target:       target,
+				Type:         link.Type,
+				RelationName: link.RelationName,
+			})
+			p.add(WizardChange{
+				Op:     WizardOpCreate,
+				Kind:   "entity_link",
+				Key:    entity.Title + " -> " + target.Title,
+				Fields: wizardCreateFields("type", link.Type, "relation_name", link.RelationName),
+			})
+		}
+	}
+	return nil
+}
+
+func (p *wizardPlanner) planOutline(projectID uint, proposal WizardOutlineProposal, volumes []*model.Volume) {
+	byTitle := make(map[string]*model.Volume, len(volumes))
+	nextVolumeOrder := 0
+	for _, volume := range volumes {
+		if _, ok := byTitle[wizardKey(volume.Title)]; !ok {
+			byTitle[wizardKey(volume.Title)] = volume
+		}
+		if volume.OrderIndex > nextVolumeOrder {
+			nextVolumeOrder = volume.OrderIndex
+		}
+	}
+
+	for _, item := range proposal.Volumes {
+		volume, found := byTitle[wizardKey(item.Title)]
+		if !found {
+			nextVolumeOrder++
+			volume = &model.Volume{
+				ProjectID:   projectID,
+				Title:       item.Title,
+				OrderIndex:  nextVolumeOrder,
+				Theme:       item.Theme,
+				CoreGoal:    item.CoreGoal,
+				PlotRoadmap: item.PlotRoadmap,
+			}
+			byTitle[wizardKey(item.Title)] = volume
+			p.plan.Volumes = append(p.plan.Volumes, repository.WizardVolume{Volume: volume})
+			p.add(WizardChange{
+				Op:     WizardOpCreate,
+				Kind:   "volume",
+				Key:    item.Title,
+				Fields: wizardCreateFields("theme", volume.Theme, "core_goal", volume.CoreGoal, "plot_roadmap", volume.PlotRoadmap),
+				ref:    &volume.ID,
+			})
+		} else {
+			var diff []WizardFieldChange
+			diff = wizardSetField(diff, "theme", &volume.Theme, item.Theme)
+			diff = wizardSetField(diff, "core_goal", &volume.CoreGoal, item.CoreGoal)
+			diff = wizardSetField(diff, "plot_roadmap", &volume.PlotRoadmap, item.PlotRoadmap)
+			if len(diff) > 0 {
+				p.plan.Volumes = append(p.plan.Volumes, repository.WizardVolume{Volume: volume, Fields: wizardDiffFields(diff)})
+			}
+			p.add(WizardChange{Kind: "volume", Key: volume.Title, TargetID: volume.ID, Fields: diff})
+		}
+
+		docs := make(map[string]*model.Document, len(volume.Documents))
+		nextDocOrder := 0
+		for i := range volume.Documents {
+			doc := &volume.Documents[i]
+			if _, ok := docs[wizardKey(doc.Title)]; !ok {
+				docs[wizardKey(doc.Title)] = doc
+			}
+			if doc.OrderIndex > nextDocOrder {
+				nextDocOrder = doc.OrderIndex
+			}
+		}
+		for _, chapter := range item.Chapters {
+			key := volume.Title + " / " + chapter.Title
+			doc, found := docs[wizardKey(chapter.Title)]
+			if !found {
+				nextDocOrder++
+				doc = &model.Document{
+					ProjectID:   projectID,
+					Title:       chapter.Title,
+					OrderIndex:  nextDocOrder,
+					ChapterGoal: chapter.ChapterGoal,
+					CorePlot:    chapter.CorePlot,
+					Hook:        chapter.Hook,
+				}
+				docs[wizardKey(chapter.Title)] = doc
+				p.plan.Documents = append(p.plan.Documents, repository.WizardDocument{Volume: volume, Document: doc})
+				p.add(WizardChange{
+					Op:     WizardOpCreate,
+					Kind:   "document",
+					Key:    key,
+					Fields: wizardCreateFields("chapter_goal", doc.ChapterGoal, "core_plot", doc.CorePlot, "hook", doc.Hook),
+					ref:    &doc.ID,
+				})
+				continue
+			}
+			var diff []WizardFieldChange
+			diff = wizardSetField(diff, "chapter_goal", &doc.ChapterGoal, chapter.ChapterGoal)
+			diff = wizardSetField(diff, "core_plot", &doc.CorePlot, chapter.CorePlot)
+			diff = wizardSetField(diff, "hook", &doc.Hook, chapter.Hook)
+			if len(diff) > 0 {
+				p.plan.Documents = append(p.plan.Documents, repository.WizardDocument{Volume: volume, Document: doc, Fields: wizardDiffFields(diff)})
+			}
+			p.add(WizardChange{Kind: "document", Key: key, TargetID: doc.ID, Fields: diff})
+		}
+	}
+}
+
+// wizardSetField 提案值非空且与原值不同时更新字段并记录差异
+func wizardSetField(diff []WizardFieldChange, field string, target *string, value string) []WizardFieldChange {
+	if value == "" || value == *target {
+		return diff
+	}
+	diff = append(diff, WizardFieldChange{Field: field, Before: *target, After: value})
+	*target = value
+	return diff
+}
+
+// wizardDiffFields 已有记录需要更新的列（差异的字段名即列名）
+func wizardDiffFields(diff []WizardFieldChange) map[string]interface{} {
+	fields := make(map[string]interface{}, len(diff))
+	for _, change := range diff {
+		fields[change.Field] = change.After
+	}
+	return fields
+}
+
+// wizardCreateFields 新建记录的非空字段（field, value 交替传入）
+func wizardCreateFields(pairs ...string) []WizardFieldChange {
+	var fields []WizardFieldChange
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			fields = append(fields, WizardFieldChange{Field: pairs[i], After: pairs[i+1]})
+		}
+	}
+	return fields
+}
+
+func wizardKey(title string) string {
+	return strings.ToLower(strings.TrimSpace(title))
+}
+
+// extractWizardJSON 从模型输出中取出 JSON 对象（兼容 ```json 代码块与前后说明文字）
+func extractWizardJSON(content string) (json.RawMessage, error) {
+	text := strings.TrimSpace(content)
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, &WizardValidationError{Message: "模型输出不是 JSON 对象"}
+	}
+	raw := json.RawMessage(text[start : end+1])
+	if !json.Valid(raw) {
+		return nil, &WizardValidationError{Message: "模型输出不是合法 JSON"}
+	}
+	return raw, nil
+}
+
+func decodeWizardProposal(data json.RawMessage, target interface{}) error {
+	if err := json.Unmarshal(data, target); err != nil {
+		return &WizardValidationError{Message: "结构化结果与 Schema 不符", Details: []string{err.Error()}}
+	}
+	return nil
+}
+
+func normalizeWizardWorld(proposal *WizardWorldProposal) error {
+	proposal.WorldRules = strings.TrimSpace(proposal.WorldRules)
+	proposal.CoreConflict = strings.TrimSpace(proposal.CoreConflict)
+	proposal.CharacterArc = strings.TrimSpace(proposal.CharacterArc)
+	proposal.UltimateValue = strings.TrimSpace(proposal.UltimateValue)
+	if proposal.WorldRules == "" {
+		return &WizardValidationError{Message: "结构化结果校验失败", Details: []string{"world_rules 不能为空"}}
+	}
+	return nil
+}
+
+func normalizeWizardCharacters(proposal *WizardCharactersProposal) error {
+	var details []string
+	if len(proposal.Entities) == 0 {
+		details = append(details, "entities 不能为空")
+	}
+	seen := make(map[string]bool, len(proposal.Entities))
+	for i := range proposal.Entities {
+		item := &proposal.Entities[i]
+		item.Title = strings.TrimSpace(item.Title)
+		item.EntityType = strings.TrimSpace(item.EntityType)
+		item.Subtitle = strings.TrimSpace(item.Subtitle)
+		item.Content = strings.TrimSpace(item.Content)
+		item.VoiceStyle = strings.TrimSpace(item.VoiceStyle)
+		item.Importance = strings.TrimSpace(item.Importance)
+		if item.EntityType == "" {
+			item.EntityType = "character"
+		}
+		switch {
+		case item.Title == "":
+			details = append(details, fmt.Sprintf("entities[%d].title 不能为空", i))
+		case seen[wizardKey(item.Title)]:
+			details = append(details, fmt.Sprintf("entities[%d].title 重复：%s", i, item.Title))
+		}
+		seen[wizardKey(item.Title)] = true
+		if !wizardEntityTypes[item.EntityType] {
+			details = append(details, fmt.Sprintf("entities[%d].entity_type 不支持：%s", i, item.EntityType))
+		}
+		if item.Importance != "" && !wizardImportances[item.Importance] {
+			details = append(details, fmt.Sprintf("entities[%d].importance 不支持：%s", i, item.Importance))
+		}
+		item.Tags = normalizeWizardTags(item.Tags)
+		links := item.Links[:0]
+		for _, link := range item.Links {
+			link.Target = strings.TrimSpace(link.Target)
+			link.Type = strings.TrimSpace(link.Type)
+			link.RelationName = strings.TrimSpace(link.RelationName)
+			if link.Target == "" {
+				continue
+			}
+			links = append(links, link)
+		}
+		item.Links = links
+	}
+	if len(details) > 0 {
+		return &WizardValidationError{Message: "结构化结果校验失败", Details: details}
+	}
+	return nil
+}
+
+func normalizeWizardOutline(proposal *WizardOutlineProposal) error {
+	var details []string
+	if len(proposal.Volumes) == 0 {
+		details = append(details, "volumes 不能为空")
+	}
+	seenVolumes := make(map[string]bool, len(proposal.Volumes))
+	for i := range proposal.Volumes {
+		volume := &proposal.Volumes[i]
+		volume.Title = strings.TrimSpace(volume.Title)
+		volume.Theme = strings.TrimSpace(volume.Theme)
+		volume.CoreGoal = strings.TrimSpace(volume.CoreGoal)
+		volume.PlotRoadmap = strings.TrimSpace(volume.PlotRoadmap)
+		switch {
+		case volume.Title == "":
+			details = append(details, fmt.Sprintf("volumes[%d].title 不能为空", i))
+		case seenVolumes[wizardKey(volume.Title)]:
+			details = append(details, fmt.Sprintf("volumes[%d].title 重复：%s", i, volume.Title))
+		}
+		seenVolumes[wizardKey(volume.Title)] = true
+
+		seenChapters := make(map[string]bool, len(volume.Chapters))
+		for j := range volume.Chapters {
+			chapter := &volume.Chapters[j]
+			chapter.Title = strings.TrimSpace(chapter.Title)
+			chapter.ChapterGoal = strings.TrimSpace(chapter.ChapterGoal)
+			chapter.CorePlot = strings.TrimSpace(chapter.CorePlot)
+			chapter.Hook = strings.TrimSpace(chapter.Hook)
+			switch {
+			case chapter.Title == "":
+				details = append(details, fmt.Sprintf("volumes[%d].chapters[%d].title 不能为空", i, j))
+			case seenChapters[wizardKey(chapter.Title)]:
+				details = append(details, fmt.Sprintf("volumes[%d].chapters[%d].title 重复：%s", i, j, chapter.Title))
+			}
+			seenChapters[wizardKey(chapter.Title)] = true
+		}
+	}
+	if len(details) > 0 {
+		return &WizardValidationError{Message: "结构化结果校验失败", Details: details}
+	}
+	return nil
+}
+
+func normalizeWizardTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || len([]rune(tag)) > 50 || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+// wizardStructuredInstruction 附加在请求最前的 system 提示：不支持原生结构化输出的供应商依赖此约束
+func wizardStructuredInstruction(format *ChatResponseFormat) string {
+	schema, _ := json.Marshal(format.Schema)
+	return "只输出一个 JSON 对象，不要输出解释或代码块标记。JSON 必须符合以下 JSON Schema：\n" + string(schema)
+}
+
+func wizardStringSchema(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "description": description}
+}
+
+// wizardSchemas 各类型的结构化输出 Schema
+var wizardSchemas = map[string]map[string]interface{}{
+	WizardKindWorld: {
+		"type": "object",
+		"properties": map[string]interface{}{
+			"world_rules":    wizardStringSchema("世界观规则：力量体系、社会结构、地理与历史等硬设定"),
+			"core_conflict":  wizardStringSchema("核心冲突"),
+			"character_arc":  wizardStringSchema("主角人物弧光"),
+			"ultimate_value": wizardStringSchema("作品终极价值/主题"),
+		},
+		"required": []interface{}{"world_rules"},
+	},
+	WizardKindCharacters: {
+		"type": "object",
+		"properties": map[string]interface{}{
+			"entities": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"entity_type": map[string]interface{}{
+							"type": "string",
+							"enum": []interface{}{"character", "setting", "organization", "item", "magic", "event"},
+						},
+						"title":       wizardStringSchema("名称"),
+						"subtitle":    wizardStringSchema("身份/一句话定位"),
+						"content":     wizardStringSchema("详细设定"),
+						"voice_style": wizardStringSchema("语言风格"),
+						"importance": map[string]interface{}{
+							"type": "string",
+							"enum": []interface{}{"main", "secondary", "minor"},
+						},
+						"tags": map[string]interface{}{
+							"type":  "array",
+							"items": map[string]interface{}{"type": "string"},
+						},
+						"links": map[string]interface{}{
+							"type": "array",
+							"items": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"target":        wizardStringSchema("关联实体名称"),
+									"type":          wizardStringSchema("关联类型"),
+									"relation_name": wizardStringSchema("关系名称，如师徒、宿敌"),
+								},
+								"required": []interface{}{"target"},
+							},
+						},
+					},
+					"required": []interface{}{"entity_type", "title", "content"},
+				},
+			},
+		},
+		"required": []interface{}{"entities"},
+	},
+	WizardKindOutline: {
+		"type": "object",
+		"properties": map[string]interface{}{
+			"volumes": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"title":        wizardStringSchema("卷名"),
+						"theme":        wizardStringSchema("本卷主题"),
+						"core_goal":    wizardStringSchema("本卷核心目标"),
+						"plot_roadmap": wizardStringSchema("本卷剧情路线"),
+						"chapters": map[string]interface{}{
+							"type": "array",
+							"items": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"title":        wizardStringSchema("章节标题"),
+									"chapter_goal": wizardStringSchema("章节目标"),
+									"core_plot":    wizardStringSchema("核心情节"),
+									"hook":         wizardStringSchema("章末钩子"),
+								},
+								"required": []interface{}{"title"},
+							},
+						},
+					},
+					"required": []interface{}{"title", "chapters"},
+				},
+			},
+		},
+		"required": []interface{}{"volumes"},
+	},
+}
@@ -1,30 +1,43 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	"novel-agent-os-backend/internal/config"
 	"novel-agent-os-backend/internal/model"
+	"novel-agent-os-backend/internal/repository"
 	"novel-agent-os-backend/pkg/logger"
 	"novel-agent-os-backend/pkg/sse"
 
+	"github.com/google/uuid"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
+// ErrChapterBatchNothingToRun 批量任务没有可重试或恢复的条目
+var ErrChapterBatchNothingToRun = errors.New("no chapter batch items to run")
+
 // RunWorkflowRequest 工作流执行请求
 type RunWorkflowRequest struct {
-	UserID       uint
-	ProjectID    uint
-	Session      *model.Session
-	SessionTitle string
-	Mode         string
-	StepTitle    string
-	FormatType   string
-	Provider     string
-	Path         string
-	Body         string
+	UserID              uint
+	ProjectID           uint
+	Session             *model.Session
+	SessionTitle        string
+	Mode                string
+	StepTitle           string
+	FormatType          string
+	Provider            string
+	Path                string
+	Body                string
+	Chat                *ChatRequest
+	Cache               string
+	PromptTemplate      *PromptTemplateRef
 	AuthorizationHeader string
 }
 
@@ -42,6 +55,18 @@ type WorkflowService interface {
 	RunChapterAnalyze(req ChapterAnalyzeRequest) (*ChapterAnalyzeResult, error)
 	RunChapterRewrite(req ChapterRewriteRequest) (*ChapterRewriteResult, error)
 	RunChapterBatch(req ChapterBatchRequest) (*ChapterBatchResult, error)
+	// GetChapterBatchJob 查询批量任务及条目状态
+	GetChapterBatchJob(userID uint, jobUUID string) (*ChapterBatchResult, error)
+	// RetryChapterBatchFailed 重新生成批量任务中失败的条目
+	RetryChapterBatchFailed(userID uint, jobUUID, authorizationHeader string) (*ChapterBatchResult, error)
+	// ResumeChapterBatch 恢复因进程重启或取消而中断的批量任务
+	ResumeChapterBatch(userID uint, jobUUID, authorizationHeader string) (*ChapterBatchResult, error)
+	// PreviewChapterContext 预览章节上下文的组装结果（不调用模型）
+	PreviewChapterContext(req ChapterContextRequest) (*ChapterContext, error)
+	// RunWizardStructured 以结构化输出执行向导步骤，校验结果并返回待确认的变更（不写入）
+	RunWizardStructured(req RunWorkflowRequest, kind string) (*WizardStructuredResult, error)
+	// CommitWizardProposal 确认并写入向导步骤中待提交的结构化结果
+	CommitWizardProposal(stepID uint) (*WizardProposal, error)
 }
 
 type workflowService struct {
@@ -50,19 +75,45 @@ type workflowService struct {
 	documentService DocumentService
 	pluginService   PluginService
 	jobService      JobService
+	projectService  ProjectService
+	usageService    AIUsageService
+	modelService    AIModelService
+	cacheService    AIResponseCacheService
+	contextBuilder  ContextBuilder
+	templateService TemplateService
+	wizardService   WizardService
+	batchRepo       repository.ChapterBatchRepository
+	proposalService DocumentProposalService
+	qualityGate     QualityGateService
 }
 
-func NewWorkflowService(aiConfigService AIConfigService, sessionService SessionService, documentService DocumentService, pluginService PluginService, jobService JobService) WorkflowService {
-	return &workflowService{
+func NewWorkflowService(aiConfigService AIConfigService, sessionService SessionService, documentService DocumentService, pluginService PluginService, jobService JobService, projectService ProjectService, usageService AIUsageService, modelService AIModelService, cacheService AIResponseCacheService, contextBuilder ContextBuilder, templateService TemplateService, wizardService WizardService, batchRepo repository.ChapterBatchRepository, proposalService DocumentProposalService, qualityGate QualityGateService) WorkflowService {
+	s := &workflowService{
 		aiConfigService: aiConfigService,
 		sessionService:  sessionService,
 		documentService: documentService,
 		pluginService:   pluginService,
 		jobService:      jobService,
-	}
+		projectService:  projectService,
+		usageService:    usageService,
+		modelService:    modelService,
+		cacheService:    cacheService,
+		contextBuilder:  contextBuilder,
+		templateService: templateService,
+		wizardService:   wizardService,
+		batchRepo:       batchRepo,
+		proposalService: proposalService,
+		qualityGate:     qualityGate,
+	}
+	jobService.RegisterRunner(model.JobTypeChapterBatch, s.runChapterBatchJob)
+	return s
 }
 
 func (s *workflowService) RunStep(req RunWorkflowRequest) (*RunWorkflowResult, error) {
+	chat, rendered, err := s.withPromptTemplate(req.Chat, req.PromptTemplate, TemplateRenderRequest{ProjectID: req.ProjectID})
+	if err != nil {
+		return nil, err
+	}
 	session, err := s.ensureSession(req.Session, req.SessionTitle, req.Mode, req.ProjectID, req.UserID)
 	if err != nil {
 		return nil, err
@@ -70,17 +121,35 @@ func (s *workflowService) RunStep(req RunWorkflowRequest) (*RunWorkflowResult, e
 
 	s.broadcastProgress(session.ID, 0, "开始")
 
-	body := s.injectToolsToBodyIfPossible(session.ID, req.ProjectID, req.Provider, req.Path, req.Body)
-	raw, content, err := callAI(s.aiConfigService, req.Provider, req.Path, body)
+	callReq, err := s.buildCallRequest(req.ProjectID, req.Provider, req.Path, req.Body, chat)
 	if err != nil {
 		return nil, err
 	}
+	callReq.CacheMode = req.Cache
+	callResult, err := s.invokeAI(context.Background(), session, callReq)
+	if err != nil {
+		return nil, err
+	}
+	raw, content := callResult.Raw, callResult.Content
 
 	if content == "" {
 		content = string(raw)
 	}
 
-	step, err := s.appendStep(session.ID, req.StepTitle, content, req.FormatType, nil)
+	metadata := map[string]interface{}{
+		"project_id":    req.ProjectID,
+		"provider":      req.Provider,
+		"path":          callReq.Path,
+		"attempts":      callResult.Attempts,
+		"provider_used": callResult.Provider,
+		"model":         callResult.Model,
+		"usage":         callResult.Usage,
+		"cached":        callResult.Cached,
+	}
+	if rendered != nil {
+		metadata["template"] = promptTemplateMetadata(req.PromptTemplate, rendered)
+	}
+	step, err := s.appendStep(session.ID, req.StepTitle, content, req.FormatType, metadata)
 	if err != nil {
 		return nil, err
 	}
@@ -89,7 +158,7 @@ func (s *workflowService) RunStep(req RunWorkflowRequest) (*RunWorkflowResult, e
 	s.broadcastDone(session.ID, req.Mode, 0)
 
 	// 如果模型返回 tool_calls，则转成异步 Job 执行（插件调用）
-	_ = s.dispatchToolCalls(session, req.UserID, req.AuthorizationHeader, raw)
+	_ = s.dispatchToolCalls(session, req.UserID, req.AuthorizationHeader, callResult.Provider, raw)
 
 	return &RunWorkflowResult{
 		Session: session,
@@ -101,6 +170,7 @@ func (s *workflowService) RunStep(req RunWorkflowRequest) (*RunWorkflowResult, e
 
 // ChapterWriteBack 章节写回配置
 type ChapterWriteBack struct {
+	// Mode 为 propose 时指定了 DocumentID 的生成/重写结果保存为待审阅提案，不直接写回
 	Mode       string `json:"mode"`
 	SetStatus  string `json:"set_status"`
 	SetSummary bool   `json:"set_summary"`
@@ -119,7 +189,13 @@ type ChapterGenerateRequest struct {
 	Provider     string
 	Path         string
 	Body         string
+	Chat         *ChatRequest
 	WriteBack    ChapterWriteBack
+	Context      *ChapterContextOptions
+	// QualityGate 质量门禁选项，未指定时按项目与全局配置
+	QualityGate         *QualityGateOptions
+	Cache               string
+	PromptTemplate      *PromptTemplateRef
 	AuthorizationHeader string
 }
 
@@ -130,19 +206,26 @@ type ChapterGenerateResult struct {
 	Steps    []*model.SessionStep
 	Content  string
 	Raw      json.RawMessage
+	// Proposal propose 模式下生成的提案，此时 Document 为未修改的文档
+	Proposal *DocumentProposalDetail
+	// Quality 启用质量门禁时的各次检查结果
+	Quality *QualityGateReport
 }
 
 // ChapterAnalyzeRequest 章节分析请求
 type ChapterAnalyzeRequest struct {
-	UserID       uint
-	ProjectID    uint
-	Session      *model.Session
-	SessionTitle string
-	DocumentID   uint
-	Provider     string
-	Path         string
-	Body         string
-	WriteBack    ChapterWriteBack
+	UserID              uint
+	ProjectID           uint
+	Session             *model.Session
+	SessionTitle        string
+	DocumentID          uint
+	Provider            string
+	Path                string
+	Body                string
+	Chat                *ChatRequest
+	WriteBack           ChapterWriteBack
+	Cache               string
+	PromptTemplate      *PromptTemplateRef
 	AuthorizationHeader string
 }
 
@@ -165,7 +248,13 @@ type ChapterRewriteRequest struct {
 	Provider     string
 	Path         string
 	Body         string
+	Chat         *ChatRequest
 	WriteBack    ChapterWriteBack
+	Context      *ChapterContextOptions
+	// QualityGate 质量门禁选项，未指定时按项目与全局配置
+	QualityGate         *QualityGateOptions
+	Cache               string
+	PromptTemplate      *PromptTemplateRef
 	AuthorizationHeader string
 }
 
@@ -175,75 +264,139 @@ type ChapterRewriteResult struct {
 	Document *model.Document
 	Content  string
 	Raw      json.RawMessage
+	// Proposal propose 模式下生成的提案，此时 Document 为未修改的文档
+	Proposal *DocumentProposalDetail
+	// Quality 启用质量门禁时的各次检查结果
+	Quality *QualityGateReport
 }
 
 // ChapterBatchItem 批量章节条目
 type ChapterBatchItem struct {
 	ClientDocumentID string `json:"client_document_id"`
-	Title      string `json:"title"`
-	OrderIndex int    `json:"order_index"`
-	Outline    string `json:"outline"`
-}
-
-// ChapterBatchItemResult 批量生成单条结果（用于前端精确映射）
-type ChapterBatchItemResult struct {
-	ClientDocumentID string          `json:"client_document_id"`
-	Document         *model.Document `json:"document"`
+	Title            string `json:"title"`
+	OrderIndex       int    `json:"order_index"`
+	Outline          string `json:"outline"`
 }
 
 // ChapterBatchRequest 批量章节请求
 type ChapterBatchRequest struct {
-	UserID       uint
-	ProjectID    uint
-	Session      *model.Session
-	SessionTitle string
-	VolumeID     uint
-	Items        []ChapterBatchItem
-	Provider     string
-	Path         string
-	BodyTemplate string
-	WriteBack    ChapterWriteBack
+	UserID         uint
+	ProjectID      uint
+	Session        *model.Session
+	SessionTitle   string
+	VolumeID       uint
+	Items          []ChapterBatchItem
+	Provider       string
+	Path           string
+	BodyTemplate   string
+	ChatTemplate   *ChatRequest
+	WriteBack      ChapterWriteBack
+	Cache          string
+	PromptTemplate *PromptTemplateRef
+	// Parallelism 同时生成的章节数，0 使用 ai.batch.parallelism
+	Parallelism         int
 	AuthorizationHeader string
 }
 
-// ChapterBatchResult 批量章节结果
+// ChapterBatchResult 批量章节任务（后台执行，进度通过会话 SSE 推送）
 type ChapterBatchResult struct {
-	Session   *model.Session
-	Documents []*model.Document
-	Results   []ChapterBatchItemResult
+	Session *model.Session
+	Job     *model.Job
+	Items   []*model.ChapterBatchJobItem
+}
+
+// ChapterBatchJobSummary 批量任务的条目统计，写入 Job.Result
+type ChapterBatchJobSummary struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Pending   int `json:"pending"`
+}
+
+// chapterBatchJobPayload 批量任务的执行配置（保存在 Job.Payload，重试与恢复时复用）
+type chapterBatchJobPayload struct {
+	ProjectID      uint               `json:"project_id"`
+	VolumeID       uint               `json:"volume_id"`
+	Provider       string             `json:"provider"`
+	Path           string             `json:"path"`
+	BodyTemplate   string             `json:"body_template"`
+	ChatTemplate   *ChatRequest       `json:"chat_template,omitempty"`
+	WriteBack      ChapterWriteBack   `json:"write_back"`
+	Cache          string             `json:"cache"`
+	PromptTemplate *PromptTemplateRef `json:"prompt_template,omitempty"`
+	Parallelism    int                `json:"parallelism"`
 }
 
 // RunChapterGenerate 生成章节并写回文档
 func (s *workflowService) RunChapterGenerate(req ChapterGenerateRequest) (*ChapterGenerateResult, error) {
+	chat, rendered, err := s.withPromptTemplate(req.Chat, req.PromptTemplate, TemplateRenderRequest{
+		ProjectID:  req.ProjectID,
+		VolumeID:   req.VolumeID,
+		DocumentID: req.DocumentID,
+	})
+	if err != nil {
+		return nil, err
+	}
 	session, err := s.ensureSession(req.Session, req.SessionTitle, "chapter_generate", req.ProjectID, req.UserID)
 	if err != nil {
 		return nil, err
 	}
 
 	s.broadcastProgress(session.ID, 0, "生成开始")
-	body := s.injectToolsToBodyIfPossible(session.ID, req.ProjectID, req.Provider, req.Path, req.Body)
-	raw, content, err := callAI(s.aiConfigService, req.Provider, req.Path, body)
+	chat, chapterCtx := s.withChapterContext(chat, req.Context, ChapterContextRequest{
+		ProjectID:  req.ProjectID,
+		VolumeID:   req.VolumeID,
+		DocumentID: req.DocumentID,
+		OrderIndex: req.OrderIndex,
+	})
+	callReq, err := s.buildCallRequest(req.ProjectID, req.Provider, req.Path, req.Body, chat)
 	if err != nil {
 		return nil, err
 	}
-	if content == "" {
-		content = string(raw)
+	callReq.CacheMode = req.Cache
+	callResult, content, quality, err := s.invokeWithQualityGate(session, req.ProjectID, req.QualityGate, callReq, "chapter.generate")
+	if err != nil {
+		return nil, err
 	}
+	raw := callResult.Raw
 
 	metadata := map[string]interface{}{
-		"project_id":  req.ProjectID,
-		"document_id": req.DocumentID,
-		"volume_id":   req.VolumeID,
-		"provider":    req.Provider,
-		"path":        req.Path,
+		"project_id":    req.ProjectID,
+		"document_id":   req.DocumentID,
+		"volume_id":     req.VolumeID,
+		"provider":      req.Provider,
+		"path":          callReq.Path,
+		"attempts":      callResult.Attempts,
+		"provider_used": callResult.Provider,
+		"model":         callResult.Model,
+		"usage":         callResult.Usage,
+		"cached":        callResult.Cached,
+	}
+	if rendered != nil {
+		metadata["template"] = promptTemplateMetadata(req.PromptTemplate, rendered)
+	}
+	if chapterCtx != nil {
+		metadata["context"] = chapterContextMetadata(chapterCtx)
+	}
+	if quality != nil {
+		metadata["quality"] = quality
 	}
 
-	promptStep, err := s.appendStep(session.ID, "生成请求", req.Body, "chapter.generate.prompt", metadata)
+	promptStep, err := s.appendStep(session.ID, "生成请求", callReq.Body, "chapter.generate.prompt", metadata)
 	if err != nil {
 		return nil, err
 	}
 
-	doc, err := s.writeBackGenerate(req, content)
+	var doc *model.Document
+	var proposal *DocumentProposalDetail
+	if req.WriteBack.Mode == WriteBackModePropose && req.DocumentID > 0 {
+		doc, proposal, err = s.proposeWriteBack(session, req.DocumentID, "chapter.generate", content, req.WriteBack)
+		if err == nil {
+			metadata["proposal_id"] = proposal.Proposal.ID
+		}
+	} else {
+		doc, err = s.writeBackGenerate(req, content, workflowRevisionSource(session, "chapter.generate"))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -256,7 +409,7 @@ func (s *workflowService) RunChapterGenerate(req ChapterGenerateRequest) (*Chapt
 
 	s.broadcastProgress(session.ID, 100, "生成完成")
 	s.broadcastDone(session.ID, "chapter_generate", doc.ID)
-	_ = s.dispatchToolCalls(session, req.UserID, req.AuthorizationHeader, raw)
+	_ = s.dispatchToolCalls(session, req.UserID, req.AuthorizationHeader, callResult.Provider, raw)
 
 	return &ChapterGenerateResult{
 		Session:  session,
@@ -264,31 +417,53 @@ func (s *workflowService) RunChapterGenerate(req ChapterGenerateRequest) (*Chapt
 		Steps:    []*model.SessionStep{promptStep, resultStep},
 		Content:  content,
 		Raw:      raw,
+		Proposal: proposal,
+		Quality:  quality,
 	}, nil
 }
 
 // RunChapterAnalyze 分析章节并写回摘要
 func (s *workflowService) RunChapterAnalyze(req ChapterAnalyzeRequest) (*ChapterAnalyzeResult, error) {
+	chat, rendered, err := s.withPromptTemplate(req.Chat, req.PromptTemplate, TemplateRenderRequest{
+		ProjectID:  req.ProjectID,
+		DocumentID: req.DocumentID,
+	})
+	if err != nil {
+		return nil, err
+	}
 	session, err := s.ensureSession(req.Session, req.SessionTitle, "chapter_analyze", req.ProjectID, req.UserID)
 	if err != nil {
 		return nil, err
 	}
 
 	s.broadcastProgress(session.ID, 0, "分析开始")
-	body := s.injectToolsToBodyIfPossible(session.ID, req.ProjectID, req.Provider, req.Path, req.Body)
-	raw, content, err := callAI(s.aiConfigService, req.Provider, req.Path, body)
+	callReq, err := s.buildCallRequest(req.ProjectID, req.Provider, req.Path, req.Body, chat)
 	if err != nil {
 		return nil, err
 	}
+	callReq.CacheMode = req.Cache
+	callResult, err := s.invokeAI(context.Background(), session, callReq)
+	if err != nil {
+		return nil, err
+	}
+	raw, content := callResult.Raw, callResult.Content
 	if content == "" {
 		content = string(raw)
 	}
 
 	metadata := map[string]interface{}{
-		"project_id":  req.ProjectID,
-		"document_id": req.DocumentID,
-		"provider":    req.Provider,
-		"path":        req.Path,
+		"project_id":    req.ProjectID,
+		"document_id":   req.DocumentID,
+		"provider":      req.Provider,
+		"path":          callReq.Path,
+		"attempts":      callResult.Attempts,
+		"provider_used": callResult.Provider,
+		"model":         callResult.Model,
+		"usage":         callResult.Usage,
+		"cached":        callResult.Cached,
+	}
+	if rendered != nil {
+		metadata["template"] = promptTemplateMetadata(req.PromptTemplate, rendered)
 	}
 	_, err = s.appendStep(session.ID, "分析结果", content, "chapter.analyze.result", metadata)
 	if err != nil {
@@ -303,7 +478,7 @@ func (s *workflowService) RunChapterAnalyze(req ChapterAnalyzeRequest) (*Chapter
 		updates["status"] = req.WriteBack.SetStatus
 	}
 	if len(updates) > 0 {
-		if _, err := s.documentService.Update(req.DocumentID, updates); err != nil {
+		if _, err := s.documentService.Update(req.DocumentID, updates, workflowRevisionSource(session, "chapter.analyze")); err != nil {
 			return nil, err
 		}
 	}
@@ -315,7 +490,7 @@ func (s *workflowService) RunChapterAnalyze(req ChapterAnalyzeRequest) (*Chapter
 
 	s.broadcastProgress(session.ID, 100, "分析完成")
 	s.broadcastDone(session.ID, "chapter_analyze", doc.ID)
-	_ = s.dispatchToolCalls(session, req.UserID, req.AuthorizationHeader, raw)
+	_ = s.dispatchToolCalls(session, req.UserID, req.AuthorizationHeader, callResult.Provider, raw)
 
 	return &ChapterAnalyzeResult{
 		Session:  session,
@@ -327,6 +502,13 @@ func (s *workflowService) RunChapterAnalyze(req ChapterAnalyzeRequest) (*Chapter
 
 // RunChapterRewrite 重写章节并写回内容
 func (s *workflowService) RunChapterRewrite(req ChapterRewriteRequest) (*ChapterRewriteResult, error) {
+	chat, rendered, err := s.withPromptTemplate(req.Chat, req.PromptTemplate, TemplateRenderRequest{
+		ProjectID:  req.ProjectID,
+		DocumentID: req.DocumentID,
+	})
+	if err != nil {
+		return nil, err
+	}
 	session, err := s.ensureSession(req.Session, req.SessionTitle, "chapter_rewrite", req.ProjectID, req.UserID)
 	if err != nil {
 		return nil, err
@@ -338,165 +520,810 @@ func (s *workflowService) RunChapterRewrite(req ChapterRewriteRequest) (*Chapter
 	}
 
 	s.broadcastProgress(session.ID, 0, "重写开始")
-	body := s.injectToolsToBodyIfPossible(session.ID, req.ProjectID, req.Provider, req.Path, req.Body)
-	raw, content, err := callAI(s.aiConfigService, req.Provider, req.Path, body)
+	chat, chapterCtx := s.withChapterContext(chat, req.Context, ChapterContextRequest{
+		ProjectID:  req.ProjectID,
+		DocumentID: req.DocumentID,
+	})
+	callReq, err := s.buildCallRequest(req.ProjectID, req.Provider, req.Path, req.Body, chat)
 	if err != nil {
 		return nil, err
 	}
-	if content == "" {
-		content = string(raw)
+	callReq.CacheMode = req.Cache
+	callResult, content, quality, err := s.invokeWithQualityGate(session, req.ProjectID, req.QualityGate, callReq, "chapter.rewrite")
+	if err != nil {
+		return nil, err
 	}
+	raw := callResult.Raw
 
 	metadata := map[string]interface{}{
-		"project_id":   req.ProjectID,
-		"document_id":  req.DocumentID,
-		"provider":     req.Provider,
-		"path":         req.Path,
-		"rewrite_mode": req.RewriteMode,
-		"prev_content": doc.Content,
+		"project_id":    req.ProjectID,
+		"document_id":   req.DocumentID,
+		"provider":      req.Provider,
+		"path":          callReq.Path,
+		"rewrite_mode":  req.RewriteMode,
+		"attempts":      callResult.Attempts,
+		"provider_used": callResult.Provider,
+		"model":         callResult.Model,
+		"usage":         callResult.Usage,
+		"cached":        callResult.Cached,
+		"prev_content":  doc.Content,
+	}
+	if rendered != nil {
+		metadata["template"] = promptTemplateMetadata(req.PromptTemplate, rendered)
+	}
+	if chapterCtx != nil {
+		metadata["context"] = chapterContextMetadata(chapterCtx)
+	}
+	if quality != nil {
+		metadata["quality"] = quality
+	}
+
+	var updated *model.Document
+	var proposal *DocumentProposalDetail
+	if req.WriteBack.Mode == WriteBackModePropose {
+		updated, proposal, err = s.proposeWriteBack(session, req.DocumentID, "chapter.rewrite", content, req.WriteBack)
+		if err != nil {
+			return nil, err
+		}
+		metadata["proposal_id"] = proposal.Proposal.ID
 	}
 	_, err = s.appendStep(session.ID, "重写结果", content, "chapter.rewrite.result", metadata)
 	if err != nil {
 		return nil, err
 	}
 
-	updates := map[string]interface{}{
-		"content": content,
-	}
-	if req.WriteBack.SetStatus != "" {
-		updates["status"] = req.WriteBack.SetStatus
-	}
-	if _, err := s.documentService.Update(req.DocumentID, updates); err != nil {
-		return nil, err
-	}
-
-	updated, err := s.documentService.GetByID(req.DocumentID)
-	if err != nil {
-		return nil, err
+	if proposal == nil {
+		updates := map[string]interface{}{
+			"content": content,
+		}
+		if req.WriteBack.SetStatus != "" {
+			updates["status"] = req.WriteBack.SetStatus
+		}
+		if _, err := s.documentService.Update(req.DocumentID, updates, workflowRevisionSource(session, "chapter.rewrite")); err != nil {
+			return nil, err
+		}
+		updated, err = s.documentService.GetByID(req.DocumentID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	s.broadcastProgress(session.ID, 100, "重写完成")
 	s.broadcastDone(session.ID, "chapter_rewrite", updated.ID)
-	_ = s.dispatchToolCalls(session, req.UserID, req.AuthorizationHeader, raw)
+	_ = s.dispatchToolCalls(session, req.UserID, req.AuthorizationHeader, callResult.Provider, raw)
 
 	return &ChapterRewriteResult{
 		Session:  session,
 		Document: updated,
 		Content:  content,
 		Raw:      raw,
+		Proposal: proposal,
+		Quality:  quality,
 	}, nil
 }
 
-// RunChapterBatch 批量生成章节
+// ChapterBatchMaxItems 单个批量任务的章节数上限（ai.batch.max_items）
+func ChapterBatchMaxItems() int {
+	return config.Get().AI.Batch.MaxItems
+}
+
+// RunChapterBatch 创建批量生成章节任务并加入后台队列：条目状态逐个落库，单个章节失败不影响其余章节
 func (s *workflowService) RunChapterBatch(req ChapterBatchRequest) (*ChapterBatchResult, error) {
+	cfg := config.Get().AI.Batch
+	parallelism := req.Parallelism
+	if parallelism <= 0 {
+		parallelism = cfg.Parallelism
+	}
+	if parallelism > cfg.MaxParallelism {
+		parallelism = cfg.MaxParallelism
+	}
+
+	// 提交前先按第一个条目渲染一次模板，模板错误直接返回而不是让每个条目失败
+	if len(req.Items) > 0 {
+		first := req.Items[0]
+		if _, _, err := s.withPromptTemplate(buildBatchChat(req.ChatTemplate, first), req.PromptTemplate, TemplateRenderRequest{
+			ProjectID: req.ProjectID,
+			VolumeID:  req.VolumeID,
+			Item:      map[string]string{"title": first.Title, "outline": first.Outline},
+		}); err != nil {
+			return nil, err
+		}
+	}
+
 	session, err := s.ensureSession(req.Session, req.SessionTitle, "chapter_batch", req.ProjectID, req.UserID)
 	if err != nil {
 		return nil, err
 	}
 
-	documents := make([]*model.Document, 0, len(req.Items))
-	results := make([]ChapterBatchItemResult, 0, len(req.Items))
+	// 未指定序号的条目在提交时依次分配，避免并行生成时序号冲突
+	nextOrder := 0
+	items := make([]*model.ChapterBatchJobItem, 0, len(req.Items))
 	for index, item := range req.Items {
-		progress := int(float64(index) / float64(len(req.Items)) * 100)
-		s.broadcastProgress(session.ID, progress, "批量生成中")
-
-		body := buildBatchBody(req.BodyTemplate, item)
-		metadata := map[string]interface{}{
-			"project_id": req.ProjectID,
-			"volume_id":  req.VolumeID,
-			"provider":   req.Provider,
-			"path":       req.Path,
-			"client_document_id": item.ClientDocumentID,
-			"title":      item.Title,
+		orderIndex := item.OrderIndex
+		if orderIndex <= 0 {
+			if nextOrder == 0 {
+				nextOrder, err = s.documentService.GetNextOrderIndex(req.ProjectID, req.VolumeID)
+				if err != nil {
+					return nil, err
+				}
+			}
+			orderIndex = nextOrder
+			nextOrder++
 		}
-		_, err := s.appendStep(session.ID, "批量生成开始", item.Outline, "chapter.batch.item.started", metadata)
-		if err != nil {
-			return nil, err
+		items = append(items, &model.ChapterBatchJobItem{
+			ItemIndex:        index,
+			ClientDocumentID: item.ClientDocumentID,
+			Title:            item.Title,
+			OrderIndex:       orderIndex,
+			Outline:          item.Outline,
+			Status:           model.JobItemStatusPending,
+		})
+	}
+
+	payload, err := json.Marshal(chapterBatchJobPayload{
+		ProjectID:      req.ProjectID,
+		VolumeID:       req.VolumeID,
+		Provider:       req.Provider,
+		Path:           req.Path,
+		BodyTemplate:   req.BodyTemplate,
+		ChatTemplate:   req.ChatTemplate,
+		WriteBack:      req.WriteBack,
+		Cache:          req.Cache,
+		PromptTemplate: req.PromptTemplate,
+		Parallelism:    parallelism,
+	})
+	if err != nil {
+		return nil, err
+	}
+	projectID := req.ProjectID
+	job := &model.Job{
+		JobUUID:   uuid.New().String(),
+		Type:      model.JobTypeChapterBatch,
+		Status:    model.JobStatusQueued,
+		UserID:    req.UserID,
+		SessionID: session.ID,
+		ProjectID: &projectID,
+		Method:    string(model.JobTypeChapterBatch),
+		Payload:   datatypes.JSON(payload),
+	}
+	if err := s.batchRepo.CreateJob(job, items); err != nil {
+		return nil, err
+	}
+	if err := s.jobService.Enqueue(job, req.AuthorizationHeader); err != nil {
+		logger.Warn("enqueue chapter batch job failed", logger.String("job_uuid", job.JobUUID), logger.Err(err))
+	}
+
+	return &ChapterBatchResult{
+		Session: session,
+		Job:     job,
+		Items:   items,
+	}, nil
+}
+
+// GetChapterBatchJob 查询批量任务及条目状态
+func (s *workflowService) GetChapterBatchJob(userID uint, jobUUID string) (*ChapterBatchResult, error) {
+	job, err := s.loadChapterBatchJob(userID, jobUUID)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.batchRepo.ListItems(job.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &ChapterBatchResult{Job: job, Items: items}, nil
+}
+
+// RetryChapterBatchFailed 将失败的条目重置为待生成并重新排队；失败前已创建章节的条目只补做摘要写回与结果步骤，不重复创建
+func (s *workflowService) RetryChapterBatchFailed(userID uint, jobUUID, authorizationHeader string) (*ChapterBatchResult, error) {
+	return s.requeueChapterBatch(userID, jobUUID, authorizationHeader, func(item *model.ChapterBatchJobItem) bool {
+		return item.Status == model.JobItemStatusFailed
+	})
+}
+
+// ResumeChapterBatch 恢复因进程重启或取消而中断的任务：执行中断的条目重新排队，已创建章节的只补做摘要写回与结果步骤
+func (s *workflowService) ResumeChapterBatch(userID uint, jobUUID, authorizationHeader string) (*ChapterBatchResult, error) {
+	return s.requeueChapterBatch(userID, jobUUID, authorizationHeader, func(item *model.ChapterBatchJobItem) bool {
+		return item.Status == model.JobItemStatusRunning
+	})
+}
+
+// requeueChapterBatch 把 reset 选中的条目重置为 pending，有待生成条目时重新排队
+func (s *workflowService) requeueChapterBatch(userID uint, jobUUID, authorizationHeader string, reset func(item *model.ChapterBatchJobItem) bool) (*ChapterBatchResult, error) {
+	job, err := s.loadChapterBatchJob(userID, jobUUID)
+	if err != nil {
+		return nil, err
+	}
+	if s.jobService.IsActive(job.JobUUID) {
+		return nil, ErrJobActive
+	}
+	items, err := s.batchRepo.ListItems(job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := 0
+	for _, item := range items {
+		if reset(item) {
+			item.Status = model.JobItemStatusPending
+			item.ErrorMessage = ""
+			item.StartedAt = nil
+			item.FinishedAt = nil
+			if err := s.batchRepo.UpdateItem(item); err != nil {
+				return nil, err
+			}
+		}
+		if item.Status == model.JobItemStatusPending {
+			pending++
 		}
+	}
+	if pending == 0 {
+		return nil, ErrChapterBatchNothingToRun
+	}
 
-		finalBody := s.injectToolsToBodyIfPossible(session.ID, req.ProjectID, req.Provider, req.Path, body)
-		raw, content, err := callAI(s.aiConfigService, req.Provider, req.Path, finalBody)
-		if err != nil {
-			return nil, err
+	if err := s.jobService.Requeue(job, authorizationHeader); err != nil {
+		return nil, err
+	}
+	return &ChapterBatchResult{Job: job, Items: items}, nil
+}
+
+func (s *workflowService) loadChapterBatchJob(userID uint, jobUUID string) (*model.Job, error) {
+	job, err := s.jobService.GetJobByUUID(jobUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
 		}
-		if content == "" {
-			content = string(raw)
+		return nil, err
+	}
+	if job.Type != model.JobTypeChapterBatch {
+		return nil, ErrJobNotFound
+	}
+	if job.UserID != userID {
+		return nil, ErrJobAccessDenied
+	}
+	return job, nil
+}
+
+// runChapterBatchJob 批量任务执行函数：按配置的并行数生成待生成条目，失败的条目记录原因后继续
+func (s *workflowService) runChapterBatchJob(ctx context.Context, job *model.Job, authorizationHeader string, report func(progress int)) (interface{}, error) {
+	var payload chapterBatchJobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("invalid batch payload: %w", err)
+	}
+	session, err := s.sessionService.GetSession(job.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.batchRepo.ListItems(job.ID)
+	if err != nil {
+		return nil, err
+	}
+	parallelism := payload.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	total := len(items)
+	var mu sync.Mutex
+	done := 0
+	for _, item := range items {
+		if item.Status != model.JobItemStatusPending {
+			done++
+		}
+	}
+	progress := func() int {
+		if total == 0 {
+			return 100
 		}
+		return done * 100 / total
+	}
+	s.broadcastProgress(session.ID, progress(), "批量生成中")
 
-		orderIndex := item.OrderIndex
-		if orderIndex <= 0 {
-			orderIndex, err = s.documentService.GetNextOrderIndex(req.ProjectID, req.VolumeID)
-			if err != nil {
-				return nil, err
-			}
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+dispatch:
+	for _, item := range items {
+		if item.Status != model.JobItemStatusPending {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case sem <- struct{}{}:
 		}
+		wg.Add(1)
+		go func(item *model.ChapterBatchJobItem) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s.runChapterBatchItem(ctx, session, job, payload, item, authorizationHeader)
+
+			mu.Lock()
+			done++
+			current := progress()
+			mu.Unlock()
+			report(current)
+			s.broadcastProgress(session.ID, current, "批量生成中")
+		}(item)
+	}
+	wg.Wait()
+
+	summary := ChapterBatchJobSummary{Total: total}
+	for _, item := range items {
+		switch item.Status {
+		case model.JobItemStatusSucceeded:
+			summary.Succeeded++
+		case model.JobItemStatusFailed:
+			summary.Failed++
+		default:
+			summary.Pending++
+		}
+	}
+	if ctx.Err() != nil {
+		return summary, ctx.Err()
+	}
+
+	s.broadcastProgress(session.ID, 100, "批量生成完成")
+	s.broadcastDone(session.ID, "chapter_batch", 0)
+	if summary.Failed > 0 {
+		return summary, fmt.Errorf("%d/%d 个章节生成失败", summary.Failed, summary.Total)
+	}
+	return summary, nil
+}
+
+// runChapterBatchItem 生成单个章节并写回条目状态；失败时记录原因并追加失败步骤，任务取消中断的条目回到待生成
+func (s *workflowService) runChapterBatchItem(ctx context.Context, session *model.Session, job *model.Job, payload chapterBatchJobPayload, item *model.ChapterBatchJobItem, authorizationHeader string) {
+	started := time.Now()
+	item.Status = model.JobItemStatusRunning
+	item.Attempts++
+	item.ErrorMessage = ""
+	item.StartedAt = &started
+	item.FinishedAt = nil
+	if err := s.batchRepo.UpdateItem(item); err != nil {
+		logger.Error("update chapter batch item failed", logger.Uint("item_id", item.ID), logger.Err(err))
+	}
+	s.broadcastBatchItem(session.ID, job, item)
+
+	metadata := map[string]interface{}{
+		"project_id":         payload.ProjectID,
+		"volume_id":          payload.VolumeID,
+		"provider":           payload.Provider,
+		"job_uuid":           job.JobUUID,
+		"item_index":         item.ItemIndex,
+		"client_document_id": item.ClientDocumentID,
+		"title":              item.Title,
+	}
+	err := s.generateChapterBatchItem(ctx, session, payload, item, metadata, authorizationHeader)
+
+	finished := time.Now()
+	item.FinishedAt = &finished
+	switch {
+	case err == nil:
+		item.Status = model.JobItemStatusSucceeded
+	case ctx.Err() != nil:
+		item.Status = model.JobItemStatusPending
+		item.StartedAt = nil
+		item.FinishedAt = nil
+	default:
+		item.Status = model.JobItemStatusFailed
+		item.ErrorMessage = err.Error()
+		metadata["error"] = item.ErrorMessage
+		_, _ = s.appendStep(session.ID, "批量生成失败", item.ErrorMessage, "chapter.batch.item.failed", metadata)
+	}
+	if err := s.batchRepo.UpdateItem(item); err != nil {
+		logger.Error("update chapter batch item failed", logger.Uint("item_id", item.ID), logger.Err(err))
+	}
+	s.broadcastBatchItem(session.ID, job, item)
+}
 
-		doc, err := s.documentService.Create(req.ProjectID, item.Title, content, "", req.WriteBack.SetStatus, orderIndex, "", "", 0, "", "", "", "", "", req.VolumeID)
+func (s *workflowService) generateChapterBatchItem(ctx context.Context, session *model.Session, payload chapterBatchJobPayload, item *model.ChapterBatchJobItem, metadata map[string]interface{}, authorizationHeader string) error {
+	source := workflowRevisionSource(session, "chapter.batch")
+	if item.DocumentID > 0 {
+		// 章节已在之前的执行中创建，只重做可重复的后续步骤，不重复生成
+		doc, err := s.documentService.GetByID(item.DocumentID)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		metadata["resumed"] = true
+		return s.finishChapterBatchItem(session, payload, doc, metadata, source)
+	}
 
-		if req.WriteBack.SetSummary {
-			if _, err := s.documentService.Update(doc.ID, map[string]interface{}{"summary": content}); err != nil {
-				return nil, err
-			}
+	batchItem := ChapterBatchItem{
+		ClientDocumentID: item.ClientDocumentID,
+		Title:            item.Title,
+		OrderIndex:       item.OrderIndex,
+		Outline:          item.Outline,
+	}
+	chat, rendered, err := s.withPromptTemplate(buildBatchChat(payload.ChatTemplate, batchItem), payload.PromptTemplate, TemplateRenderRequest{
+		ProjectID: payload.ProjectID,
+		VolumeID:  payload.VolumeID,
+		Item:      map[string]string{"title": item.Title, "outline": item.Outline},
+	})
+	if err != nil {
+		return err
+	}
+	callReq, err := s.buildCallRequest(payload.ProjectID, payload.Provider, payload.Path, buildBatchBody(payload.BodyTemplate, batchItem), chat)
+	if err != nil {
+		return err
+	}
+	callReq.CacheMode = payload.Cache
+	metadata["path"] = callReq.Path
+	if rendered != nil {
+		metadata["template"] = promptTemplateMetadata(payload.PromptTemplate, rendered)
+	}
+	if _, err := s.appendStep(session.ID, "批量生成开始", item.Outline, "chapter.batch.item.started", metadata); err != nil {
+		return err
+	}
+
+	callResult, err := s.invokeAI(ctx, session, callReq)
+	if err != nil {
+		return err
+	}
+	raw, content := callResult.Raw, callResult.Content
+	if content == "" {
+		content = string(raw)
+	}
+	metadata["attempts"] = callResult.Attempts
+	metadata["provider_used"] = callResult.Provider
+	metadata["model"] = callResult.Model
+	metadata["usage"] = callResult.Usage
+	metadata["cached"] = callResult.Cached
+
+	doc, err := s.documentService.Create(payload.ProjectID, item.Title, content, "", payload.WriteBack.SetStatus, item.OrderIndex, "", "", 0, "", "", "", "", "", payload.VolumeID, source)
+	if err != nil {
+		return err
+	}
+	// 章节创建后立即记录，进程中断后恢复时不会重复生成
+	item.DocumentID = doc.ID
+	if err := s.batchRepo.UpdateItem(item); err != nil {
+		logger.Error("update chapter batch item failed", logger.Uint("item_id", item.ID), logger.Err(err))
+	}
+
+	if err := s.finishChapterBatchItem(session, payload, doc, metadata, source); err != nil {
+		return err
+	}
+
+	_ = s.dispatchToolCalls(session, session.UserID, authorizationHeader, callResult.Provider, raw)
+	return nil
+}
+
+// finishChapterBatchItem 章节创建后的步骤：按配置写回摘要并追加结果步骤，重试或恢复时对已创建的章节重新执行
+func (s *workflowService) finishChapterBatchItem(session *model.Session, payload chapterBatchJobPayload, doc *model.Document, metadata map[string]interface{}, source DocumentRevisionSource) error {
+	if payload.WriteBack.SetSummary && doc.Summary != doc.Content {
+		if _, err := s.documentService.Update(doc.ID, map[string]interface{}{"summary": doc.Content}, source); err != nil {
+			return err
 		}
+	}
 
-		metadata["document_id"] = doc.ID
-		_, err = s.appendStep(session.ID, "批量生成结果", content, "chapter.batch.item.result", metadata)
-		if err != nil {
+	metadata["document_id"] = doc.ID
+	_, err := s.appendStep(session.ID, "批量生成结果", doc.Content, "chapter.batch.item.result", metadata)
+	return err
+}
+
+// broadcastBatchItem 推送批量任务条目状态变化（job.item.updated）
+func (s *workflowService) broadcastBatchItem(sessionID uint, job *model.Job, item *model.ChapterBatchJobItem) {
+	hub := sse.GetHub()
+	hub.BroadcastToSession(fmt.Sprintf("%d", sessionID), sse.Event{
+		Type: sse.EventType("job.item.updated"),
+		Data: map[string]interface{}{
+			"job_uuid":           job.JobUUID,
+			"item_index":         item.ItemIndex,
+			"client_document_id": item.ClientDocumentID,
+			"title":              item.Title,
+			"status":             item.Status,
+			"document_id":        item.DocumentID,
+			"attempts":           item.Attempts,
+			"error":              item.ErrorMessage,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// buildCallRequest 构建上游调用请求：
+// - 传入 chat 时由后端按 provider 编码请求体，path 为空则自动推导，并附带已启用插件的 tools
+// - 否则沿用原始 body 透传（仅对 OpenAI 兼容 chat/completions 注入 tools）
+// - 附带项目/全局配置的备用供应商链
+// - 模型能力登记为不支持工具调用时不注入 tools
+func (s *workflowService) buildCallRequest(projectID uint, provider, path, body string, chat *ChatRequest) (AICallRequest, error) {
+	callReq := AICallRequest{
+		Provider: provider,
+		Fallback: resolveAIFallbackChain(s.projectService, projectID, provider),
+	}
+	if chat == nil {
+		if strings.TrimSpace(body) == "" {
+			return callReq, fmt.Errorf("body or chat required")
+		}
+		callReq.Path = path
+		callReq.Body = body
+		if s.modelSupportsTools(provider, detectRequestModel(callReq)) {
+			callReq.Body = s.injectToolsToBodyIfPossible(path, body)
+		}
+		return callReq, nil
+	}
+
+	chatReq := chat.Clone()
+	// 结构化输出请求不附带插件 tools，避免模型改为调用工具
+	if len(chatReq.Tools) == 0 && chatReq.ResponseFormat == nil && s.modelSupportsTools(provider, chatReq.Model) {
+		chatReq.Tools = s.buildPluginTools()
+	}
+	encodedPath, encodedBody, err := buildChatCall(s.aiConfigService, provider, path, chatReq)
+	if err != nil {
+		return callReq, err
+	}
+	callReq.Path = encodedPath
+	callReq.Body = encodedBody
+	callReq.Chat = chatReq
+	return callReq, nil
+}
+
+// withChapterContext 按请求选项或 ai.context.auto_inject 为 chat 请求注入服务端组装的章节上下文
+// 原始 body 请求不注入；组装失败时记录告警并按原请求继续
+func (s *workflowService) withChapterContext(chat *ChatRequest, opts *ChapterContextOptions, target ChapterContextRequest) (*ChatRequest, *ChapterContext) {
+	if chat == nil || s.contextBuilder == nil || !chapterContextEnabled(opts) {
+		return chat, nil
+	}
+	if opts != nil {
+		target.TokenBudget = opts.TokenBudget
+	}
+	chapterCtx, err := s.contextBuilder.Build(target)
+	if err != nil {
+		logger.Warn("build chapter context failed", logger.Uint("project_id", target.ProjectID), logger.Err(err))
+		return chat, nil
+	}
+	return injectChapterContext(chat, chapterCtx.Text), chapterCtx
+}
+
+// withPromptTemplate 渲染请求引用的提示词模板并追加到 chat；未引用模板时原样返回
+func (s *workflowService) withPromptTemplate(chat *ChatRequest, ref *PromptTemplateRef, target TemplateRenderRequest) (*ChatRequest, *TemplateRenderResult, error) {
+	if ref == nil {
+		return chat, nil, nil
+	}
+	if chat == nil {
+		return nil, nil, newTemplateValidationError("使用 prompt_template 时需要提供 chat")
+	}
+	target.Vars = ref.Vars
+	target.EntityIDs = ref.EntityIDs
+	rendered, err := s.templateService.Render(ref.ID, target)
+	if err != nil {
+		var validationErr *TemplateValidationError
+		if errors.As(err, &validationErr) {
+			return nil, nil, err
+		}
+		return nil, nil, newTemplateValidationError(fmt.Sprintf("提示词模板 %d 不可用：%v", ref.ID, err))
+	}
+	return appendPromptTemplateMessage(chat, ref, rendered.Prompt), rendered, nil
+}
+
+// PreviewChapterContext 预览章节上下文的组装结果（不调用模型）
+func (s *workflowService) PreviewChapterContext(req ChapterContextRequest) (*ChapterContext, error) {
+	return s.contextBuilder.Build(req)
+}
+
+// 向导结构化结果在会话步骤元数据中的状态
+const (
+	wizardStatusPending   = "pending"
+	wizardStatusCommitted = "committed"
+	wizardStatusInvalid   = "invalid"
+)
+
+// WizardStructuredResult 向导结构化执行结果
+type WizardStructuredResult struct {
+	RunWorkflowResult
+	Proposal *WizardProposal
+}
+
+// wizardStepMu 串行化向导提交，避免同一步骤被重复写入
+var wizardStepMu sync.Mutex
+
+// RunWizardStructured 要求模型按 Schema 输出 JSON，校验后把结果与差异记录在步骤元数据 wizard 中，待用户确认后提交
+func (s *workflowService) RunWizardStructured(req RunWorkflowRequest, kind string) (*WizardStructuredResult, error) {
+	format, err := s.wizardService.ResponseFormat(kind)
+	if err != nil {
+		return nil, err
+	}
+	if req.Chat == nil {
+		return nil, &WizardValidationError{Message: "结构化输出需要提供 chat"}
+	}
+	chat := req.Chat.Clone()
+	chat.ResponseFormat = format
+	chat.Messages = append([]ChatMessage{{Role: "system", Content: wizardStructuredInstruction(format)}}, chat.Messages...)
+	req.Chat = chat
+
+	result, err := s.RunStep(req)
+	if err != nil {
+		return nil, err
+	}
+
+	wizard := map[string]interface{}{"kind": kind}
+	proposal, proposeErr := s.wizardService.Propose(req.ProjectID, kind, result.Content)
+	if proposeErr != nil {
+		wizard["status"] = wizardStatusInvalid
+		wizard["error"] = proposeErr.Error()
+	} else {
+		wizard["status"] = wizardStatusPending
+		wizard["data"] = proposal.Data
+		wizard["changes"] = proposal.Changes
+		wizard["summary"] = proposal.Summary
+	}
+	if err := s.updateStepMetadata(result.Step, "wizard", wizard); err != nil {
+		return nil, err
+	}
+	if proposeErr != nil {
+		return nil, proposeErr
+	}
+	return &WizardStructuredResult{RunWorkflowResult: *result, Proposal: proposal}, nil
+}
+
+// CommitWizardProposal 按当前项目状态重新计算差异并写入；提交后步骤状态置为 committed，不可重复提交
+func (s *workflowService) CommitWizardProposal(stepID uint) (*WizardProposal, error) {
+	wizardStepMu.Lock()
+	defer wizardStepMu.Unlock()
+
+	step, err := s.sessionService.GetStep(stepID)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.sessionService.GetSession(step.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata struct {
+		Wizard struct {
+			Kind   string          `json:"kind"`
+			Status string          `json:"status"`
+			Data   json.RawMessage `json:"data"`
+		} `json:"wizard"`
+	}
+	if len(step.Metadata) > 0 {
+		if err := json.Unmarshal(step.Metadata, &metadata); err != nil {
 			return nil, err
 		}
+	}
+	switch metadata.Wizard.Status {
+	case wizardStatusPending:
+	case wizardStatusCommitted:
+		return nil, ErrWizardProposalCommitted
+	default:
+		return nil, &WizardValidationError{Message: "该步骤没有待提交的向导结果"}
+	}
 
-		_ = s.dispatchToolCalls(session, req.UserID, req.AuthorizationHeader, raw)
+	proposal, err := s.wizardService.Commit(session.ProjectID, metadata.Wizard.Kind, metadata.Wizard.Data)
+	if err != nil {
+		return nil, err
+	}
 
-		documents = append(documents, doc)
-		results = append(results, ChapterBatchItemResult{ClientDocumentID: item.ClientDocumentID, Document: doc})
+	wizard := map[string]interface{}{
+		"kind":         proposal.Kind,
+		"status":       wizardStatusCommitted,
+		"data":         proposal.Data,
+		"changes":      proposal.Changes,
+		"summary":      proposal.Summary,
+		"committed_at": time.Now().Format(time.RFC3339),
+	}
+	if err := s.updateStepMetadata(step, "wizard", wizard); err != nil {
+		// 数据已写入，元数据更新失败只记录日志
+		logger.Error("更新向导步骤状态失败", logger.Uint("step_id", step.ID), logger.Err(err))
 	}
+	return proposal, nil
+}
 
-	s.broadcastProgress(session.ID, 100, "批量生成完成")
-	s.broadcastDone(session.ID, "chapter_batch", 0)
-	// batch 每个 item 都可能触发 tool_calls，这里不再重复扫描 raw
+// updateStepMetadata 设置步骤元数据中的一个键并保存
+func (s *workflowService) updateStepMetadata(step *model.SessionStep, key string, value interface{}) error {
+	metadata := make(map[string]interface{})
+	if len(step.Metadata) > 0 {
+		if err := json.Unmarshal(step.Metadata, &metadata); err != nil {
+			return err
+		}
+	}
+	metadata[key] = value
+	step.Metadata = encodeMetadata(metadata)
+	return s.sessionService.UpdateStep(step)
+}
 
-	return &ChapterBatchResult{
-		Session:   session,
-		Documents: documents,
-		Results:   results,
-	}, nil
+// invokeAI 调用上游并按会话归属记录用量；ctx 取消时放弃排队与重试等待并中断进行中的请求
+func (s *workflowService) invokeAI(ctx context.Context, session *model.Session, callReq AICallRequest) (*AICallResult, error) {
+	if err := s.modelService.CheckContextWindow(callReq); err != nil {
+		return nil, err
+	}
+	if err := s.usageService.CheckBeforeCall(session.UserID, callReq.Provider, callReq.Fallback); err != nil {
+		return nil, err
+	}
+	callReq.UserID = session.UserID
+	callReq.SessionID = session.ID
+	result, err := callAI(ctx, s.aiConfigService, s.cacheService, callReq)
+	s.usageService.RecordCall(AIUsageScope{
+		UserID:    session.UserID,
+		ProjectID: session.ProjectID,
+		SessionID: session.ID,
+		Source:    "workflow",
+	}, result, err)
+	return result, err
 }
 
-type toolCall struct {
-	Name      string
-	Arguments map[string]interface{}
+// invokeWithQualityGate 调用 AI 生成正文；启用质量门禁且得分低于阈值时附上检测到的问题重新提示（仅 chat 请求可重新提示），
+// 每次检查记录为一个会话步骤，返回得分最高的候选
+func (s *workflowService) invokeWithQualityGate(session *model.Session, projectID uint, opts *QualityGateOptions, callReq AICallRequest, stepType string) (*AICallResult, string, *QualityGateReport, error) {
+	contentOf := func(result *AICallResult) string {
+		if result.Content == "" {
+			return string(result.Raw)
+		}
+		return result.Content
+	}
+	callResult, err := s.invokeAI(context.Background(), session, callReq)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	settings := resolveQualityGateSettings(s.projectService, projectID, opts)
+	if !settings.Enabled || s.qualityGate == nil {
+		return callResult, contentOf(callResult), nil, nil
+	}
+
+	results := []*AICallResult{callResult}
+	var regenerate func(previous string, check *QualityCheckResult) (string, error)
+	if callReq.Chat != nil {
+		regenerate = func(previous string, check *QualityCheckResult) (string, error) {
+			chat := qualityRetryChat(callReq.Chat, previous, check, settings.MinScore)
+			path, body, err := buildChatCall(s.aiConfigService, callReq.Provider, callReq.Path, chat)
+			if err != nil {
+				return "", err
+			}
+			retryReq := callReq
+			retryReq.Path, retryReq.Body, retryReq.Chat = path, body, chat
+			result, err := s.invokeAI(context.Background(), session, retryReq)
+			if err != nil {
+				return "", err
+			}
+			results = append(results, result)
+			return contentOf(result), nil
+		}
+	}
+	best, report := runQualityGate(s.qualityGate, settings, contentOf(callResult), regenerate, func(attempt QualityGateAttempt, candidate string) {
+		title := fmt.Sprintf("质量检查 第 %d 次（%d 分）", attempt.Attempt, attempt.Score)
+		if _, err := s.appendStep(session.ID, title, candidate, stepType+".quality", qualityAttemptMetadata(attempt, settings)); err != nil {
+			logger.Warn("append quality step failed", logger.Uint("session_id", session.ID), logger.Err(err))
+		}
+	})
+	return results[best], contentOf(results[best]), report, nil
 }
 
-func (s *workflowService) injectToolsToBodyIfPossible(sessionID uint, projectID uint, provider, path, body string) string {
-	// 仅对 OpenAI 兼容 /chat/completions 注入 tools
-	if !strings.Contains(path, "chat/completions") {
-		return body
+// modelSupportsTools 模型是否支持工具调用（未登记的模型视为支持）
+func (s *workflowService) modelSupportsTools(provider, modelID string) bool {
+	if s.modelService == nil || modelID == "" {
+		return true
 	}
+	return s.modelService.GetModelInfo(provider, modelID).SupportsTools
+}
 
+// buildPluginTools 将已启用插件的能力转换为供应商无关的工具声明
+func (s *workflowService) buildPluginTools() []ChatTool {
 	plugins, err := s.pluginService.ListEnabledPlugins()
 	if err != nil || len(plugins) == 0 {
-		return body
+		return nil
 	}
 
-	tools := make([]map[string]interface{}, 0)
+	tools := make([]ChatTool, 0)
 	for _, p := range plugins {
 		for _, cap := range p.Capabilities {
-			name := s.buildToolName(p.ID, cap.CapID)
-			tool := map[string]interface{}{
-				"type": "function",
-				"function": map[string]interface{}{
-					"name":        name,
-					"description": strings.TrimSpace(cap.Description),
-					"parameters":  s.readSchemaOrDefault(cap.InputSchema),
-				},
-			}
-			tools = append(tools, tool)
+			tools = append(tools, ChatTool{
+				Name:        s.buildToolName(p.ID, cap.CapID),
+				Description: strings.TrimSpace(cap.Description),
+				Parameters:  s.readSchemaOrDefault(cap.InputSchema),
+			})
 		}
 	}
-	if len(tools) == 0 {
+	return tools
+}
+
+func (s *workflowService) injectToolsToBodyIfPossible(path, body string) string {
+	// 仅对 OpenAI 兼容 /chat/completions 与 /responses 注入 tools
+	responsesAPI := isOpenAIResponsesPath(path)
+	if !strings.Contains(path, "chat/completions") && !responsesAPI {
+		return body
+	}
+
+	chatTools := s.buildPluginTools()
+	if len(chatTools) == 0 {
 		return body
 	}
 
@@ -508,7 +1335,12 @@ func (s *workflowService) injectToolsToBodyIfPossible(sessionID uint, projectID
 	if _, ok := payload["tools"]; ok {
 		return body
 	}
-	payload["tools"] = tools
+	if responsesAPI {
+		payload["tools"] = encodeResponsesTools(chatTools)
+	} else {
+		encoded := encodeOpenAIChat(&ChatRequest{Tools: chatTools})
+		payload["tools"] = encoded["tools"]
+	}
 	if _, ok := payload["tool_choice"]; !ok {
 		payload["tool_choice"] = "auto"
 	}
@@ -553,11 +1385,12 @@ func (s *workflowService) buildToolName(pluginID uint, capID string) string {
 	return fmt.Sprintf("plugin_%d_%s", pluginID, clean)
 }
 
-func (s *workflowService) dispatchToolCalls(session *model.Session, userID uint, authorizationHeader string, raw json.RawMessage) error {
-	calls := extractOpenAIToolCalls(raw)
-	if len(calls) == 0 {
+func (s *workflowService) dispatchToolCalls(session *model.Session, userID uint, authorizationHeader string, provider string, raw json.RawMessage) error {
+	decoded, err := DecodeChatResponse(provider, raw)
+	if err != nil || len(decoded.ToolCalls) == 0 {
 		return nil
 	}
+	calls := decoded.ToolCalls
 
 	// 将 tool_calls 记录到步骤，便于排查
 	data, _ := json.Marshal(calls)
@@ -599,51 +1432,6 @@ func (s *workflowService) buildToolMap() (map[string]resolvedTool, error) {
 	return out, nil
 }
 
-func extractOpenAIToolCalls(raw json.RawMessage) []toolCall {
-	var payload map[string]interface{}
-	if err := json.Unmarshal(raw, &payload); err != nil {
-		return nil
-	}
-	choices, ok := payload["choices"].([]interface{})
-	if !ok || len(choices) == 0 {
-		return nil
-	}
-	choice0, ok := choices[0].(map[string]interface{})
-	if !ok {
-		return nil
-	}
-	message, ok := choice0["message"].(map[string]interface{})
-	if !ok {
-		return nil
-	}
-	toolCalls, ok := message["tool_calls"].([]interface{})
-	if !ok || len(toolCalls) == 0 {
-		return nil
-	}
-
-	out := make([]toolCall, 0, len(toolCalls))
-	for _, item := range toolCalls {
-		m, ok := item.(map[string]interface{})
-		if !ok {
-			continue
-		}
-		fn, ok := m["function"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-		name, _ := fn["name"].(string)
-		argsStr, _ := fn["arguments"].(string)
-		if strings.TrimSpace(name) == "" {
-			continue
-		}
-		args := map[string]interface{}{}
-		_ = json.Unmarshal([]byte(argsStr), &args)
-		out = append(out, toolCall{Name: name, Arguments: args})
-	}
-	return out
-}
-
-
 func (s *workflowService) broadcastStep(sessionID uint, step *model.SessionStep) {
 	data := map[string]interface{}{
 		"step_id":   step.ID,
@@ -701,15 +1489,15 @@ func (s *workflowService) broadcastProgress(sessionID uint, progress int, messag
 
 func (s *workflowService) broadcastDone(sessionID uint, mode string, documentID uint) {
 	data := map[string]interface{}{
-		"mode":       mode,
+		"mode":        mode,
 		"document_id": documentID,
-		"timestamp":  time.Now().Format(time.RFC3339),
+		"timestamp":   time.Now().Format(time.RFC3339),
 	}
 	hub := sse.GetHub()
 	hub.BroadcastToSession(fmt.Sprintf("%d", sessionID), sse.NewWorkflowDoneEvent(data))
 }
 
-func (s *workflowService) writeBackGenerate(req ChapterGenerateRequest, content string) (*model.Document, error) {
+func (s *workflowService) writeBackGenerate(req ChapterGenerateRequest, content string, source DocumentRevisionSource) (*model.Document, error) {
 	if req.DocumentID > 0 {
 		updates := map[string]interface{}{
 			"content": content,
@@ -720,7 +1508,7 @@ func (s *workflowService) writeBackGenerate(req ChapterGenerateRequest, content
 		if req.WriteBack.SetSummary {
 			updates["summary"] = content
 		}
-		if _, err := s.documentService.Update(req.DocumentID, updates); err != nil {
+		if _, err := s.documentService.Update(req.DocumentID, updates, source); err != nil {
 			return nil, err
 		}
 		return s.documentService.GetByID(req.DocumentID)
@@ -735,12 +1523,12 @@ func (s *workflowService) writeBackGenerate(req ChapterGenerateRequest, content
 		}
 	}
 
-	doc, err := s.documentService.Create(req.ProjectID, req.Title, content, "", req.WriteBack.SetStatus, orderIndex, "", "", 0, "", "", "", "", "", req.VolumeID)
+	doc, err := s.documentService.Create(req.ProjectID, req.Title, content, "", req.WriteBack.SetStatus, orderIndex, "", "", 0, "", "", "", "", "", req.VolumeID, source)
 	if err != nil {
 		return nil, err
 	}
 	if req.WriteBack.SetSummary {
-		if _, err := s.documentService.Update(doc.ID, map[string]interface{}{"summary": content}); err != nil {
+		if _, err := s.documentService.Update(doc.ID, map[string]interface{}{"summary": content}, source); err != nil {
 			return nil, err
 		}
 		return s.documentService.GetByID(doc.ID)
@@ -748,12 +1536,55 @@ func (s *workflowService) writeBackGenerate(req ChapterGenerateRequest, content
 	return doc, nil
 }
 
+// proposeWriteBack 将 AI 输出保存为文档的待审阅提案（写回配置在采纳时生效），返回未修改的文档
+func (s *workflowService) proposeWriteBack(session *model.Session, documentID uint, source, content string, writeBack ChapterWriteBack) (*model.Document, *DocumentProposalDetail, error) {
+	proposal, err := s.proposalService.Propose(DocumentProposalRequest{
+		DocumentID: documentID,
+		SessionID:  session.ID,
+		UserID:     session.UserID,
+		Source:     source,
+		Content:    content,
+		SetStatus:  writeBack.SetStatus,
+		SetSummary: writeBack.SetSummary,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := s.documentService.GetByID(documentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, proposal, nil
+}
+
+// workflowRevisionSource 工作流写回正文时的修订来源
+func workflowRevisionSource(session *model.Session, note string) DocumentRevisionSource {
+	return DocumentRevisionSource{
+		Source:    model.RevisionSourceWorkflow,
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		Note:      note,
+	}
+}
+
 func buildBatchBody(template string, item ChapterBatchItem) string {
 	body := strings.ReplaceAll(template, "{{title}}", item.Title)
 	body = strings.ReplaceAll(body, "{{outline}}", item.Outline)
 	return body
 }
 
+// buildBatchChat 复制对话模板并替换 {{title}}/{{outline}} 变量
+func buildBatchChat(template *ChatRequest, item ChapterBatchItem) *ChatRequest {
+	if template == nil {
+		return nil
+	}
+	chat := template.Clone()
+	for i := range chat.Messages {
+		chat.Messages[i].Content = buildBatchBody(chat.Messages[i].Content, item)
+	}
+	return chat
+}
+
 func encodeMetadata(metadata map[string]interface{}) datatypes.JSON {
 	if metadata == nil {
 		return nil
@@ -16,23 +16,32 @@ import (
 type WorkflowStreamService struct {
 	aiConfigService AIConfigService
 	sessionRepo     repository.SessionRepository
+	projectService  ProjectService
+	usageService    AIUsageService
+	modelService    AIModelService
 }
 
 // NewWorkflowStreamService 创建流式工作流服务
-func NewWorkflowStreamService(aiConfigService AIConfigService, sessionRepo repository.SessionRepository) *WorkflowStreamService {
+func NewWorkflowStreamService(aiConfigService AIConfigService, sessionRepo repository.SessionRepository, projectService ProjectService, usageService AIUsageService, modelService AIModelService) *WorkflowStreamService {
 	return &WorkflowStreamService{
 		aiConfigService: aiConfigService,
 		sessionRepo:     sessionRepo,
+		projectService:  projectService,
+		usageService:    usageService,
+		modelService:    modelService,
 	}
 }
 
 // ExecuteWorkflowStreamRequest 执行流式工作流请求
 type ExecuteWorkflowStreamRequest struct {
 	SessionID uint
+	UserID    uint
+	ProjectID uint
 	StepTitle string
 	Provider  string
 	Path      string
 	Body      string
+	Chat      *ChatRequest
 	Timeout   time.Duration
 }
 
@@ -45,6 +54,33 @@ type ExecuteWorkflowStreamResponse struct {
 
 // ExecuteWorkflowStream 执行流式工作流
 func (s *WorkflowStreamService) ExecuteWorkflowStream(req ExecuteWorkflowStreamRequest) (*ExecuteWorkflowStreamResponse, error) {
+	callReq := AICallRequest{
+		Provider:  req.Provider,
+		Path:      req.Path,
+		Body:      req.Body,
+		Fallback:  resolveAIFallbackChain(s.projectService, req.ProjectID, req.Provider),
+		UserID:    req.UserID,
+		SessionID: req.SessionID,
+	}
+	if err := s.usageService.CheckBeforeCall(req.UserID, req.Provider, callReq.Fallback); err != nil {
+		return nil, err
+	}
+	// 供应商无关的对话请求由后端编码为上游请求体
+	if req.Chat != nil {
+		chat := req.Chat.Clone()
+		chat.Stream = true
+		path, body, err := buildChatCall(s.aiConfigService, req.Provider, req.Path, chat)
+		if err != nil {
+			return nil, err
+		}
+		callReq.Path = path
+		callReq.Body = body
+		callReq.Chat = chat
+	}
+	if err := checkAIStreamRequest(s.modelService, callReq); err != nil {
+		return nil, err
+	}
+
 	// 创建 SessionStep
 	step := &model.SessionStep{
 		SessionID:    req.SessionID,
@@ -61,7 +97,7 @@ func (s *WorkflowStreamService) ExecuteWorkflowStream(req ExecuteWorkflowStreamR
 	}
 
 	// 异步执行流式调用
-	go s.executeStreamInBackground(req, step)
+	go s.executeStreamInBackground(req, callReq, step)
 
 	return &ExecuteWorkflowStreamResponse{
 		StepID:    step.ID,
@@ -71,7 +107,7 @@ func (s *WorkflowStreamService) ExecuteWorkflowStream(req ExecuteWorkflowStreamR
 }
 
 // executeStreamInBackground 在后台执行流式调用
-func (s *WorkflowStreamService) executeStreamInBackground(req ExecuteWorkflowStreamRequest, step *model.SessionStep) {
+func (s *WorkflowStreamService) executeStreamInBackground(req ExecuteWorkflowStreamRequest, callReq AICallRequest, step *model.SessionStep) {
 	ctx := context.Background()
 	if req.Timeout > 0 {
 		var cancel context.CancelFunc
@@ -110,10 +146,24 @@ func (s *WorkflowStreamService) executeStreamInBackground(req ExecuteWorkflowStr
 	}
 
 	// 执行流式调用
-	err := CallAIStream(ctx, s.aiConfigService, req.Provider, req.Path, req.Body, chunkHandler)
+	callResult, err := CallAIStream(ctx, s.aiConfigService, callReq, chunkHandler)
+	s.usageService.RecordCall(AIUsageScope{
+		UserID:    req.UserID,
+		ProjectID: req.ProjectID,
+		SessionID: req.SessionID,
+		Source:    "stream",
+	}, callResult, err)
 
 	// 最终更新
 	step.Content = contentBuilder.String()
+	step.Metadata = encodeMetadata(map[string]interface{}{
+		"provider":      req.Provider,
+		"path":          callReq.Path,
+		"attempts":      callResult.Attempts,
+		"provider_used": callResult.Provider,
+		"model":         callResult.Model,
+		"usage":         callResult.Usage,
+	})
 	if err != nil {
 		logger.Error("stream execution failed", logger.Err(err))
 		step.StreamStatus = "error"
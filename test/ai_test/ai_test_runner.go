@@ -11,11 +11,12 @@ import (
 	"time"
 )
 
-const (
-	BaseURL = "http://localhost:8080"
-	APIBase = "http://192.168.32.15:39999/v1"  // Must include /v1
-	APIKey  = "sk-jUIhuUtm36APmZhqvrkmjZOU4bRwDApQMfUQehK8Z2vht60B"
-	Model   = "glm-4.7"
+// 默认使用内置 mock 供应商（需开启 ai.mock.enabled，或设置环境变量 NOVEL_AGENT_OS_AI_MOCK_ENABLED=true），不依赖真实模型与网络
+// 可通过 AI_TEST_BASE_URL / AI_TEST_PROVIDER / AI_TEST_MODEL 覆盖
+var (
+	BaseURL  = envOrDefault("AI_TEST_BASE_URL", "http://localhost:8080")
+	Provider = envOrDefault("AI_TEST_PROVIDER", "mock")
+	Model    = envOrDefault("AI_TEST_MODEL", "mock-chat")
 )
 
 type TestResult struct {
@@ -27,6 +28,13 @@ type TestResult struct {
 
 var testResults []TestResult
 
+func envOrDefault(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
 func logTest(name string, success bool, details string) {
 	result := TestResult{
 		Name:      name,
@@ -78,6 +86,40 @@ func parseResponse(resp *http.Response) (map[string]interface{}, error) {
 	return result, nil
 }
 
+// chatBody 构造供应商无关的对话请求
+func chatBody(prompt string) map[string]interface{} {
+	return map[string]interface{}{
+		"model": Model,
+		"messages": []map[string]string{
+			{"role": "system", "content": "你是小说写作助手"},
+			{"role": "user", "content": prompt},
+		},
+		"temperature": 0.7,
+		"max_tokens":  200,
+	}
+}
+
+// runWorld 执行世界观工作流，返回响应 code 与 data
+func runWorld(token string, projectID uint, prompt string) (int, map[string]interface{}, error) {
+	body := map[string]interface{}{
+		"project_id": projectID,
+		"title":      "mock 测试",
+		"provider":   Provider,
+		"chat":       chatBody(prompt),
+	}
+	resp, err := makeRequest("POST", "/api/v1/workflows/world", body, token)
+	if err != nil {
+		return 0, nil, err
+	}
+	result, err := parseResponse(resp)
+	if err != nil {
+		return 0, nil, err
+	}
+	code, _ := result["code"].(float64)
+	data, _ := result["data"].(map[string]interface{})
+	return int(code), data, nil
+}
+
 // Test 1: Health Check
 func testHealthCheck() {
 	fmt.Println("\n[Test] 服务健康检查")
@@ -147,78 +189,11 @@ func testUserAuth() string {
 	return token
 }
 
-// Test 3: AI Config
-func testAIConfig(token string) {
-	fmt.Println("\n[Test] AI配置管理")
-
-	// Update provider config - use the correct API key
-	providerBody := map[string]string{
-		"provider": "zhipu",
-		"base_url": "http://192.168.32.15:39999/v1",
-		"api_key":  "sk-jUIhuUtm36APmZhqvrkmjZOU4bRwDApQMfUQehK8Z2vht60B",
-	}
-
-	resp, err := makeRequest("PUT", "/api/v1/ai/providers", providerBody, token)
-	if err != nil {
-		logTest("更新供应商配置", false, err.Error())
-		return
-	}
-
-	_, err = parseResponse(resp)
-	if err != nil {
-		logTest("更新供应商配置", false, err.Error())
-		return
-	}
-	logTest("更新供应商配置", true, "Provider: zhipu")
-
-	// Get provider config
-	resp, err = makeRequest("GET", "/api/v1/ai/providers?provider=zhipu", nil, token)
-	if err != nil {
-		logTest("获取供应商配置", false, err.Error())
-		return
-	}
-
-	result, err := parseResponse(resp)
-	if err != nil {
-		logTest("获取供应商配置", false, err.Error())
-		return
-	}
-
-	data, ok := result["data"].(map[string]interface{})
-	if ok && data["provider"] == "zhipu" {
-		logTest("获取供应商配置", true, "BaseURL: "+data["base_url"].(string))
-	} else {
-		logTest("获取供应商配置", false, "配置获取失败")
-	}
-
-	// Test provider connection
-	testBody := map[string]string{"provider": "zhipu"}
-	resp, err = makeRequest("POST", "/api/v1/ai/providers/test", testBody, token)
-	if err != nil {
-		logTest("测试供应商连接", false, err.Error())
-		return
-	}
-
-	result, err = parseResponse(resp)
-	if err != nil {
-		logTest("测试供应商连接", false, err.Error())
-		return
-	}
-
-	code, _ := result["code"].(float64)
-	if code == 0 {
-		logTest("测试供应商连接", true, "连接成功")
-	} else {
-		msg, _ := result["message"].(string)
-		logTest("测试供应商连接", false, "连接失败: "+msg)
-	}
-}
-
-// Test 4: Model List
+// Test 3: Model List
 func testModelList(token string) {
 	fmt.Println("\n[Test] AI模型列表")
 
-	resp, err := makeRequest("GET", "/api/v1/ai/models?provider=zhipu", nil, token)
+	resp, err := makeRequest("GET", "/api/v1/ai/models?provider="+Provider, nil, token)
 	if err != nil {
 		logTest("获取模型列表", false, err.Error())
 		return
@@ -231,127 +206,29 @@ func testModelList(token string) {
 	}
 
 	data, ok := result["data"].([]interface{})
-	if ok && len(data) > 0 {
-		modelNames := []string{}
-		for i, m := range data {
-			if i >= 5 {
-				break
-			}
-			model := m.(map[string]interface{})
-			modelNames = append(modelNames, model["id"].(string))
-		}
-		logTest("获取模型列表", true, fmt.Sprintf("找到 %d 个模型: %s", len(data), strings.Join(modelNames, ", ")))
-	} else {
+	if !ok || len(data) == 0 {
 		logTest("获取模型列表", false, "模型列表为空或获取失败")
-	}
-}
-
-// Test 5: AI Chat
-func testAIChat(token string) {
-	fmt.Println("\n[Test] AI普通对话")
-	fmt.Println("  发送请求到 /v1/chat/completions...")
-
-	chatBody := map[string]interface{}{
-		"provider": "zhipu",
-		"path":     "/v1/chat/completions",
-		"body": map[string]interface{}{
-			"model": Model,
-			"messages": []map[string]string{
-				{"role": "system", "content": "你是一个 helpful assistant."},
-				{"role": "user", "content": "你好，请简单介绍一下自己"},
-			},
-			"temperature": 0.7,
-			"max_tokens":  200,
-		},
-	}
-
-	resp, err := makeRequest("POST", "/api/v1/ai/proxy", chatBody, token)
-	if err != nil {
-		logTest("AI普通对话", false, err.Error())
-		return
-	}
-
-	// Parse as raw JSON since it's proxied from AI provider
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logTest("AI普通对话", false, err.Error())
 		return
 	}
-
-	var aiResp map[string]interface{}
-	if err := json.Unmarshal(body, &aiResp); err != nil {
-		logTest("AI普通对话", false, "解析响应失败: "+err.Error())
-		return
-	}
-
-	choices, ok := aiResp["choices"].([]interface{})
-	if !ok || len(choices) == 0 {
-		logTest("AI普通对话", false, "响应中没有choices")
-		return
-	}
-
-	choice := choices[0].(map[string]interface{})
-	message := choice["message"].(map[string]interface{})
-	content := message["content"].(string)
-	model := aiResp["model"].(string)
-
-	preview := content
-	if len(preview) > 80 {
-		preview = preview[:80] + "..."
-	}
-	fmt.Printf("  Model: %s\n", model)
-	fmt.Printf("  响应: %s\n", preview)
-	logTest("AI普通对话", true, fmt.Sprintf("Model: %s, 响应长度: %d", model, len(content)))
-}
-
-// Test 6: AI Stream
-func testAIStream(token string) {
-	fmt.Println("\n[Test] AI流式对话")
-	fmt.Println("  发送流式请求...")
-
-	streamBody := map[string]interface{}{
-		"provider": "zhipu",
-		"path":     "/v1/chat/completions",
-		"body": map[string]interface{}{
-			"model": Model,
-			"messages": []map[string]string{
-				{"role": "user", "content": "用一句话描述春天的美丽"},
-			},
-			"stream":     true,
-			"max_tokens": 100,
-		},
-	}
-
-	url := BaseURL + "/api/v1/ai/proxy/stream"
-	jsonBody, _ := json.Marshal(streamBody)
-
-	req, err := http.NewRequest("POST", url, bytes.NewReader(jsonBody))
-	if err != nil {
-		logTest("AI流式对话", false, err.Error())
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+token)
-
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		logTest("AI流式对话", false, err.Error())
-		return
+	modelNames := []string{}
+	found := false
+	for _, m := range data {
+		model, _ := m.(map[string]interface{})
+		id, _ := model["id"].(string)
+		modelNames = append(modelNames, id)
+		if id == Model {
+			found = true
+		}
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 200 {
-		logTest("AI流式对话", true, "流式响应接收成功")
+	if found {
+		logTest("获取模型列表", true, fmt.Sprintf("找到 %d 个模型: %s", len(data), strings.Join(modelNames, ", ")))
 	} else {
-		logTest("AI流式对话", false, fmt.Sprintf("状态码: %d", resp.StatusCode))
+		logTest("获取模型列表", false, fmt.Sprintf("模型列表中没有 %s: %s", Model, strings.Join(modelNames, ", ")))
 	}
 }
 
-// Test 7: Workflow
-func testWorkflow(token string) {
+// Test 4: Workflow
+func testWorkflow(token string) (uint, uint) {
 	fmt.Println("\n[Test] 工作流功能")
 
 	// Create project
@@ -363,19 +240,19 @@ func testWorkflow(token string) {
 	resp, err := makeRequest("POST", "/api/v1/projects/", projectBody, token)
 	if err != nil {
 		logTest("创建项目", false, err.Error())
-		return
+		return 0, 0
 	}
 
 	result, err := parseResponse(resp)
 	if err != nil {
 		logTest("创建项目", false, err.Error())
-		return
+		return 0, 0
 	}
 
 	data, ok := result["data"].(map[string]interface{})
 	if !ok {
 		logTest("创建项目", false, "响应格式错误")
-		return
+		return 0, 0
 	}
 
 	projectID := uint(data["id"].(float64))
@@ -383,38 +260,129 @@ func testWorkflow(token string) {
 
 	// World building workflow
 	fmt.Println("  执行世界构建工作流...")
-	worldBody := map[string]interface{}{
-		"project_id":     projectID,
-		"session_title":  "世界构建测试",
-		"prompt":         "创建一个简单的奇幻世界设定，包含世界名称和基本描述",
-		"provider":       "zhipu",
-		"model":          Model,
-	}
-
-	resp, err = makeRequest("POST", "/api/v1/workflows/world", worldBody, token)
+	code, respData, err := runWorld(token, projectID, "创建一个简单的奇幻世界设定，包含世界名称和基本描述")
 	if err != nil {
 		logTest("世界构建工作流", false, err.Error())
-		return
+		return projectID, 0
+	}
+	if code != 0 {
+		logTest("世界构建工作流", false, fmt.Sprintf("code: %d", code))
+		return projectID, 0
+	}
+
+	session, _ := respData["session"].(map[string]interface{})
+	sessionID := uint(session["id"].(float64))
+	content, _ := respData["content"].(string)
+	if content == "" {
+		logTest("世界构建工作流", false, "返回内容为空")
+		return projectID, sessionID
 	}
+	logTest("世界构建工作流", true, fmt.Sprintf("SessionID: %d, 响应长度: %d", sessionID, len(content)))
 
-	result, err = parseResponse(resp)
+	// 相同请求的 mock 回复应完全一致
+	_, again, err := runWorld(token, projectID, "创建一个简单的奇幻世界设定，包含世界名称和基本描述")
 	if err != nil {
-		logTest("世界构建工作流", false, err.Error())
-		return
+		logTest("确定性回复", false, err.Error())
+		return projectID, sessionID
+	}
+	if againContent, _ := again["content"].(string); againContent == content {
+		logTest("确定性回复", true, "两次回复一致")
+	} else {
+		logTest("确定性回复", false, "两次回复不一致")
 	}
+	return projectID, sessionID
+}
 
-	code, _ := result["code"].(float64)
-	if code == 0 {
-		respData := result["data"].(map[string]interface{})
-		session := respData["session"].(map[string]interface{})
-		logTest("世界构建工作流", true, fmt.Sprintf("SessionID: %d", uint(session["id"].(float64))))
+// Test 5: Failure Injection
+func testFailureInjection(token string, projectID uint) {
+	fmt.Println("\n[Test] 重试与失败注入")
+
+	// 前 2 次返回 429，第 3 次成功（ai.retry.max_attempts 默认为 3）
+	code, data, err := runWorld(token, projectID, "重试测试 [mock:429x2]")
+	if err != nil {
+		logTest("429 重试", false, err.Error())
+	} else if code != 0 {
+		logTest("429 重试", false, fmt.Sprintf("code: %d", code))
 	} else {
+		step, _ := data["step"].(map[string]interface{})
+		metadata, _ := step["metadata"].(map[string]interface{})
+		attempts, _ := metadata["attempts"].([]interface{})
+		logTest("429 重试", len(attempts) == 3, fmt.Sprintf("尝试次数: %d", len(attempts)))
+	}
+
+	for _, directive := range []string{"[mock:500]", "[mock:timeout]"} {
+		name := "失败注入 " + directive
+		code, _, err := runWorld(token, projectID, "失败测试 "+directive)
+		if err != nil {
+			logTest(name, false, err.Error())
+			continue
+		}
+		if code != 0 {
+			logTest(name, true, fmt.Sprintf("正确返回错误 code: %d", code))
+		} else {
+			logTest(name, false, "应该返回错误")
+		}
+	}
+}
+
+// Test 6: AI Stream
+func testAIStream(token string, sessionID uint) {
+	fmt.Println("\n[Test] AI流式对话")
+	fmt.Println("  发送流式请求...")
+
+	streamBody := map[string]interface{}{
+		"session_id": sessionID,
+		"step_title": "流式测试",
+		"provider":   Provider,
+		"chat":       chatBody("用一句话描述春天的美丽"),
+	}
+	resp, err := makeRequest("POST", "/api/v1/workflows/stream", streamBody, token)
+	if err != nil {
+		logTest("AI流式对话", false, err.Error())
+		return
+	}
+	result, err := parseResponse(resp)
+	if err != nil {
+		logTest("AI流式对话", false, err.Error())
+		return
+	}
+	data, ok := result["data"].(map[string]interface{})
+	if !ok {
 		msg, _ := result["message"].(string)
-		logTest("世界构建工作流", false, msg)
+		logTest("AI流式对话", false, "启动失败: "+msg)
+		return
 	}
+	stepID := uint(data["step_id"].(float64))
+
+	// 流式内容通过 SSE 推送，这里轮询步骤状态
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := makeRequest("GET", fmt.Sprintf("/api/v1/sessions/steps/%d", stepID), nil, token)
+		if err != nil {
+			logTest("AI流式对话", false, err.Error())
+			return
+		}
+		result, err := parseResponse(resp)
+		if err != nil {
+			logTest("AI流式对话", false, err.Error())
+			return
+		}
+		step, _ := result["data"].(map[string]interface{})
+		switch step["stream_status"] {
+		case "completed":
+			content, _ := step["content"].(string)
+			logTest("AI流式对话", content != "", fmt.Sprintf("StepID: %d, 响应长度: %d", stepID, len(content)))
+			return
+		case "error", "cancelled":
+			logTest("AI流式对话", false, fmt.Sprintf("流式状态: %v", step["stream_status"]))
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	logTest("AI流式对话", false, "等待流式完成超时")
 }
 
-// Test 8: Error Handling
+// Test 7: Error Handling
 func testErrorHandling(token string) {
 	fmt.Println("\n[Test] 错误处理测试")
 
@@ -433,8 +401,13 @@ func testErrorHandling(token string) {
 	}
 
 	// Test unauthorized access
-	resp, err = makeRequest("GET", "/api/v1/ai/models?provider=zhipu", nil, "")
+	resp, err = makeRequest("GET", "/api/v1/ai/models?provider="+Provider, nil, "")
 	if err != nil {
+		logTest("未授权访问处理", false, err.Error())
+		return
+	}
+	result, _ := parseResponse(resp)
+	if code, _ := result["code"].(float64); code != 0 {
 		logTest("未授权访问处理", true, "正确拒绝未授权请求")
 	} else {
 		logTest("未授权访问处理", false, "应该拒绝未授权请求")
@@ -480,7 +453,8 @@ func main() {
 	fmt.Println("========================================")
 	fmt.Println("  AI功能全量测试开始")
 	fmt.Println("========================================")
-	fmt.Printf("API: %s\n", APIBase)
+	fmt.Printf("Backend: %s\n", BaseURL)
+	fmt.Printf("Provider: %s\n", Provider)
 	fmt.Printf("Model: %s\n", Model)
 	fmt.Println("========================================")
 
@@ -495,22 +469,23 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 3. AI Config
-	testAIConfig(token)
-
-	// 4. Model List
+	// 3. Model List
 	testModelList(token)
 
-	// 5. AI Chat
-	testAIChat(token)
+	// 4. Workflow
+	projectID, sessionID := testWorkflow(token)
 
-	// 6. AI Stream
-	testAIStream(token)
+	if projectID > 0 {
+		// 5. Failure Injection
+		testFailureInjection(token, projectID)
+	}
 
-	// 7. Workflow
-	testWorkflow(token)
+	if sessionID > 0 {
+		// 6. AI Stream
+		testAIStream(token, sessionID)
+	}
 
-	// 8. Error Handling
+	// 7. Error Handling
 	testErrorHandling(token)
 
 	// Print report